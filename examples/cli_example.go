@@ -121,11 +121,11 @@ func handleBackup(ctx context.Context, config resticlib.Config, args []string) {
 	}
 
 	fmt.Printf("Creating backup of: %v\n", args)
-	snapshotID, err := repo.Backup(ctx, opts)
+	summary, err := repo.Backup(ctx, opts)
 	if err != nil {
 		log.Fatalf("Backup failed: %v", err)
 	}
-	fmt.Printf("Snapshot saved as: %s\n", snapshotID)
+	fmt.Printf("Snapshot saved as: %s\n", summary.SnapshotID)
 }
 
 func handleSnapshots(ctx context.Context, config resticlib.Config) {
@@ -145,7 +145,7 @@ func handleSnapshots(ctx context.Context, config resticlib.Config) {
 	for _, sn := range snapshots {
 		fmt.Printf("%-9s | %-20s | %-10s | %-10v | %v\n",
 			sn.ID.String()[:8]+"...",
-			sn.Time,
+			sn.Time.Format("2006-01-02 15:04:05"),
 			sn.Hostname,
 			sn.Tags,
 			sn.Paths)
@@ -172,7 +172,7 @@ func handleRestore(ctx context.Context, config resticlib.Config, args []string)
 	}
 
 	fmt.Printf("Restoring snapshot %s to %s\n", snapshotID, targetDir)
-	err = repo.Restore(ctx, snapshotID, opts)
+	_, err = repo.Restore(ctx, snapshotID, opts)
 	if err != nil {
 		log.Fatalf("Restore failed: %v", err)
 	}
@@ -187,7 +187,7 @@ func handleCheck(ctx context.Context, config resticlib.Config) {
 	defer repo.Close()
 
 	fmt.Println("Checking repository integrity...")
-	report, err := repo.Check(ctx, resticlib.CheckDepthDefault)
+	report, err := repo.Check(ctx, resticlib.CheckOptions{Depth: resticlib.CheckDepthDefault})
 	if err != nil {
 		log.Fatalf("Check failed: %v", err)
 	}
@@ -2,6 +2,7 @@ package archiver
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
@@ -130,6 +131,10 @@ type Archiver struct {
 	// default.
 	WithAtime bool
 
+	// MetadataOnly saves regular files as empty files with all metadata. The
+	// original size is stored in the generic attribute TypeMetadataOnly.
+	MetadataOnly bool
+
 	// Flags controlling change detection. See doc/040_backup.rst for details.
 	ChangeIgnoreFlags uint
 }
@@ -262,6 +267,26 @@ func (arch *Archiver) trackItem(item string, previous, current *data.Node, s Ite
 	}
 }
 
+// metadataOnlyNode returns the node of an empty placeholder for a file
+func (arch *Archiver) metadataOnlyNode(snPath, target string, meta fs.File) (*data.Node, error) {
+	node, err := arch.nodeFromFileInfo(snPath, target, meta, false)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := json.Marshal(node.Size)
+	if err != nil {
+		return nil, err
+	}
+	if node.GenericAttributes == nil {
+		node.GenericAttributes = make(map[data.GenericAttributeType]json.RawMessage)
+	}
+	node.GenericAttributes[data.TypeMetadataOnly] = size
+	node.Size = 0
+	node.Content = restic.IDs{}
+	return node, nil
+}
+
 // nodeFromFileInfo returns the restic node from an os.FileInfo.
 func (arch *Archiver) nodeFromFileInfo(snPath, filename string, meta ToNoder, ignoreXattrListError bool) (*data.Node, error) {
 	node, err := meta.ToNode(ignoreXattrListError, func(format string, args ...any) {
@@ -516,6 +541,20 @@ func (arch *Archiver) save(ctx context.Context, snPath, target string, previous
 	case fi.Mode.IsRegular():
 		debug.Log("  %v regular file", target)
 
+		if arch.MetadataOnly {
+			node, err := arch.metadataOnlyNode(snPath, target, meta)
+			if err != nil {
+				return filterError(err)
+			}
+			arch.trackItem(snPath, previous, node, ItemStats{}, time.Since(start))
+			fn = newFutureNodeWithResult(futureNodeResult{
+				snPath: snPath,
+				target: target,
+				node:   node,
+			})
+			return fn, false, nil
+		}
+
 		// check if the file has not changed before performing a fopen operation (more expensive, specially
 		// in network filesystems)
 		if previous != nil && !fileChanged(fi, previous, arch.ChangeIgnoreFlags) {
@@ -810,6 +849,8 @@ type SnapshotOptions struct {
 	Time           time.Time
 	ParentSnapshot *data.Snapshot
 	ProgramVersion string
+	// Username overrides the user recorded in the snapshot, the current user is used if empty.
+	Username string
 	// SkipIfUnchanged omits the snapshot creation if it is identical to the parent snapshot.
 	SkipIfUnchanged bool
 }
@@ -940,6 +981,9 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, nil, err
 	}
 
+	if opts.Username != "" {
+		sn.Username = opts.Username
+	}
 	sn.ProgramVersion = opts.ProgramVersion
 	sn.Excludes = opts.Excludes
 	if opts.ParentSnapshot != nil {
@@ -42,6 +42,10 @@ type Backend struct {
 }
 
 const saveLargeSize = 256 * 1024 * 1024
+
+// defaultBlockSize is the block size used by saveLarge if none is configured
+const defaultBlockSize = 100 * 1024 * 1024
+
 const defaultListMaxItems = 5000
 
 // make sure that *Backend implements backend.Backend
@@ -68,6 +72,9 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	}
 
 	url := fmt.Sprintf("https://%s.blob.%s/%s", cfg.AccountName, endpointSuffix, cfg.Container)
+	if cfg.Endpoint != "" {
+		url = strings.TrimSuffix(cfg.Endpoint, "/") + "/" + cfg.Container
+	}
 	opts := &azContainer.ClientOptions{
 		ClientOptions: azcore.ClientOptions{
 			Transport: &http.Client{Transport: rt},
@@ -254,8 +261,14 @@ func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.Rewind
 		accessTier = be.accessTier
 	}
 
+	largeSize := int64(saveLargeSize)
+	if be.cfg.BlockSize > 0 {
+		// files are buffered in memory, a configured block size limits this
+		largeSize = int64(be.cfg.BlockSize) * 1024 * 1024
+	}
+
 	var err error
-	if rd.Length() < saveLargeSize {
+	if rd.Length() < largeSize {
 		// if it's smaller than 256miB, then just create the file directly from the reader
 		err = be.saveSmall(ctx, objName, rd, accessTier)
 	} else {
@@ -296,7 +309,12 @@ func (be *Backend) saveSmall(ctx context.Context, objName string, rd backend.Rew
 func (be *Backend) saveLarge(ctx context.Context, objName string, rd backend.RewindReader, accessTier blob.AccessTier) error {
 	blockBlobClient := be.container.NewBlockBlobClient(objName)
 
-	buf := make([]byte, 100*1024*1024)
+	blockSize := defaultBlockSize
+	if be.cfg.BlockSize > 0 {
+		blockSize = int(be.cfg.BlockSize) * 1024 * 1024
+	}
+
+	buf := make([]byte, blockSize)
 	blocks := []string{}
 	uploadedBytes := 0
 
@@ -23,7 +23,9 @@ type Config struct {
 	Prefix             string
 
 	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	BlockSize   uint   `option:"block-size" help:"set the block size in MiB, larger files are uploaded in blocks (default: 100)"`
 	AccessTier  string `option:"access-tier" help:"set the access tier for the blob storage (default: inferred from the storage account defaults)"`
+	Endpoint    string `option:"endpoint" help:"set a custom blob service URL, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -70,6 +70,9 @@ func newClient(ctx context.Context, cfg Config, rt http.RoundTripper) (*b2.Clien
 
 	sniffer := &sniffingRoundTripper{RoundTripper: rt}
 	opts := []b2.ClientOption{b2.Transport(sniffer)}
+	if cfg.Endpoint != "" {
+		opts = append(opts, b2.APIBase(cfg.Endpoint))
+	}
 
 	// if the connection B2 fails, this can cause the client to hang
 	// cancel the connection after a minute to at least provide some feedback to the user
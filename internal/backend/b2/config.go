@@ -19,7 +19,8 @@ type Config struct {
 	Bucket    string
 	Prefix    string
 
-	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	Endpoint    string `option:"endpoint" help:"set a custom API base URL (default: https://api.backblazeb2.com)"`
 }
 
 // NewConfig returns a new config with default options applied.
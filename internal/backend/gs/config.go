@@ -19,7 +19,9 @@ type Config struct {
 	Prefix    string
 
 	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+	ChunkSize   uint   `option:"chunk-size" help:"set the chunk size in MiB for resumable uploads (default: 0, disabled)"`
 	Region      string `option:"region" help:"region to create the bucket in (default: us)"`
+	Endpoint    string `option:"endpoint" help:"set a custom API endpoint, e.g. for fake-gcs-server"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -42,6 +42,7 @@ type Backend struct {
 	region       string
 	bucket       *storage.BucketHandle
 	prefix       string
+	chunkSize    int
 	listMaxItems int
 	layout.Layout
 }
@@ -53,7 +54,7 @@ func NewFactory() location.Factory {
 	return location.NewHTTPBackendFactory("gs", ParseConfig, location.NoPassword, Create, Open)
 }
 
-func getStorageClient(rt http.RoundTripper) (*storage.Client, error) {
+func getStorageClient(rt http.RoundTripper, endpoint string) (*storage.Client, error) {
 	// create a new HTTP client
 	httpClient := &http.Client{
 		Transport: rt,
@@ -78,7 +79,12 @@ func getStorageClient(rt http.RoundTripper) (*storage.Client, error) {
 
 	oauthClient := oauth2.NewClient(ctx, ts)
 
-	gcsClient, err := storage.NewClient(ctx, option.WithHTTPClient(oauthClient))
+	opts := []option.ClientOption{option.WithHTTPClient(oauthClient)}
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+
+	gcsClient, err := storage.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +105,7 @@ const defaultListMaxItems = 1000
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
-	gcsClient, err := getStorageClient(rt)
+	gcsClient, err := getStorageClient(rt, cfg.Endpoint)
 	if err != nil {
 		return nil, errors.Wrap(err, "getStorageClient")
 	}
@@ -112,6 +118,7 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		region:       cfg.Region,
 		bucket:       gcsClient.Bucket(cfg.Bucket),
 		prefix:       cfg.Prefix,
+		chunkSize:    int(cfg.ChunkSize) * 1024 * 1024,
 		Layout:       layout.NewDefaultLayout(cfg.Prefix, path.Join),
 		listMaxItems: defaultListMaxItems,
 	}
@@ -231,9 +238,10 @@ func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.Rewind
 	// in better rate limiting behavior.
 	//
 	// restic typically writes small blobs (4MB-30MB), so the resumable
-	// uploads are not providing significant benefit anyways.
+	// uploads are not providing significant benefit anyways. They can be
+	// enabled for very large packs using the chunk-size option.
 	w := be.bucket.Object(objName).NewWriter(ctx)
-	w.ChunkSize = 0
+	w.ChunkSize = be.chunkSize
 	w.MD5 = rd.Hash()
 	wbytes, err := io.Copy(w, rd)
 	cerr := w.Close()
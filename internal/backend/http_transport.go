@@ -29,11 +29,29 @@ type TransportOptions struct {
 	// Skip TLS certificate verification
 	InsecureTLS bool
 
+	// Minimum TLS version, zero selects the default of crypto/tls
+	TLSMinVersion uint16
+
+	// Allowed TLS 1.0-1.2 cipher suites, nil selects the default of crypto/tls
+	TLSCipherSuites []uint16
+
 	// Specify Custom User-Agent for the http Client
 	HTTPUserAgent string
 
 	// Timeout after which to retry stuck requests
 	StuckRequestTimeout time.Duration
+
+	// Only use HTTP/1.1, some servers misbehave with HTTP/2
+	DisableHTTP2 bool
+
+	// Maximum number of idle connections kept per host, zero selects the default
+	MaxIdleConnsPerHost int
+
+	// Time after which idle connections are closed, zero selects the default
+	IdleConnTimeout time.Duration
+
+	// Interval of TCP keep-alive probes, zero selects the default
+	KeepAlive time.Duration
 }
 
 // readPEMCertKey reads a file and returns the PEM encoded certificate and key
@@ -71,30 +89,48 @@ func readPEMCertKey(filename string) (certs []byte, key []byte, err error) {
 // a custom rootCertFilename is non-empty, it must point to a valid PEM file,
 // otherwise the function will return an error.
 func Transport(opts TransportOptions) (http.RoundTripper, error) {
+	keepAlive := 30 * time.Second
+	if opts.KeepAlive > 0 {
+		keepAlive = opts.KeepAlive
+	}
+	maxIdleConns := 100
+	if opts.MaxIdleConnsPerHost > 0 {
+		maxIdleConns = opts.MaxIdleConnsPerHost
+	}
+	idleConnTimeout := 90 * time.Second
+	if opts.IdleConnTimeout > 0 {
+		idleConnTimeout = opts.IdleConnTimeout
+	}
+
 	// copied from net/http
 	tr := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
+			KeepAlive: keepAlive,
 		}).DialContext,
-		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   100,
-		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		TLSClientConfig:       &tls.Config{},
 	}
 
-	// ensure that http2 connections are closed if they are broken
-	h2, err := http2.ConfigureTransports(tr)
-	if err != nil {
-		panic(err)
-	}
-	if feature.Flag.Enabled(feature.BackendErrorRedesign) {
-		h2.WriteByteTimeout = 120 * time.Second
-		h2.ReadIdleTimeout = 60 * time.Second
-		h2.PingTimeout = 60 * time.Second
+	if opts.DisableHTTP2 {
+		// a non-nil, empty map prevents the upgrade to http2
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	} else {
+		// ensure that http2 connections are closed if they are broken
+		h2, err := http2.ConfigureTransports(tr)
+		if err != nil {
+			panic(err)
+		}
+		if feature.Flag.Enabled(feature.BackendErrorRedesign) {
+			h2.WriteByteTimeout = 120 * time.Second
+			h2.ReadIdleTimeout = 60 * time.Second
+			h2.PingTimeout = 60 * time.Second
+		}
 	}
 
 	unixtransport.Register(tr)
@@ -103,6 +139,9 @@ func Transport(opts TransportOptions) (http.RoundTripper, error) {
 		tr.TLSClientConfig.InsecureSkipVerify = true
 	}
 
+	tr.TLSClientConfig.MinVersion = opts.TLSMinVersion
+	tr.TLSClientConfig.CipherSuites = opts.TLSCipherSuites
+
 	if opts.TLSClientCertKeyFilename != "" {
 		certs, key, err := readPEMCertKey(opts.TLSClientCertKeyFilename)
 		if err != nil {
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransportDisableHTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	for _, test := range []struct {
+		disable bool
+		proto   string
+	}{
+		{false, "HTTP/2.0"},
+		{true, "HTTP/1.1"},
+	} {
+		rt, err := Transport(TransportOptions{InsecureTLS: true, DisableHTTP2: test.disable})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		client := &http.Client{Transport: rt}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Log("failed to close response body")
+		}
+
+		if resp.Proto != test.proto {
+			t.Errorf("DisableHTTP2=%v: expected protocol %v, got %v", test.disable, test.proto, resp.Proto)
+		}
+	}
+}
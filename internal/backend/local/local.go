@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/layout"
@@ -251,6 +252,16 @@ func (b *Local) Stat(_ context.Context, h backend.Handle) (backend.FileInfo, err
 	return backend.FileInfo{Size: fi.Size(), Name: h.Name}, nil
 }
 
+// ModTime returns the time the file was last modified.
+func (b *Local) ModTime(_ context.Context, h backend.Handle) (time.Time, error) {
+	fi, err := os.Stat(b.Filename(h))
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	return fi.ModTime(), nil
+}
+
 // Remove removes the blob with the given name and type.
 func (b *Local) Remove(_ context.Context, h backend.Handle) error {
 	fn := b.Filename(h)
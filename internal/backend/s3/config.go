@@ -27,6 +27,17 @@ type Config struct {
 	RestoreTimeout time.Duration `option:"restore-timeout" help:"maximum time to wait for objects transition (default: 24h)"`
 	RestoreTier    string        `option:"restore-tier" help:"Retrieval tier at which the restore will be processed. (Standard, Bulk or Expedited) (default: Standard)"`
 
+	// RestoreOptIn allows EnableRestore without the s3-restore feature flag.
+	// It is set by library users which enable restores explicitly and cannot
+	// be set as an option.
+	RestoreOptIn bool
+
+	PartSize        uint `option:"part-size" help:"set the part size for multipart uploads in MiB (default: 200)"`
+	PartConcurrency uint `option:"part-concurrency" help:"set the number of parts of a file uploaded in parallel (default: 4)"`
+
+	ObjectLockMode      string        `option:"object-lock-mode" help:"set S3 object lock retention mode for uploaded files (GOVERNANCE or COMPLIANCE)"`
+	ObjectLockRetention time.Duration `option:"object-lock-retention" help:"retention period of the S3 object lock for uploaded files"`
+
 	Connections         uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
 	MaxRetries          uint   `option:"retries" help:"set the number of retries attempted"`
 	Region              string `option:"region" help:"set region"`
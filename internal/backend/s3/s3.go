@@ -46,6 +46,9 @@ const (
 	warmupStatusLukewarm
 )
 
+// defaultPartSize is the part size of multipart uploads if none is configured
+const defaultPartSize = 200 * 1024 * 1024
+
 func NewFactory() location.Factory {
 	return location.NewHTTPBackendFactory("s3", ParseConfig, location.NoPassword, Create, Open)
 }
@@ -53,10 +56,19 @@ func NewFactory() location.Factory {
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
-	if cfg.EnableRestore && !feature.Flag.Enabled(feature.S3Restore) {
+	if cfg.EnableRestore && !cfg.RestoreOptIn && !feature.Flag.Enabled(feature.S3Restore) {
 		return nil, fmt.Errorf("feature flag `s3-restore` is required to use `-o s3.enable-restore=true`")
 	}
 
+	if cfg.ObjectLockMode != "" {
+		if !minio.RetentionMode(strings.ToUpper(cfg.ObjectLockMode)).IsValid() {
+			return nil, fmt.Errorf(`bad object-lock-mode %q must be "GOVERNANCE" or "COMPLIANCE"`, cfg.ObjectLockMode)
+		}
+		if cfg.ObjectLockRetention <= 0 {
+			return nil, fmt.Errorf("object-lock-retention must be set when using object-lock-mode")
+		}
+	}
+
 	if cfg.MaxRetries > 0 {
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
@@ -295,11 +307,21 @@ func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.Rewind
 		// the only option with the high-level api is to let the library handle the checksum computation
 		SendContentMd5: true,
 		// only use multipart uploads for very large files
-		PartSize: 200 * 1024 * 1024,
+		PartSize:   defaultPartSize,
+		NumThreads: be.cfg.PartConcurrency,
+	}
+	if be.cfg.PartSize > 0 {
+		opts.PartSize = uint64(be.cfg.PartSize) * 1024 * 1024
 	}
 	if be.useStorageClass(h) {
 		opts.StorageClass = be.cfg.StorageClass
 	}
+	// only data is retained: lock, index and snapshot files are rewritten or
+	// removed by regular operations such as forget and index rebuilds
+	if be.cfg.ObjectLockMode != "" && h.Type == backend.PackFile {
+		opts.Mode = minio.RetentionMode(strings.ToUpper(be.cfg.ObjectLockMode))
+		opts.RetainUntilDate = time.Now().Add(be.cfg.ObjectLockRetention)
+	}
 
 	info, err := be.client.PutObject(ctx, be.cfg.Bucket, objName, io.NopCloser(rd), rd.Length(), opts)
 
@@ -311,6 +333,31 @@ func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.Rewind
 	return errors.Wrap(err, "client.PutObject")
 }
 
+// RetainUntil returns the time until which the object lock retention protects
+// the file from deletion. The zero time is returned for unprotected files.
+func (be *Backend) RetainUntil(ctx context.Context, h backend.Handle) (time.Time, error) {
+	_, until, err := be.client.GetObjectRetention(ctx, be.cfg.Bucket, be.Filename(h), "")
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchObjectLockConfiguration" {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, "client.GetObjectRetention")
+	}
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
+// ModTime returns the time the file was last modified.
+func (be *Backend) ModTime(ctx context.Context, h backend.Handle) (time.Time, error) {
+	info, err := be.client.StatObject(ctx, be.cfg.Bucket, be.Filename(h), minio.StatObjectOptions{})
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "client.StatObject")
+	}
+	return info.LastModified, nil
+}
+
 // Load runs fn with a reader that yields the contents of the file at h at the
 // given offset.
 func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
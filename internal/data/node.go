@@ -48,11 +48,14 @@ const (
 	TypeSecurityDescriptor GenericAttributeType = "windows.security_descriptor"
 
 	// Generic Attributes for other OS types should be defined here.
+
+	// TypeMetadataOnly marks files whose contents were not saved, it stores the original file size.
+	TypeMetadataOnly GenericAttributeType = "restic.metadata_only"
 )
 
 // init is called when the package is initialized. Any new GenericAttributeTypes being created must be added here as well.
 func init() {
-	storeGenericAttributeType(TypeCreationTime, TypeFileAttributes, TypeSecurityDescriptor)
+	storeGenericAttributeType(TypeCreationTime, TypeFileAttributes, TypeSecurityDescriptor, TypeMetadataOnly)
 }
 
 // genericAttributesForOS maintains a map of known genericAttributesForOS to the OSType
@@ -229,9 +229,22 @@ func mkfifo(path string, mode uint32) (err error) {
 	return mknod(path, mode|syscall.S_IFIFO, 0)
 }
 
+// MetadataSkip selects metadata which is not restored, e.g. for restores
+// without the privileges to change the owner of files.
+type MetadataSkip struct {
+	Ownership   bool
+	Permissions bool
+	Timestamps  bool
+}
+
 // NodeRestoreMetadata restores node metadata
 func NodeRestoreMetadata(node *data.Node, path string, warn func(msg string), xattrSelectFilter func(xattrName string) bool) error {
-	err := nodeRestoreMetadata(node, path, warn, xattrSelectFilter)
+	return NodeRestoreMetadataSkip(node, path, warn, xattrSelectFilter, MetadataSkip{})
+}
+
+// NodeRestoreMetadataSkip restores node metadata except for the parts selected by skip
+func NodeRestoreMetadataSkip(node *data.Node, path string, warn func(msg string), xattrSelectFilter func(xattrName string) bool, skip MetadataSkip) error {
+	err := nodeRestoreMetadata(node, path, warn, xattrSelectFilter, skip)
 	if err != nil {
 		// It is common to have permission errors for folders like /home
 		// unless you're running as root, so ignore those.
@@ -246,11 +259,13 @@ func NodeRestoreMetadata(node *data.Node, path string, warn func(msg string), xa
 	return err
 }
 
-func nodeRestoreMetadata(node *data.Node, path string, warn func(msg string), xattrSelectFilter func(xattrName string) bool) error {
+func nodeRestoreMetadata(node *data.Node, path string, warn func(msg string), xattrSelectFilter func(xattrName string) bool, skip MetadataSkip) error {
 	var firsterr error
 
-	if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
-		firsterr = errors.WithStack(err)
+	if !skip.Ownership {
+		if err := lchown(path, int(node.UID), int(node.GID)); err != nil {
+			firsterr = errors.WithStack(err)
+		}
 	}
 
 	if err := nodeRestoreExtendedAttributes(node, path, xattrSelectFilter); err != nil {
@@ -267,17 +282,19 @@ func nodeRestoreMetadata(node *data.Node, path string, warn func(msg string), xa
 		}
 	}
 
-	if err := nodeRestoreTimestamps(node, path); err != nil {
-		debug.Log("error restoring timestamps for %v: %v", path, err)
-		if firsterr == nil {
-			firsterr = err
+	if !skip.Timestamps {
+		if err := nodeRestoreTimestamps(node, path); err != nil {
+			debug.Log("error restoring timestamps for %v: %v", path, err)
+			if firsterr == nil {
+				firsterr = err
+			}
 		}
 	}
 
 	// Moving RestoreTimestamps and restoreExtendedAttributes calls above as for readonly files in windows
 	// calling Chmod below will no longer allow any modifications to be made on the file and the
 	// calls above would fail.
-	if node.Type != data.NodeTypeSymlink {
+	if node.Type != data.NodeTypeSymlink && !skip.Permissions {
 		if err := chmod(path, node.Mode); err != nil {
 			if firsterr == nil {
 				firsterr = errors.WithStack(err)
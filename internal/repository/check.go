@@ -28,6 +28,27 @@ func (e *ErrPackData) Error() string {
 	return fmt.Sprintf("pack %v contains %v errors: %v", e.PackID, len(e.errs), e.errs)
 }
 
+// Errors returns the individual errors found in the pack. Errors of single
+// blobs are of type *ErrBlobData.
+func (e *ErrPackData) Errors() []error {
+	return e.errs
+}
+
+// ErrBlobData is returned if a blob of a packfile cannot be decrypted or does
+// not match its ID
+type ErrBlobData struct {
+	Handle restic.BlobHandle
+	Err    error
+}
+
+func (e *ErrBlobData) Error() string {
+	return fmt.Sprintf("blob %v: %v", e.Handle.ID, e.Err)
+}
+
+func (e *ErrBlobData) Unwrap() error {
+	return e.Err
+}
+
 type partialReadError struct {
 	err error
 }
@@ -112,7 +133,7 @@ func checkPackInner(ctx context.Context, r *Repository, id restic.ID, blobs []re
 			debug.Log("  check blob %v: %v", val.Handle.ID, val.Handle)
 			if val.Err != nil {
 				debug.Log("  error verifying blob %v: %v", val.Handle.ID, val.Err)
-				blobErrors = append(blobErrors, errors.Errorf("blob %v: %v", val.Handle.ID, val.Err))
+				blobErrors = append(blobErrors, &ErrBlobData{Handle: val.Handle, Err: val.Err})
 			}
 		}
 
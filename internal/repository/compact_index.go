@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// CompactIndex rewrites all index files into as few full index files as
+// possible. The content of the index is not changed.
+func CompactIndex(ctx context.Context, repo *Repository, printer progress.Printer) error {
+	printer.P("loading indexes...\n")
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return err
+	}
+
+	err := rewriteIndexFiles(ctx, repo, nil, nil, nil, printer)
+	if err != nil {
+		return err
+	}
+
+	// drop outdated in-memory index
+	repo.clearIndex()
+	return nil
+}
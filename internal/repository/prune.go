@@ -29,6 +29,10 @@ type PruneOptions struct {
 	RepackCacheableOnly bool
 	RepackSmall         bool
 	RepackUncompressed  bool
+
+	KeepPacks restic.IDSet // packs which must neither be removed nor repacked
+
+	Warmup bool // restore packs from cold storage before repacking, also without the s3-restore feature flag
 }
 
 type PruneStats struct {
@@ -342,6 +346,15 @@ func decidePackAction(ctx context.Context, opts PruneOptions, repo *Repository,
 	bar.SetMax(uint64(len(indexPack)))
 	err := repo.List(ctx, restic.PackFile, func(id restic.ID, packSize int64) error {
 		p, ok := indexPack[id]
+		if opts.KeepPacks.Has(id) {
+			// Pack is protected by the caller => keep pack!
+			stats.Packs.Keep++
+			if ok {
+				delete(indexPack, id)
+				bar.Add(1)
+			}
+			return nil
+		}
 		if !ok {
 			// Pack was not referenced in index and is not used  => immediately remove!
 			printer.V("will remove pack %v as it is unused and not indexed\n", id.Str())
@@ -527,6 +540,15 @@ func (plan *PrunePlan) Stats() PruneStats {
 	return plan.stats
 }
 
+// AffectedPacks returns the packs which the plan removes or repacks.
+func (plan *PrunePlan) AffectedPacks() restic.IDSet {
+	packs := restic.NewIDSet()
+	packs.Merge(plan.removePacksFirst)
+	packs.Merge(plan.repackPacks)
+	packs.Merge(plan.removePacks)
+	return packs
+}
+
 // Execute does the actual pruning:
 // - remove unreferenced packs first
 // - repack given pack files while keeping the given blobs
@@ -564,7 +586,7 @@ func (plan *PrunePlan) Execute(ctx context.Context, printer progress.Printer) er
 		printer.P("repacking packs\n")
 		bar := printer.NewCounter("packs repacked")
 		bar.SetMax(uint64(len(plan.repackPacks)))
-		_, err := Repack(ctx, repo, repo, plan.repackPacks, plan.keepBlobs, bar, printer.P)
+		_, err := Repack(ctx, repo, repo, plan.repackPacks, plan.keepBlobs, plan.opts.Warmup, bar, printer.P)
 		bar.Done()
 		if err != nil {
 			return errors.Fatalf("%s", err)
@@ -27,13 +27,15 @@ type LogFunc func(msg string, args ...interface{})
 // be removed.
 //
 // The map keepBlobs is modified by Repack, it is used to keep track of which
-// blobs have been processed.
+// blobs have been processed. If warmup is set, the packs are restored from
+// cold storage first, which otherwise requires the s3-restore feature flag.
 func Repack(
 	ctx context.Context,
 	repo restic.Repository,
 	dstRepo restic.Repository,
 	packs restic.IDSet,
 	keepBlobs repackBlobSet,
+	warmup bool,
 	p *progress.Counter,
 	logf LogFunc,
 ) (obsoletePacks restic.IDSet, err error) {
@@ -52,7 +54,7 @@ func Repack(
 	dstRepo.StartPackUploader(wgCtx, wg)
 	wg.Go(func() error {
 		var err error
-		obsoletePacks, err = repack(wgCtx, repo, dstRepo, packs, keepBlobs, p, logf)
+		obsoletePacks, err = repack(wgCtx, repo, dstRepo, packs, keepBlobs, warmup, p, logf)
 		return err
 	})
 
@@ -68,12 +70,13 @@ func repack(
 	dstRepo restic.Repository,
 	packs restic.IDSet,
 	keepBlobs repackBlobSet,
+	warmup bool,
 	p *progress.Counter,
 	logf LogFunc,
 ) (obsoletePacks restic.IDSet, err error) {
 	wg, wgCtx := errgroup.WithContext(ctx)
 
-	if feature.Flag.Enabled(feature.S3Restore) {
+	if warmup || feature.Flag.Enabled(feature.S3Restore) {
 		job, err := repo.StartWarmup(ctx, packs)
 		if err != nil {
 			return nil, err
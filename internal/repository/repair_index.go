@@ -13,7 +13,29 @@ type RepairIndexOptions struct {
 	ReadAllPacks bool
 }
 
+// RepairIndexStats describes the changes made by RepairIndexWithStats
+type RepairIndexStats struct {
+	// InvalidIndexes are index files which could not be loaded
+	InvalidIndexes restic.IDs
+	// PacksAdded were missing from the index, PacksReindexed had an
+	// unexpected size and PacksRemoved no longer exist
+	PacksAdded     restic.IDs
+	PacksReindexed restic.IDs
+	PacksRemoved   restic.IDs
+	// IncompletePacks could not be read completely and were skipped
+	IncompletePacks restic.IDs
+	// ObsoleteIndexes is the number of index files which were replaced
+	ObsoleteIndexes int
+}
+
 func RepairIndex(ctx context.Context, repo *Repository, opts RepairIndexOptions, printer progress.Printer) error {
+	_, err := RepairIndexWithStats(ctx, repo, opts, printer)
+	return err
+}
+
+// RepairIndexWithStats works like RepairIndex and returns what was changed
+func RepairIndexWithStats(ctx context.Context, repo *Repository, opts RepairIndexOptions, printer progress.Printer) (RepairIndexStats, error) {
+	var stats RepairIndexStats
 	var obsoleteIndexes restic.IDs
 	packSizeFromList := make(map[restic.ID]int64)
 	packSizeFromIndex := make(map[restic.ID]int64)
@@ -26,7 +48,7 @@ func RepairIndex(ctx context.Context, repo *Repository, opts RepairIndexOptions,
 			return nil
 		})
 		if err != nil {
-			return err
+			return stats, err
 		}
 		repo.clearIndex()
 
@@ -36,21 +58,23 @@ func RepairIndex(ctx context.Context, repo *Repository, opts RepairIndexOptions,
 			if err != nil {
 				printer.E("removing invalid index %v: %v\n", id, err)
 				obsoleteIndexes = append(obsoleteIndexes, id)
+				stats.InvalidIndexes = append(stats.InvalidIndexes, id)
 				return nil
 			}
 			return nil
 		})
 		if err != nil {
-			return err
+			return stats, err
 		}
 
 		packSizeFromIndex, err = pack.Size(ctx, repo, false)
 		if err != nil {
-			return err
+			return stats, err
 		}
 	}
 
 	oldIndexes := repo.idx.IDs()
+	stats.ObsoleteIndexes = len(oldIndexes) + len(obsoleteIndexes)
 
 	printer.P("getting pack files to read...\n")
 	err := repo.List(ctx, restic.PackFile, func(id restic.ID, packSize int64) error {
@@ -62,20 +86,23 @@ func RepairIndex(ctx context.Context, repo *Repository, opts RepairIndexOptions,
 		}
 		if !ok {
 			printer.E("adding pack file to index %v\n", id)
+			stats.PacksAdded = append(stats.PacksAdded, id)
 		} else if size != packSize {
+			stats.PacksReindexed = append(stats.PacksReindexed, id)
 			printer.E("reindexing pack file %v with unexpected size %v instead of %v\n", id, packSize, size)
 		}
 		delete(packSizeFromIndex, id)
 		return nil
 	})
 	if err != nil {
-		return err
+		return stats, err
 	}
 	for id := range packSizeFromIndex {
 		// forget pack files that are referenced in the index but do not exist
 		// when rebuilding the index
 		removePacks.Insert(id)
 		printer.E("removing not found pack file %v\n", id)
+		stats.PacksRemoved = append(stats.PacksRemoved, id)
 	}
 
 	if len(packSizeFromList) > 0 {
@@ -85,26 +112,27 @@ func RepairIndex(ctx context.Context, repo *Repository, opts RepairIndexOptions,
 		invalidFiles, err := repo.createIndexFromPacks(ctx, packSizeFromList, bar)
 		bar.Done()
 		if err != nil {
-			return err
+			return stats, err
 		}
 
 		for _, id := range invalidFiles {
 			printer.V("skipped incomplete pack file: %v\n", id)
 		}
+		stats.IncompletePacks = invalidFiles
 	}
 
 	if err := repo.Flush(ctx); err != nil {
-		return err
+		return stats, err
 	}
 
 	err = rewriteIndexFiles(ctx, repo, removePacks, oldIndexes, obsoleteIndexes, printer)
 	if err != nil {
-		return err
+		return stats, err
 	}
 
 	// drop outdated in-memory index
 	repo.clearIndex()
-	return nil
+	return stats, nil
 }
 
 func rewriteIndexFiles(ctx context.Context, repo *Repository, removePacks restic.IDSet, oldIndexes restic.IDSet, extraObsolete restic.IDs, printer progress.Printer) error {
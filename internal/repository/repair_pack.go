@@ -10,7 +10,23 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// RepairPacksStats describes the blobs handled by RepairPacksWithStats
+type RepairPacksStats struct {
+	// BlobsSalvaged were copied to new pack files, BlobsLost could not be
+	// read from the damaged pack files
+	BlobsSalvaged int
+	BlobsLost     int
+}
+
 func RepairPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printer progress.Printer) error {
+	_, err := RepairPacksWithStats(ctx, repo, ids, printer)
+	return err
+}
+
+// RepairPacksWithStats works like RepairPacks and returns how many blobs
+// could be salvaged
+func RepairPacksWithStats(ctx context.Context, repo *Repository, ids restic.IDSet, printer progress.Printer) (RepairPacksStats, error) {
+	var stats RepairPacksStats
 	wg, wgCtx := errgroup.WithContext(ctx)
 	repo.StartPackUploader(wgCtx, wg)
 
@@ -23,6 +39,7 @@ func RepairPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printe
 		// examine all data the indexes have for the pack file
 		for b := range repo.ListPacksFromIndex(wgCtx, ids) {
 			blobs := b.Blobs
+			stats.BlobsLost += len(blobs)
 			if len(blobs) == 0 {
 				printer.E("no blobs found for pack %v", b.PackID)
 				bar.Add(1)
@@ -38,6 +55,10 @@ func RepairPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printe
 				if !id.Equal(blob.ID) {
 					panic("pack id mismatch during upload")
 				}
+				if err == nil {
+					stats.BlobsSalvaged++
+					stats.BlobsLost--
+				}
 				return err
 			})
 			// ignore truncated file parts
@@ -52,13 +73,13 @@ func RepairPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printe
 	err := wg.Wait()
 	bar.Done()
 	if err != nil {
-		return err
+		return stats, err
 	}
 
 	// remove salvaged packs from index
 	err = rewriteIndexFiles(ctx, repo, ids, nil, nil, printer)
 	if err != nil {
-		return err
+		return stats, err
 	}
 
 	// cleanup
@@ -68,5 +89,5 @@ func RepairPacks(ctx context.Context, repo *Repository, ids restic.IDSet, printe
 	_ = restic.ParallelRemove(ctx, &internalRepository{repo}, ids, restic.PackFile, nil, bar)
 	bar.Done()
 
-	return nil
+	return stats, nil
 }
@@ -51,6 +51,8 @@ type fileRestorer struct {
 	blobsLoader blobsLoaderFn
 
 	startWarmup startWarmupFn
+	// warmup warms up packs also without the s3-restore feature flag
+	warmup bool
 
 	workerCount int
 	filesWriter *filesWriter
@@ -201,7 +203,7 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 	// drop no longer necessary file list
 	r.files = nil
 
-	if feature.Flag.Enabled(feature.S3Restore) {
+	if r.warmup || feature.Flag.Enabled(feature.S3Restore) {
 		warmupJob, err := r.startWarmup(ctx, restic.NewIDSet(packOrder...))
 		if err != nil {
 			return err
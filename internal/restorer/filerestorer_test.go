@@ -249,6 +249,28 @@ func TestFileRestorerBasic(t *testing.T) {
 	}
 }
 
+func TestFileRestorerWarmup(t *testing.T) {
+	content := []TestFile{
+		{
+			name: "file1",
+			blobs: []TestBlob{
+				{"data1-1", "pack1"},
+			},
+		},
+	}
+
+	for _, warmup := range []bool{false, true} {
+		repo := newTestRepo(content)
+		r := newFileRestorer(rtest.TempDir(t), repo.loader, repo.Lookup, 2, false, false, repo.StartWarmup, nil)
+		r.files = repo.files
+		r.warmup = warmup
+
+		rtest.OK(t, r.restoreFiles(context.TODO()))
+		// without the feature flag only an explicit opt-in warms up packs
+		rtest.Equals(t, warmup, len(repo.warmupJobs) != 0)
+	}
+}
+
 func TestFileRestorerPackSkip(t *testing.T) {
 	tempdir := rtest.TempDir(t)
 
@@ -35,6 +35,16 @@ type Restorer struct {
 	SelectFilter func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool)
 
 	XattrSelectFilter func(xattrName string) (xattrSelectedForRestore bool)
+
+	// MapOwner returns the uid and gid to restore for a node, if set.
+	MapOwner func(node *data.Node) (uid, gid uint32)
+
+	// SkipMetadata selects metadata which is not restored.
+	SkipMetadata fs.MetadataSkip
+
+	// MetadataError is called instead of failing the restore if restoring the
+	// metadata of a node fails, if set. Returning nil continues the restore.
+	MetadataError func(location string, err error) error
 }
 
 var restorerAbortOnAllErrors = func(_ string, err error) error { return err }
@@ -45,6 +55,9 @@ type Options struct {
 	Progress  *restoreui.Progress
 	Overwrite OverwriteBehavior
 	Delete    bool
+	// Warmup restores packs from cold storage before reading them, also
+	// without the s3-restore feature flag
+	Warmup bool
 }
 
 type OverwriteBehavior int
@@ -292,10 +305,18 @@ func (res *Restorer) restoreNodeMetadataTo(node *data.Node, target, location str
 	if res.opts.DryRun {
 		return nil
 	}
+	if res.MapOwner != nil {
+		mapped := *node
+		mapped.UID, mapped.GID = res.MapOwner(node)
+		node = &mapped
+	}
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
-	err := fs.NodeRestoreMetadata(node, target, res.Warn, res.XattrSelectFilter)
+	err := fs.NodeRestoreMetadataSkip(node, target, res.Warn, res.XattrSelectFilter, res.SkipMetadata)
 	if err != nil {
 		debug.Log("node.RestoreMetadata(%s) error %v", target, err)
+		if res.MetadataError != nil {
+			return res.MetadataError(location, err)
+		}
 	}
 	return err
 }
@@ -362,6 +383,7 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) (uint64, error)
 		res.repo.Connections(), res.opts.Sparse, res.opts.Delete, res.repo.StartWarmup, res.opts.Progress)
 	filerestorer.Error = res.Error
 	filerestorer.Info = res.Info
+	filerestorer.warmup = res.opts.Warmup
 
 	debug.Log("first pass for %q", dst)
 
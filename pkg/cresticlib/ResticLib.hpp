@@ -30,13 +30,11 @@ struct Config {
     std::string accessKey;  // optional
     std::string secretKey;  // optional
     int parallelism = 4;
-};
-
-// Snapshot information
-struct Snapshot {
-    std::string id;
-    std::string time;
-    std::string hostname;
+    
+    // Only used when initializing a repository
+    int repoVersion = 0;      // 0 for the latest
+    std::string compression;  // optional, "auto" if empty
+    int packSizeMiB = 0;      // 0 for the default
 };
 
 // RAII wrapper for C string
@@ -65,6 +63,39 @@ private:
     char* ptr_;
 };
 
+// RAII wrapper for restic_result
+class Result {
+public:
+    explicit Result(restic_result* ptr) : ptr_(ptr) {}
+    ~Result() { restic_result_free(ptr_); }
+    
+    Result(const Result&) = delete;
+    Result& operator=(const Result&) = delete;
+    
+    // Throws if the call failed
+    const restic_result& get() const {
+        if (!ptr_) {
+            throw ResticException(RESTIC_ERROR_UNKNOWN, "Out of memory");
+        }
+        if (ptr_->code != RESTIC_OK) {
+            if (ptr_->error) {
+                throw ResticException(ptr_->code, ptr_->error);
+            }
+            CString error_msg(restic_get_error_message(ptr_->code));
+            throw ResticException(ptr_->code, error_msg.str());
+        }
+        return *ptr_;
+    }
+    
+    std::string value() const {
+        const restic_result& res = get();
+        return res.value ? std::string(res.value) : std::string();
+    }
+    
+private:
+    restic_result* ptr_;
+};
+
 // Main Repository class
 class Repository {
 public:
@@ -75,13 +106,17 @@ public:
         
         int result;
         if (init_repo) {
-            result = restic_init(
+            const char* compression = config.compression.empty() ? nullptr : config.compression.c_str();
+            result = restic_init_ex(
                 const_cast<char*>(config.repoUrl.c_str()),
                 const_cast<char*>(config.backend.c_str()),
                 const_cast<char*>(config.password.c_str()),
                 const_cast<char*>(access_key),
                 const_cast<char*>(secret_key),
-                config.parallelism
+                config.parallelism,
+                config.repoVersion,
+                const_cast<char*>(compression),
+                config.packSizeMiB
             );
         } else {
             result = restic_open(
@@ -168,6 +203,34 @@ public:
         return snapshot_id_wrapper.str();
     }
     
+    // Preview a backup, returns the JSON backup summary
+    std::string previewBackup(const std::vector<std::string>& paths, const std::vector<std::string>& tags = {}) {
+        if (paths.empty()) {
+            throw ResticException(RESTIC_ERROR_INVALID_PARAMS, "Paths cannot be empty");
+        }
+        
+        std::vector<char*> c_paths;
+        c_paths.reserve(paths.size());
+        for (const auto& path : paths) {
+            c_paths.push_back(const_cast<char*>(path.c_str()));
+        }
+        
+        std::vector<char*> c_tags;
+        c_tags.reserve(tags.size());
+        for (const auto& tag : tags) {
+            c_tags.push_back(const_cast<char*>(tag.c_str()));
+        }
+        
+        Result result(restic_backup_dry_run(
+            repo_id_,
+            c_paths.data(),
+            static_cast<int>(c_paths.size()),
+            tags.empty() ? nullptr : c_tags.data(),
+            static_cast<int>(c_tags.size())
+        ));
+        return result.value();
+    }
+    
     // Restore a snapshot
     void restore(const std::string& snapshot_id, const std::string& target_dir) {
         int result = restic_restore(
@@ -182,35 +245,39 @@ public:
         }
     }
     
-    // List all snapshots
-    std::vector<Snapshot> listSnapshots() {
-        char** ids = nullptr;
-        char** times = nullptr;
-        char** hostnames = nullptr;
-        int count = 0;
-        
-        int result = restic_list_snapshots(repo_id_, &ids, &times, &hostnames, &count);
+    // Extract a single file from a snapshot to out_path
+    void dumpFile(const std::string& snapshot_id, const std::string& path, const std::string& out_path) {
+        int result = restic_dump_file(
+            repo_id_,
+            const_cast<char*>(snapshot_id.c_str()),
+            const_cast<char*>(path.c_str()),
+            const_cast<char*>(out_path.c_str())
+        );
         
         if (result != RESTIC_OK) {
             CString error_msg(restic_get_error_message(result));
             throw ResticException(result, error_msg.str());
         }
+    }
+    
+    // Read a single file from a snapshot into memory
+    std::vector<unsigned char> dumpFile(const std::string& snapshot_id, const std::string& path) {
+        Result result(restic_dump_file_result(
+            repo_id_,
+            const_cast<char*>(snapshot_id.c_str()),
+            const_cast<char*>(path.c_str())
+        ));
         
-        std::vector<Snapshot> snapshots;
-        snapshots.reserve(count);
-        
-        for (int i = 0; i < count; i++) {
-            Snapshot snapshot;
-            snapshot.id = ids[i] ? std::string(ids[i]) : "";
-            snapshot.time = times[i] ? std::string(times[i]) : "";
-            snapshot.hostname = hostnames[i] ? std::string(hostnames[i]) : "";
-            snapshots.push_back(std::move(snapshot));
-        }
-        
-        // Free the arrays
-        restic_free_snapshot_arrays(ids, times, hostnames, count);
-        
-        return snapshots;
+        const restic_result& res = result.get();
+        const unsigned char* bytes = static_cast<const unsigned char*>(res.data);
+        return std::vector<unsigned char>(bytes, bytes + res.data_size);
+    }
+    
+    // List snapshots as a JSON array with all snapshot details, filter_json
+    // restricts the list, e.g. {"hosts": ["laptop"], "limit": 10}
+    std::string listSnapshotsJSON(const std::string& filter_json = "") {
+        Result result(restic_list_snapshots_filtered(repo_id_, const_cast<char*>(filter_json.c_str())));
+        return result.value();
     }
     
     // Check repository integrity
@@ -226,6 +293,14 @@ public:
         return errors;
     }
     
+    // Call a library method with a JSON request, the "repo" field is set
+    // automatically. Returns the JSON response including the error code.
+    std::string call(const std::string& method, const std::string& options_json = "{}") {
+        std::string request = "{\"repo\": " + std::to_string(repo_id_) + ", \"options\": " + options_json + "}";
+        CString response(restic_call(const_cast<char*>(method.c_str()), const_cast<char*>(request.c_str())));
+        return response.str();
+    }
+    
     // Get library version
     static std::string getVersion() {
         CString version(restic_get_version());
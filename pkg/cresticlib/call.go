@@ -0,0 +1,522 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/pkg/resticlib"
+)
+
+// callRequest is the envelope of all restic_call requests. Repo is the
+// handle returned by the init and open methods, Options holds the options
+// struct of the called operation.
+type callRequest struct {
+	Repo         int                    `json:"repo,omitempty"`
+	Destination  int                    `json:"destination,omitempty"`
+	SnapshotID   resticlib.SnapshotID   `json:"snapshot_id,omitempty"`
+	SnapshotIDs  []resticlib.SnapshotID `json:"snapshot_ids,omitempty"`
+	Ref          string                 `json:"ref,omitempty"`
+	Path         string                 `json:"path,omitempty"`
+	Pattern      string                 `json:"pattern,omitempty"`
+	Depth        resticlib.CheckDepth   `json:"depth,omitempty"`
+	Wait         bool                   `json:"wait,omitempty"`
+	ObjectType   resticlib.ObjectType   `json:"object_type,omitempty"`
+	ID           string                 `json:"id,omitempty"`
+	PackIDs      []string               `json:"pack_ids,omitempty"`
+	ReadAllPacks bool                   `json:"read_all_packs,omitempty"`
+	Until        time.Time              `json:"until,omitempty"`
+	Password     string                 `json:"password,omitempty"`
+	KeyID        resticlib.KeyID        `json:"key_id,omitempty"`
+	Options      json.RawMessage        `json:"options,omitempty"`
+}
+
+// callResponse is the envelope of all restic_call responses
+type callResponse struct {
+	Code   int         `json:"code"`
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// callConfig are the repository settings accepted by the init and open methods
+type callConfig struct {
+	RepoURL        string                `json:"repo_url"`
+	Backend        resticlib.BackendKind `json:"backend"`
+	Password       string                `json:"password"`
+	AccessKey      string                `json:"access_key,omitempty"`
+	SecretKey      string                `json:"secret_key,omitempty"`
+	Token          string                `json:"token,omitempty"`
+	Endpoint       string                `json:"endpoint,omitempty"`
+	Parallelism    int                   `json:"parallelism,omitempty"`
+	Profile        resticlib.Profile     `json:"profile,omitempty"`
+	RepoVersion    uint                  `json:"repo_version,omitempty"`
+	Compression    resticlib.Compression `json:"compression,omitempty"`
+	PackSizeMiB    uint                  `json:"pack_size_mib,omitempty"`
+	MemoryLimitMiB uint                  `json:"memory_limit_mib,omitempty"`
+	TempDir        string                `json:"temp_dir,omitempty"`
+	PathIndexDir   string                `json:"path_index_dir,omitempty"`
+}
+
+func (c callConfig) config() resticlib.Config {
+	cfg := resticlib.Config{
+		RepoURL:        c.RepoURL,
+		Backend:        c.Backend,
+		Password:       []byte(c.Password),
+		Endpoint:       c.Endpoint,
+		Parallelism:    c.Parallelism,
+		Profile:        c.Profile,
+		RepoVersion:    c.RepoVersion,
+		Compression:    c.Compression,
+		PackSizeMiB:    c.PackSizeMiB,
+		MemoryLimitMiB: c.MemoryLimitMiB,
+		TempDir:        c.TempDir,
+		PathIndexDir:   c.PathIndexDir,
+	}
+	if c.AccessKey != "" || c.SecretKey != "" || c.Token != "" {
+		cfg.Credentials = &resticlib.Credentials{
+			AccessKey: c.AccessKey,
+			SecretKey: c.SecretKey,
+			Token:     c.Token,
+		}
+	}
+	return cfg
+}
+
+// callError carries the error code returned for a failed call
+type callError struct {
+	code int
+	err  error
+}
+
+func (e *callError) Error() string { return e.err.Error() }
+func (e *callError) Unwrap() error { return e.err }
+
+// withCode attaches an error code to err
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &callError{code: code, err: err}
+}
+
+type callHandler func(ctx context.Context, req callRequest) (interface{}, error)
+
+// callMethods maps the method names of restic_call to their handlers
+var callMethods = map[string]callHandler{
+	"version": func(_ context.Context, _ callRequest) (interface{}, error) {
+		return "resticlib-v0.1.0", nil
+	},
+
+	"init": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return openRepo(ctx, req, resticlib.Init, RESTIC_ERROR_REPO_NOT_FOUND)
+	},
+	"open": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return openRepo(ctx, req, resticlib.Open, RESTIC_ERROR_INVALID_PASSWORD)
+	},
+	"close": func(_ context.Context, req callRequest) (interface{}, error) {
+		if code := restic_close(C.int(req.Repo)); code != RESTIC_OK {
+			return nil, withCode(int(code), errors.New("failed to close repository"))
+		}
+		return nil, nil
+	},
+
+	"backup": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.BackupOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			summary, err := repo.Backup(ctx, opts)
+			return summary, withCode(RESTIC_ERROR_BACKUP_FAILED, err)
+		})
+	},
+	"restore": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.RestoreOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			report, err := repo.Restore(ctx, req.SnapshotID, opts)
+			return report, withCode(RESTIC_ERROR_RESTORE_FAILED, err)
+		})
+	},
+	"verify_restore": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.VerifyRestore(ctx, req.SnapshotID, req.Path)
+		})
+	},
+	"dump_file": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts struct {
+			Target string `json:"target"`
+		}
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return nil, withCode(RESTIC_ERROR_RESTORE_FAILED, dumpToFile(ctx, repo, req.SnapshotID, req.Path, opts.Target))
+		})
+	},
+	"warmup": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Warmup(ctx, req.SnapshotID, req.Wait)
+		})
+	},
+	"dump_archive": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts struct {
+			Format resticlib.ArchiveFormat `json:"format"`
+			Target string                  `json:"target"`
+		}
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return nil, withCode(RESTIC_ERROR_RESTORE_FAILED, writeFile(opts.Target, func(w io.Writer) error {
+				return repo.DumpArchive(ctx, req.SnapshotID, req.Path, opts.Format, w)
+			}))
+		})
+	},
+	"ls": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			entries := []resticlib.LsEntry{}
+			err := repo.Ls(ctx, req.SnapshotID, func(entry resticlib.LsEntry) error {
+				entries = append(entries, entry)
+				return nil
+			})
+			return entries, err
+		})
+	},
+	"cat": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			// encoded as base64 string, blobs are not necessarily JSON
+			return repo.Cat(ctx, req.ObjectType, req.ID)
+		})
+	},
+	"stats": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.StatsOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Stats(ctx, opts)
+		})
+	},
+
+	"snapshots": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var filter resticlib.SnapshotFilter
+		return withRepo(req, &filter, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Snapshots(ctx, filter)
+		})
+	},
+	"resolve_snapshot": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var filter resticlib.SnapshotFilter
+		return withRepo(req, &filter, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.ResolveSnapshot(ctx, req.Ref, filter)
+		})
+	},
+	"change_summary": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.ChangeSummary(ctx, req.SnapshotID)
+		})
+	},
+	"find_paths": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.FindPaths(ctx, req.Pattern)
+		})
+	},
+	"find": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.FindOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Find(ctx, opts)
+		})
+	},
+	"update_path_index": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return nil, repo.UpdatePathIndex(ctx)
+		})
+	},
+	"sync": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.SyncOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			dst, exists := lookupRepo(C.int(req.Destination))
+			if !exists {
+				return nil, withCode(RESTIC_ERROR_INVALID_HANDLE, errors.New("destination repository not found"))
+			}
+			return repo.SyncTo(ctx, dst, opts)
+		})
+	},
+	"export_snapshot": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts struct {
+			resticlib.ExportOptions
+			Target string `json:"target"`
+		}
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			var report resticlib.ExportReport
+			err := writeFile(opts.Target, func(w io.Writer) (err error) {
+				report, err = repo.ExportSnapshot(ctx, req.SnapshotID, w, opts.ExportOptions)
+				return err
+			})
+			return report, err
+		})
+	},
+	"import_snapshot": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts struct {
+			Source string `json:"source"`
+		}
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			if opts.Source == "" {
+				return nil, withCode(RESTIC_ERROR_INVALID_PARAMS, errors.New("source is required"))
+			}
+			f, err := os.Open(opts.Source)
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = f.Close() }()
+			return repo.ImportSnapshot(ctx, f)
+		})
+	},
+
+	"retain_snapshot": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.RetainSnapshot(ctx, req.SnapshotID, req.Until)
+		})
+	},
+	"delete_snapshots": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.DeleteSnapshots(ctx, req.SnapshotIDs)
+		})
+	},
+	"forget": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var policy resticlib.ForgetPolicy
+		return withRepo(req, &policy, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Forget(ctx, policy)
+		})
+	},
+	"prune": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.PruneOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Prune(ctx, opts)
+		})
+	},
+	"compact_index": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.CompactIndexOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.CompactIndex(ctx, opts)
+		})
+	},
+	"rewrite": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.RewriteOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Rewrite(ctx, req.SnapshotIDs, opts)
+		})
+	},
+	"check": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.CheckOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			if opts.Depth == "" {
+				opts.Depth = checkDepth(req.Depth)
+			}
+			return repo.Check(ctx, opts)
+		})
+	},
+	"check_snapshot": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.CheckSnapshot(ctx, req.SnapshotID, checkDepth(req.Depth))
+		})
+	},
+	"repair_index": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.RepairIndexOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.RepairIndex(ctx, opts)
+		})
+	},
+	"rebuild_index": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.RebuildIndex(ctx, req.ReadAllPacks)
+		})
+	},
+	"repair_packs": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.RepairPacksOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.RepairPacks(ctx, req.PackIDs, opts)
+		})
+	},
+	"repair_snapshots": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.RepairSnapshotsOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.RepairSnapshots(ctx, opts)
+		})
+	},
+	"unlock": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return nil, repo.Unlock(ctx)
+		})
+	},
+	"unlock_all": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.UnlockAll(ctx)
+		})
+	},
+
+	"password_index": func(_ context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.PasswordIndex(), nil
+		})
+	},
+	"keys": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.Keys(ctx)
+		})
+	},
+	"add_key": func(ctx context.Context, req callRequest) (interface{}, error) {
+		var opts resticlib.AddKeyOptions
+		return withRepo(req, &opts, func(repo resticlib.Repository) (interface{}, error) {
+			return repo.AddKey(ctx, []byte(req.Password), opts)
+		})
+	},
+	"remove_key": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return nil, repo.RemoveKey(ctx, req.KeyID)
+		})
+	},
+	"change_password": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			return nil, repo.ChangePassword(ctx, []byte(req.Password))
+		})
+	},
+
+	"shutdown": func(ctx context.Context, req callRequest) (interface{}, error) {
+		return withRepo(req, nil, func(repo resticlib.Repository) (interface{}, error) {
+			if err := repo.Shutdown(ctx); err != nil {
+				return nil, err
+			}
+			unregisterRepo(C.int(req.Repo))
+			return nil, nil
+		})
+	},
+}
+
+func init() {
+	// registered here, the handler refers to callMethods itself
+	callMethods["methods"] = func(_ context.Context, _ callRequest) (interface{}, error) {
+		names := make([]string, 0, len(callMethods))
+		for name := range callMethods {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+}
+
+// openRepo opens or initializes a repository and returns its handle
+func openRepo(ctx context.Context, req callRequest, open func(context.Context, resticlib.Config) (resticlib.Repository, error), code int) (interface{}, error) {
+	var cfg callConfig
+	if err := decodeJSON(string(req.Options), &cfg); err != nil {
+		return nil, withCode(RESTIC_ERROR_INVALID_PARAMS, err)
+	}
+	if cfg.RepoURL == "" || cfg.Backend == "" || cfg.Password == "" {
+		return nil, withCode(RESTIC_ERROR_INVALID_PARAMS, errors.New("repo_url, backend and password are required"))
+	}
+
+	repo, err := open(ctx, cfg.config())
+	if err != nil {
+		return nil, withCode(code, err)
+	}
+	return map[string]int{"repo": int(registerRepo(repo))}, nil
+}
+
+// withRepo decodes the options of the request into opts, if not nil, and
+// runs fn with the repository of the request
+func withRepo(req callRequest, opts interface{}, fn func(repo resticlib.Repository) (interface{}, error)) (interface{}, error) {
+	repo, exists := lookupRepo(C.int(req.Repo))
+	if !exists {
+		return nil, withCode(RESTIC_ERROR_INVALID_HANDLE, errors.New("repository not found"))
+	}
+	if opts != nil {
+		if err := decodeJSON(string(req.Options), opts); err != nil {
+			return nil, withCode(RESTIC_ERROR_INVALID_PARAMS, err)
+		}
+	}
+	return fn(repo)
+}
+
+// dumpToFile writes a single file from a snapshot to target
+func dumpToFile(ctx context.Context, repo resticlib.Repository, snapshotID resticlib.SnapshotID, path string, target string) error {
+	return writeFile(target, func(w io.Writer) error {
+		return repo.DumpFile(ctx, snapshotID, path, w)
+	})
+}
+
+// writeFile creates target and writes its content with fn
+func writeFile(target string, fn func(w io.Writer) error) error {
+	if target == "" {
+		return withCode(RESTIC_ERROR_INVALID_PARAMS, errors.New("target is required"))
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	err = fn(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// do not leave a truncated file behind
+		_ = os.Remove(target)
+	}
+	return err
+}
+
+// checkDepth returns the default depth if none is set
+func checkDepth(depth resticlib.CheckDepth) resticlib.CheckDepth {
+	if depth == "" {
+		return resticlib.CheckDepthDefault
+	}
+	return depth
+}
+
+// call dispatches a JSON request to the handler of method and returns the
+// JSON encoded response
+func call(ctx context.Context, method string, request string) string {
+	resp := callResponse{Code: RESTIC_OK}
+
+	handler, ok := callMethods[method]
+	var req callRequest
+	var err error
+	if !ok {
+		err = withCode(RESTIC_ERROR_INVALID_PARAMS, fmt.Errorf("unknown method %q", method))
+	} else if err = decodeJSON(request, &req); err != nil {
+		err = withCode(RESTIC_ERROR_INVALID_PARAMS, err)
+	} else {
+		resp.Result, err = handler(ctx, req)
+	}
+
+	if err != nil {
+		resp.Code = RESTIC_ERROR_UNKNOWN
+		var ce *callError
+		if errors.As(err, &ce) {
+			resp.Code = ce.code
+		}
+		resp.Error = err.Error()
+		resp.Result = nil
+	}
+
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		buf, _ = json.Marshal(callResponse{Code: RESTIC_ERROR_UNKNOWN, Error: err.Error()})
+	}
+	return string(buf)
+}
+
+// restic_call runs the library method named method with a JSON request and
+// returns a JSON response of the form {"code": 0, "error": "...", "result": ...}.
+// The request contains the repository handle in "repo" and the options of
+// the operation in "options", see the methods method for all method names.
+//
+//export restic_call
+func restic_call(method *C.char, request_json *C.char) *C.char {
+	if method == nil {
+		return C.CString(call(context.Background(), "", ""))
+	}
+
+	var request string
+	if request_json != nil {
+		request = C.GoString(request_json)
+	}
+	return C.CString(call(context.Background(), C.GoString(method), request))
+}
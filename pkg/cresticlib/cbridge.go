@@ -2,13 +2,13 @@ package main
 
 /*
 #include <stdlib.h>
-#include <string.h>
 */
 import "C"
 
 import (
 	"context"
 	"fmt"
+	"sync"
 	"unsafe"
 
 	"github.com/restic/restic/pkg/resticlib"
@@ -22,21 +22,79 @@ const (
 	RESTIC_ERROR_INVALID_PASSWORD = -3
 	RESTIC_ERROR_BACKUP_FAILED    = -4
 	RESTIC_ERROR_RESTORE_FAILED   = -5
+	RESTIC_ERROR_INVALID_HANDLE   = -6
 	RESTIC_ERROR_UNKNOWN          = -99
 )
 
 // ResticRepo is an opaque pointer to a repository instance
 type ResticRepo uintptr
 
-// Global repository storage (in real implementation, you'd use a proper registry)
-var repositories = make(map[ResticRepo]resticlib.Repository)
-var nextRepoID ResticRepo = 1
+// Global repository storage, handles are never reused so that stale handles
+// can be detected after restic_close
+var (
+	repositoriesMu sync.Mutex
+	repositories              = make(map[ResticRepo]resticlib.Repository)
+	nextRepoID     ResticRepo = 1
+)
+
+// registerRepo stores a repository and returns its handle
+func registerRepo(repo resticlib.Repository) C.int {
+	repositoriesMu.Lock()
+	defer repositoriesMu.Unlock()
+
+	repoID := nextRepoID
+	nextRepoID++
+	repositories[repoID] = repo
+	return C.int(repoID)
+}
+
+// lookupRepo returns the repository of a handle, it reports false for
+// handles which were closed or never issued
+func lookupRepo(repo_id C.int) (resticlib.Repository, bool) {
+	repositoriesMu.Lock()
+	defer repositoriesMu.Unlock()
+
+	repo, exists := repositories[ResticRepo(repo_id)]
+	return repo, exists
+}
+
+// unregisterRepo removes the handle of a closed repository
+func unregisterRepo(repo_id C.int) {
+	repositoriesMu.Lock()
+	defer repositoriesMu.Unlock()
+
+	delete(repositories, ResticRepo(repo_id))
+}
+
+// goStrings converts a C array of strings to a Go slice, it returns nil for
+// empty or NULL arrays
+func goStrings(arr **C.char, count C.int) []string {
+	if arr == nil || count <= 0 {
+		return nil
+	}
+
+	items := make([]string, int(count))
+	cItems := (*[1 << 30]*C.char)(unsafe.Pointer(arr))[:count:count]
+	for i, cItem := range cItems {
+		items[i] = C.GoString(cItem)
+	}
+	return items
+}
 
 // restic_init initializes a new repository
 //
 //export restic_init
 func restic_init(repo_url *C.char, backend *C.char, password *C.char, access_key *C.char, secret_key *C.char, parallelism C.int) C.int {
-	if repo_url == nil || backend == nil || password == nil {
+	return restic_init_ex(repo_url, backend, password, access_key, secret_key, parallelism, 0, nil, 0)
+}
+
+// restic_init_ex initializes a new repository with the given format version,
+// compression mode and pack size, zero values and NULL use the defaults
+//
+//export restic_init_ex
+func restic_init_ex(repo_url *C.char, backend *C.char, password *C.char, access_key *C.char, secret_key *C.char, parallelism C.int,
+	repo_version C.int, compression *C.char, pack_size_mib C.int) C.int {
+	if repo_url == nil || backend == nil || password == nil || repo_version < 0 || pack_size_mib < 0 {
 		return RESTIC_ERROR_INVALID_PARAMS
 	}
 
@@ -47,6 +105,12 @@ func restic_init(repo_url *C.char, backend *C.char, password *C.char, access_key
 		Backend:     resticlib.BackendKind(C.GoString(backend)),
 		Password:    []byte(C.GoString(password)),
 		Parallelism: int(parallelism),
+		RepoVersion: uint(repo_version),
+		PackSizeMiB: uint(pack_size_mib),
+	}
+
+	if compression != nil {
+		cfg.Compression = resticlib.Compression(C.GoString(compression))
 	}
 
 	if access_key != nil && secret_key != nil {
@@ -61,11 +125,7 @@ func restic_init(repo_url *C.char, backend *C.char, password *C.char, access_key
 		return RESTIC_ERROR_REPO_NOT_FOUND
 	}
 
-	repoID := nextRepoID
-	nextRepoID++
-	repositories[repoID] = repo
-
-	return C.int(repoID)
+	return registerRepo(repo)
 }
 
 // restic_open opens an existing repository
@@ -97,20 +157,16 @@ func restic_open(repo_url *C.char, backend *C.char, password *C.char, access_key
 		return RESTIC_ERROR_INVALID_PASSWORD
 	}
 
-	repoID := nextRepoID
-	nextRepoID++
-	repositories[repoID] = repo
-
-	return C.int(repoID)
+	return registerRepo(repo)
 }
 
 // restic_backup creates a backup and returns snapshot ID as string
 //
 //export restic_backup
 func restic_backup(repo_id C.int, paths **C.char, paths_count C.int, tags **C.char, tags_count C.int, snapshot_id_out **C.char) C.int {
-	repo, exists := repositories[ResticRepo(repo_id)]
+	repo, exists := lookupRepo(repo_id)
 	if !exists {
-		return RESTIC_ERROR_INVALID_PARAMS
+		return RESTIC_ERROR_INVALID_HANDLE
 	}
 
 	if paths == nil || paths_count <= 0 {
@@ -119,33 +175,17 @@ func restic_backup(repo_id C.int, paths **C.char, paths_count C.int, tags **C.ch
 
 	ctx := context.Background()
 
-	// Convert C arrays to Go slices
-	pathSlice := make([]string, int(paths_count))
-	cPaths := (*[1 << 30]*C.char)(unsafe.Pointer(paths))[:paths_count:paths_count]
-	for i, cPath := range cPaths {
-		pathSlice[i] = C.GoString(cPath)
-	}
-
-	var tagSlice []string
-	if tags_count > 0 && tags != nil {
-		tagSlice = make([]string, int(tags_count))
-		cTags := (*[1 << 30]*C.char)(unsafe.Pointer(tags))[:tags_count:tags_count]
-		for i, cTag := range cTags {
-			tagSlice[i] = C.GoString(cTag)
-		}
-	}
-
 	backupOpts := resticlib.BackupOptions{
-		Paths: pathSlice,
-		Tags:  tagSlice,
+		Paths: goStrings(paths, paths_count),
+		Tags:  goStrings(tags, tags_count),
 	}
 
-	snapshotID, err := repo.Backup(ctx, backupOpts)
+	summary, err := repo.Backup(ctx, backupOpts)
 	if err != nil {
 		return RESTIC_ERROR_BACKUP_FAILED
 	}
 
-	*snapshot_id_out = C.CString(string(snapshotID))
+	*snapshot_id_out = C.CString(string(summary.SnapshotID))
 	return RESTIC_OK
 }
 
@@ -153,9 +193,9 @@ func restic_backup(repo_id C.int, paths **C.char, paths_count C.int, tags **C.ch
 //
 //export restic_restore
 func restic_restore(repo_id C.int, snapshot_id *C.char, target_dir *C.char) C.int {
-	repo, exists := repositories[ResticRepo(repo_id)]
+	repo, exists := lookupRepo(repo_id)
 	if !exists {
-		return RESTIC_ERROR_INVALID_PARAMS
+		return RESTIC_ERROR_INVALID_HANDLE
 	}
 
 	if snapshot_id == nil || target_dir == nil {
@@ -169,7 +209,7 @@ func restic_restore(repo_id C.int, snapshot_id *C.char, target_dir *C.char) C.in
 		Overwrite: true,
 	}
 
-	err := repo.Restore(ctx, resticlib.SnapshotID(C.GoString(snapshot_id)), restoreOpts)
+	_, err := repo.Restore(ctx, resticlib.SnapshotID(C.GoString(snapshot_id)), restoreOpts)
 	if err != nil {
 		return RESTIC_ERROR_RESTORE_FAILED
 	}
@@ -177,47 +217,26 @@ func restic_restore(repo_id C.int, snapshot_id *C.char, target_dir *C.char) C.in
 	return RESTIC_OK
 }
 
-// restic_list_snapshots lists all snapshots in the repository
+// restic_dump_file writes a single file from a snapshot to out_path
 //
-//export restic_list_snapshots
-func restic_list_snapshots(repo_id C.int, ids_out ***C.char, times_out ***C.char, hostnames_out ***C.char, count_out *C.int) C.int {
-	repo, exists := repositories[ResticRepo(repo_id)]
+//export restic_dump_file
+func restic_dump_file(repo_id C.int, snapshot_id *C.char, path *C.char, out_path *C.char) C.int {
+	repo, exists := lookupRepo(repo_id)
 	if !exists {
+		return RESTIC_ERROR_INVALID_HANDLE
+	}
+
+	if snapshot_id == nil || path == nil || out_path == nil {
 		return RESTIC_ERROR_INVALID_PARAMS
 	}
 
 	ctx := context.Background()
 
-	snapshots, err := repo.Snapshots(ctx, resticlib.SnapshotFilter{})
+	err := dumpToFile(ctx, repo, resticlib.SnapshotID(C.GoString(snapshot_id)), C.GoString(path), C.GoString(out_path))
 	if err != nil {
-		return RESTIC_ERROR_UNKNOWN
-	}
-
-	if len(snapshots) == 0 {
-		*count_out = 0
-		return RESTIC_OK
-	}
-
-	// Allocate arrays for snapshot data
-	count := len(snapshots)
-	idsArray := C.malloc(C.size_t(count) * C.size_t(unsafe.Sizeof(uintptr(0))))
-	timesArray := C.malloc(C.size_t(count) * C.size_t(unsafe.Sizeof(uintptr(0))))
-	hostnamesArray := C.malloc(C.size_t(count) * C.size_t(unsafe.Sizeof(uintptr(0))))
-
-	cIds := (*[1 << 30]*C.char)(idsArray)
-	cTimes := (*[1 << 30]*C.char)(timesArray)
-	cHostnames := (*[1 << 30]*C.char)(hostnamesArray)
-
-	for i, snapshot := range snapshots {
-		cIds[i] = C.CString(string(snapshot.ID))
-		cTimes[i] = C.CString(snapshot.Time)
-		cHostnames[i] = C.CString(snapshot.Hostname)
+		return RESTIC_ERROR_RESTORE_FAILED
 	}
 
-	*ids_out = (**C.char)(idsArray)
-	*times_out = (**C.char)(timesArray)
-	*hostnames_out = (**C.char)(hostnamesArray)
-	*count_out = C.int(count)
 	return RESTIC_OK
 }
 
@@ -225,14 +244,14 @@ func restic_list_snapshots(repo_id C.int, ids_out ***C.char, times_out ***C.char
 //
 //export restic_check
 func restic_check(repo_id C.int, errors_out *C.int) C.int {
-	repo, exists := repositories[ResticRepo(repo_id)]
+	repo, exists := lookupRepo(repo_id)
 	if !exists {
-		return RESTIC_ERROR_INVALID_PARAMS
+		return RESTIC_ERROR_INVALID_HANDLE
 	}
 
 	ctx := context.Background()
 
-	report, err := repo.Check(ctx, resticlib.CheckDepthDefault)
+	report, err := repo.Check(ctx, resticlib.CheckOptions{Depth: resticlib.CheckDepthDefault})
 	if err != nil {
 		return RESTIC_ERROR_UNKNOWN
 	}
@@ -245,13 +264,16 @@ func restic_check(repo_id C.int, errors_out *C.int) C.int {
 //
 //export restic_close
 func restic_close(repo_id C.int) C.int {
-	repo, exists := repositories[ResticRepo(repo_id)]
+	repo, exists := lookupRepo(repo_id)
 	if !exists {
-		return RESTIC_ERROR_INVALID_PARAMS
+		return RESTIC_ERROR_INVALID_HANDLE
 	}
 
-	repo.Close()
-	delete(repositories, ResticRepo(repo_id))
+	if err := repo.Close(); err != nil {
+		// keep the handle, operations may still be running
+		return RESTIC_ERROR_UNKNOWN
+	}
+	unregisterRepo(repo_id)
 	return RESTIC_OK
 }
 
@@ -264,41 +286,6 @@ func restic_free_string(str *C.char) {
 	}
 }
 
-// restic_free_snapshot_arrays frees arrays returned by restic_list_snapshots
-//
-//export restic_free_snapshot_arrays
-func restic_free_snapshot_arrays(ids **C.char, times **C.char, hostnames **C.char, count C.int) {
-	if ids != nil {
-		cIds := (*[1 << 30]*C.char)(unsafe.Pointer(ids))
-		for i := 0; i < int(count); i++ {
-			if cIds[i] != nil {
-				C.free(unsafe.Pointer(cIds[i]))
-			}
-		}
-		C.free(unsafe.Pointer(ids))
-	}
-
-	if times != nil {
-		cTimes := (*[1 << 30]*C.char)(unsafe.Pointer(times))
-		for i := 0; i < int(count); i++ {
-			if cTimes[i] != nil {
-				C.free(unsafe.Pointer(cTimes[i]))
-			}
-		}
-		C.free(unsafe.Pointer(times))
-	}
-
-	if hostnames != nil {
-		cHostnames := (*[1 << 30]*C.char)(unsafe.Pointer(hostnames))
-		for i := 0; i < int(count); i++ {
-			if cHostnames[i] != nil {
-				C.free(unsafe.Pointer(cHostnames[i]))
-			}
-		}
-		C.free(unsafe.Pointer(hostnames))
-	}
-}
-
 // restic_get_version returns the library version
 //
 //export restic_get_version
@@ -323,6 +310,8 @@ func restic_get_error_message(error_code C.int) *C.char {
 		return C.CString("Backup operation failed")
 	case RESTIC_ERROR_RESTORE_FAILED:
 		return C.CString("Restore operation failed")
+	case RESTIC_ERROR_INVALID_HANDLE:
+		return C.CString("Repository handle is closed or invalid")
 	default:
 		return C.CString("Unknown error")
 	}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/pkg/resticlib"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	var filter resticlib.SnapshotFilter
+	if err := decodeJSON(`{"hosts": ["laptop"], "tags": ["daily"], "limit": 5}`, &filter); err != nil {
+		t.Fatalf("decodeJSON failed: %v", err)
+	}
+	if len(filter.Hosts) != 1 || filter.Hosts[0] != "laptop" || filter.Limit != 5 {
+		t.Errorf("Unexpected filter: %+v", filter)
+	}
+
+	if err := decodeJSON(`{"host": ["laptop"]}`, &filter); err == nil {
+		t.Error("Expected unknown field to be rejected")
+	}
+	if err := decodeJSON("", &filter); err != nil {
+		t.Errorf("Expected empty request to be accepted: %v", err)
+	}
+}
+
+func TestDoubleClose(t *testing.T) {
+	repo, err := resticlib.Init(context.Background(), resticlib.Config{
+		RepoURL:  "local:" + filepath.Join(t.TempDir(), "repo"),
+		Backend:  resticlib.BackendLocal,
+		Password: []byte("testpassword123"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	id := registerRepo(repo)
+	if code := restic_close(id); code != RESTIC_OK {
+		t.Fatalf("Expected first close to succeed, got %d", code)
+	}
+	if code := restic_close(id); code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected double close to return %d, got %d", RESTIC_ERROR_INVALID_HANDLE, code)
+	}
+	if code := restic_check(id, nil); code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected check on closed handle to return %d, got %d", RESTIC_ERROR_INVALID_HANDLE, code)
+	}
+
+	res := restic_list_snapshots_result(id)
+	defer restic_result_free(res)
+	if res.code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected result code %d for closed handle, got %d", RESTIC_ERROR_INVALID_HANDLE, res.code)
+	}
+}
+
+func TestNeverIssuedHandle(t *testing.T) {
+	if code := restic_close(0); code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected close of handle 0 to return %d, got %d", RESTIC_ERROR_INVALID_HANDLE, code)
+	}
+	if code := restic_close(-1); code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected close of handle -1 to return %d, got %d", RESTIC_ERROR_INVALID_HANDLE, code)
+	}
+	if code := restic_close(1 << 20); code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected close of unknown handle to return %d, got %d", RESTIC_ERROR_INVALID_HANDLE, code)
+	}
+}
+
+func TestCall(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	invoke := func(method string, request interface{}, result interface{}) callResponse {
+		t.Helper()
+		buf, err := json.Marshal(request)
+		if err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+		var resp struct {
+			callResponse
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(call(ctx, method, string(buf))), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result != nil && resp.Code == RESTIC_OK {
+			if err := json.Unmarshal(resp.Result, result); err != nil {
+				t.Fatalf("Failed to decode result of %s: %v", method, err)
+			}
+		}
+		return resp.callResponse
+	}
+
+	var handle struct {
+		Repo int `json:"repo"`
+	}
+	resp := invoke("init", map[string]interface{}{"options": map[string]interface{}{
+		"repo_url": "local:" + filepath.Join(dir, "repo"),
+		"backend":  "local",
+		"password": "testpassword123",
+	}}, &handle)
+	if resp.Code != RESTIC_OK {
+		t.Fatalf("init failed: %+v", resp)
+	}
+
+	var summary resticlib.BackupSummary
+	resp = invoke("backup", map[string]interface{}{
+		"repo":    handle.Repo,
+		"options": map[string]interface{}{"paths": []string{dataDir}},
+	}, &summary)
+	if resp.Code != RESTIC_OK || summary.SnapshotID == "" {
+		t.Fatalf("backup failed: %+v", resp)
+	}
+
+	var snapshots []resticlib.Snapshot
+	resp = invoke("snapshots", map[string]interface{}{"repo": handle.Repo}, &snapshots)
+	if resp.Code != RESTIC_OK || len(snapshots) != 1 || snapshots[0].ID != summary.SnapshotID {
+		t.Fatalf("Unexpected snapshots: %+v %+v", resp, snapshots)
+	}
+
+	resp = invoke("backup", map[string]interface{}{
+		"repo":    handle.Repo,
+		"options": map[string]interface{}{"pathz": []string{dataDir}},
+	}, nil)
+	if resp.Code != RESTIC_ERROR_INVALID_PARAMS {
+		t.Errorf("Expected unknown option to be rejected: %+v", resp)
+	}
+
+	var entries []resticlib.LsEntry
+	resp = invoke("ls", map[string]interface{}{"repo": handle.Repo, "snapshot_id": summary.SnapshotID}, &entries)
+	if resp.Code != RESTIC_OK || len(entries) == 0 || entries[len(entries)-1].Path != filepath.ToSlash(filepath.Join(dataDir, "file.txt")) {
+		t.Errorf("Unexpected ls result: %+v %+v", resp, entries)
+	}
+
+	var keys []resticlib.KeyInfo
+	resp = invoke("keys", map[string]interface{}{"repo": handle.Repo}, &keys)
+	if resp.Code != RESTIC_OK || len(keys) != 1 || !keys[0].Current {
+		t.Errorf("Unexpected keys: %+v %+v", resp, keys)
+	}
+
+	if resp := invoke("close", map[string]interface{}{"repo": handle.Repo}, nil); resp.Code != RESTIC_OK {
+		t.Fatalf("close failed: %+v", resp)
+	}
+	if resp := invoke("check", map[string]interface{}{"repo": handle.Repo}, nil); resp.Code != RESTIC_ERROR_INVALID_HANDLE {
+		t.Errorf("Expected closed handle to be rejected: %+v", resp)
+	}
+	if resp := invoke("frobnicate", nil, nil); resp.Code != RESTIC_ERROR_INVALID_PARAMS || resp.Error == "" {
+		t.Errorf("Expected unknown method to be rejected: %+v", resp)
+	}
+}
+
+func TestCallMethods(t *testing.T) {
+	// Mount and StartHealthChecks run until they are canceled
+	unsupported := map[string]bool{"Mount": true, "StartHealthChecks": true}
+	renamed := map[string]string{"SyncTo": "sync"}
+	// the report variants replace the plain calls
+	suffixes := regexp.MustCompile(`(WithSummary|WithReport|WithOptions)$`)
+	words := regexp.MustCompile(`([a-z])([A-Z])`)
+
+	repoType := reflect.TypeOf((*resticlib.Repository)(nil)).Elem()
+	for i := 0; i < repoType.NumMethod(); i++ {
+		name := repoType.Method(i).Name
+		if unsupported[name] {
+			continue
+		}
+		method := strings.ToLower(words.ReplaceAllString(suffixes.ReplaceAllString(name, ""), "${1}_${2}"))
+		if m, ok := renamed[name]; ok {
+			method = m
+		}
+		if _, ok := callMethods[method]; !ok {
+			t.Errorf("Repository.%s is not available as restic_call method %q", name, method)
+		}
+	}
+}
@@ -50,28 +50,17 @@ int main() {
     
     // List snapshots
     printf("Listing snapshots...\n");
-    char** ids = NULL;
-    char** times = NULL;
-    char** hostnames = NULL;
-    int count = 0;
-    
-    result = restic_list_snapshots(repo_id, &ids, &times, &hostnames, &count);
-    if (result != RESTIC_OK) {
-        print_error(result);
+    restic_result* snapshots = restic_list_snapshots_result(repo_id);
+    if (snapshots->code != RESTIC_OK) {
+        printf("Error: %s\n", snapshots->error);
+        restic_result_free(snapshots);
         restic_free_string(snapshot_id);
         restic_close(repo_id);
         return 1;
     }
     
-    printf("Found %d snapshots:\n", count);
-    for (int i = 0; i < count; i++) {
-        printf("  ID: %s, Time: %s, Host: %s\n", 
-               ids[i], times[i], hostnames[i]);
-    }
-    printf("\n");
-    
-    // Clean up snapshot arrays
-    restic_free_snapshot_arrays(ids, times, hostnames, count);
+    printf("Found %d snapshots:\n%s\n\n", snapshots->count, snapshots->value);
+    restic_result_free(snapshots);
     
     // Restore the backup
     printf("Restoring backup to /tmp/restore-test...\n");
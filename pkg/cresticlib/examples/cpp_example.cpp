@@ -40,15 +40,7 @@ int main() {
         
         // List snapshots
         std::cout << "Listing snapshots...\n";
-        auto snapshots = repo.listSnapshots();
-        
-        std::cout << "Found " << snapshots.size() << " snapshots:\n";
-        for (const auto& snapshot : snapshots) {
-            std::cout << "  ID: " << snapshot.id 
-                      << ", Time: " << snapshot.time 
-                      << ", Host: " << snapshot.hostname << "\n";
-        }
-        std::cout << "\n";
+        std::cout << repo.listSnapshotsJSON() << "\n\n";
         
         // Restore the backup
         std::cout << "Restoring backup to /tmp/restore-test-cpp...\n";
@@ -73,11 +65,7 @@ int main() {
         
         // List snapshots again
         std::cout << "Updated snapshot list:\n";
-        snapshots = repo.listSnapshots();
-        for (const auto& snapshot : snapshots) {
-            std::cout << "  ID: " << snapshot.id << ", Host: " << snapshot.hostname << "\n";
-        }
-        std::cout << "\n";
+        std::cout << repo.listSnapshotsJSON() << "\n\n";
         
         std::cout << "Example completed successfully!\n";
         
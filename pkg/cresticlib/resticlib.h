@@ -1,6 +1,8 @@
 #ifndef RESTICLIB_H
 #define RESTICLIB_H
 
+#include <stddef.h>
+
 #ifdef __cplusplus
 extern "C" {
 #endif
@@ -12,14 +14,29 @@ extern "C" {
 #define RESTIC_ERROR_INVALID_PASSWORD -3
 #define RESTIC_ERROR_BACKUP_FAILED   -4
 #define RESTIC_ERROR_RESTORE_FAILED  -5
+#define RESTIC_ERROR_INVALID_HANDLE  -6
 #define RESTIC_ERROR_UNKNOWN        -99
 
 /* Note: This interface uses simple parameters to avoid complex struct passing */
 
+/**
+ * Result of the *_result functions. All fields are owned by the result and
+ * released together with restic_result_free, callers never free fields
+ * individually. Fields which do not apply to a call are NULL or 0.
+ */
+typedef struct restic_result {
+    int code;          /* RESTIC_OK or an error code */
+    char* error;       /* detailed error message if code is not RESTIC_OK */
+    char* value;       /* string result, JSON for structured results */
+    int count;         /* number of items described by value */
+    void* data;        /* binary result, e.g. file content */
+    size_t data_size;  /* size of data in bytes */
+} restic_result;
+
 /**
  * Initialize a new repository
  * @param repo_url Repository URL (e.g., "/path/to/repo" or "s3:bucket/path")
- * @param backend Backend type: "local", "s3", "azure", "gcs", "b2", "sftp", "swift", "rest"
+ * @param backend Backend type: "local", "s3", "azure", "gcs", "b2", "sftp", "swift", "rest", "rclone"
  * @param password Repository password
  * @param access_key Access key for cloud backends (optional, can be NULL)
  * @param secret_key Secret key for cloud backends (optional, can be NULL)
@@ -28,6 +45,22 @@ extern "C" {
  */
 extern int restic_init(char* repo_url, char* backend, char* password, char* access_key, char* secret_key, int parallelism);
 
+/**
+ * Initialize a new repository with format options
+ * @param repo_url Repository URL
+ * @param backend Backend type
+ * @param password Repository password
+ * @param access_key Access key for cloud backends (optional, can be NULL)
+ * @param secret_key Secret key for cloud backends (optional, can be NULL)
+ * @param parallelism Number of parallel workers
+ * @param repo_version Repository format version, 1 or 2 (0 for the latest)
+ * @param compression Compression mode: "auto", "off", "fastest", "better" or "max" (optional, can be NULL)
+ * @param pack_size_mib Target pack file size in MiB (0 for the default)
+ * @return Repository ID (>= 0) on success, error code (< 0) on failure
+ */
+extern int restic_init_ex(char* repo_url, char* backend, char* password, char* access_key, char* secret_key, int parallelism,
+                          int repo_version, char* compression, int pack_size_mib);
+
 /**
  * Open an existing repository
  * @param repo_url Repository URL
@@ -62,15 +95,14 @@ extern int restic_backup(int repo_id, char** paths, int paths_count, char** tags
 extern int restic_restore(int repo_id, char* snapshot_id, char* target_dir);
 
 /**
- * List all snapshots in repository
+ * Extract a single file from a snapshot without restoring the whole snapshot
  * @param repo_id Repository ID
- * @param ids_out Output parameter for snapshot IDs array (caller must free with restic_free_snapshot_arrays)
- * @param times_out Output parameter for snapshot times array (caller must free with restic_free_snapshot_arrays)
- * @param hostnames_out Output parameter for hostnames array (caller must free with restic_free_snapshot_arrays)
- * @param count_out Output parameter for number of snapshots
+ * @param snapshot_id Snapshot ID
+ * @param path Path of the file in the snapshot (e.g., "/home/user/notes.txt")
+ * @param out_path Path of the file to write, it is overwritten if it exists
  * @return RESTIC_OK on success, error code on failure
  */
-extern int restic_list_snapshots(int repo_id, char*** ids_out, char*** times_out, char*** hostnames_out, int* count_out);
+extern int restic_dump_file(int repo_id, char* snapshot_id, char* path, char* out_path);
 
 /**
  * Perform repository integrity check
@@ -83,24 +115,101 @@ extern int restic_check(int repo_id, int* errors_out);
 /**
  * Close repository and free resources
  * @param repo_id Repository ID
- * @return RESTIC_OK on success, error code on failure
+ * @return RESTIC_OK on success, RESTIC_ERROR_INVALID_HANDLE if the repository
+ *         was already closed, error code on failure
  */
 extern int restic_close(int repo_id);
 
 /**
- * Free a string returned by the library
- * @param str String to free
+ * Create a backup
+ * @param repo_id Repository ID
+ * @param paths Array of paths to backup
+ * @param paths_count Number of paths
+ * @param tags Array of tags (optional, can be NULL)
+ * @param tags_count Number of tags
+ * @return Result with the snapshot ID as value (caller must free with restic_result_free)
  */
-extern void restic_free_string(char* str);
+extern restic_result* restic_backup_result(int repo_id, char** paths, int paths_count, char** tags, int tags_count);
+
+/**
+ * Preview a backup without writing anything to the repository
+ * @param repo_id Repository ID
+ * @param paths Array of paths to backup
+ * @param paths_count Number of paths
+ * @param tags Array of tags (optional, can be NULL)
+ * @param tags_count Number of tags
+ * @return Result with the JSON backup summary as value, e.g. the data each path
+ *         would add (caller must free with restic_result_free)
+ */
+extern restic_result* restic_backup_dry_run(int repo_id, char** paths, int paths_count, char** tags, int tags_count);
+
+/**
+ * List all snapshots in repository
+ * @param repo_id Repository ID
+ * @return Result with a JSON array of snapshots as value and their number as count
+ *         (caller must free with restic_result_free)
+ */
+extern restic_result* restic_list_snapshots_result(int repo_id);
+
+/**
+ * List the snapshots matching a filter
+ * @param repo_id Repository ID
+ * @param filter_json JSON object with the optional fields "hosts", "paths", "tags"
+ *        (arrays of strings), "since", "until" (RFC 3339 times), "limit" and
+ *        "with_sizes" (NULL or "" lists all snapshots)
+ * @return Result with a JSON array of snapshots as value and their number as count
+ *         (caller must free with restic_result_free)
+ */
+extern restic_result* restic_list_snapshots_filtered(int repo_id, char* filter_json);
+
+/**
+ * Read a single file from a snapshot into memory
+ * @param repo_id Repository ID
+ * @param snapshot_id Snapshot ID
+ * @param path Path of the file in the snapshot
+ * @return Result with the file content as data (caller must free with restic_result_free)
+ */
+extern restic_result* restic_dump_file_result(int repo_id, char* snapshot_id, char* path);
+
+/**
+ * Perform repository integrity check
+ * @param repo_id Repository ID
+ * @return Result with the JSON check report as value and the number of errors as count,
+ *         "problems" describes each finding with its "type" ("pack", "blob", "index"
+ *         or "tree") and IDs (caller must free with restic_result_free)
+ */
+extern restic_result* restic_check_result(int repo_id);
 
 /**
- * Free arrays returned by restic_list_snapshots
- * @param ids Snapshot IDs array
- * @param times Snapshot times array
- * @param hostnames Hostnames array
- * @param count Number of snapshots
+ * Free a result and everything it contains
+ * @param res Result to free, can be NULL
  */
-extern void restic_free_snapshot_arrays(char** ids, char** times, char** hostnames, int count);
+extern void restic_result_free(restic_result* res);
+
+/**
+ * Call a library method with a JSON request, for FFI wrappers
+ *
+ * Requests are JSON objects with the repository handle in "repo" and the
+ * options of the operation in "options", e.g.
+ *   restic_call("open", "{\"options\": {\"repo_url\": \"/srv/repo\", \"backend\": \"local\", \"password\": \"...\"}}")
+ *   restic_call("backup", "{\"repo\": 1, \"options\": {\"paths\": [\"/home/user\"]}}")
+ *   restic_call("snapshots", "{\"repo\": 1, \"options\": {\"hosts\": [\"laptop\"]}}")
+ * The "methods" method lists all method names. The result of "cat" is the
+ * base64 encoded object, "dump_file", "dump_archive" and "export_snapshot"
+ * write to the file in "options.target".
+ *
+ * @param method Method name
+ * @param request_json JSON request (can be NULL for methods without parameters)
+ * @return JSON response {"code": 0, "error": "...", "result": ...} where code is
+ *         RESTIC_OK or an error code (caller must free with restic_free_string)
+ */
+extern char* restic_call(char* method, char* request_json);
+
+/**
+ * Free a string returned by the library
+ * @param str String to free
+ */
+extern void restic_free_string(char* str);
 
 /**
  * Get library version
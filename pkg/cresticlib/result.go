@@ -0,0 +1,214 @@
+package main
+
+/*
+#include <stdlib.h>
+#include "resticlib.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/restic/restic/pkg/resticlib"
+)
+
+// newResult allocates a result with the given code and the message of err
+func newResult(code C.int, err error) *C.restic_result {
+	res := (*C.restic_result)(C.calloc(1, C.size_t(unsafe.Sizeof(C.restic_result{}))))
+	res.code = code
+	if err != nil {
+		res.error = C.CString(err.Error())
+	}
+	return res
+}
+
+// setJSON stores v encoded as JSON in the value of the result
+func setJSON(res *C.restic_result, v interface{}) *C.restic_result {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		res.code = RESTIC_ERROR_UNKNOWN
+		res.error = C.CString(err.Error())
+		return res
+	}
+	res.value = C.CString(string(buf))
+	return res
+}
+
+// decodeJSON decodes a JSON request into v, unknown fields are rejected to
+// catch typos in option names. An empty string leaves v unchanged.
+func decodeJSON(data string, v interface{}) error {
+	if strings.TrimSpace(data) == "" {
+		return nil
+	}
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON request: %w", err)
+	}
+	return nil
+}
+
+// restic_result_free frees a result and everything it contains
+//
+//export restic_result_free
+func restic_result_free(res *C.restic_result) {
+	if res == nil {
+		return
+	}
+	C.free(unsafe.Pointer(res.error))
+	C.free(unsafe.Pointer(res.value))
+	C.free(res.data)
+	C.free(unsafe.Pointer(res))
+}
+
+// restic_backup_result creates a backup, the value of the result is the snapshot ID
+//
+//export restic_backup_result
+func restic_backup_result(repo_id C.int, paths **C.char, paths_count C.int, tags **C.char, tags_count C.int) *C.restic_result {
+	repo, exists := lookupRepo(repo_id)
+	if !exists {
+		return newResult(RESTIC_ERROR_INVALID_HANDLE, nil)
+	}
+
+	if paths == nil || paths_count <= 0 {
+		return newResult(RESTIC_ERROR_INVALID_PARAMS, nil)
+	}
+
+	ctx := context.Background()
+
+	backupOpts := resticlib.BackupOptions{
+		Paths: goStrings(paths, paths_count),
+		Tags:  goStrings(tags, tags_count),
+	}
+
+	summary, err := repo.Backup(ctx, backupOpts)
+	if err != nil {
+		return newResult(RESTIC_ERROR_BACKUP_FAILED, err)
+	}
+
+	res := newResult(RESTIC_OK, nil)
+	res.value = C.CString(string(summary.SnapshotID))
+	return res
+}
+
+// restic_backup_dry_run previews a backup without writing anything, the
+// value of the result is the JSON backup summary
+//
+//export restic_backup_dry_run
+func restic_backup_dry_run(repo_id C.int, paths **C.char, paths_count C.int, tags **C.char, tags_count C.int) *C.restic_result {
+	repo, exists := lookupRepo(repo_id)
+	if !exists {
+		return newResult(RESTIC_ERROR_INVALID_HANDLE, nil)
+	}
+
+	if paths == nil || paths_count <= 0 {
+		return newResult(RESTIC_ERROR_INVALID_PARAMS, nil)
+	}
+
+	ctx := context.Background()
+
+	backupOpts := resticlib.BackupOptions{
+		Paths:  goStrings(paths, paths_count),
+		Tags:   goStrings(tags, tags_count),
+		DryRun: true,
+	}
+
+	summary, err := repo.Backup(ctx, backupOpts)
+	if err != nil {
+		return newResult(RESTIC_ERROR_BACKUP_FAILED, err)
+	}
+
+	return setJSON(newResult(RESTIC_OK, nil), summary)
+}
+
+// restic_list_snapshots_result lists all snapshots in the repository, the
+// value of the result is a JSON array of snapshots
+//
+//export restic_list_snapshots_result
+func restic_list_snapshots_result(repo_id C.int) *C.restic_result {
+	return restic_list_snapshots_filtered(repo_id, nil)
+}
+
+// restic_list_snapshots_filtered lists the snapshots matching a JSON encoded
+// SnapshotFilter, the value of the result is a JSON array of snapshots
+//
+//export restic_list_snapshots_filtered
+func restic_list_snapshots_filtered(repo_id C.int, filter_json *C.char) *C.restic_result {
+	repo, exists := lookupRepo(repo_id)
+	if !exists {
+		return newResult(RESTIC_ERROR_INVALID_HANDLE, nil)
+	}
+
+	var filter resticlib.SnapshotFilter
+	if filter_json != nil {
+		if err := decodeJSON(C.GoString(filter_json), &filter); err != nil {
+			return newResult(RESTIC_ERROR_INVALID_PARAMS, err)
+		}
+	}
+
+	ctx := context.Background()
+
+	snapshots, err := repo.Snapshots(ctx, filter)
+	if err != nil {
+		return newResult(RESTIC_ERROR_UNKNOWN, err)
+	}
+
+	res := newResult(RESTIC_OK, nil)
+	res.count = C.int(len(snapshots))
+	return setJSON(res, snapshots)
+}
+
+// restic_dump_file_result reads a single file from a snapshot into the data
+// of the result
+//
+//export restic_dump_file_result
+func restic_dump_file_result(repo_id C.int, snapshot_id *C.char, path *C.char) *C.restic_result {
+	repo, exists := lookupRepo(repo_id)
+	if !exists {
+		return newResult(RESTIC_ERROR_INVALID_HANDLE, nil)
+	}
+
+	if snapshot_id == nil || path == nil {
+		return newResult(RESTIC_ERROR_INVALID_PARAMS, nil)
+	}
+
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	err := repo.DumpFile(ctx, resticlib.SnapshotID(C.GoString(snapshot_id)), C.GoString(path), &buf)
+	if err != nil {
+		return newResult(RESTIC_ERROR_RESTORE_FAILED, err)
+	}
+
+	res := newResult(RESTIC_OK, nil)
+	res.data = C.CBytes(buf.Bytes())
+	res.data_size = C.size_t(buf.Len())
+	return res
+}
+
+// restic_check_result performs a repository integrity check, the value of the
+// result is the JSON check report and count is the number of errors
+//
+//export restic_check_result
+func restic_check_result(repo_id C.int) *C.restic_result {
+	repo, exists := lookupRepo(repo_id)
+	if !exists {
+		return newResult(RESTIC_ERROR_INVALID_HANDLE, nil)
+	}
+
+	ctx := context.Background()
+
+	report, err := repo.Check(ctx, resticlib.CheckOptions{Depth: resticlib.CheckDepthDefault})
+	if err != nil {
+		return newResult(RESTIC_ERROR_UNKNOWN, err)
+	}
+
+	res := newResult(RESTIC_OK, nil)
+	res.count = C.int(len(report.Errors))
+	return setJSON(res, report)
+}
@@ -4,140 +4,468 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/user"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/restic/restic/internal/archiver"
 	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/errors"
+	"github.com/restic/restic/internal/filter"
 	"github.com/restic/restic/internal/fs"
 	"github.com/restic/restic/internal/restic"
 )
 
+// metadataOnlyTag marks snapshots whose files are stored as empty
+// placeholders, see BackupOptions.MetadataOnly
+const metadataOnlyTag = "metadata-only"
+
 // archiverWrapper helps with archiver functionality
 type archiverWrapper struct {
 	arch     *archiver.Archiver
 	reporter ProgressReporter
 }
 
-// Backup creates a new backup snapshot
-func (r *repositoryImpl) Backup(ctx context.Context, opts BackupOptions) (SnapshotID, error) {
-	if len(opts.Paths) == 0 {
-		return "", errors.New("no paths specified for backup")
+// Backup creates a new backup snapshot and returns statistics for each of the
+// backed up paths
+func (r *repositoryImpl) Backup(ctx context.Context, opts BackupOptions) (result BackupSummary, err error) {
+	if err := r.begin(); err != nil {
+		return BackupSummary{}, err
 	}
+	defer r.end()
+
+	start := time.Now()
+	// notify with the original context, the lock context is cancelled on unlock
+	defer func(ctx context.Context) { r.notify(ctx, "backup", start, result, true, err) }(ctx)
 
-	r.logf("info", "Starting backup of paths: %v", opts.Paths)
+	paths, err := r.backupTargets(opts)
+	if err != nil {
+		return BackupSummary{}, err
+	}
+	if len(paths) == 0 {
+		return BackupSummary{}, errors.New("no paths specified for backup")
+	}
+
+	// like restic backup, a shared lock keeps prune from removing the data
+	// the backup writes or deduplicates against
+	if opts.Lock.NoLock && !opts.DryRun {
+		return BackupSummary{}, errors.New("backup requires a lock")
+	}
+	ctx, unlock, err := r.lockShared(ctx, opts.Lock)
+	if err != nil {
+		return BackupSummary{}, err
+	}
+	defer unlock()
+
+	r.logf("info", "Starting backup of paths: %v", paths)
+
+	// nothing is written to the repository in dry runs
+	repo := r.repo
+	if opts.DryRun {
+		repo, err = r.dryRunRepository(ctx)
+		if err != nil {
+			return BackupSummary{}, err
+		}
+	}
 
 	// Load index
-	err := r.repo.LoadIndex(ctx, nil)
+	err = repo.LoadIndex(ctx, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to load index: %w", err)
+		return BackupSummary{}, fmt.Errorf("failed to load index: %w", err)
 	}
 
 	// Set up filesystem
-	targetFS := fs.Local{}
+	cpu := r.cfg.Profile.backupCPU(opts.CPU)
+	var sourceFS fs.FS = fs.Local{}
+	if opts.Source != nil {
+		sourceFS = newSourceFS(opts.Source)
+	}
+	targetFS := newPacedFS(sourceFS, cpu.ReadRateKiB)
 
 	// Create archiver
-	arch := archiver.New(r.repo, targetFS, archiver.Options{})
+	arch := archiver.New(repo, targetFS, r.backupArchiverOptions(opts, cpu))
 
-	// Set up select functions for filtering
-	arch.SelectByName = func(item string) bool {
-		// Apply includes first (if any)
-		if len(opts.Includes) > 0 {
-			matched := false
-			for _, pattern := range opts.Includes {
-				if matched, _ = filepath.Match(pattern, filepath.Base(item)); matched {
-					break
-				}
-			}
-			if !matched {
-				return false
-			}
-		}
+	arch.MetadataOnly = opts.MetadataOnly
 
-		// Apply excludes
-		for _, pattern := range opts.Excludes {
-			if matched, _ := filepath.Match(pattern, filepath.Base(item)); matched {
-				return false
-			}
-		}
-		return true
+	if opts.IgnoreInode {
+		// on FUSE the ctime is not reliable either
+		arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime | archiver.ChangeIgnoreInode
+	}
+	if opts.IgnoreCtime {
+		arch.ChangeIgnoreFlags |= archiver.ChangeIgnoreCtime
+	}
+
+	// all patterns use the syntax of the CLI, see ParsePatterns
+	excludeFilePatterns, err := ReadPatternFiles(opts.ExcludeFiles...)
+	if err != nil {
+		return BackupSummary{}, err
+	}
+	excludes := append(append([]string(nil), opts.Excludes...), excludeFilePatterns...)
+	if err := filter.ValidatePatterns(excludes); err != nil {
+		return BackupSummary{}, err
+	}
+	if err := filter.ValidatePatterns(opts.Includes); err != nil {
+		return BackupSummary{}, err
+	}
+	rejectByPattern := filter.RejectByPattern(excludes, func(msg string, args ...interface{}) {
+		r.logf("warn", msg, args...)
+	})
+	arch.SelectByName = func(item string) bool {
+		return !rejectByPattern(item)
 	}
 
 	// Set up error handling
+	var itemMu sync.Mutex
 	arch.Error = func(file string, err error) error {
+		if opts.OnItem != nil {
+			itemMu.Lock()
+			opts.OnItem(BackupItem{Path: file, Status: BackupItemError, Error: err.Error()})
+			itemMu.Unlock()
+		}
 		if opts.Progress != nil {
 			return opts.Progress.Error(file, err)
 		}
 		return err
 	}
 
-	// Set up progress reporting
-	if opts.Progress != nil {
-		arch.CompleteItem = func(item string, previous, current *data.Node, s archiver.ItemStats, d time.Duration) {
-			opts.Progress.Add(s.DataSize + s.TreeSize)
+	// Resolve and clean paths
+	var resolvedPaths []string
+	for _, path := range paths {
+		absPath, err := targetFS.Abs(path)
+		if err != nil {
+			return BackupSummary{}, fmt.Errorf("failed to resolve path %q: %w", path, err)
 		}
+		resolvedPaths = append(resolvedPaths, absPath)
 	}
 
-	// Find parent snapshot if specified
-	var parentSnapshot *data.Snapshot
-	if opts.ParentID != nil {
-		id, err := restic.ParseID(string(*opts.ParentID))
-		if err != nil {
-			return "", fmt.Errorf("invalid parent ID: %w", err)
+	rejects, err := r.backupRejects(opts, resolvedPaths, targetFS)
+	if err != nil {
+		return BackupSummary{}, err
+	}
+	arch.Select = archiver.CombineRejects(rejects)
+
+	// Collect statistics per path and report progress
+	pathStats := newBackupPathStats(resolvedPaths)
+	if opts.Progress != nil {
+		defer opts.Progress.Finish()
+	}
+	var meter *throughputMeter
+	if reporter, ok := opts.Progress.(ThroughputReporter); ok {
+		meter = startThroughputMeter(reporter, opts.ThroughputInterval)
+		defer meter.stop()
+		arch.CompleteBlob = func(bytes uint64) {
+			meter.read.Add(bytes)
 		}
-		parentSnapshot, err = data.LoadSnapshot(ctx, r.repo, id)
-		if err != nil {
-			return "", fmt.Errorf("failed to load parent snapshot: %w", err)
+	}
+	var changes *BackupChanges
+	if opts.DryRun {
+		changes = &BackupChanges{}
+	}
+	arch.CompleteItem = func(item string, previous, current *data.Node, s archiver.ItemStats, d time.Duration) {
+		pathStats.add(item, current, s)
+		if meter != nil {
+			meter.added.Add(s.DataSize)
+			meter.stored.Add(s.DataSizeInRepo + s.TreeSizeInRepo)
+		}
+		if opts.Progress != nil && current != nil && current.Type == data.NodeTypeFile {
+			opts.Progress.Add(current.Size)
+		}
+		if (opts.OnItem != nil || changes != nil) && current != nil {
+			itemMu.Lock()
+			bi := backupItem(item, previous, current, s, d)
+			if opts.OnItem != nil {
+				opts.OnItem(bi)
+			}
+			if changes != nil {
+				changes.add(bi)
+			}
+			itemMu.Unlock()
 		}
 	}
 
 	// Create snapshot metadata
-	hostname := "unknown"
-	if h, err := os.Hostname(); err == nil {
-		hostname = h
+	hostname := opts.Hostname
+	if hostname == "" {
+		hostname = "unknown"
+		if h, err := os.Hostname(); err == nil {
+			hostname = h
+		}
 	}
 
-	username := "unknown"
-	if u, err := user.Current(); err == nil {
-		username = u.Username
+	backupStart := time.Now()
+	snapshotTime := backupStart
+	if !opts.Time.IsZero() {
+		snapshotTime = opts.Time
 	}
-	_ = username // Mark as used for now
 
-	// Resolve and clean paths
-	var resolvedPaths []string
-	for _, path := range opts.Paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return "", fmt.Errorf("failed to resolve path %q: %w", path, err)
-		}
-		resolvedPaths = append(resolvedPaths, absPath)
+	parentSnapshot, err := r.findParentSnapshot(ctx, repo, opts, hostname, resolvedPaths, snapshotTime)
+	if err != nil {
+		return BackupSummary{}, err
+	}
+
+	tags := opts.Tags
+	if opts.MetadataOnly {
+		tags = append(append([]string(nil), tags...), metadataOnlyTag)
 	}
 
 	// Create snapshot options
 	snapshotOpts := archiver.SnapshotOptions{
-		Tags:           opts.Tags,
+		Tags:           tags,
 		Hostname:       hostname,
-		Excludes:       opts.Excludes,
-		BackupStart:    time.Now(),
-		Time:           time.Now(),
+		Username:       opts.Username,
+		Excludes:       excludes,
+		BackupStart:    backupStart,
+		Time:           snapshotTime,
 		ParentSnapshot: parentSnapshot,
 		ProgramVersion: "resticlib",
 	}
 
+	if opts.Progress != nil && !opts.NoScan {
+		stopScan := r.startScan(ctx, arch, targetFS, resolvedPaths, opts.Progress)
+		defer stopScan()
+	}
+
 	// Run archiver
-	_, snapshotID, summary, err := arch.Snapshot(ctx, resolvedPaths, snapshotOpts)
+	sn, snapshotID, summary, err := arch.Snapshot(ctx, resolvedPaths, snapshotOpts)
 	if err != nil {
-		return "", fmt.Errorf("backup failed: %w", err)
+		return BackupSummary{}, fmt.Errorf("backup failed: %w", err)
 	}
 
-	r.logf("info", "Backup completed successfully, snapshot ID: %s", snapshotID.Str())
+	result = BackupSummary{Paths: pathStats.stats}
 	if summary != nil {
 		r.logf("info", "Processed %d files, %d bytes",
 			summary.Files.New+summary.Files.Changed+summary.Files.Unchanged,
 			summary.ProcessedBytes)
+		result.addArchiverSummary(summary)
+	}
+
+	if opts.DryRun {
+		r.logf("info", "Dry run completed, no data was written")
+		changes.DataAdded = result.DataAdded
+		changes.DataAddedPacked = result.DataAddedPacked
+		result.DryRun = true
+		result.Changes = changes
+		return result, nil
 	}
 
-	return SnapshotID(snapshotID.String()), nil
+	r.logf("info", "Backup completed successfully, snapshot ID: %s", snapshotID.Str())
+
+	// keep the persistent path index up to date, it is rebuilt on demand otherwise
+	if r.cfg.PathIndexDir != "" {
+		if _, err := r.indexSnapshot(ctx, snapshotID, *sn.Tree); err != nil {
+			r.logf("warn", "Failed to update path index: %v", err)
+		}
+	}
+
+	result.SnapshotID = SnapshotID(snapshotID.String())
+	return result, nil
+}
+
+// addArchiverSummary copies the statistics of the archiver
+func (s *BackupSummary) addArchiverSummary(summary *archiver.Summary) {
+	s.FilesNew = summary.Files.New
+	s.FilesChanged = summary.Files.Changed
+	s.FilesUnmodified = summary.Files.Unchanged
+	s.DirsNew = summary.Dirs.New
+	s.DirsChanged = summary.Dirs.Changed
+	s.DirsUnmodified = summary.Dirs.Unchanged
+	s.DataAdded = summary.DataSize + summary.TreeSize
+	s.DataAddedPacked = summary.DataSizeInRepo + summary.TreeSizeInRepo
+	s.TotalFilesProcessed = summary.Files.New + summary.Files.Changed + summary.Files.Unchanged
+	s.TotalBytesProcessed = summary.ProcessedBytes
+	s.BackupStart = summary.BackupStart
+	s.BackupEnd = summary.BackupEnd
+	s.Duration = summary.BackupEnd.Sub(summary.BackupStart)
+}
+
+// findParentSnapshot returns the snapshot used to detect unchanged files,
+// either ParentID or the latest snapshot of the group selected by
+// ParentGroupBy taken before the snapshot time. It returns nil if there is
+// none.
+func (r *repositoryImpl) findParentSnapshot(ctx context.Context, repo restic.Repository, opts BackupOptions, hostname string, targets []string, snapshotTime time.Time) (*data.Snapshot, error) {
+	if opts.Force {
+		return nil, nil
+	}
+
+	if opts.ParentID != nil {
+		id, err := restic.ParseID(string(*opts.ParentID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent ID: %w", err)
+		}
+		sn, err := data.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parent snapshot: %w", err)
+		}
+		return sn, nil
+	}
+
+	if opts.ParentGroupBy == nil {
+		return nil, nil
+	}
+
+	f := data.SnapshotFilter{TimestampLimit: snapshotTime}
+	if opts.ParentGroupBy.Host {
+		f.Hosts = []string{hostname}
+	}
+	if opts.ParentGroupBy.Paths {
+		f.Paths = targets
+	}
+	if opts.ParentGroupBy.Tags {
+		f.Tags = data.TagLists{opts.Tags}
+	}
+
+	sn, _, err := f.FindLatest(ctx, repo, repo, "latest")
+	if errors.Is(err, data.ErrNoSnapshotFound) {
+		r.logf("info", "No parent snapshot found, all files are read")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find parent snapshot: %w", err)
+	}
+	r.logf("info", "Using parent snapshot %s", sn.ID().Str())
+	return sn, nil
+}
+
+// cacheDirTag marks cache directories, see https://bford.info/cachedir/
+const cacheDirTag = "CACHEDIR.TAG:Signature: 8a477f597d28d172789f06886806bc55"
+
+// backupRejects returns the functions which exclude files from the backup
+// based on their file info, like the CLI options of the same name
+func (r *repositoryImpl) backupRejects(opts BackupOptions, targets []string, filesystem fs.FS) ([]archiver.RejectFunc, error) {
+	warnf := func(msg string, args ...interface{}) {
+		r.logf("warn", msg, args...)
+	}
+
+	var rejects []archiver.RejectFunc
+	if len(opts.Includes) > 0 {
+		// directories are kept as long as their children may be included
+		include := filter.IncludeByPattern(opts.Includes, warnf)
+		rejects = append(rejects, func(item string, fi *fs.ExtendedFileInfo, _ fs.FS) bool {
+			matched, childMayMatch := include(item)
+			return !matched && !(fi.Mode.IsDir() && childMayMatch)
+		})
+	}
+	if opts.OneFileSystem {
+		f, err := archiver.RejectByDevice(targets, filesystem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine file systems: %w", err)
+		}
+		rejects = append(rejects, f)
+	}
+
+	specs := append([]string(nil), opts.ExcludeIfPresent...)
+	if opts.ExcludeCaches {
+		specs = append(specs, cacheDirTag)
+	}
+
+	for _, spec := range specs {
+		f, err := archiver.RejectIfPresent(spec, warnf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude-if-present %q: %w", spec, err)
+		}
+		rejects = append(rejects, f)
+	}
+	return rejects, nil
+}
+
+// backupItem classifies an item completed by the archiver like the verbose
+// output of the CLI
+func backupItem(item string, previous, current *data.Node, s archiver.ItemStats, d time.Duration) BackupItem {
+	status := BackupItemChanged
+	switch {
+	case previous == nil:
+		status = BackupItemNew
+	case previous.Equals(*current):
+		status = BackupItemUnmodified
+	}
+	return BackupItem{
+		Path:      item,
+		Type:      string(current.Type),
+		Status:    status,
+		Size:      s.DataSize + s.TreeSize,
+		DataAdded: s.DataSizeInRepo + s.TreeSizeInRepo,
+		Duration:  d,
+	}
+}
+
+// MaxBackupChangesFiles is the maximum number of files listed in
+// BackupChanges.Files
+const MaxBackupChangesFiles = 1000
+
+// add records a completed file
+func (c *BackupChanges) add(item BackupItem) {
+	if item.Type != string(data.NodeTypeFile) {
+		return
+	}
+	switch item.Status {
+	case BackupItemNew:
+		c.FilesNew++
+	case BackupItemChanged:
+		c.FilesChanged++
+	default:
+		c.FilesUnmodified++
+		return
+	}
+	if len(c.Files) >= MaxBackupChangesFiles {
+		c.FilesOmitted++
+		return
+	}
+	c.Files = append(c.Files, item)
+}
+
+// backupPathStats attributes the items completed by the archiver to the
+// backup path containing them
+type backupPathStats struct {
+	mu       sync.Mutex
+	prefixes []string
+	stats    []BackupPathStats
+}
+
+func newBackupPathStats(paths []string) *backupPathStats {
+	s := &backupPathStats{stats: make([]BackupPathStats, len(paths))}
+	for i, path := range paths {
+		s.prefixes = append(s.prefixes, snapshotItemPath(path))
+		s.stats[i].Path = path
+	}
+	return s
+}
+
+// add records an item, items outside of all backup paths such as their
+// parent directories are ignored
+func (s *backupPathStats) add(item string, current *data.Node, is archiver.ItemStats) {
+	item = strings.TrimSuffix(item, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// nested paths are attributed to the innermost path
+	match := -1
+	for i, prefix := range s.prefixes {
+		if item != prefix && !strings.HasPrefix(item, prefix+"/") {
+			continue
+		}
+		if match < 0 || len(prefix) > len(s.prefixes[match]) {
+			match = i
+		}
+	}
+	if match < 0 {
+		return
+	}
+
+	stats := &s.stats[match]
+	stats.DataAdded += is.DataSize + is.TreeSize
+	if current != nil && current.Type == data.NodeTypeFile {
+		stats.Files++
+		stats.Bytes += current.Size
+	}
+}
+
+// snapshotItemPath converts an absolute path to the form used for items by
+// the archiver, e.g. C:\dir becomes /C/dir
+func snapshotItemPath(path string) string {
+	if vol := filepath.VolumeName(path); vol != "" {
+		path = "/" + strings.TrimSuffix(vol, ":") + path[len(vol):]
+	}
+	return strings.TrimSuffix(filepath.ToSlash(path), "/")
 }
@@ -0,0 +1,162 @@
+package resticlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// ObjectType is a type of repository object returned by Cat
+type ObjectType string
+
+// Object types, see restic cat
+const (
+	// ObjectConfig is the repository config as JSON, the ID is ignored
+	ObjectConfig ObjectType = "config"
+	// ObjectIndex is a decrypted index file
+	ObjectIndex ObjectType = "index"
+	// ObjectSnapshot is a snapshot as JSON
+	ObjectSnapshot ObjectType = "snapshot"
+	// ObjectKey is a key file as JSON, with the master key still encrypted
+	ObjectKey ObjectType = "key"
+	// ObjectLock is a lock file as JSON
+	ObjectLock ObjectType = "lock"
+	// ObjectBlob is the decrypted content of a data or tree blob
+	ObjectBlob ObjectType = "blob"
+	// ObjectPack is the raw, encrypted content of a pack file
+	ObjectPack ObjectType = "pack"
+	// ObjectPackHeader lists the blobs of a pack file as JSON
+	ObjectPackHeader ObjectType = "pack-header"
+)
+
+// PackHeaderEntry is an entry of the header of a pack file, see
+// ObjectPackHeader
+type PackHeaderEntry struct {
+	Type               string `json:"type"`
+	ID                 string `json:"id"`
+	Offset             uint   `json:"offset"`
+	Length             uint   `json:"length"`
+	UncompressedLength uint   `json:"uncompressed_length,omitempty"`
+}
+
+// Cat returns the decoded content of a repository object for debugging, like
+// restic cat. JSON objects are indented. Except for blobs, IDs may be
+// abbreviated. Pack files are returned even if their content does not match
+// their ID.
+func (r *repositoryImpl) Cat(ctx context.Context, objectType ObjectType, id string) ([]byte, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	switch objectType {
+	case ObjectConfig:
+		return json.MarshalIndent(r.repo.Config(), "", "  ")
+
+	case ObjectSnapshot:
+		sn, _, err := r.findSnapshot(ctx, id, SnapshotFilter{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find snapshot: %w", err)
+		}
+		return json.MarshalIndent(sn, "", "  ")
+
+	case ObjectBlob:
+		blobID, err := restic.ParseID(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blob ID %q: %w", id, err)
+		}
+		err = r.repo.LoadIndex(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load index: %w", err)
+		}
+		for _, t := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+			if _, ok := r.repo.LookupBlobSize(t, blobID); ok {
+				return r.repo.LoadBlob(ctx, t, blobID, nil)
+			}
+		}
+		return nil, fmt.Errorf("blob %s not found", blobID.Str())
+	}
+
+	var fileType restic.FileType
+	switch objectType {
+	case ObjectIndex:
+		fileType = restic.IndexFile
+	case ObjectKey:
+		fileType = restic.KeyFile
+	case ObjectLock:
+		fileType = restic.LockFile
+	case ObjectPack, ObjectPackHeader:
+		fileType = restic.PackFile
+	default:
+		return nil, fmt.Errorf("unknown object type %q", objectType)
+	}
+
+	fileID, err := restic.Find(ctx, r.repo, fileType, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s %q: %w", objectType, id, err)
+	}
+
+	switch objectType {
+	case ObjectIndex:
+		buf, err := r.repo.LoadUnpacked(ctx, restic.IndexFile, fileID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load index: %w", err)
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, buf, "", "  "); err != nil {
+			return nil, fmt.Errorf("failed to decode index: %w", err)
+		}
+		return out.Bytes(), nil
+
+	case ObjectKey:
+		key, err := repository.LoadKey(ctx, r.repo, fileID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key: %w", err)
+		}
+		return json.MarshalIndent(key, "", "  ")
+
+	case ObjectLock:
+		lock, err := restic.LoadLock(ctx, r.repo, fileID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load lock: %w", err)
+		}
+		return json.MarshalIndent(lock, "", "  ")
+
+	case ObjectPack:
+		buf, err := r.repo.LoadRaw(ctx, restic.PackFile, fileID)
+		// damaged pack files are returned as well
+		if buf == nil {
+			return nil, fmt.Errorf("failed to load pack: %w", err)
+		}
+		if hash := restic.Hash(buf); !hash.Equal(fileID) {
+			r.logf("warn", "Hash of pack %s does not match its ID, got %s", fileID.Str(), hash.Str())
+		}
+		return buf, nil
+
+	default:
+		fi, err := r.be.Stat(ctx, backend.Handle{Type: backend.PackFile, Name: fileID.String()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat pack: %w", err)
+		}
+		blobs, _, err := r.repo.ListPack(ctx, fileID, fi.Size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pack header: %w", err)
+		}
+		entries := make([]PackHeaderEntry, len(blobs))
+		for i, blob := range blobs {
+			entries[i] = PackHeaderEntry{
+				Type:               blob.Type.String(),
+				ID:                 blob.ID.String(),
+				Offset:             blob.Offset,
+				Length:             blob.Length,
+				UncompressedLength: blob.UncompressedLength,
+			}
+		}
+		return json.MarshalIndent(entries, "", "  ")
+	}
+}
@@ -1,28 +1,296 @@
 package resticlib
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"golang.org/x/sync/errgroup"
 )
 
-// Check verifies repository integrity
-func (r *repositoryImpl) Check(ctx context.Context, depth CheckDepth) (CheckReport, error) {
-	r.logf("info", "Starting integrity check (depth: %s)", depth)
+// checkBufferSize is the size of the read buffer used for each pack reader
+const checkBufferSize = 4 * 1024 * 1024
 
-	report := CheckReport{
-		Errors:   []string{},
-		Warnings: []string{},
-		Success:  true,
+// PackError is a pack file which is missing, damaged or not referenced by the
+// index
+type PackError struct {
+	// PackID is empty if the error does not concern a single pack
+	PackID string
+	Err    error
+}
+
+func (e *PackError) Error() string {
+	if e.PackID == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("pack %s: %v", e.PackID, e.Err)
+}
+
+func (e *PackError) Unwrap() error {
+	return e.Err
+}
+
+// BlobError is a blob which is damaged or missing from the index
+type BlobError struct {
+	BlobID string
+	// PackID is empty if the blob is not contained in any pack
+	PackID string
+	Err    error
+}
+
+func (e *BlobError) Error() string {
+	if e.PackID == "" {
+		return fmt.Sprintf("blob %s: %v", e.BlobID, e.Err)
+	}
+	return fmt.Sprintf("blob %s in pack %s: %v", e.BlobID, e.PackID, e.Err)
+}
+
+func (e *BlobError) Unwrap() error {
+	return e.Err
+}
+
+// IndexError is an index file which cannot be loaded or contradicts the pack
+// files
+type IndexError struct {
+	Err error
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("index: %v", e.Err)
+}
+
+func (e *IndexError) Unwrap() error {
+	return e.Err
+}
+
+// TreeError is a damaged tree of a snapshot, or a file of the tree whose data
+// is missing. In the latter case Err is a *BlobError.
+type TreeError struct {
+	SnapshotID SnapshotID
+	TreeID     string
+	// Path is the path of the tree or file within the snapshot
+	Path string
+	Err  error
+}
+
+func (e *TreeError) Error() string {
+	return fmt.Sprintf("snapshot %s: tree %s at %s: %v", e.SnapshotID, e.TreeID, e.Path, e.Err)
+}
+
+func (e *TreeError) Unwrap() error {
+	return e.Err
+}
+
+// checkProblem is the JSON encoding of a typed check finding. A TreeError
+// for missing file data includes the fields of its BlobError.
+type checkProblem struct {
+	Type       string     `json:"type"` // "pack", "blob", "index", "tree" or "other"
+	SnapshotID SnapshotID `json:"snapshot_id,omitempty"`
+	TreeID     string     `json:"tree_id,omitempty"`
+	Path       string     `json:"path,omitempty"`
+	BlobID     string     `json:"blob_id,omitempty"`
+	PackID     string     `json:"pack_id,omitempty"`
+	Error      string     `json:"error"`
+}
+
+// newCheckProblem returns the JSON encoding of a finding
+func newCheckProblem(problem error) checkProblem {
+	switch e := problem.(type) {
+	case *PackError:
+		return checkProblem{Type: "pack", PackID: e.PackID, Error: e.Err.Error()}
+	case *BlobError:
+		return checkProblem{Type: "blob", BlobID: e.BlobID, PackID: e.PackID, Error: e.Err.Error()}
+	case *IndexError:
+		return checkProblem{Type: "index", Error: e.Err.Error()}
+	case *TreeError:
+		p := checkProblem{Type: "tree", SnapshotID: e.SnapshotID, TreeID: e.TreeID, Path: e.Path, Error: e.Err.Error()}
+		if blobErr, ok := e.Err.(*BlobError); ok {
+			p.BlobID, p.PackID, p.Error = blobErr.BlobID, blobErr.PackID, blobErr.Err.Error()
+		}
+		return p
+	default:
+		return checkProblem{Type: "other", Error: problem.Error()}
+	}
+}
+
+// problem returns the typed finding of the JSON encoding
+func (p checkProblem) problem() error {
+	err := errors.New(p.Error)
+	switch p.Type {
+	case "pack":
+		return &PackError{PackID: p.PackID, Err: err}
+	case "blob":
+		return &BlobError{BlobID: p.BlobID, PackID: p.PackID, Err: err}
+	case "index":
+		return &IndexError{Err: err}
+	case "tree":
+		if p.BlobID != "" {
+			err = &BlobError{BlobID: p.BlobID, PackID: p.PackID, Err: err}
+		}
+		return &TreeError{SnapshotID: p.SnapshotID, TreeID: p.TreeID, Path: p.Path, Err: err}
+	default:
+		return err
+	}
+}
+
+// checkReportJSON prevents the recursion of the JSON methods of CheckReport
+type checkReportJSON CheckReport
+
+// MarshalJSON encodes Problems as objects describing the typed findings
+func (r CheckReport) MarshalJSON() ([]byte, error) {
+	problems := make([]checkProblem, 0, len(r.Problems))
+	for _, problem := range r.Problems {
+		problems = append(problems, newCheckProblem(problem))
+	}
+	return json.Marshal(struct {
+		checkReportJSON
+		Problems []checkProblem `json:"problems,omitempty"`
+	}{checkReportJSON(r), problems})
+}
+
+// UnmarshalJSON decodes the findings encoded by MarshalJSON, their Err only
+// keeps the message
+func (r *CheckReport) UnmarshalJSON(buf []byte) error {
+	var v struct {
+		checkReportJSON
+		Problems []checkProblem `json:"problems,omitempty"`
+	}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	*r = CheckReport(v.checkReportJSON)
+	r.Problems = nil
+	for _, p := range v.Problems {
+		r.Problems = append(r.Problems, p.problem())
+	}
+	return nil
+}
+
+// checkRun collects the findings of a running check and forwards them as events
+type checkRun struct {
+	opts     CheckOptions
+	report   CheckReport
+	progress CheckEvent
+
+	// damagedPacks were found damaged while reading data
+	damagedPacks restic.IDSet
+}
+
+// newCheckRun returns a check run with an empty, successful report
+func newCheckRun(opts CheckOptions) *checkRun {
+	return &checkRun{
+		opts:         opts,
+		damagedPacks: restic.NewIDSet(),
+		report: CheckReport{
+			Errors:   []string{},
+			Warnings: []string{},
+			Success:  true,
+		},
+	}
+}
+
+// emit passes an event based on the current progress to the callback
+func (c *checkRun) emit(phase CheckPhase, pack string, errMsg string, warning string) {
+	if c.opts.OnEvent == nil {
+		return
+	}
+	ev := c.progress
+	ev.Phase = phase
+	ev.Pack = pack
+	ev.Error = errMsg
+	ev.Warning = warning
+	c.opts.OnEvent(ev)
+}
+
+// addError records an error in the report along with the problems found
+func (c *checkRun) addError(phase CheckPhase, pack string, msg string, problems ...error) {
+	c.report.Errors = append(c.report.Errors, msg)
+	c.report.Problems = append(c.report.Problems, problems...)
+	c.report.Success = false
+	c.emit(phase, pack, msg, "")
+}
+
+// addWarning records a warning in the report
+func (c *checkRun) addWarning(phase CheckPhase, msg string) {
+	c.report.Warnings = append(c.report.Warnings, msg)
+	c.emit(phase, "", "", msg)
+}
+
+// abort marks the report as incomplete
+func (c *checkRun) abort(err error) (CheckReport, error) {
+	c.report.Incomplete = true
+	c.report.Success = false
+	return c.report, err
+}
+
+// Check verifies repository integrity and streams progress events
+func (r *repositoryImpl) Check(ctx context.Context, opts CheckOptions) (report CheckReport, err error) {
+	if err := r.begin(); err != nil {
+		return CheckReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	// notify with the original context, the lock context is cancelled on unlock
+	defer func(ctx context.Context) { r.notify(ctx, "check", start, report, report.Success, err) }(ctx)
+
+	r.logf("info", "Starting integrity check (depth: %s)", opts.Depth)
+
+	subset, err := parseReadDataSubset(opts.ReadDataSubset)
+	if err != nil {
+		return CheckReport{}, err
+	}
+	if opts.Repair && opts.Lock.NoLock {
+		return CheckReport{}, errors.New("repairing the repository requires a lock")
+	}
+
+	ctx, unlock, err := r.lock(ctx, opts.Lock)
+	if err != nil {
+		return CheckReport{}, err
+	}
+	defer unlock()
+
+	if opts.Progress != nil {
+		defer opts.Progress.Finish()
+	}
+	run := newCheckRun(opts)
+
+	report, err = r.checkRepository(ctx, run, subset)
+	if !opts.Repair || report.Success || report.Incomplete {
+		return report, err
 	}
 
+	// the findings and the error of the check remain in the report
+	repair, repairErr := r.repairFindings(ctx, run)
+	report.Repair = &repair
+	if repairErr != nil {
+		return report, errors.Join(err, fmt.Errorf("repair failed: %w", repairErr))
+	}
+	return report, err
+}
+
+// checkRepository runs the checks of Check
+func (r *repositoryImpl) checkRepository(ctx context.Context, run *checkRun, subset *readDataSubset) (CheckReport, error) {
+	opts := run.opts
+
 	// Load index
 	err := r.repo.LoadIndex(ctx, nil)
 	if err != nil {
-		report.Errors = append(report.Errors, fmt.Sprintf("failed to load index: %v", err))
-		report.Success = false
-		return report, err
+		run.addError(CheckPhaseIndex, "", fmt.Sprintf("failed to load index: %v", err), &IndexError{Err: err})
+		return run.report, err
 	}
 
 	// Create checker
@@ -33,22 +301,22 @@ func (r *repositoryImpl) Check(ctx context.Context, depth CheckDepth) (CheckRepo
 
 	// Process hints (warnings)
 	for _, hint := range hints {
-		report.Warnings = append(report.Warnings, hint.Error())
+		run.addWarning(CheckPhaseIndex, hint.Error())
 	}
 
 	// Process errors
 	for _, err := range errs {
-		report.Errors = append(report.Errors, err.Error())
-		report.Success = false
+		run.addError(CheckPhaseIndex, "", err.Error(), &IndexError{Err: err})
 	}
 
 	if len(errs) > 0 {
 		r.logf("error", "Index check failed with %d errors", len(errs))
-		return report, fmt.Errorf("index check failed")
+		return run.report, fmt.Errorf("index check failed")
 	}
 
 	// Check packs
 	r.logf("debug", "Checking pack files")
+	run.progress.PacksTotal = checker.CountPacks()
 	errChan := make(chan error, 100)
 	go func() {
 		checker.Packs(ctx, errChan)
@@ -57,49 +325,401 @@ func (r *repositoryImpl) Check(ctx context.Context, depth CheckDepth) (CheckRepo
 
 	packErrors := 0
 	for err := range errChan {
-		report.Errors = append(report.Errors, fmt.Sprintf("pack error: %v", err))
-		report.Success = false
+		problem := &PackError{Err: err}
+		if packErr, ok := err.(*repository.PackError); ok {
+			problem = &PackError{PackID: packErr.ID.String(), Err: packErr.Err}
+		}
+		run.addError(CheckPhasePacks, problem.PackID, fmt.Sprintf("pack error: %v", err), problem)
 		packErrors++
 	}
 
 	if packErrors > 0 {
 		r.logf("error", "Pack check failed with %d errors", packErrors)
 	}
+	if ctx.Err() != nil {
+		return run.abort(ctx.Err())
+	}
 
 	// For read-data depth, actually read and verify data
-	if depth == CheckDepthReadData {
-		r.logf("debug", "Reading and verifying pack data")
-
-		dataErrChan := make(chan error, 100)
-		go func() {
-			checker.ReadData(ctx, dataErrChan)
-			// Note: ReadData() -> ReadPacks() closes the channel itself
-		}()
+	if opts.Depth == CheckDepthReadData || subset != nil {
+		packs := checker.GetPacks()
+		if subset != nil {
+			packs = subset.selectPacks(packs)
+			r.logf("debug", "Reading and verifying %d of %d packs (subset %s)",
+				len(packs), checker.CountPacks(), opts.ReadDataSubset)
+		} else {
+			r.logf("debug", "Reading and verifying pack data")
+		}
 
-		dataErrors := 0
-		for err := range dataErrChan {
-			report.Errors = append(report.Errors, fmt.Sprintf("data error: %v", err))
-			report.Success = false
-			dataErrors++
+		errCount := len(run.report.Errors)
+		err := r.readPacks(ctx, packs, run)
+		if err != nil {
+			return run.abort(err)
 		}
 
-		if dataErrors > 0 {
+		if dataErrors := len(run.report.Errors) - errCount; dataErrors > 0 {
 			r.logf("error", "Data verification failed with %d errors", dataErrors)
 		}
 	}
 
-	if report.Success {
+	if run.report.Success {
 		r.logf("info", "Integrity check completed successfully")
 	} else {
 		r.logf("error", "Integrity check found %d errors and %d warnings",
-			len(report.Errors), len(report.Warnings))
+			len(run.report.Errors), len(run.report.Warnings))
+	}
+
+	return run.report, nil
+}
+
+// repairFindings repairs the repository after a check found errors: the index
+// is rebuilt, the intact blobs of damaged packs are salvaged and snapshots
+// referencing lost data are replaced by repaired ones. The caller must hold an
+// exclusive lock.
+func (r *repositoryImpl) repairFindings(ctx context.Context, run *checkRun) (report CheckRepairReport, err error) {
+	r.logf("info", "Repairing %d errors found by the check", len(run.report.Errors))
+
+	report.Index, err = r.repairIndexLocked(ctx, false)
+	if err != nil {
+		return report, err
+	}
+
+	if len(run.damagedPacks) > 0 {
+		report.Packs, err = r.repairPacksLocked(ctx, run.damagedPacks)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	snapshots, err := r.selectSnapshots(ctx, nil, SnapshotFilter{})
+	if err != nil {
+		return report, err
 	}
+	// the originals still reference the lost data and would fail every check
+	report.Snapshots, err = r.repairSnapshotsLocked(ctx, snapshots, true, false)
+	return report, err
+}
+
+// CheckSnapshot verifies the trees and data referenced by a single snapshot
+func (r *repositoryImpl) CheckSnapshot(ctx context.Context, snapshotID SnapshotID, depth CheckDepth) (CheckReport, error) {
+	if err := r.begin(); err != nil {
+		return CheckReport{}, err
+	}
+	defer r.end()
+
+	r.logf("info", "Starting integrity check of snapshot %s (depth: %s)", snapshotID, depth)
+
+	run := newCheckRun(CheckOptions{Depth: depth})
+
+	sn, _, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return run.report, fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		run.addError(CheckPhaseIndex, "", fmt.Sprintf("failed to load index: %v", err), &IndexError{Err: err})
+		return run.report, err
+	}
+
+	// Walk the snapshot and collect the packs containing its blobs
+	usedPacks := restic.NewIDSet()
+	lookup := func(tpe restic.BlobType, id restic.ID) bool {
+		blobs := r.repo.LookupBlob(tpe, id)
+		for _, pb := range blobs {
+			usedPacks.Insert(pb.PackID)
+		}
+		return len(blobs) > 0
+	}
+
+	r.logf("debug", "Checking trees of snapshot %s", sn.ID().Str())
+	wg, wctx := errgroup.WithContext(ctx)
+	seen := restic.NewIDSet()
+	treeStream := data.StreamTrees(wctx, wg, r.repo, restic.IDs{*sn.Tree}, func(id restic.ID) bool {
+		visited := seen.Has(id)
+		seen.Insert(id)
+		return visited
+	}, nil)
+
+	// trees are streamed after their parent, which records their path
+	paths := map[restic.ID]string{*sn.Tree: "/"}
+	fullID := SnapshotID(sn.ID().String())
+	for item := range treeStream {
+		if item.Error != nil {
+			run.addError(CheckPhaseStructure, "", fmt.Sprintf("tree %v: %v", item.ID.Str(), item.Error),
+				&TreeError{SnapshotID: fullID, TreeID: item.ID.String(), Path: paths[item.ID], Err: item.Error})
+			continue
+		}
+		lookup(restic.TreeBlob, item.ID)
+
+		for _, node := range item.Nodes {
+			nodePath := path.Join(paths[item.ID], node.Name)
+			if node.Subtree != nil {
+				if _, ok := paths[*node.Subtree]; !ok {
+					paths[*node.Subtree] = nodePath
+				}
+			}
+			if node.Type != data.NodeTypeFile {
+				continue
+			}
+			for _, blobID := range node.Content {
+				if !lookup(restic.DataBlob, blobID) {
+					run.addError(CheckPhaseStructure, "", fmt.Sprintf("tree %v: file %q blob %v not found in index",
+						item.ID.Str(), node.Name, blobID.Str()),
+						&TreeError{SnapshotID: fullID, TreeID: item.ID.String(), Path: nodePath,
+							Err: &BlobError{BlobID: blobID.String(), Err: errors.New("not found in index")}})
+				}
+			}
+		}
+	}
+	if err := wg.Wait(); err != nil {
+		return run.abort(err)
+	}
+	if ctx.Err() != nil {
+		return run.abort(ctx.Err())
+	}
+
+	if depth == CheckDepthFull || depth == CheckDepthReadData {
+		r.logf("debug", "Checking %d pack files", len(usedPacks))
+		packs := make(map[restic.ID]int64, len(usedPacks))
+		err = r.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+			if usedPacks.Has(id) {
+				packs[id] = size
+			}
+			return nil
+		})
+		if err != nil {
+			return run.abort(fmt.Errorf("failed to list packs: %w", err))
+		}
+
+		for id := range usedPacks {
+			if _, ok := packs[id]; !ok {
+				run.addError(CheckPhasePacks, id.String(), fmt.Sprintf("pack error: pack %v does not exist", id.Str()),
+					&PackError{PackID: id.String(), Err: errors.New("does not exist")})
+			}
+		}
+
+		if depth == CheckDepthReadData {
+			err = r.readPacks(ctx, packs, run)
+			if err != nil {
+				return run.abort(err)
+			}
+		}
+	}
+
+	if run.report.Success {
+		r.logf("info", "Integrity check of snapshot %s completed successfully", sn.ID().Str())
+	} else {
+		r.logf("error", "Integrity check of snapshot %s found %d errors", sn.ID().Str(), len(run.report.Errors))
+	}
+
+	return run.report, nil
+}
+
+// packProblems converts an error reading a pack into the damaged blobs of the
+// pack, or the pack itself if the error does not concern single blobs
+func packProblems(id restic.ID, err error) []error {
+	var packErr *repository.ErrPackData
+	if !errors.As(err, &packErr) {
+		return []error{&PackError{PackID: id.String(), Err: err}}
+	}
+
+	var problems []error
+	for _, err := range packErr.Errors() {
+		var blobErr *repository.ErrBlobData
+		if errors.As(err, &blobErr) {
+			problems = append(problems, &BlobError{BlobID: blobErr.Handle.ID.String(), PackID: id.String(), Err: blobErr.Err})
+		} else {
+			problems = append(problems, &PackError{PackID: id.String(), Err: err})
+		}
+	}
+	return problems
+}
+
+// readDataSubsetBucketsMax is the maximum number of buckets of a subset, the
+// bucket of a pack is selected by the first byte of its ID
+const readDataSubsetBucketsMax = 256
+
+// readDataSubset selects the packs read by a check, either bucket n of t, a
+// random percentage or a random selection of about size bytes
+type readDataSubset struct {
+	bucket, buckets uint
+	percentage      float64
+	size            int64
+}
+
+// parseReadDataSubset parses "n/t", "x%" or a size such as "500M", it
+// returns nil for an empty string
+func parseReadDataSubset(s string) (*readDataSubset, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if n, t, ok := strings.Cut(s, "/"); ok {
+		bucket, err1 := strconv.ParseUint(n, 10, 0)
+		buckets, err2 := strconv.ParseUint(t, 10, 0)
+		if err1 != nil || err2 != nil || bucket == 0 || bucket > buckets || buckets > readDataSubsetBucketsMax {
+			return nil, fmt.Errorf("invalid read data subset %q, n/t must satisfy 0 < n <= t <= %d", s, readDataSubsetBucketsMax)
+		}
+		return &readDataSubset{bucket: uint(bucket), buckets: uint(buckets)}, nil
+	}
+
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percentage, err := strconv.ParseFloat(p, 64)
+		if err != nil || percentage <= 0 || percentage > 100 {
+			return nil, fmt.Errorf("invalid read data subset %q, the percentage must be above 0%% and at most 100%%", s)
+		}
+		return &readDataSubset{percentage: percentage}, nil
+	}
+
+	size, err := ui.ParseBytes(s)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid read data subset %q, expected n/t, a percentage or a size", s)
+	}
+	return &readDataSubset{size: size}, nil
+}
+
+// selectPacks returns the packs of the subset
+func (s *readDataSubset) selectPacks(packs map[restic.ID]int64) map[restic.ID]int64 {
+	if s.buckets > 0 {
+		selected := make(map[restic.ID]int64)
+		for id, size := range packs {
+			if uint(id[0])%s.buckets == s.bucket-1 {
+				selected[id] = size
+			}
+		}
+		return selected
+	}
+
+	percentage := s.percentage
+	if s.size > 0 {
+		var total int64
+		for _, size := range packs {
+			total += size
+		}
+		percentage = 100
+		if total > s.size {
+			percentage = float64(s.size) / float64(total) * 100
+		}
+	}
+
+	ids := make(restic.IDs, 0, len(packs))
+	for id := range packs {
+		ids = append(ids, id)
+	}
+	rand.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	n := int(float64(len(ids)) * percentage / 100)
+	if n == 0 && len(ids) > 0 {
+		n = 1
+	}
+
+	selected := make(map[restic.ID]int64, n)
+	for _, id := range ids[:n] {
+		selected[id] = packs[id]
+	}
+	return selected
+}
+
+// readPacks reads and verifies the given packs. Each checked pack is reported
+// to the check run as soon as it is done.
+func (r *repositoryImpl) readPacks(ctx context.Context, packs map[restic.ID]int64, run *checkRun) error {
+	type result struct {
+		id   restic.ID
+		size int64
+		err  error
+	}
+
+	packSet := restic.NewIDSet()
+	run.progress = CheckEvent{PacksTotal: uint64(len(packs))}
+	for id, size := range packs {
+		packSet.Insert(id)
+		run.progress.BytesTotal += uint64(size)
+	}
+	if run.opts.Progress != nil {
+		run.opts.Progress.SetTotal(run.progress.BytesTotal)
+	}
+
+	// stops the workers if the progress reporter aborts the check
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tasks := make(chan restic.PackBlobs)
+	results := make(chan result)
+
+	go func() {
+		defer close(tasks)
+		for pbs := range r.repo.ListPacksFromIndex(ctx, packSet) {
+			select {
+			case tasks <- pbs:
+			case <-ctx.Done():
+			}
+		}
+	}()
 
-	return report, nil
+	// as packs are streamed the concurrency is limited by IO
+	var workers sync.WaitGroup
+	for i := 0; i < int(r.repo.Connections()); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			bufRd := bufio.NewReaderSize(nil, checkBufferSize)
+			dec, err := zstd.NewReader(nil)
+			if err != nil {
+				panic(err)
+			}
+			defer dec.Close()
+
+			for pbs := range tasks {
+				size := packs[pbs.PackID]
+				err := repository.CheckPack(ctx, r.repo, pbs.PackID, pbs.Blobs, size, bufRd, dec)
+				select {
+				case results <- result{id: pbs.PackID, size: size, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	for res := range results {
+		run.progress.PacksChecked++
+		run.progress.BytesRead += uint64(res.size)
+		if run.opts.Progress != nil {
+			run.opts.Progress.Add(uint64(res.size))
+		}
+		if res.err != nil {
+			run.addError(CheckPhaseReadData, res.id.String(), fmt.Sprintf("data error: %v", res.err), packProblems(res.id, res.err)...)
+			var packErr *repository.ErrPackData
+			if errors.As(res.err, &packErr) {
+				run.damagedPacks.Insert(res.id)
+			}
+			if run.opts.Progress != nil {
+				if err := run.opts.Progress.Error(res.id.String(), res.err); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		run.emit(CheckPhaseReadData, res.id.String(), "", "")
+	}
+
+	return ctx.Err()
 }
 
 // Unlock removes stale locks from repository
 func (r *repositoryImpl) Unlock(ctx context.Context) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
 	r.logf("info", "Removing stale locks from repository")
 
 	// Use the internal RemoveStaleLocks function which handles the proper lock removal
@@ -116,3 +736,22 @@ func (r *repositoryImpl) Unlock(ctx context.Context) error {
 
 	return nil
 }
+
+// UnlockAll removes all locks from the repository, including locks of
+// clients which are still running, and returns the number of removed locks
+func (r *repositoryImpl) UnlockAll(ctx context.Context) (int, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	r.logf("warn", "Removing all locks from repository")
+
+	removedCount, err := repository.RemoveAllLocks(ctx, r.repo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove locks: %w", err)
+	}
+
+	r.logf("info", "Removed %d locks", removedCount)
+	return int(removedCount), nil
+}
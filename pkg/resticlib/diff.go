@@ -0,0 +1,194 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// changeCollector accumulates the differences between two trees
+type changeCollector struct {
+	repo    *repository.Repository
+	summary ChangeSummary
+
+	// data blobs referenced by removed/changed and added/changed files
+	oldBlobs restic.BlobSet
+	newBlobs restic.BlobSet
+}
+
+// ChangeSummary computes a compact summary of the changes between a snapshot
+// and its parent. Snapshots without a parent are compared against an empty tree.
+func (r *repositoryImpl) ChangeSummary(ctx context.Context, snapshotID SnapshotID) (ChangeSummary, error) {
+	if err := r.begin(); err != nil {
+		return ChangeSummary{}, err
+	}
+	defer r.end()
+
+	sn, _, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return ChangeSummary{}, fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	var parentTree *restic.ID
+	var parentID SnapshotID
+	if sn.Parent != nil {
+		parent, err := data.LoadSnapshot(ctx, r.repo, *sn.Parent)
+		if err != nil {
+			return ChangeSummary{}, fmt.Errorf("failed to load parent snapshot: %w", err)
+		}
+		parentTree = parent.Tree
+		parentID = SnapshotID(sn.Parent.String())
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return ChangeSummary{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	c := &changeCollector{
+		repo:     r.repo,
+		oldBlobs: restic.NewBlobSet(),
+		newBlobs: restic.NewBlobSet(),
+	}
+	c.summary.Parent = parentID
+
+	err = c.diffTrees(ctx, parentTree, sn.Tree)
+	if err != nil {
+		return ChangeSummary{}, fmt.Errorf("failed to compare trees: %w", err)
+	}
+
+	for h := range c.newBlobs.Sub(c.oldBlobs) {
+		if size, found := r.repo.LookupBlobSize(h.Type, h.ID); found {
+			c.summary.BytesAdded += uint64(size)
+		}
+	}
+	for h := range c.oldBlobs.Sub(c.newBlobs) {
+		if size, found := r.repo.LookupBlobSize(h.Type, h.ID); found {
+			c.summary.BytesRemoved += uint64(size)
+		}
+	}
+
+	r.logf("debug", "Snapshot %s changes: %+v", sn.ID().Str(), c.summary)
+	return c.summary, nil
+}
+
+// loadTree loads a tree, a nil ID results in an empty tree
+func (c *changeCollector) loadTree(ctx context.Context, id *restic.ID) (*data.Tree, error) {
+	if id == nil {
+		return data.NewTree(0), nil
+	}
+	return data.LoadTree(ctx, c.repo, *id)
+}
+
+// diffTrees compares two trees recursively, identical subtrees are skipped
+func (c *changeCollector) diffTrees(ctx context.Context, oldID, newID *restic.ID) error {
+	if oldID != nil && newID != nil && oldID.Equal(*newID) {
+		return nil
+	}
+
+	oldTree, err := c.loadTree(ctx, oldID)
+	if err != nil {
+		return err
+	}
+	newTree, err := c.loadTree(ctx, newID)
+	if err != nil {
+		return err
+	}
+
+	oldNodes := make(map[string]*data.Node, len(oldTree.Nodes))
+	for _, node := range oldTree.Nodes {
+		oldNodes[node.Name] = node
+	}
+
+	for _, node := range newTree.Nodes {
+		old, ok := oldNodes[node.Name]
+		delete(oldNodes, node.Name)
+
+		switch {
+		case !ok || old.Type != node.Type:
+			if ok {
+				if err := c.addNode(ctx, old, false); err != nil {
+					return err
+				}
+			}
+			if err := c.addNode(ctx, node, true); err != nil {
+				return err
+			}
+
+		case node.Type == data.NodeTypeDir:
+			if err := c.diffTrees(ctx, old.Subtree, node.Subtree); err != nil {
+				return err
+			}
+
+		case node.Type == data.NodeTypeFile && !sameContent(old, node):
+			c.summary.FilesModified++
+			c.collectBlobs(old, c.oldBlobs)
+			c.collectBlobs(node, c.newBlobs)
+		}
+	}
+
+	// all remaining nodes only exist in the old tree
+	for _, node := range oldNodes {
+		if err := c.addNode(ctx, node, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addNode records a node and everything below it as added or removed
+func (c *changeCollector) addNode(ctx context.Context, node *data.Node, added bool) error {
+	switch node.Type {
+	case data.NodeTypeFile:
+		if added {
+			c.summary.FilesAdded++
+			c.collectBlobs(node, c.newBlobs)
+		} else {
+			c.summary.FilesRemoved++
+			c.collectBlobs(node, c.oldBlobs)
+		}
+
+	case data.NodeTypeDir:
+		if added {
+			c.summary.DirsAdded++
+		} else {
+			c.summary.DirsRemoved++
+		}
+		if node.Subtree == nil {
+			return nil
+		}
+		tree, err := c.loadTree(ctx, node.Subtree)
+		if err != nil {
+			return err
+		}
+		for _, child := range tree.Nodes {
+			if err := c.addNode(ctx, child, added); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectBlobs adds the data blobs of a file to the set
+func (c *changeCollector) collectBlobs(node *data.Node, blobs restic.BlobSet) {
+	for _, id := range node.Content {
+		blobs.Insert(restic.BlobHandle{ID: id, Type: restic.DataBlob})
+	}
+}
+
+// sameContent returns true if both files reference the same data blobs
+func sameContent(a, b *data.Node) bool {
+	if len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !a.Content[i].Equal(b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
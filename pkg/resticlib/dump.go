@@ -0,0 +1,129 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/dump"
+)
+
+// ArchiveFormat is the format of archives written by DumpArchive
+type ArchiveFormat string
+
+// Archive formats
+const (
+	ArchiveTar ArchiveFormat = "tar"
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+// DumpFile writes the content of a single file from a snapshot to w, without
+// restoring anything else. The path is relative to the snapshot root, e.g.
+// "/home/user/notes.txt".
+func (r *repositoryImpl) DumpFile(ctx context.Context, snapshotID SnapshotID, filename string, w io.Writer) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	sn, subfolder, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	node, err := r.findNode(ctx, sn, path.Join(subfolder, filename))
+	if err != nil {
+		return err
+	}
+	if node.Type != data.NodeTypeFile {
+		return fmt.Errorf("%q is a %s, not a file", filename, node.Type)
+	}
+
+	r.logf("debug", "Dumping %s (%d bytes) from snapshot %s", filename, node.Size, sn.ID().Str())
+
+	err = dump.New("tar", r.repo, w).WriteNode(ctx, node)
+	if err != nil {
+		return fmt.Errorf("failed to dump %s: %w", filename, err)
+	}
+	return nil
+}
+
+// DumpArchive writes a path of a snapshot as tar or zip archive to w. The
+// archive of a directory contains the directory and all its contents, paths
+// in the archive are relative to the snapshot root. Use "/" to dump the
+// entire snapshot.
+func (r *repositoryImpl) DumpArchive(ctx context.Context, snapshotID SnapshotID, dumpPath string, format ArchiveFormat, w io.Writer) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if format != ArchiveTar && format != ArchiveZip {
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+
+	sn, subfolder, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	var tree *data.Tree
+	dumpPath = path.Clean("/" + path.Join(subfolder, dumpPath))
+	if dumpPath == "/" {
+		tree, err = data.LoadTree(ctx, r.repo, *sn.Tree)
+		if err != nil {
+			return fmt.Errorf("failed to load tree: %w", err)
+		}
+	} else {
+		node, err := r.findNode(ctx, sn, dumpPath)
+		if err != nil {
+			return err
+		}
+		// the archive contains the node itself, placed below its parent
+		tree = &data.Tree{Nodes: []*data.Node{node}}
+		dumpPath = path.Dir(dumpPath)
+	}
+
+	r.logf("debug", "Dumping %s of snapshot %s as %s archive", dumpPath, sn.ID().Str(), format)
+
+	err = dump.New(string(format), r.repo, w).DumpTree(ctx, tree, dumpPath)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// findNode returns the node at a path of the snapshot
+func (r *repositoryImpl) findNode(ctx context.Context, sn *data.Snapshot, nodepath string) (*data.Node, error) {
+	dir, name := path.Split(path.Clean("/" + nodepath))
+	if name == "" {
+		return nil, fmt.Errorf("path %q not found in snapshot", nodepath)
+	}
+
+	treeID, err := data.FindTreeDirectory(ctx, r.repo, sn.Tree, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %q: %w", nodepath, err)
+	}
+	tree, err := data.LoadTree(ctx, r.repo, *treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tree: %w", err)
+	}
+
+	node := tree.Find(name)
+	if node == nil {
+		return nil, fmt.Errorf("path %q not found in snapshot", nodepath)
+	}
+	return node, nil
+}
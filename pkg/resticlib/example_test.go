@@ -80,7 +80,7 @@ func Example() {
 	}
 
 	// Check repository integrity
-	checkReport, err := repo.Check(ctx, resticlib.CheckDepthDefault)
+	checkReport, err := repo.Check(ctx, resticlib.CheckOptions{Depth: resticlib.CheckDepthDefault})
 	if err != nil {
 		fmt.Printf("Check failed: %v\n", err)
 		return
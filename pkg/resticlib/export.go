@@ -0,0 +1,345 @@
+package resticlib
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// exportHeader starts every export stream, the digit is the format version
+const exportHeader = "resticlib-export-1\n"
+
+// record types of an export stream. Each record consists of the type, the
+// length of the payload as uint32 and the payload. Blob payloads start with
+// the blob ID.
+const (
+	exportRecordTree     byte = 'T'
+	exportRecordData     byte = 'D'
+	exportRecordSnapshot byte = 'S'
+	exportRecordEnd      byte = 'E'
+)
+
+// maxExportRecordSize limits the payload of a record, blobs are never larger
+// than a pack file
+const maxExportRecordSize = repository.MaxPackSize + len(restic.ID{})
+
+// ExportOptions control which data is written by ExportSnapshot
+type ExportOptions struct {
+	// Base are snapshots the receiving repository already has, blobs
+	// referenced by them are not exported (optional)
+	Base []SnapshotID `json:"base,omitempty"`
+}
+
+// ExportReport summarizes an export or import
+type ExportReport struct {
+	Snapshot SnapshotID `json:"snapshot"`
+	Blobs    int        `json:"blobs"`
+	Bytes    uint64     `json:"bytes"`
+
+	// BlobsSkipped counts blobs which were not exported because a base
+	// snapshot references them, or not imported because they already existed
+	BlobsSkipped int `json:"blobs_skipped"`
+}
+
+// ExportSnapshot writes the snapshot and all blobs it references, except
+// those referenced by the base snapshots, to w. The stream can be imported
+// into another repository with ImportSnapshot, e.g. to transfer snapshots to
+// an air-gapped system. The stream is compressed but NOT encrypted, it
+// contains the plaintext of all exported files.
+func (r *repositoryImpl) ExportSnapshot(ctx context.Context, snapshotID SnapshotID, w io.Writer, opts ExportOptions) (ExportReport, error) {
+	if err := r.begin(); err != nil {
+		return ExportReport{}, err
+	}
+	defer r.end()
+
+	sn, _, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return ExportReport{}, fmt.Errorf("failed to find snapshot: %w", err)
+	}
+	id := *sn.ID()
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return ExportReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	var baseTrees restic.IDs
+	for _, base := range opts.Base {
+		bsn, _, err := r.findSnapshot(ctx, string(base), SnapshotFilter{})
+		if err != nil {
+			return ExportReport{}, fmt.Errorf("failed to find base snapshot %s: %w", base, err)
+		}
+		baseTrees = append(baseTrees, *bsn.Tree)
+	}
+
+	baseBlobs := restic.NewBlobSet()
+	err = data.FindUsedBlobs(ctx, r.repo, baseTrees, baseBlobs, nil)
+	if err != nil {
+		return ExportReport{}, fmt.Errorf("failed to find blobs of base snapshots: %w", err)
+	}
+
+	blobs := restic.NewBlobSet()
+	err = data.FindUsedBlobs(ctx, r.repo, restic.IDs{*sn.Tree}, blobs, nil)
+	if err != nil {
+		return ExportReport{}, fmt.Errorf("failed to find blobs of snapshot: %w", err)
+	}
+
+	report := ExportReport{Snapshot: SnapshotID(id.String())}
+	packs := restic.NewIDSet()
+	for h := range blobs {
+		if baseBlobs.Has(h) {
+			blobs.Delete(h)
+			report.BlobsSkipped++
+			continue
+		}
+		for _, pb := range r.repo.LookupBlob(h.Type, h.ID) {
+			packs.Insert(pb.PackID)
+			break
+		}
+	}
+
+	r.logf("info", "Exporting %d blobs of snapshot %s", len(blobs), id.Str())
+
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		return report, err
+	}
+	// stops the encoder goroutines on errors, closing again is a no-op
+	defer func() { _ = enc.Close() }()
+	bw := bufio.NewWriter(enc)
+	if _, err := bw.WriteString(exportHeader); err != nil {
+		return report, fmt.Errorf("failed to write export: %w", err)
+	}
+
+	// blobs are read pack by pack, each blob is written only once even if
+	// it is stored in several packs
+	for pbs := range r.repo.ListPacksFromIndex(ctx, packs) {
+		var wanted []restic.Blob
+		for _, blob := range pbs.Blobs {
+			if blobs.Has(blob.BlobHandle) {
+				wanted = append(wanted, blob)
+				blobs.Delete(blob.BlobHandle)
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+
+		err := r.repo.LoadBlobsFromPack(ctx, pbs.PackID, wanted, func(h restic.BlobHandle, buf []byte, err error) error {
+			if err != nil {
+				return err
+			}
+			tpe := exportRecordData
+			if h.Type == restic.TreeBlob {
+				tpe = exportRecordTree
+			}
+			report.Blobs++
+			report.Bytes += uint64(len(buf))
+			return writeExportRecord(bw, tpe, h.ID[:], buf)
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to export pack %s: %w", pbs.PackID.Str(), err)
+		}
+	}
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+	if len(blobs) > 0 {
+		return report, fmt.Errorf("%d blobs of snapshot %s not found in index", len(blobs), id.Str())
+	}
+
+	buf, err := json.Marshal(sn)
+	if err != nil {
+		return report, err
+	}
+	if err := writeExportRecord(bw, exportRecordSnapshot, id[:], buf); err != nil {
+		return report, err
+	}
+	if err := writeExportRecord(bw, exportRecordEnd, nil, nil); err != nil {
+		return report, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return report, fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return report, fmt.Errorf("failed to write export: %w", err)
+	}
+
+	r.logf("info", "Exported %d blobs (%d bytes) of snapshot %s", report.Blobs, report.Bytes, id.Str())
+	return report, nil
+}
+
+// ImportSnapshot reads a stream written by ExportSnapshot and saves the
+// contained blobs and the snapshot. Blobs referenced by the base snapshots of
+// the export must already exist in the repository, otherwise the import
+// fails before the snapshot is saved.
+func (r *repositoryImpl) ImportSnapshot(ctx context.Context, rd io.Reader) (ExportReport, error) {
+	if err := r.begin(); err != nil {
+		return ExportReport{}, err
+	}
+	defer r.end()
+
+	// keep prune from removing the imported blobs before the snapshot is saved
+	ctx, unlock, err := r.lockShared(ctx, LockOptions{})
+	if err != nil {
+		return ExportReport{}, err
+	}
+	defer unlock()
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return ExportReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	dec, err := zstd.NewReader(rd)
+	if err != nil {
+		return ExportReport{}, err
+	}
+	defer dec.Close()
+	br := bufio.NewReader(dec)
+
+	header := make([]byte, len(exportHeader))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != exportHeader {
+		return ExportReport{}, fmt.Errorf("invalid export stream")
+	}
+
+	var report ExportReport
+	var sn *data.Snapshot
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	r.repo.StartPackUploader(wgCtx, wg)
+	wg.Go(func() error {
+		for {
+			tpe, payload, err := readExportRecord(br)
+			if err != nil {
+				return err
+			}
+
+			switch tpe {
+			case exportRecordTree, exportRecordData:
+				blobType := restic.DataBlob
+				if tpe == exportRecordTree {
+					blobType = restic.TreeBlob
+				}
+				var id restic.ID
+				copy(id[:], payload)
+				buf := payload[len(id):]
+				if restic.Hash(buf) != id {
+					return fmt.Errorf("invalid export stream: blob %s has wrong hash", id.Str())
+				}
+
+				_, known, _, err := r.repo.SaveBlob(wgCtx, blobType, buf, id, false)
+				if err != nil {
+					return fmt.Errorf("failed to save blob %s: %w", id.Str(), err)
+				}
+				if known {
+					report.BlobsSkipped++
+					continue
+				}
+				report.Blobs++
+				report.Bytes += uint64(len(buf))
+
+			case exportRecordSnapshot:
+				sn = &data.Snapshot{}
+				if err := json.Unmarshal(payload[len(restic.ID{}):], sn); err != nil {
+					return fmt.Errorf("invalid export stream: %w", err)
+				}
+				// keep the ID of the exported snapshot, like copy does
+				if sn.Original == nil {
+					var id restic.ID
+					copy(id[:], payload)
+					sn.Original = &id
+				}
+				sn.Parent = nil
+
+			case exportRecordEnd:
+				return r.repo.Flush(wgCtx)
+
+			default:
+				return fmt.Errorf("invalid export stream: unknown record type %q", tpe)
+			}
+		}
+	})
+	if err := wg.Wait(); err != nil {
+		return report, fmt.Errorf("failed to import: %w", err)
+	}
+	if sn == nil || sn.Tree == nil {
+		return report, fmt.Errorf("invalid export stream: snapshot missing")
+	}
+
+	// all blobs of the snapshot must be available before it is saved
+	blobs := restic.NewBlobSet()
+	err = data.FindUsedBlobs(ctx, r.repo, restic.IDs{*sn.Tree}, blobs, nil)
+	if err != nil {
+		return report, fmt.Errorf("incomplete import, base snapshot missing in repository: %w", err)
+	}
+	for h := range blobs {
+		if _, ok := r.repo.LookupBlobSize(h.Type, h.ID); !ok {
+			return report, fmt.Errorf("incomplete import, base snapshot missing in repository: blob %s not found", h.ID.Str())
+		}
+	}
+
+	id, err := data.SaveSnapshot(ctx, r.repo, sn)
+	if err != nil {
+		return report, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	report.Snapshot = SnapshotID(id.String())
+
+	r.logf("info", "Imported snapshot %s with %d new blobs (%d bytes)", id.Str(), report.Blobs, report.Bytes)
+	return report, nil
+}
+
+// writeExportRecord writes a record consisting of type, length and the
+// concatenation of prefix and payload
+func writeExportRecord(w io.Writer, tpe byte, prefix []byte, payload []byte) error {
+	length := len(prefix) + len(payload)
+	if length > maxExportRecordSize {
+		return fmt.Errorf("record too large")
+	}
+
+	var hdr [5]byte
+	hdr[0] = tpe
+	binary.BigEndian.PutUint32(hdr[1:], uint32(length))
+	for _, buf := range [][]byte{hdr[:], prefix, payload} {
+		if _, err := w.Write(buf); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+	}
+	return nil
+}
+
+// readExportRecord reads the next record and checks that blob and snapshot
+// records are long enough to contain an ID
+func readExportRecord(rd io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(rd, hdr[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, fmt.Errorf("invalid export stream: %w", err)
+	}
+
+	length := int(binary.BigEndian.Uint32(hdr[1:]))
+	if length > maxExportRecordSize {
+		return 0, nil, fmt.Errorf("invalid export stream: record of %d bytes too large", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(rd, payload); err != nil {
+		return 0, nil, fmt.Errorf("invalid export stream: %w", err)
+	}
+	if hdr[0] != exportRecordEnd && len(payload) < len(restic.ID{}) {
+		return 0, nil, fmt.Errorf("invalid export stream: record too short")
+	}
+	return hdr[0], payload, nil
+}
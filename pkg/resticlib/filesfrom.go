@@ -0,0 +1,144 @@
+package resticlib
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/restic/restic/internal/textfile"
+)
+
+// TargetList is a list of backup targets computed by another tool, read
+// either from a file or from a reader
+type TargetList struct {
+	// Path of the file containing the list
+	Path string `json:"path,omitempty"`
+
+	// Reader is read instead of Path if set
+	Reader io.Reader `json:"-"`
+}
+
+// read returns the content of the list
+func (l TargetList) read() ([]byte, error) {
+	if l.Reader != nil {
+		return io.ReadAll(l.Reader)
+	}
+	if l.Path == "" {
+		return nil, errors.New("target list without path or reader")
+	}
+	return os.ReadFile(l.Path)
+}
+
+// lines returns the lines of the list, text in UTF-16 is converted to UTF-8
+func (l TargetList) lines() ([]string, error) {
+	data, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	data, err = textfile.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// backupTargets returns Paths and the targets read from the lists in FilesFrom,
+// FilesFromVerbatim and FilesFromRaw, like the CLI options of the same name
+func (r *repositoryImpl) backupTargets(opts BackupOptions) ([]string, error) {
+	var targets []string
+
+	for _, list := range opts.FilesFrom {
+		lines, err := list.lines()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read target list: %w", err)
+		}
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' { // '#' marks a comment
+				continue
+			}
+
+			expanded, err := globTarget(opts.Source, line)
+			if err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", line, err)
+			}
+			if len(expanded) == 0 {
+				r.logf("warn", "Pattern %q does not match any files, skipping", line)
+			}
+			targets = append(targets, expanded...)
+		}
+	}
+
+	for _, list := range opts.FilesFromVerbatim {
+		lines, err := list.lines()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read target list: %w", err)
+		}
+		for _, line := range lines {
+			if line != "" {
+				targets = append(targets, line)
+			}
+		}
+	}
+
+	for _, list := range opts.FilesFromRaw {
+		data, err := list.read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read target list: %w", err)
+		}
+		names, err := splitRawTargets(data)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, names...)
+	}
+
+	return append(targets, opts.Paths...), nil
+}
+
+// splitRawTargets splits a list of filenames which are each terminated by a
+// zero byte
+func splitRawTargets(data []byte) ([]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[len(data)-1] != 0 {
+		return nil, errors.New("raw target list: trailing zero byte missing")
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(data[:len(data)-1]), "\x00") {
+		// the empty filename is never valid and would otherwise be
+		// backed up as filepath.Clean("") == "."
+		if name == "" {
+			return nil, errors.New("raw target list: empty filename in listing")
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// globTarget expands a pattern on the local file system or in source
+func globTarget(source iofs.FS, pattern string) ([]string, error) {
+	if source == nil {
+		return filepath.Glob(pattern)
+	}
+	matches, err := iofs.Glob(source, strings.TrimPrefix(path.Clean("/"+pattern), "/"))
+	for i := range matches {
+		matches[i] = "/" + matches[i]
+	}
+	return matches, err
+}
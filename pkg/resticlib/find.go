@@ -0,0 +1,231 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+// FindOptions configures Find
+type FindOptions struct {
+	// Patterns are matched against the base name of each path, patterns
+	// containing a slash against the full path
+	Patterns   []string `json:"patterns,omitempty"`
+	IgnoreCase bool     `json:"ignore_case,omitempty"`
+
+	// Oldest and Newest restrict the modification time of pattern matches,
+	// both are inclusive (optional)
+	Oldest *time.Time `json:"oldest,omitempty"`
+	Newest *time.Time `json:"newest,omitempty"`
+
+	// BlobIDs and TreeIDs find the files and directories referencing the
+	// given blobs or trees instead, IDs may be abbreviated. They cannot be
+	// combined with Patterns.
+	BlobIDs []string `json:"blob_ids,omitempty"`
+	TreeIDs []string `json:"tree_ids,omitempty"`
+
+	// Snapshots to search, the snapshots matching Filter are searched if empty
+	Snapshots []SnapshotID   `json:"snapshots,omitempty"`
+	Filter    SnapshotFilter `json:"filter,omitempty"`
+}
+
+// FindMatch is a path found by Find
+type FindMatch struct {
+	PathMatch
+
+	// BlobID or TreeID is the ID the path was found by, if any
+	BlobID string `json:"blob_id,omitempty"`
+	TreeID string `json:"tree_id,omitempty"`
+}
+
+// Find searches snapshots for paths matching patterns or referencing blobs or
+// trees, newest snapshots first. Pattern searches use the path index.
+func (r *repositoryImpl) Find(ctx context.Context, opts FindOptions) ([]FindMatch, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	byID := len(opts.BlobIDs) > 0 || len(opts.TreeIDs) > 0
+	if byID && len(opts.Patterns) > 0 {
+		return nil, errors.New("patterns cannot be combined with blob or tree IDs")
+	}
+	if !byID && len(opts.Patterns) == 0 {
+		return nil, errors.New("no patterns or IDs given")
+	}
+
+	patterns := opts.Patterns
+	if opts.IgnoreCase {
+		patterns = make([]string, len(opts.Patterns))
+		for i, pattern := range opts.Patterns {
+			patterns[i] = strings.ToLower(pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	snapshots, err := r.selectSnapshots(ctx, opts.Snapshots, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	var result []FindMatch
+	for _, sn := range snapshots {
+		var matches []FindMatch
+		if byID {
+			matches, err = r.findIDs(ctx, sn, opts)
+		} else {
+			matches, err = r.findPatterns(ctx, sn, patterns, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to search snapshot %s: %w", sn.ID().Str(), err)
+		}
+		result = append(result, matches...)
+	}
+
+	r.logf("debug", "Found %d matches in %d snapshots", len(result), len(snapshots))
+	return result, nil
+}
+
+// findPatterns returns the paths of a snapshot matching any of the patterns
+func (r *repositoryImpl) findPatterns(ctx context.Context, sn *data.Snapshot, patterns []string, opts FindOptions) ([]FindMatch, error) {
+	paths, err := r.snapshotPaths(ctx, sn)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PathEntry
+	if opts.IgnoreCase {
+		for _, entry := range paths.entries {
+			if matchesAnyPattern(patterns, strings.ToLower(entry.Path)) {
+				entries = append(entries, entry)
+			}
+		}
+	} else {
+		seen := make(map[string]struct{})
+		for _, pattern := range patterns {
+			found, err := paths.find(pattern)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range found {
+				if _, ok := seen[entry.Path]; !ok {
+					seen[entry.Path] = struct{}{}
+					entries = append(entries, entry)
+				}
+			}
+		}
+		// merge the matches of several patterns
+		if len(patterns) > 1 {
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+		}
+	}
+
+	var result []FindMatch
+	for _, entry := range entries {
+		if opts.Oldest != nil && entry.ModTime.Before(*opts.Oldest) {
+			continue
+		}
+		if opts.Newest != nil && entry.ModTime.After(*opts.Newest) {
+			continue
+		}
+		result = append(result, FindMatch{PathMatch: PathMatch{
+			Snapshot:  SnapshotID(sn.ID().String()),
+			PathEntry: entry,
+		}})
+	}
+	return result, nil
+}
+
+// matchesAnyPattern matches a path like snapshotPaths.find
+func matchesAnyPattern(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		name := path.Base(p)
+		if strings.Contains(pattern, "/") {
+			name = p
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// findIDs returns the files and directories of a snapshot which reference any
+// of the blob or tree IDs
+func (r *repositoryImpl) findIDs(ctx context.Context, sn *data.Snapshot, opts FindOptions) ([]FindMatch, error) {
+	matchID := func(prefixes []string, id restic.ID) (string, bool) {
+		s := id.String()
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(s, strings.ToLower(prefix)) {
+				return s, true
+			}
+		}
+		return "", false
+	}
+
+	snapshot := SnapshotID(sn.ID().String())
+	var result []FindMatch
+	if id, ok := matchID(opts.TreeIDs, *sn.Tree); ok {
+		result = append(result, FindMatch{
+			PathMatch: PathMatch{Snapshot: snapshot, PathEntry: PathEntry{Path: "/", Type: string(data.NodeTypeDir)}},
+			TreeID:    id,
+		})
+	}
+
+	err := walker.Walk(ctx, r.repo, *sn.Tree, walker.WalkVisitor{
+		ProcessNode: func(_ restic.ID, nodepath string, node *data.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return nil
+			}
+
+			match := FindMatch{PathMatch: PathMatch{
+				Snapshot: snapshot,
+				PathEntry: PathEntry{
+					Path:    nodepath,
+					Type:    string(node.Type),
+					Size:    node.Size,
+					ModTime: node.ModTime,
+				},
+			}}
+			if node.Type == data.NodeTypeDir && node.Subtree != nil {
+				if id, ok := matchID(opts.TreeIDs, *node.Subtree); ok {
+					match.TreeID = id
+					result = append(result, match)
+				}
+				return nil
+			}
+			for _, blob := range node.Content {
+				if id, ok := matchID(opts.BlobIDs, blob); ok {
+					match.BlobID = id
+					result = append(result, match)
+					break
+				}
+			}
+			return nil
+		},
+	})
+	return result, err
+}
@@ -3,24 +3,54 @@ package resticlib
 import (
 	"context"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/data"
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui"
+	"github.com/restic/restic/internal/ui/progress"
+	"golang.org/x/sync/errgroup"
 )
 
-// Forget removes snapshots according to policy
-func (r *repositoryImpl) Forget(ctx context.Context, policy ForgetPolicy) ([]SnapshotID, error) {
+// Forget removes snapshots according to policy and returns a detailed report
+func (r *repositoryImpl) Forget(ctx context.Context, policy ForgetPolicy) (ForgetReport, error) {
+	if err := r.begin(); err != nil {
+		return ForgetReport{}, err
+	}
+	defer r.end()
+
+	report := ForgetReport{DryRun: policy.DryRun}
 	if policy.Empty() {
-		return nil, errors.New("forget policy is empty")
+		return report, errors.New("forget policy is empty")
+	}
+
+	// like restic forget, removing snapshots must not race a prune
+	if policy.Lock.NoLock && !policy.DryRun {
+		return report, errors.New("forget requires a lock")
+	}
+	ctx, unlock, err := r.lock(ctx, policy.Lock)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	internalPolicy, err := policy.expirePolicy()
+	if err != nil {
+		return report, err
 	}
 
 	r.logf("info", "Applying forget policy: %+v", policy)
 
 	// Load all snapshots
 	var allSnapshots data.Snapshots
-	err := r.repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+	err = r.repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
 		sn, err := data.LoadSnapshot(ctx, r.repo, id)
 		if err != nil {
 			r.logf("warn", "Failed to load snapshot %s: %v", id.Str(), err)
@@ -30,133 +60,655 @@ func (r *repositoryImpl) Forget(ctx context.Context, policy ForgetPolicy) ([]Sna
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		return report, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	// Restrict the policy to the selected snapshots
+	candidates := allSnapshots
+	if policy.Filter != nil {
+		candidates = nil
+		for _, sn := range allSnapshots {
+			if r.matchesFilter(sn, *policy.Filter) {
+				candidates = append(candidates, sn)
+			}
+		}
+		r.logf("debug", "Forget policy applies to %d of %d snapshots", len(candidates), len(allSnapshots))
 	}
 
-	// Group snapshots by hostname and paths
+	// Group snapshots by hostname and paths unless configured otherwise
 	groupBy := data.SnapshotGroupByOptions{Host: true, Path: true}
-	groups, _, err := data.GroupSnapshots(allSnapshots, groupBy)
+	if policy.GroupBy != nil {
+		groupBy = policy.GroupBy.options()
+	}
+	groups, _, err := data.GroupSnapshots(candidates, groupBy)
 	if err != nil {
-		return nil, fmt.Errorf("failed to group snapshots: %w", err)
+		return report, fmt.Errorf("failed to group snapshots: %w", err)
 	}
 
-	var removedIDs []SnapshotID
+	var removedSnapshots data.Snapshots
 
 	for _, group := range groups {
-		// Convert policy to internal format
-		internalPolicy := data.ExpirePolicy{
-			Last:    policy.KeepLast,
-			Hourly:  policy.KeepHourly,
-			Daily:   policy.KeepDaily,
-			Weekly:  policy.KeepWeekly,
-			Monthly: policy.KeepMonthly,
-			Yearly:  policy.KeepYearly,
-		}
-
-		// Convert tags to TagList
-		if len(policy.KeepTags) > 0 {
-			tagList := make(data.TagList, len(policy.KeepTags))
-			for i, tag := range policy.KeepTags {
-				tagList[i] = tag
-			}
-			internalPolicy.Tags = []data.TagList{tagList}
+		// Apply policy to group
+		keep, remove, reasons := data.ApplyPolicy(group, internalPolicy)
+		keptBecause := make(map[*data.Snapshot][]string, len(reasons))
+		for _, reason := range reasons {
+			keptBecause[reason.Snapshot] = reason.Matches
 		}
 
-		if policy.KeepWithin != nil {
-			within, err := data.ParseDuration(*policy.KeepWithin)
-			if err != nil {
-				return nil, fmt.Errorf("invalid keep-within duration %q: %w", *policy.KeepWithin, err)
+		// Never remove protected snapshots
+		if len(policy.Protect) > 0 {
+			var unprotected data.Snapshots
+			for _, sn := range remove {
+				if policy.protects(sn) {
+					r.logf("info", "Keeping protected snapshot %s", sn.ID().Str())
+					report.Protected = append(report.Protected, SnapshotID(sn.ID().String()))
+					keptBecause[sn] = []string{"protected"}
+					keep = append(keep, sn)
+					continue
+				}
+				unprotected = append(unprotected, sn)
 			}
-			internalPolicy.Within = within
+			remove = unprotected
 		}
 
-		// Apply policy to group
-		keep, remove, _ := data.ApplyPolicy(group, internalPolicy)
+		// Never remove snapshots before their retention lock expires
+		var unlocked data.Snapshots
+		for _, sn := range remove {
+			if err := checkRetention(sn); err != nil {
+				r.logf("info", "Keeping snapshot: %v", err)
+				report.RetentionLocked = append(report.RetentionLocked, SnapshotID(sn.ID().String()))
+				keptBecause[sn] = []string{"retention lock"}
+				keep = append(keep, sn)
+				continue
+			}
+			unlocked = append(unlocked, sn)
+		}
+		remove = unlocked
 
 		// Safety check: don't remove all snapshots
 		if len(keep) == 0 && len(remove) > 0 {
 			r.logf("warn", "Refusing to delete last snapshot of group")
-			continue
+			for _, sn := range remove {
+				keptBecause[sn] = []string{"last snapshot of group"}
+			}
+			keep, remove = remove, nil
 		}
 
-		// Remove snapshots
-		for _, sn := range remove {
-			err := r.repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, *sn.ID())
+		for _, sn := range keep {
+			report.Kept = append(report.Kept, KeptSnapshot{
+				ID:      SnapshotID(sn.ID().String()),
+				Reasons: keptBecause[sn],
+			})
+		}
+		removedSnapshots = append(removedSnapshots, remove...)
+	}
+
+	if policy.DryRun {
+		for _, sn := range removedSnapshots {
+			report.Removed = append(report.Removed, SnapshotID(sn.ID().String()))
+			r.logf("info", "Would remove snapshot %s", sn.ID().String())
+		}
+		if len(removedSnapshots) > 0 {
+			err = r.estimateReclaimable(ctx, allSnapshots, removedSnapshots, &report)
 			if err != nil {
-				r.logf("error", "Failed to remove snapshot %s: %v", sn.ID().Str(), err)
-				continue
+				return report, fmt.Errorf("failed to estimate reclaimable space: %w", err)
 			}
-			removedIDs = append(removedIDs, SnapshotID(sn.ID().String()))
-			r.logf("info", "Removed snapshot %s", sn.ID().String())
 		}
+		r.logf("info", "Forget dry-run completed, would remove %d snapshots freeing about %d bytes",
+			len(report.Removed), report.ReclaimableBytes)
+		return report, nil
 	}
 
-	r.logf("info", "Forget completed, removed %d snapshots", len(removedIDs))
-	return removedIDs, nil
+	// Ask for approval before deleting anything
+	if policy.ConfirmRemoval != nil && len(removedSnapshots) > 0 {
+		toRemove := make([]Snapshot, len(removedSnapshots))
+		for i, sn := range removedSnapshots {
+			toRemove[i] = r.convertSnapshot(sn)
+		}
+		confirmed, err := policy.ConfirmRemoval(toRemove)
+		if err != nil {
+			return report, fmt.Errorf("removal confirmation failed: %w", err)
+		}
+		if !confirmed {
+			r.logf("info", "Removal of %d snapshots was not confirmed", len(removedSnapshots))
+			return report, ErrRemovalNotConfirmed
+		}
+	}
+
+	// Remove snapshots
+	for _, sn := range removedSnapshots {
+		err := removeSnapshot(ctx, r.repo, sn)
+		if err != nil {
+			r.logf("error", "Failed to remove snapshot %s: %v", sn.ID().Str(), err)
+			continue
+		}
+		report.Removed = append(report.Removed, SnapshotID(sn.ID().String()))
+		r.logf("info", "Removed snapshot %s", sn.ID().String())
+	}
+
+	r.logf("info", "Forget completed, removed %d snapshots", len(report.Removed))
+	return report, nil
+}
+
+// DeleteSnapshots removes the given snapshots and returns their IDs.
+// Unreferenced data is removed by the next Prune. If any of the snapshots is
+// retention-locked, ErrSnapshotRetained is returned and none is removed.
+func (r *repositoryImpl) DeleteSnapshots(ctx context.Context, snapshotIDs []SnapshotID) ([]SnapshotID, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	ctx, unlock, err := r.lock(ctx, LockOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var snapshots data.Snapshots
+	for _, id := range snapshotIDs {
+		sn, _, err := r.findSnapshot(ctx, string(id), SnapshotFilter{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find snapshot %s: %w", id, err)
+		}
+		if err := checkRetention(sn); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, sn)
+	}
+
+	removed := []SnapshotID{}
+	for _, sn := range snapshots {
+		if err := removeSnapshot(ctx, r.repo, sn); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", sn.ID().Str(), err)
+		}
+		removed = append(removed, SnapshotID(sn.ID().String()))
+		r.logf("info", "Removed snapshot %s", sn.ID().String())
+	}
+	return removed, nil
+}
+
+// estimateReclaimable computes which blobs are only referenced by the removed
+// snapshots and sums up their size.
+func (r *repositoryImpl) estimateReclaimable(ctx context.Context, all, removed data.Snapshots, report *ForgetReport) error {
+	err := r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	removedIDs := restic.NewIDSet()
+	var removedTrees restic.IDs
+	for _, sn := range removed {
+		removedIDs.Insert(*sn.ID())
+		removedTrees = append(removedTrees, *sn.Tree)
+	}
+
+	var keptTrees restic.IDs
+	for _, sn := range all {
+		if !removedIDs.Has(*sn.ID()) {
+			keptTrees = append(keptTrees, *sn.Tree)
+		}
+	}
+
+	kept := restic.NewBlobSet()
+	err = data.FindUsedBlobs(ctx, r.repo, keptTrees, kept, nil)
+	if err != nil {
+		return err
+	}
+
+	// Seed the set with the kept blobs, such that shared subtrees are skipped
+	// and only blobs exclusively referenced by removed snapshots are added.
+	referenced := restic.NewBlobSet()
+	referenced.Merge(kept)
+	err = data.FindUsedBlobs(ctx, r.repo, removedTrees, referenced, nil)
+	if err != nil {
+		return err
+	}
+
+	for h := range referenced.Sub(kept) {
+		size, found := r.repo.LookupBlobSize(h.Type, h.ID)
+		if !found {
+			continue
+		}
+		report.ReclaimableBlobs++
+		report.ReclaimableBytes += uint64(size)
+	}
+	return nil
+}
+
+// protects returns true if the snapshot is listed in Protect
+func (p ForgetPolicy) protects(sn *data.Snapshot) bool {
+	id := sn.ID().String()
+	for _, protected := range p.Protect {
+		if protected != "" && strings.HasPrefix(id, string(protected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// expirePolicy converts the policy to the internal format
+func (p ForgetPolicy) expirePolicy() (data.ExpirePolicy, error) {
+	internalPolicy := data.ExpirePolicy{
+		Last:    p.KeepLast,
+		Hourly:  p.KeepHourly,
+		Daily:   p.KeepDaily,
+		Weekly:  p.KeepWeekly,
+		Monthly: p.KeepMonthly,
+		Yearly:  p.KeepYearly,
+	}
+
+	// Convert tags to TagList
+	if len(p.KeepTags) > 0 {
+		tagList := make(data.TagList, len(p.KeepTags))
+		for i, tag := range p.KeepTags {
+			tagList[i] = tag
+		}
+		internalPolicy.Tags = []data.TagList{tagList}
+	}
+
+	for _, within := range []struct {
+		name  string
+		value *string
+		dest  *data.Duration
+	}{
+		{"keep-within", p.KeepWithin, &internalPolicy.Within},
+		{"keep-within-hourly", p.KeepWithinHourly, &internalPolicy.WithinHourly},
+		{"keep-within-daily", p.KeepWithinDaily, &internalPolicy.WithinDaily},
+		{"keep-within-weekly", p.KeepWithinWeekly, &internalPolicy.WithinWeekly},
+		{"keep-within-monthly", p.KeepWithinMonthly, &internalPolicy.WithinMonthly},
+		{"keep-within-yearly", p.KeepWithinYearly, &internalPolicy.WithinYearly},
+	} {
+		if within.value == nil {
+			continue
+		}
+		d, err := data.ParseDuration(*within.value)
+		if err != nil {
+			return data.ExpirePolicy{}, fmt.Errorf("invalid %s duration %q: %w", within.name, *within.value, err)
+		}
+		*within.dest = d
+	}
+
+	return internalPolicy, nil
+}
+
+// options converts the grouping to the options of the internal package
+func (g SnapshotGroupBy) options() data.SnapshotGroupByOptions {
+	return data.SnapshotGroupByOptions{Host: g.Host, Path: g.Paths, Tag: g.Tags}
+}
+
+// repositoryOptions parses MaxUnused and MaxRepackSize into the options of
+// the prune planner
+func (opts PruneOptions) repositoryOptions() (repository.PruneOptions, error) {
+	pruneOpts := repository.PruneOptions{
+		DryRun:         opts.DryRun,
+		MaxRepackBytes: math.MaxUint64,
+	}
+
+	if opts.MaxRepackSize != "" {
+		size, err := parsePositiveSize(opts.MaxRepackSize)
+		if err != nil {
+			return pruneOpts, fmt.Errorf("invalid max repack size %q: %w", opts.MaxRepackSize, err)
+		}
+		pruneOpts.MaxRepackBytes = size
+	}
+
+	maxUnused := strings.TrimSpace(opts.MaxUnused)
+	switch {
+	case maxUnused == "":
+		// tolerate 5% unused space, like restic prune by default
+		pruneOpts.MaxUnusedBytes = func(used uint64) uint64 {
+			return used * 5 / 95
+		}
+
+	case maxUnused == "unlimited":
+		pruneOpts.MaxUnusedBytes = func(_ uint64) uint64 {
+			return math.MaxUint64
+		}
+
+	case strings.HasSuffix(maxUnused, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(maxUnused, "%"), 64)
+		if err != nil {
+			return pruneOpts, fmt.Errorf("invalid max unused percentage %q: %w", opts.MaxUnused, err)
+		}
+		if p < 0 || p >= 100 {
+			return pruneOpts, fmt.Errorf("max unused percentage %q must be between 0%% and 100%%", opts.MaxUnused)
+		}
+		// p percent of the repository size after pruning, i.e. of used + unused
+		pruneOpts.MaxUnusedBytes = func(used uint64) uint64 {
+			return uint64(p / (100 - p) * float64(used))
+		}
+
+	default:
+		size, err := parsePositiveSize(maxUnused)
+		if err != nil {
+			return pruneOpts, fmt.Errorf("invalid max unused size %q: %w", opts.MaxUnused, err)
+		}
+		pruneOpts.MaxUnusedBytes = func(_ uint64) uint64 {
+			return size
+		}
+	}
+
+	return pruneOpts, nil
+}
+
+// parsePositiveSize parses a size such as "10G", which must be above zero
+func parsePositiveSize(s string) (uint64, error) {
+	size, err := ui.ParseBytes(s)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return 0, errors.New("the size must be above zero")
+	}
+	return uint64(size), nil
 }
 
 // Prune removes unused data from repository
-func (r *repositoryImpl) Prune(ctx context.Context, opts PruneOptions) (PruneReport, error) {
+func (r *repositoryImpl) Prune(ctx context.Context, opts PruneOptions) (report PruneReport, err error) {
+	if err := r.begin(); err != nil {
+		return PruneReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	// notify with the original context, the lock context is cancelled on unlock
+	defer func(ctx context.Context) { r.notify(ctx, "prune", start, report, true, err) }(ctx)
+
 	r.logf("info", "Starting prune operation (dry-run: %v)", opts.DryRun)
 
+	pruneOpts, err := opts.repositoryOptions()
+	if err != nil {
+		return PruneReport{}, err
+	}
+	pruneOpts.Warmup = r.cfg.ColdStorage != nil
+
+	if opts.Lock.NoLock {
+		return PruneReport{}, errors.New("prune requires a lock")
+	}
+	ctx, unlock, err := r.lock(ctx, opts.Lock)
+	if err != nil {
+		return PruneReport{}, err
+	}
+	defer unlock()
+
 	// Load index
-	err := r.repo.LoadIndex(ctx, nil)
+	err = r.repo.LoadIndex(ctx, nil)
 	if err != nil {
 		return PruneReport{}, fmt.Errorf("failed to load index: %w", err)
 	}
 
-	// Create repository wrapper for prune operations
-	repoWrapper := &internalRepository{r.repo}
-
-	// Perform prune - this is a simplified version
-	// In a real implementation, we would use the internal prune logic
-	stats, err := r.performPrune(ctx, repoWrapper, opts)
+	report, err = r.performPrune(ctx, opts, pruneOpts)
 	if err != nil {
 		return PruneReport{}, fmt.Errorf("prune failed: %w", err)
 	}
 
 	r.logf("info", "Prune completed: deleted %d packs, repacked %d packs",
-		stats.PacksDeleted, stats.PacksRepacked)
+		report.PacksDeleted, report.PacksRepacked)
 
-	return stats, nil
+	return report, nil
 }
 
-// performPrune performs the actual prune operation
-func (r *repositoryImpl) performPrune(ctx context.Context, repo *internalRepository, opts PruneOptions) (PruneReport, error) {
-	// This is a simplified prune implementation
-	// A full implementation would include the complex prune logic from internal/repository
+// performPrune plans which packs to delete and to repack and executes the
+// plan unless this is a dry run
+func (r *repositoryImpl) performPrune(ctx context.Context, opts PruneOptions, pruneOpts repository.PruneOptions) (PruneReport, error) {
+	var report PruneReport
 
-	report := PruneReport{
-		PacksDeleted:  0,
-		PacksKept:     0,
-		PacksRepacked: 0,
-		BytesDeleted:  0,
-		BytesRepacked: 0,
+	keep, err := r.protectedPacks(ctx, opts, &report)
+	if err != nil {
+		return report, err
 	}
+	pruneOpts.KeepPacks = keep
 
-	// Count existing packs
-	packCount := 0
-	err := r.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
-		packCount++
-		report.PacksKept++
-		return nil
-	})
+	printer := &prunePrinter{r: r}
+	plan, err := r.planPrune(ctx, pruneOpts, printer, &report)
 	if err != nil {
 		return report, err
 	}
 
-	r.logf("info", "Found %d packs in repository", packCount)
+	stats := plan.Stats()
+	report.PacksDeleted = int(stats.Packs.Unref + stats.Packs.Remove)
+	report.PacksRepacked = int(stats.Packs.Repack)
+	report.PacksKept = int(stats.Packs.Keep)
+	report.BytesDeleted = stats.Size.Unref + stats.Size.Remove + stats.Size.Repackrm
+	report.BytesRepacked = stats.Size.Repack
 
-	// For now, we don't actually perform pruning to avoid data loss
-	// A full implementation would analyze pack usage and remove unused packs
-	if !opts.DryRun {
-		r.logf("warn", "Actual pruning not implemented yet - this is a dry-run")
+	r.logf("info", "Prune plan: delete %d packs, repack %d packs, keep %d packs, free %d bytes",
+		report.PacksDeleted, report.PacksRepacked, report.PacksKept, report.BytesDeleted)
+
+	if opts.Progress != nil {
+		defer opts.Progress.Finish()
+		opts.Progress.SetTotal(uint64(report.PacksDeleted + report.PacksRepacked))
+	}
+	if opts.DryRun {
+		return report, nil
 	}
 
+	// the pruned trees are no longer needed in the cache
+	defer r.resetTreeSizes()
+	if err := plan.Execute(ctx, printer); err != nil {
+		return report, err
+	}
+	if opts.Progress != nil {
+		opts.Progress.Add(uint64(report.PacksDeleted + report.PacksRepacked))
+	}
 	return report, nil
 }
 
-// internalRepository wraps our repository for internal operations
-type internalRepository struct {
-	*repository.Repository
+// planPrune plans the prune run. The packs a plan would delete or repack are
+// checked for an object lock, locked packs are kept and the prune is planned
+// again until no locked pack is affected.
+func (r *repositoryImpl) planPrune(ctx context.Context, pruneOpts repository.PruneOptions, printer progress.Printer, report *PruneReport) (*repository.PrunePlan, error) {
+	be := r.retentionBackend()
+	if be == nil {
+		return repository.PlanPrune(ctx, pruneOpts, r.repo, r.usedBlobs, printer)
+	}
+
+	// the used blobs do not change between the plans, only search them once
+	var used restic.BlobSet
+	usedBlobs := func(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
+		if used == nil {
+			set := restic.NewBlobSet()
+			if err := r.usedBlobs(ctx, repo, set); err != nil {
+				return err
+			}
+			used = set
+		}
+		for h := range used {
+			usedBlobs.Insert(h)
+		}
+		return nil
+	}
+
+	keep := restic.NewIDSet()
+	keep.Merge(pruneOpts.KeepPacks)
+	checked := restic.NewIDSet()
+	for {
+		plan, err := repository.PlanPrune(ctx, pruneOpts, r.repo, usedBlobs, printer)
+		if err != nil {
+			return nil, err
+		}
+
+		// packs which were not locked before cannot be locked now
+		affected := plan.AffectedPacks().Sub(checked)
+		checked.Merge(affected)
+		locked, err := r.lockedPacks(ctx, be, affected)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query object lock retention: %w", err)
+		}
+		if len(locked) == 0 {
+			return plan, nil
+		}
+
+		r.logf("info", "Keeping %d packs protected by an object lock", len(locked))
+		report.PacksLocked += len(locked)
+		keep.Merge(locked)
+		pruneOpts.KeepPacks = keep
+	}
+}
+
+// protectedPacks returns the unindexed packs written within the KeepRecent
+// window, which prune must neither delete nor repack. Packs whose age cannot
+// be determined are treated as recent.
+func (r *repositoryImpl) protectedPacks(ctx context.Context, opts PruneOptions, report *PruneReport) (restic.IDSet, error) {
+	indexedPacks, err := r.indexedPacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var unindexed restic.IDs
+	packs := 0
+	err = r.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		packs++
+		if !indexedPacks.Has(id) {
+			unindexed = append(unindexed, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.logf("info", "Found %d packs in repository", packs)
+
+	keep := restic.NewIDSet()
+	if opts.KeepRecent <= 0 || len(unindexed) == 0 {
+		return keep, nil
+	}
+
+	// Unindexed packs may still be written by a concurrent backup
+	be := backend.AsBackend[modTimeBackend](r.be)
+	for _, id := range unindexed {
+		if be != nil {
+			modTime, err := be.ModTime(ctx, backend.Handle{Type: restic.PackFile, Name: id.String()})
+			if err != nil {
+				r.logf("warn", "Failed to determine the age of pack %s: %v", id.Str(), err)
+			} else if time.Since(modTime) >= opts.KeepRecent {
+				continue
+			}
+		}
+		r.logf("debug", "Keeping unindexed pack %s within safety window", id.Str())
+		keep.Insert(id)
+		report.PacksRecent++
+	}
+
+	if report.PacksRecent > 0 {
+		r.logf("info", "Keeping %d unindexed packs written within the last %v", report.PacksRecent, opts.KeepRecent)
+	}
+	return keep, nil
+}
+
+// indexedPacks returns the packs referenced by the loaded index
+func (r *repositoryImpl) indexedPacks(ctx context.Context) (restic.IDSet, error) {
+	packs := restic.NewIDSet()
+	err := r.repo.ListBlobs(ctx, func(blob restic.PackedBlob) {
+		packs.Insert(blob.PackID)
+	})
+	return packs, err
+}
+
+// usedBlobs adds the blobs referenced by any snapshot to usedBlobs
+func (r *repositoryImpl) usedBlobs(ctx context.Context, repo restic.Repository, usedBlobs restic.FindBlobSet) error {
+	var trees restic.IDs
+	err := data.ForAllSnapshots(ctx, repo, repo, nil, func(id restic.ID, sn *data.Snapshot, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot %s: %w", id.Str(), err)
+		}
+		trees = append(trees, *sn.Tree)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.logf("info", "Finding data still in use by %d snapshots", len(trees))
+	return data.FindUsedBlobs(ctx, repo, trees, usedBlobs, nil)
+}
+
+// prunePrinter forwards the messages of the prune planner to the log
+type prunePrinter struct {
+	r *repositoryImpl
+}
+
+var _ progress.Printer = (*prunePrinter)(nil)
+
+func (p *prunePrinter) NewCounter(_ string) *progress.Counter {
+	return nil
+}
+
+func (p *prunePrinter) NewCounterTerminalOnly(_ string) *progress.Counter {
+	return nil
+}
+
+func (p *prunePrinter) E(msg string, args ...interface{}) {
+	p.r.logf("error", "%s", strings.TrimSpace(fmt.Sprintf(msg, args...)))
+}
+
+func (p *prunePrinter) S(msg string, args ...interface{}) {
+	p.r.logf("info", "%s", strings.TrimSpace(fmt.Sprintf(msg, args...)))
+}
+
+func (p *prunePrinter) PT(_ string, _ ...interface{}) {}
+
+func (p *prunePrinter) P(msg string, args ...interface{}) {
+	p.r.logf("info", "%s", strings.TrimSpace(fmt.Sprintf(msg, args...)))
+}
+
+func (p *prunePrinter) V(msg string, args ...interface{}) {
+	p.r.logf("debug", "%s", strings.TrimSpace(fmt.Sprintf(msg, args...)))
+}
+
+func (p *prunePrinter) VV(msg string, args ...interface{}) {
+	p.r.logf("debug", "%s", strings.TrimSpace(fmt.Sprintf(msg, args...)))
+}
+
+// modTimeBackend is implemented by backends which report the modification
+// time of files
+type modTimeBackend interface {
+	backend.Backend
+	ModTime(ctx context.Context, h backend.Handle) (time.Time, error)
+}
+
+// retentionBackend is implemented by backends which support object lock retention
+type retentionBackend interface {
+	backend.Backend
+	RetainUntil(ctx context.Context, h backend.Handle) (time.Time, error)
+}
+
+// retentionBackend returns the backend if object lock retention is enabled
+// and supported, and nil otherwise
+func (r *repositoryImpl) retentionBackend() retentionBackend {
+	if r.cfg.ObjectLock == nil {
+		return nil
+	}
+	return backend.AsBackend[retentionBackend](r.be)
+}
+
+// lockedPacks returns the packs whose object lock retention has not expired
+// yet. The retention of the packs is queried concurrently.
+func (r *repositoryImpl) lockedPacks(ctx context.Context, be retentionBackend, packs restic.IDSet) (restic.IDSet, error) {
+	var mu sync.Mutex
+	locked := restic.NewIDSet()
+	now := time.Now()
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	wg.SetLimit(int(r.repo.Connections()))
+	for id := range packs {
+		wg.Go(func() error {
+			until, err := be.RetainUntil(wgCtx, backend.Handle{Type: restic.PackFile, Name: id.String()})
+			if err != nil {
+				return err
+			}
+			if until.After(now) {
+				r.logf("debug", "Pack %s is locked until %v", id.Str(), until)
+				mu.Lock()
+				locked.Insert(id)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	return locked, nil
 }
@@ -0,0 +1,79 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+)
+
+// CompactIndexOptions configures CompactIndex
+type CompactIndexOptions struct {
+	// Lock controls waiting for locks of other clients, the repository is
+	// locked exclusively while the index is rewritten
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// CompactIndexReport describes an index compaction
+type CompactIndexReport struct {
+	IndexFilesBefore int `json:"index_files_before"`
+	IndexFilesAfter  int `json:"index_files_after"`
+}
+
+// CompactIndex merges the index files into as few large index files as
+// possible, like prune does, without removing any data. Repositories with
+// thousands of small index files from frequent backups open faster
+// afterwards.
+func (r *repositoryImpl) CompactIndex(ctx context.Context, opts CompactIndexOptions) (report CompactIndexReport, err error) {
+	if err := r.begin(); err != nil {
+		return CompactIndexReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	// notify with the original context, the lock context is cancelled on unlock
+	defer func(ctx context.Context) { r.notify(ctx, "compact-index", start, report, true, err) }(ctx)
+
+	if opts.Lock.NoLock {
+		return CompactIndexReport{}, fmt.Errorf("index compaction requires a lock")
+	}
+	ctx, unlock, err := r.lock(ctx, opts.Lock)
+	if err != nil {
+		return CompactIndexReport{}, err
+	}
+	defer unlock()
+
+	report.IndexFilesBefore, err = r.countIndexFiles(ctx)
+	if err != nil {
+		return report, err
+	}
+	r.logf("info", "Compacting %d index files", report.IndexFilesBefore)
+
+	err = repository.CompactIndex(ctx, r.repo, &progress.NoopPrinter{})
+	if err != nil {
+		return report, fmt.Errorf("failed to compact index: %w", err)
+	}
+
+	report.IndexFilesAfter, err = r.countIndexFiles(ctx)
+	if err != nil {
+		return report, err
+	}
+	r.logf("info", "Index compacted to %d files", report.IndexFilesAfter)
+	return report, nil
+}
+
+// countIndexFiles returns the number of index files in the repository
+func (r *repositoryImpl) countIndexFiles(ctx context.Context) (int, error) {
+	count := 0
+	err := r.repo.List(ctx, restic.IndexFile, func(restic.ID, int64) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list index files: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,19 @@
+// Package backendhook lets the resticlibtest package provide the backends of
+// its in-memory repositories. Open is nil unless resticlibtest is linked.
+package backendhook
+
+import (
+	"github.com/restic/restic/internal/backend"
+)
+
+// Open returns the backend of a repository URL, it reports false for URLs
+// which are opened by the backend registry
+var Open func(repoURL string) (backend.Backend, bool)
+
+// Lookup calls Open if it is set
+func Lookup(repoURL string) (backend.Backend, bool) {
+	if Open == nil {
+		return nil, false
+	}
+	return Open(repoURL)
+}
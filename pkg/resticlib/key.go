@@ -0,0 +1,181 @@
+package resticlib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// KeyID identifies a key of the repository
+type KeyID string
+
+// KeyInfo describes a key of the repository
+type KeyInfo struct {
+	ID       KeyID     `json:"id"`
+	Current  bool      `json:"current"` // used to open this repository
+	Username string    `json:"username"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// AddKeyOptions configures AddKey
+type AddKeyOptions struct {
+	// Username and Hostname are stored in the key (default: the current
+	// user and host)
+	Username string `json:"username,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// Keys lists the keys of the repository, oldest first
+func (r *repositoryImpl) Keys(ctx context.Context) ([]KeyInfo, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	var keys []KeyInfo
+	err := r.repo.List(ctx, restic.KeyFile, func(id restic.ID, _ int64) error {
+		k, err := repository.LoadKey(ctx, r.repo, id)
+		if err != nil {
+			r.logf("warn", "Failed to load key %s: %v", id.Str(), err)
+			return nil
+		}
+		keys = append(keys, KeyInfo{
+			ID:       KeyID(id.String()),
+			Current:  id == r.repo.KeyID(),
+			Username: k.Username,
+			Hostname: k.Hostname,
+			Created:  k.Created,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, nil
+}
+
+// AddKey adds a key for another password to the repository
+func (r *repositoryImpl) AddKey(ctx context.Context, password []byte, opts AddKeyOptions) (KeyID, error) {
+	if err := r.begin(); err != nil {
+		return "", err
+	}
+	defer r.end()
+
+	ctx, unlock, err := r.lockShared(ctx, LockOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	key, err := r.addKey(ctx, password, opts)
+	if err != nil {
+		return "", err
+	}
+
+	id := key.ID()
+	r.logf("info", "Added key %s", id.Str())
+	return KeyID(id.String()), nil
+}
+
+// RemoveKey removes a key from the repository. The key used to open the
+// repository cannot be removed.
+func (r *repositoryImpl) RemoveKey(ctx context.Context, keyID KeyID) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	id, err := restic.Find(ctx, r.repo, restic.KeyFile, string(keyID))
+	if err != nil {
+		return fmt.Errorf("failed to find key: %w", err)
+	}
+	if id == r.repo.KeyID() {
+		return errors.New("refusing to remove the key used to open the repository")
+	}
+
+	ctx, unlock, err := r.lock(ctx, LockOptions{})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = repository.RemoveKey(ctx, r.repo, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove key: %w", err)
+	}
+
+	r.logf("info", "Removed key %s", id.Str())
+	return nil
+}
+
+// ChangePassword replaces the key used to open the repository by a key for
+// the new password. Other keys are not changed.
+func (r *repositoryImpl) ChangePassword(ctx context.Context, newPassword []byte) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	ctx, unlock, err := r.lock(ctx, LockOptions{})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	oldID := r.repo.KeyID()
+	key, err := r.addKey(ctx, newPassword, AddKeyOptions{})
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.SearchKey(ctx, string(newPassword), 0, key.ID().String())
+	if err != nil {
+		return fmt.Errorf("failed to switch to new key: %w", err)
+	}
+
+	err = repository.RemoveKey(ctx, r.repo, oldID)
+	if err != nil {
+		return fmt.Errorf("failed to remove old key: %w", err)
+	}
+
+	// the caller may reuse the slice
+	r.stateMu.Lock()
+	r.cfg.Password = bytes.Clone(newPassword)
+	r.passwordIndex = 0
+	r.stateMu.Unlock()
+
+	newID := key.ID()
+	r.logf("info", "Changed password, replaced key %s by %s", oldID.Str(), newID.Str())
+	return nil
+}
+
+// addKey adds a key for the password. A key which cannot be opened is removed
+// again, as it could render the repository inaccessible.
+func (r *repositoryImpl) addKey(ctx context.Context, password []byte, opts AddKeyOptions) (*repository.Key, error) {
+	if len(password) == 0 {
+		return nil, errors.New("password is required")
+	}
+
+	key, err := repository.AddKey(ctx, r.repo, string(password), opts.Username, opts.Hostname, r.repo.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to add key: %w", err)
+	}
+
+	_, err = repository.OpenKey(ctx, r.repo, key.ID(), string(password))
+	if err != nil {
+		_ = repository.RemoveKey(ctx, r.repo, key.ID())
+		return nil, fmt.Errorf("failed to open repository with new key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,91 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// LockOptions controls how an operation handles locks of other clients
+type LockOptions struct {
+	// Wait is the time to wait for locks of other clients to be released
+	// (default: fail immediately)
+	Wait time.Duration `json:"wait,omitempty"`
+
+	// OnBlocked is called for each lock blocking the operation before
+	// waiting starts (optional)
+	OnBlocked func(lock LockInfo) `json:"-"`
+
+	// NoLock runs the operation without locking the repository, only
+	// supported by read-only operations such as Check and Mount
+	NoLock bool `json:"no_lock,omitempty"`
+}
+
+// LockInfo describes a lock held by another client
+type LockInfo struct {
+	Time      time.Time `json:"time"`
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+}
+
+// lock locks the repository exclusively for an operation. The returned
+// context is cancelled if the lock is lost.
+func (r *repositoryImpl) lock(ctx context.Context, opts LockOptions) (context.Context, func(), error) {
+	return r.lockRepository(ctx, opts, true)
+}
+
+// lockShared locks the repository for a read-only operation, which may run
+// concurrently with other non-exclusive operations
+func (r *repositoryImpl) lockShared(ctx context.Context, opts LockOptions) (context.Context, func(), error) {
+	return r.lockRepository(ctx, opts, false)
+}
+
+func (r *repositoryImpl) lockRepository(ctx context.Context, opts LockOptions, exclusive bool) (context.Context, func(), error) {
+	if opts.NoLock {
+		return ctx, func() {}, nil
+	}
+
+	blocked := func(string) {
+		r.logf("info", "Repository is locked by another client, waiting up to %s", opts.Wait)
+		if opts.OnBlocked != nil {
+			r.reportBlockingLocks(ctx, opts.OnBlocked)
+		}
+	}
+	logger := func(format string, args ...interface{}) {
+		r.logf("warn", format, args...)
+	}
+
+	unlocker, ctx, err := repository.Lock(ctx, r.repo, exclusive, opts.Wait, blocked, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock repository: %w", err)
+	}
+	return ctx, unlocker.Unlock, nil
+}
+
+// reportBlockingLocks calls fn for every lock which is not stale
+func (r *repositoryImpl) reportBlockingLocks(ctx context.Context, fn func(lock LockInfo)) {
+	err := restic.ForAllLocks(ctx, r.repo, nil, func(id restic.ID, lock *restic.Lock, err error) error {
+		if err != nil {
+			r.logf("warn", "Failed to load lock %s: %v", id.Str(), err)
+			return nil
+		}
+		if !lock.Stale() {
+			fn(LockInfo{
+				Time:      lock.Time,
+				Exclusive: lock.Exclusive,
+				Hostname:  lock.Hostname,
+				Username:  lock.Username,
+				PID:       lock.PID,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		r.logf("warn", "Failed to list locks: %v", err)
+	}
+}
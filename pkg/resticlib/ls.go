@@ -0,0 +1,91 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+// SkipDir can be returned by the callback of Ls for a directory to skip its
+// contents
+var SkipDir = errors.New("skip this directory")
+
+// LsEntry describes a file or directory of a snapshot
+type LsEntry struct {
+	Path       string      `json:"path"`
+	Type       string      `json:"type"` // "file", "dir", "symlink", ...
+	Size       uint64      `json:"size"`
+	Mode       os.FileMode `json:"mode"`
+	ModTime    time.Time   `json:"mtime"`
+	UID        uint32      `json:"uid"`
+	GID        uint32      `json:"gid"`
+	User       string      `json:"user,omitempty"`
+	Group      string      `json:"group,omitempty"`
+	LinkTarget string      `json:"link_target,omitempty"`
+}
+
+// Ls walks the tree of a snapshot and calls fn for every entry, directories
+// before their contents. Only one tree is loaded at a time, such that large
+// snapshots can be browsed with little memory. Errors returned by fn abort
+// the walk, except for SkipDir.
+func (r *repositoryImpl) Ls(ctx context.Context, snapshotID SnapshotID, fn func(entry LsEntry) error) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	sn, subfolder, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	root := sn.Tree
+	if subfolder != "" {
+		root, err = data.FindTreeDirectory(ctx, r.repo, sn.Tree, subfolder)
+		if err != nil {
+			return fmt.Errorf("failed to find %q: %w", subfolder, err)
+		}
+	}
+
+	return walker.Walk(ctx, r.repo, *root, walker.WalkVisitor{
+		ProcessNode: func(_ restic.ID, nodepath string, node *data.Node, err error) error {
+			if err != nil {
+				return fmt.Errorf("failed to load tree of %s: %w", nodepath, err)
+			}
+			if node == nil {
+				return nil
+			}
+
+			err = fn(LsEntry{
+				Path:       nodepath,
+				Type:       string(node.Type),
+				Size:       node.Size,
+				Mode:       node.Mode,
+				ModTime:    node.ModTime,
+				UID:        node.UID,
+				GID:        node.GID,
+				User:       node.User,
+				Group:      node.Group,
+				LinkTarget: node.LinkTarget,
+			})
+			if errors.Is(err, SkipDir) {
+				if node.Type == data.NodeTypeDir {
+					return walker.ErrSkipNode
+				}
+				return nil
+			}
+			return err
+		},
+	})
+}
@@ -0,0 +1,195 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// defaultHealthCheckInterval is used if HealthCheckOptions.Interval is not set
+const defaultHealthCheckInterval = time.Hour
+
+// HealthCheckOptions configures the background health checks
+type HealthCheckOptions struct {
+	// Interval between two health check runs (default: 1h)
+	Interval time.Duration `json:"interval,omitempty"`
+
+	// RemoveStaleLocks removes stale locks on each run
+	RemoveStaleLocks bool `json:"remove_stale_locks,omitempty"`
+
+	// ReadDataPacks is the number of randomly selected packs which are read
+	// and verified on each run, 0 disables reading data
+	ReadDataPacks int `json:"read_data_packs,omitempty"`
+
+	// OnResult is called after each run (optional)
+	OnResult func(result HealthCheckResult) `json:"-"`
+}
+
+// HealthCheckResult contains the results of one health check run
+type HealthCheckResult struct {
+	Time              time.Time     `json:"time"`
+	Duration          time.Duration `json:"duration"`
+	StaleLocksRemoved uint          `json:"stale_locks_removed"`
+	PacksRead         int           `json:"packs_read"`
+	Errors            []string      `json:"errors,omitempty"`
+	Success           bool          `json:"success"`
+}
+
+// HealthChecker periodically runs lightweight checks on an open repository
+type HealthChecker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Stop stops the health checks and waits for a running check to finish
+func (h *HealthChecker) Stop() {
+	h.cancel()
+	<-h.done
+}
+
+// StartHealthChecks starts running lightweight checks in the background until
+// the context is cancelled, Stop is called or the repository is closed
+func (r *repositoryImpl) StartHealthChecks(ctx context.Context, opts HealthCheckOptions) (*HealthChecker, error) {
+	if opts.Interval < 0 || opts.ReadDataPacks < 0 {
+		return nil, fmt.Errorf("invalid health check options")
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultHealthCheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	h := &HealthChecker{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			result, err := r.runHealthCheck(ctx, opts)
+			if err != nil {
+				r.logf("debug", "Stopping health checks: %v", err)
+				return
+			}
+			if opts.OnResult != nil {
+				opts.OnResult(result)
+			}
+		}
+	}()
+
+	r.logf("info", "Started health checks every %v", opts.Interval)
+	return h, nil
+}
+
+// runHealthCheck runs a single health check. An error is only returned if the
+// check could not run at all, problems found are part of the result.
+func (r *repositoryImpl) runHealthCheck(ctx context.Context, opts HealthCheckOptions) (HealthCheckResult, error) {
+	if err := r.begin(); err != nil {
+		return HealthCheckResult{}, err
+	}
+	defer r.end()
+
+	result := HealthCheckResult{Time: time.Now()}
+	addError := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		result.Errors = append(result.Errors, msg)
+		r.logf("warn", "Health check: %s", msg)
+	}
+
+	// the config must always be readable with our key
+	if _, err := restic.LoadConfig(ctx, r.repo); err != nil {
+		addError("failed to load config: %v", err)
+	}
+
+	if opts.RemoveStaleLocks {
+		removed, err := repository.RemoveStaleLocks(ctx, r.repo)
+		if err != nil {
+			addError("failed to remove stale locks: %v", err)
+		}
+		result.StaleLocksRemoved = removed
+	}
+
+	if opts.ReadDataPacks > 0 {
+		packs, err := r.randomPacks(ctx, opts.ReadDataPacks)
+		if err != nil {
+			addError("failed to select packs: %v", err)
+		} else {
+			run := newCheckRun(CheckOptions{Depth: CheckDepthReadData})
+			err = r.readPacks(ctx, packs, run)
+			if err != nil {
+				return HealthCheckResult{}, err
+			}
+			result.PacksRead = int(run.progress.PacksChecked)
+			for _, msg := range run.report.Errors {
+				addError("%s", msg)
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return HealthCheckResult{}, ctx.Err()
+	}
+
+	result.Duration = time.Since(result.Time)
+	result.Success = len(result.Errors) == 0
+	return result, nil
+}
+
+// randomPacks selects up to n random packs from the index along with their size
+func (r *repositoryImpl) randomPacks(ctx context.Context, n int) (map[restic.ID]int64, error) {
+	err := r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := restic.NewIDSet()
+	err = r.repo.ListBlobs(ctx, func(pb restic.PackedBlob) {
+		indexed.Insert(pb.PackID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := indexed.List()
+	rand.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+
+	packs := make(map[restic.ID]int64, len(ids))
+	err = r.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if indexed.Has(id) {
+			packs[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[restic.ID]int64, len(ids))
+	for _, id := range ids {
+		size, ok := packs[id]
+		if !ok {
+			return nil, fmt.Errorf("pack %v is missing", id.Str())
+		}
+		selected[id] = size
+	}
+	return selected, nil
+}
@@ -0,0 +1,98 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/data"
+)
+
+// ErrMountNotSupported is returned by Mount on platforms without FUSE support
+var ErrMountNotSupported = errors.New("mounting is not supported on this platform")
+
+// MountOptions configures Mount
+type MountOptions struct {
+	// AllowOther allows other users to access the mount, the kernel checks
+	// the permissions of the files unless NoDefaultPermissions is set
+	AllowOther           bool `json:"allow_other,omitempty"`
+	NoDefaultPermissions bool `json:"no_default_permissions,omitempty"`
+
+	// OwnerRoot reports all files as owned by root
+	OwnerRoot bool `json:"owner_root,omitempty"`
+
+	// Hosts, Tags and Paths restrict the snapshots shown, a snapshot must
+	// match one of the hosts, contain all tags of one of the entries of Tags
+	// (comma separated) and contain all paths
+	Hosts []string `json:"hosts,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+
+	// TimeTemplate names the snapshot directories (default:
+	// "2006-01-02T15:04:05Z07:00"), PathTemplates replace the default
+	// snapshots/, hosts/ and tags/ layout (optional), see restic mount
+	TimeTemplate  string   `json:"time_template,omitempty"`
+	PathTemplates []string `json:"path_templates,omitempty"`
+
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// Mount exposes the snapshots of the repository as read-only filesystem at
+// mountpoint using FUSE. The layout matches restic mount, with the snapshots/,
+// hosts/ and tags/ directories at the top level. Mount blocks until ctx is
+// cancelled or the filesystem is unmounted externally, new snapshots show up
+// while mounted. If the filesystem cannot be unmounted once ctx is cancelled,
+// Mount keeps its lock until it is unmounted externally and returns the error.
+func (r *repositoryImpl) Mount(ctx context.Context, mountpoint string, opts MountOptions) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if opts.TimeTemplate == "" {
+		opts.TimeTemplate = "2006-01-02T15:04:05Z07:00"
+	}
+	if strings.HasPrefix(opts.TimeTemplate, "/") || strings.HasSuffix(opts.TimeTemplate, "/") {
+		return errors.New("time template cannot start or end with '/'")
+	}
+	if _, err := os.Stat(mountpoint); err != nil {
+		return fmt.Errorf("invalid mountpoint: %w", err)
+	}
+	filter, err := opts.snapshotFilter()
+	if err != nil {
+		return err
+	}
+
+	ctx, unlock, err := r.lockShared(ctx, opts.Lock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+
+	r.logf("info", "Mounting repository at %s", mountpoint)
+	return r.serveMount(ctx, mountpoint, filter, opts)
+}
+
+// snapshotFilter returns the filter for the snapshots shown in the mount
+func (opts MountOptions) snapshotFilter() (data.SnapshotFilter, error) {
+	var tags data.TagLists
+	for _, tag := range opts.Tags {
+		var list data.TagList
+		if err := list.Set(tag); err != nil {
+			return data.SnapshotFilter{}, fmt.Errorf("invalid tags %q: %w", tag, err)
+		}
+		tags = append(tags, list)
+	}
+	return data.SnapshotFilter{
+		Hosts: opts.Hosts,
+		Tags:  tags,
+		Paths: opts.Paths,
+	}, nil
+}
@@ -0,0 +1,68 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package resticlib
+
+import (
+	"context"
+	"fmt"
+
+	systemFuse "github.com/anacrolix/fuse"
+	"github.com/anacrolix/fuse/fs"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/fuse"
+)
+
+// serveMount serves the FUSE filesystem until ctx is cancelled or the
+// mountpoint is unmounted. If unmounting fails, serveMount waits for the
+// filesystem to be unmounted externally, so the caller keeps its lock while
+// the repository is accessible.
+func (r *repositoryImpl) serveMount(ctx context.Context, mountpoint string, filter data.SnapshotFilter, opts MountOptions) error {
+	mountOptions := []systemFuse.MountOption{
+		systemFuse.ReadOnly(),
+		systemFuse.FSName(fmt.Sprintf("restic:%s", r.repo.Config().ID[:10])),
+		systemFuse.MaxReadahead(128 * 1024),
+	}
+	if opts.AllowOther {
+		mountOptions = append(mountOptions, systemFuse.AllowOther())
+		if !opts.NoDefaultPermissions {
+			mountOptions = append(mountOptions, systemFuse.DefaultPermissions())
+		}
+	}
+
+	root := fuse.NewRoot(r.repo, fuse.Config{
+		OwnerIsRoot:   opts.OwnerRoot,
+		Filter:        filter,
+		TimeTemplate:  opts.TimeTemplate,
+		PathTemplates: opts.PathTemplates,
+	})
+
+	c, err := systemFuse.Mount(mountpoint, mountOptions...)
+	if err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+	defer func() { _ = c.Close() }()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- fs.Serve(c, root)
+	}()
+
+	select {
+	case <-ctx.Done():
+		r.logf("info", "Unmounting %s", mountpoint)
+		if err := systemFuse.Unmount(mountpoint); err != nil {
+			r.logf("warn", "Failed to unmount %s, waiting for it to be unmounted externally: %v", mountpoint, err)
+			<-serveErr
+			return fmt.Errorf("failed to unmount: %w", err)
+		}
+		<-serveErr
+		return nil
+	case err := <-serveErr:
+		if err != nil {
+			return fmt.Errorf("failed to serve mount: %w", err)
+		}
+		return nil
+	}
+}
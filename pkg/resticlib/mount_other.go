@@ -0,0 +1,14 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package resticlib
+
+import (
+	"context"
+
+	"github.com/restic/restic/internal/data"
+)
+
+func (r *repositoryImpl) serveMount(_ context.Context, _ string, _ data.SnapshotFilter, _ MountOptions) error {
+	return ErrMountNotSupported
+}
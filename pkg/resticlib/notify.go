@@ -0,0 +1,197 @@
+package resticlib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultWebhookRetryDelay is used if WebhookNotifier.RetryDelay is not set
+const defaultWebhookRetryDelay = 5 * time.Second
+
+// defaultWebhookClient is used if WebhookNotifier.Client is not set
+var defaultWebhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notification describes the outcome of a completed operation
+type Notification struct {
+	// Operation is one of "backup", "check" or "prune"
+	Operation string        `json:"operation"`
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration"`
+	Success   bool          `json:"success"`
+	Error     string        `json:"error,omitempty"`
+
+	// Result is the BackupSummary, CheckReport or PruneReport of the operation
+	Result interface{} `json:"result,omitempty"`
+}
+
+// Notifier is informed about completed operations
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier sends notifications to webhooks via HTTP POST. URLs and Body
+// are text/template strings which are executed with the Notification, this
+// allows e.g. "https://hc-ping.com/<uuid>{{if not .Success}}/fail{{end}}".
+type WebhookNotifier struct {
+	// URLs which are notified
+	URLs []string
+
+	// Body is the template for the request body (default: the notification as JSON)
+	Body string
+
+	// ContentType of the request body (default: application/json)
+	ContentType string
+
+	// Operations restricts notifications to these operations (default: all)
+	Operations []string
+
+	// OnlyFailures suppresses notifications of successful operations
+	OnlyFailures bool
+
+	// Retries is the number of additional attempts if a request fails
+	Retries int
+
+	// RetryDelay between two attempts (default: 5s)
+	RetryDelay time.Duration
+
+	// Client is used to send the requests (default: client with a 30s timeout)
+	Client *http.Client
+}
+
+// Notify sends the notification to all configured URLs
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	if w.OnlyFailures && n.Success {
+		return nil
+	}
+	if len(w.Operations) > 0 {
+		found := false
+		for _, op := range w.Operations {
+			if op == n.Operation {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	body, err := w.render("body", w.Body, n)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, tmpl := range w.URLs {
+		url, err := w.render("url", tmpl, n)
+		if err != nil {
+			return err
+		}
+		if err := w.post(ctx, string(url), body); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to notify webhooks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// render executes a template with the notification, an empty body template
+// results in the notification encoded as JSON
+func (w *WebhookNotifier) render(name, text string, n Notification) ([]byte, error) {
+	if text == "" && name == "body" {
+		return json.Marshal(n)
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook %s template: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return nil, fmt.Errorf("failed to render webhook %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends the body to the URL, retrying on network errors and server errors
+func (w *WebhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	client := w.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	delay := w.RetryDelay
+	if delay == 0 {
+		delay = defaultWebhookRetryDelay
+	}
+
+	var err error
+	for attempt := 0; attempt <= w.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err != nil {
+			continue
+		}
+		_ = resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode < 500:
+			// client errors will not go away by retrying
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		err = fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return err
+}
+
+// notify passes the outcome of an operation to the configured notifier.
+// Failing notifications are logged but never fail the operation.
+func (r *repositoryImpl) notify(ctx context.Context, operation string, start time.Time, result interface{}, success bool, err error) {
+	if r.cfg.Notifier == nil {
+		return
+	}
+
+	n := Notification{
+		Operation: operation,
+		Time:      start,
+		Duration:  time.Since(start),
+		Success:   success && err == nil,
+		Result:    result,
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+
+	// notify about cancelled operations, too
+	if nerr := r.cfg.Notifier.Notify(context.WithoutCancel(ctx), n); nerr != nil {
+		r.logf("warn", "Failed to send %s notification: %v", operation, nerr)
+	}
+}
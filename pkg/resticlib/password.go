@@ -0,0 +1,472 @@
+package resticlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/oauth2/google"
+)
+
+// defaultSecretClient is used by the password providers if no client is set
+var defaultSecretClient = &http.Client{Timeout: 30 * time.Second}
+
+// PasswordProvider fetches the repository password, e.g. from a secrets
+// manager, so that it does not have to be stored in the application config.
+// Surrounding whitespace of the password is removed, like restic does for
+// password files.
+type PasswordProvider interface {
+	Password(ctx context.Context) ([]byte, error)
+}
+
+// PasswordsProvider can be implemented in addition to PasswordProvider to
+// supply several candidate passwords, which Open tries in order. The first
+// one is used by Init.
+type PasswordsProvider interface {
+	Passwords(ctx context.Context) ([][]byte, error)
+}
+
+// VaultPassword reads the password from a HashiCorp Vault KV secret engine,
+// version 1 or 2
+type VaultPassword struct {
+	// KVVersion is the version of the KV secret engine, 1 or 2 (default: 2)
+	KVVersion int
+
+	// Address of the Vault server (default: $VAULT_ADDR)
+	Address string
+
+	// Token used for authentication (default: $VAULT_TOKEN)
+	Token string
+
+	// Path of the secret including the mount, e.g. "secret/data/backup" for
+	// KV version 2 or "kv/backup" for version 1
+	Path string
+
+	// Field of the secret which holds the password (default: "password")
+	Field string
+
+	// Client is used to send the requests (default: client with a 30s timeout)
+	Client *http.Client
+}
+
+// Password fetches the password from Vault
+func (v *VaultPassword) Password(ctx context.Context) ([]byte, error) {
+	address := orDefault(v.Address, os.Getenv("VAULT_ADDR"))
+	token := orDefault(v.Token, os.Getenv("VAULT_TOKEN"))
+	if address == "" || v.Path == "" {
+		return nil, errors.New("vault address and secret path are required")
+	}
+	version := v.KVVersion
+	if version == 0 {
+		version = 2
+	}
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported KV secret engine version %d", v.KVVersion)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(address, "/")+"/v1/"+strings.TrimLeft(v.Path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := doSecretRequest(v.Client, req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read vault secret: %w", err)
+	}
+
+	// KV version 2 nests the fields of the secret in data.data
+	fields := resp.Data
+	if version == 2 {
+		nested, ok := resp.Data["data"]
+		if !ok {
+			return nil, errors.New("vault secret has no data, is the KV engine version 1?")
+		}
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return nil, fmt.Errorf("failed to decode vault secret: %w", err)
+		}
+	}
+	return secretField(fields, orDefault(v.Field, "password"))
+}
+
+// AWSSecretsManagerPassword reads the password from AWS Secrets Manager.
+// Credentials are taken from the environment, the shared credentials file
+// or the instance role if none are set.
+type AWSSecretsManagerPassword struct {
+	// Region of the secret, e.g. "eu-central-1"
+	Region string
+
+	// SecretID is the name or ARN of the secret
+	SecretID string
+
+	// VersionStage selects a version of the secret (default: AWSCURRENT)
+	VersionStage string
+
+	// Field reads the password from a field of a JSON secret (default: the
+	// whole secret string is the password)
+	Field string
+
+	// Credentials for the request (optional)
+	Credentials *Credentials
+
+	// Endpoint overrides the API endpoint, e.g. for VPC endpoints (optional)
+	Endpoint string
+
+	// Client is used to send the requests (default: client with a 30s timeout)
+	Client *http.Client
+}
+
+// Password fetches the password from AWS Secrets Manager
+func (a *AWSSecretsManagerPassword) Password(ctx context.Context) ([]byte, error) {
+	if a.Region == "" || a.SecretID == "" {
+		return nil, errors.New("region and secret ID are required")
+	}
+
+	request := map[string]string{"SecretId": a.SecretID}
+	if a.VersionStage != "" {
+		request["VersionStage"] = a.VersionStage
+	}
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+	}
+	err := callAWS(ctx, a.Client, a.Credentials, a.Region, "secretsmanager", a.Endpoint,
+		"secretsmanager.GetSecretValue", request, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	secret := resp.SecretString
+	if secret == "" {
+		secret = string(resp.SecretBinary)
+	}
+	if a.Field == "" {
+		return trimPassword([]byte(secret))
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	return secretField(fields, a.Field)
+}
+
+// AWSKMSPassword decrypts a password which was encrypted with an AWS KMS key,
+// e.g. using "aws kms encrypt". Credentials are found like for
+// AWSSecretsManagerPassword.
+type AWSKMSPassword struct {
+	// Region of the KMS key
+	Region string
+
+	// Ciphertext is the encrypted password
+	Ciphertext []byte
+
+	// KeyID restricts decryption to this key (optional)
+	KeyID string
+
+	// Credentials for the request (optional)
+	Credentials *Credentials
+
+	// Endpoint overrides the API endpoint (optional)
+	Endpoint string
+
+	// Client is used to send the requests (default: client with a 30s timeout)
+	Client *http.Client
+}
+
+// Password decrypts the password with AWS KMS
+func (a *AWSKMSPassword) Password(ctx context.Context) ([]byte, error) {
+	if a.Region == "" || len(a.Ciphertext) == 0 {
+		return nil, errors.New("region and ciphertext are required")
+	}
+
+	request := map[string]string{"CiphertextBlob": base64.StdEncoding.EncodeToString(a.Ciphertext)}
+	if a.KeyID != "" {
+		request["KeyId"] = a.KeyID
+	}
+
+	var resp struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	err := callAWS(ctx, a.Client, a.Credentials, a.Region, "kms", a.Endpoint, "TrentService.Decrypt", request, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt password: %w", err)
+	}
+	return trimPassword(resp.Plaintext)
+}
+
+// GCPSecretManagerPassword reads the password from Google Cloud Secret
+// Manager using the application default credentials
+type GCPSecretManagerPassword struct {
+	// Name of the secret version, e.g.
+	// "projects/my-project/secrets/restic/versions/latest"
+	Name string
+
+	// Endpoint overrides the API endpoint (optional)
+	Endpoint string
+
+	// Client is an authenticated client for the requests (default: client
+	// using the application default credentials)
+	Client *http.Client
+}
+
+// Password fetches the password from Secret Manager
+func (g *GCPSecretManagerPassword) Password(ctx context.Context) ([]byte, error) {
+	if g.Name == "" {
+		return nil, errors.New("secret name is required")
+	}
+
+	client := g.Client
+	if client == nil {
+		var err error
+		client, err = google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find default credentials: %w", err)
+		}
+	}
+
+	endpoint := orDefault(g.Endpoint, "https://secretmanager.googleapis.com")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(endpoint, "/")+"/v1/"+g.Name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(client, req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to access secret: %w", err)
+	}
+	return trimPassword(resp.Payload.Data)
+}
+
+// AzureKeyVaultPassword reads the password from an Azure Key Vault secret
+type AzureKeyVaultPassword struct {
+	// VaultURL is the URL of the vault, e.g. "https://myvault.vault.azure.net"
+	VaultURL string
+
+	// Name of the secret
+	Name string
+
+	// Version of the secret (default: latest)
+	Version string
+
+	// Credential used to request tokens (default: azidentity.DefaultAzureCredential)
+	Credential azcore.TokenCredential
+
+	// Client is used to send the requests (default: client with a 30s timeout)
+	Client *http.Client
+}
+
+// Password fetches the password from Key Vault
+func (a *AzureKeyVaultPassword) Password(ctx context.Context) ([]byte, error) {
+	if a.VaultURL == "" || a.Name == "" {
+		return nil, errors.New("vault URL and secret name are required")
+	}
+
+	cred := a.Credential
+	if cred == nil {
+		var err error
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find default credentials: %w", err)
+		}
+	}
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://vault.azure.net/.default"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	secretURL := strings.TrimRight(a.VaultURL, "/") + "/secrets/" + url.PathEscape(a.Name)
+	if a.Version != "" {
+		secretURL += "/" + url.PathEscape(a.Version)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL+"?api-version=7.4", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := doSecretRequest(a.Client, req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+	return trimPassword([]byte(resp.Value))
+}
+
+// doSecretRequest sends a request and decodes the JSON response into out
+func doSecretRequest(client *http.Client, req *http.Request, out interface{}) error {
+	if client == nil {
+		client = defaultSecretClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// error responses do not contain the secret, they help with debugging permissions
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// secretField returns a string field of a secret as password
+func secretField(fields map[string]json.RawMessage, field string) ([]byte, error) {
+	raw, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("secret has no field %q", field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("field %q is not a string", field)
+	}
+	return trimPassword([]byte(value))
+}
+
+// trimPassword removes surrounding whitespace and rejects empty passwords
+func trimPassword(password []byte) ([]byte, error) {
+	password = bytes.TrimSpace(password)
+	if len(password) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+	return password, nil
+}
+
+// callAWS sends a request to an AWS JSON API signed with signature version 4
+func callAWS(ctx context.Context, client *http.Client, creds *Credentials, region, service, endpoint, target string, request, out interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	endpoint = orDefault(endpoint, "https://"+service+"."+region+".amazonaws.com")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	value, err := awsCredentials(creds)
+	if err != nil {
+		return err
+	}
+	signAWSRequest(req, body, value, region, service, time.Now())
+
+	return doSecretRequest(client, req, out)
+}
+
+// awsCredentials returns the given credentials or looks them up like the s3 backend
+func awsCredentials(creds *Credentials) (credentials.Value, error) {
+	if creds != nil && creds.AccessKey != "" {
+		return credentials.Value{
+			AccessKeyID:     creds.AccessKey,
+			SecretAccessKey: creds.SecretKey,
+			SessionToken:    creds.Token,
+		}, nil
+	}
+
+	chain := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	value, err := chain.Get()
+	if err != nil {
+		return value, fmt.Errorf("failed to find AWS credentials: %w", err)
+	}
+	if value.AccessKeyID == "" {
+		return value, errors.New("no AWS credentials found")
+	}
+	return value, nil
+}
+
+// signAWSRequest adds an AWS signature version 4 to a request without query
+// parameters
+func signAWSRequest(req *http.Request, body []byte, creds credentials.Value, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// all headers set so far are signed, in sorted order
+	names := []string{"content-type", "host", "x-amz-date"}
+	if creds.SessionToken != "" {
+		names = append(names, "x-amz-security-token")
+	}
+	names = append(names, "x-amz-target")
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		orDefault(req.URL.EscapedPath(), "/"),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
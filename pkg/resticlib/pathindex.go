@@ -0,0 +1,393 @@
+package resticlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+// pathIndexVersion is increased whenever the format of the index files changes
+const pathIndexVersion = 2
+
+// The index files are encrypted with the repository key. Version 1 stored
+// them as plaintext with the legacy suffix, such files are removed.
+const (
+	pathIndexSuffix       = ".pathidx"
+	legacyPathIndexSuffix = ".json.gz"
+)
+
+// PathEntry describes a single path stored in a snapshot
+type PathEntry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    uint64    `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// PathMatch is a path found in a snapshot
+type PathMatch struct {
+	Snapshot SnapshotID `json:"snapshot"`
+	PathEntry
+}
+
+// pathIndexFile is the on-disk format of the path index of one snapshot
+type pathIndexFile struct {
+	Version int         `json:"version"`
+	Tree    restic.ID   `json:"tree"`
+	Entries []PathEntry `json:"entries"`
+}
+
+// snapshotPaths is the in-memory path index of one snapshot. byName maps the
+// base name of each path to the positions in entries.
+type snapshotPaths struct {
+	tree    restic.ID
+	entries []PathEntry
+	byName  map[string][]int
+}
+
+func newSnapshotPaths(tree restic.ID, entries []PathEntry) *snapshotPaths {
+	p := &snapshotPaths{
+		tree:    tree,
+		entries: entries,
+		byName:  make(map[string][]int),
+	}
+	for i, entry := range entries {
+		name := path.Base(entry.Path)
+		p.byName[name] = append(p.byName[name], i)
+	}
+	return p
+}
+
+// find returns all entries matching the pattern. Patterns containing a slash
+// are matched against the full path, all others against the base name.
+func (p *snapshotPaths) find(pattern string) ([]PathEntry, error) {
+	var result []PathEntry
+
+	if strings.Contains(pattern, "/") {
+		for _, entry := range p.entries {
+			matched, err := path.Match(pattern, entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				result = append(result, entry)
+			}
+		}
+		return result, nil
+	}
+
+	// plain names are looked up directly
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		for _, i := range p.byName[pattern] {
+			result = append(result, p.entries[i])
+		}
+		return result, nil
+	}
+
+	for name, positions := range p.byName {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			for _, i := range positions {
+				result = append(result, p.entries[i])
+			}
+		}
+	}
+	return result, nil
+}
+
+// UpdatePathIndex indexes all snapshots which are not yet part of the path
+// index and removes the index files of snapshots which no longer exist
+func (r *repositoryImpl) UpdatePathIndex(ctx context.Context) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	snapshots, err := r.listSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := restic.NewIDSet()
+	for _, sn := range snapshots {
+		existing.Insert(*sn.ID())
+		if _, err := r.snapshotPaths(ctx, sn); err != nil {
+			return fmt.Errorf("failed to index snapshot %s: %w", sn.ID().Str(), err)
+		}
+	}
+
+	r.pathIndexMu.Lock()
+	for id := range r.pathIndexes {
+		if !existing.Has(id) {
+			delete(r.pathIndexes, id)
+		}
+	}
+	r.pathIndexMu.Unlock()
+
+	if r.cfg.PathIndexDir == "" {
+		return nil
+	}
+
+	files, err := os.ReadDir(r.cfg.PathIndexDir)
+	if err != nil {
+		return fmt.Errorf("failed to read path index: %w", err)
+	}
+	for _, file := range files {
+		name, legacy := strings.CutSuffix(file.Name(), legacyPathIndexSuffix)
+		if !legacy {
+			name = strings.TrimSuffix(name, pathIndexSuffix)
+		}
+		id, err := restic.ParseID(name)
+		if err != nil || (existing.Has(id) && !legacy) {
+			continue
+		}
+		r.logf("debug", "Removing path index of snapshot %s", id.Str())
+		if err := os.Remove(filepath.Join(r.cfg.PathIndexDir, file.Name())); err != nil {
+			r.logf("warn", "Failed to remove path index of snapshot %s: %v", id.Str(), err)
+		}
+	}
+
+	r.logf("info", "Path index contains %d snapshots", len(snapshots))
+	return nil
+}
+
+// FindPaths returns all paths in all snapshots matching the pattern. Patterns
+// containing a slash are matched against the full path, all others against the
+// base name. Snapshots which are not yet indexed are indexed first.
+func (r *repositoryImpl) FindPaths(ctx context.Context, pattern string) ([]PathMatch, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	snapshots, err := r.listSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// newest snapshots first
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+
+	var result []PathMatch
+	for _, sn := range snapshots {
+		paths, err := r.snapshotPaths(ctx, sn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index snapshot %s: %w", sn.ID().Str(), err)
+		}
+
+		entries, err := paths.find(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			result = append(result, PathMatch{
+				Snapshot:  SnapshotID(sn.ID().String()),
+				PathEntry: entry,
+			})
+		}
+	}
+
+	r.logf("debug", "Found %d paths matching %q", len(result), pattern)
+	return result, nil
+}
+
+// listSnapshots loads all snapshots of the repository
+func (r *repositoryImpl) listSnapshots(ctx context.Context) (data.Snapshots, error) {
+	var snapshots data.Snapshots
+	err := data.ForAllSnapshots(ctx, r.repo, r.repo, nil, func(id restic.ID, sn *data.Snapshot, err error) error {
+		if err != nil {
+			r.logf("warn", "Failed to load snapshot %s: %v", id.Str(), err)
+			return nil
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// snapshotPaths returns the path index of a snapshot. It is taken from memory,
+// loaded from the index directory or built by walking the snapshot tree.
+func (r *repositoryImpl) snapshotPaths(ctx context.Context, sn *data.Snapshot) (*snapshotPaths, error) {
+	id := *sn.ID()
+
+	r.pathIndexMu.Lock()
+	paths, ok := r.pathIndexes[id]
+	r.pathIndexMu.Unlock()
+	if ok {
+		return paths, nil
+	}
+
+	if r.cfg.PathIndexDir != "" {
+		paths, err := r.loadPathIndex(id)
+		if err == nil && paths.tree.Equal(*sn.Tree) {
+			r.cachePathIndex(id, paths)
+			return paths, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			r.logf("warn", "Ignoring path index of snapshot %s: %v", id.Str(), err)
+		}
+	}
+
+	return r.indexSnapshot(ctx, id, *sn.Tree)
+}
+
+// indexSnapshot walks the tree of a snapshot and stores its path index
+func (r *repositoryImpl) indexSnapshot(ctx context.Context, id restic.ID, tree restic.ID) (*snapshotPaths, error) {
+	var entries []PathEntry
+	err := walker.Walk(ctx, r.repo, tree, walker.WalkVisitor{
+		ProcessNode: func(_ restic.ID, nodepath string, node *data.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return nil
+			}
+			entries = append(entries, PathEntry{
+				Path:    nodepath,
+				Type:    string(node.Type),
+				Size:    node.Size,
+				ModTime: node.ModTime,
+			})
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths := newSnapshotPaths(tree, entries)
+	r.cachePathIndex(id, paths)
+
+	if r.cfg.PathIndexDir != "" {
+		if err := r.savePathIndex(id, paths); err != nil {
+			r.logf("warn", "Failed to save path index of snapshot %s: %v", id.Str(), err)
+		}
+	}
+
+	r.logf("debug", "Indexed %d paths of snapshot %s", len(entries), id.Str())
+	return paths, nil
+}
+
+func (r *repositoryImpl) cachePathIndex(id restic.ID, paths *snapshotPaths) {
+	r.pathIndexMu.Lock()
+	defer r.pathIndexMu.Unlock()
+
+	if r.pathIndexes == nil {
+		r.pathIndexes = make(map[restic.ID]*snapshotPaths)
+	}
+	r.pathIndexes[id] = paths
+}
+
+func (r *repositoryImpl) pathIndexFilename(id restic.ID) string {
+	return filepath.Join(r.cfg.PathIndexDir, id.String()+pathIndexSuffix)
+}
+
+// loadPathIndex reads the path index of a snapshot from the index directory
+func (r *repositoryImpl) loadPathIndex(id restic.ID) (*snapshotPaths, error) {
+	buf, err := os.ReadFile(r.pathIndexFilename(id))
+	if err != nil {
+		return nil, err
+	}
+
+	key := r.repo.Key()
+	if len(buf) < key.NonceSize()+key.Overhead() {
+		return nil, fmt.Errorf("file too short")
+	}
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plaintext))
+	if err != nil {
+		return nil, err
+	}
+
+	var file pathIndexFile
+	if err := json.NewDecoder(zr).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Version != pathIndexVersion {
+		return nil, fmt.Errorf("unsupported version %d", file.Version)
+	}
+
+	return newSnapshotPaths(file.Tree, file.Entries), nil
+}
+
+// savePathIndex encrypts the path index of a snapshot with the repository key
+// and writes it to the index directory
+func (r *repositoryImpl) savePathIndex(id restic.ID, paths *snapshotPaths) error {
+	if err := os.MkdirAll(r.cfg.PathIndexDir, 0700); err != nil {
+		return err
+	}
+
+	var plaintext bytes.Buffer
+	zw := gzip.NewWriter(&plaintext)
+	err := json.NewEncoder(zw).Encode(pathIndexFile{
+		Version: pathIndexVersion,
+		Tree:    paths.tree,
+		Entries: paths.entries,
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	key := r.repo.Key()
+	nonce := crypto.NewRandomNonce()
+	buf := key.Seal(bytes.Clone(nonce), nonce, plaintext.Bytes(), nil)
+
+	// write to a temporary file first so that readers never see partial files
+	f, err := os.CreateTemp(r.cfg.PathIndexDir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), r.pathIndexFilename(id)); err != nil {
+		return err
+	}
+
+	// drop the plaintext index of older versions
+	err = os.Remove(filepath.Join(r.cfg.PathIndexDir, id.String()+legacyPathIndexSuffix))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package resticlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/restic/restic/internal/textfile"
+)
+
+// ParsePatterns reads patterns in the format of the --exclude-file and
+// --include-file options of the CLI: one pattern per line, surrounding white
+// space is removed, empty lines and lines starting with '#' are ignored.
+// Environment variables such as $HOME are expanded, $$ is a literal dollar
+// sign.
+func ParsePatterns(rd io.Reader) ([]string, error) {
+	getenvOrDollar := func(s string) string {
+		if s == "$" {
+			return "$"
+		}
+		return os.Getenv(s)
+	}
+
+	var patterns []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, os.Expand(line, getenvOrDollar))
+	}
+	return patterns, scanner.Err()
+}
+
+// ReadPatternFiles reads the patterns of all files, see ParsePatterns. Files
+// may be encoded as UTF-8 or UTF-16 with byte order mark.
+func ReadPatternFiles(files ...string) ([]string, error) {
+	var patterns []string
+	for _, filename := range files {
+		data, err := textfile.Read(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patterns from file %q: %w", filename, err)
+		}
+		p, err := ParsePatterns(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patterns from file %q: %w", filename, err)
+		}
+		patterns = append(patterns, p...)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,70 @@
+package resticlib
+
+import (
+	"fmt"
+
+	"github.com/restic/restic/internal/repository"
+)
+
+// Profile is a performance preset which tunes several settings coherently
+type Profile string
+
+const (
+	// ProfileDefault uses the defaults of all settings
+	ProfileDefault Profile = ""
+
+	// ProfileLowMemory is meant for small devices, it reduces concurrency,
+	// pack size and upload buffers
+	ProfileLowMemory Profile = "low-memory"
+
+	// ProfileHighThroughput is meant for servers with fast connections, it
+	// uses more connections and larger packs and upload parts
+	ProfileHighThroughput Profile = "high-throughput"
+
+	// ProfileLowImpact is meant for backups running in the background on
+	// desktops, it limits connections and backups to a single core
+	ProfileLowImpact Profile = "low-impact"
+)
+
+// apply fills the settings of cfg which are not set explicitly with the
+// values of the profile
+func (p Profile) apply(cfg Config) (Config, error) {
+	switch p {
+	case ProfileDefault:
+	case ProfileLowMemory:
+		cfg.MemoryLimitMiB = orDefault(cfg.MemoryLimitMiB, 64)
+		cfg.PackSizeMiB = orDefault(cfg.PackSizeMiB, repository.MinPackSize/1024/1024)
+		cfg.Upload.PartSizeMiB = orDefault(cfg.Upload.PartSizeMiB, 16)
+		cfg.Upload.PartConcurrency = orDefault(cfg.Upload.PartConcurrency, 1)
+		cfg.Upload.BlockSizeMiB = orDefault(cfg.Upload.BlockSizeMiB, 16)
+		cfg.HTTP.MaxIdleConnsPerHost = orDefault(cfg.HTTP.MaxIdleConnsPerHost, 2)
+	case ProfileHighThroughput:
+		cfg.Parallelism = orDefault(cfg.Parallelism, 16)
+		cfg.PackSizeMiB = orDefault(cfg.PackSizeMiB, 64)
+		cfg.Upload.PartConcurrency = orDefault(cfg.Upload.PartConcurrency, 8)
+		cfg.Upload.ChunkSizeMiB = orDefault(cfg.Upload.ChunkSizeMiB, 32)
+	case ProfileLowImpact:
+		cfg.Parallelism = orDefault(cfg.Parallelism, 2)
+		cfg.Upload.PartConcurrency = orDefault(cfg.Upload.PartConcurrency, 1)
+	default:
+		return cfg, fmt.Errorf("unknown profile %q", p)
+	}
+	return cfg, nil
+}
+
+// backupCPU returns the CPU limit of backups if none is set explicitly
+func (p Profile) backupCPU(limit CPULimit) CPULimit {
+	if p == ProfileLowImpact && limit == (CPULimit{}) {
+		return CPULimit{MaxProcs: 1}
+	}
+	return limit
+}
+
+// orDefault returns value if it is set, def otherwise
+func orDefault[T comparable](value, def T) T {
+	var zero T
+	if value == zero {
+		return def
+	}
+	return value
+}
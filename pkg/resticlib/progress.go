@@ -0,0 +1,71 @@
+package resticlib
+
+// ProgressEventKind identifies the type of a ProgressEvent
+type ProgressEventKind string
+
+const (
+	// ProgressEventTotal sets the expected total in Total
+	ProgressEventTotal ProgressEventKind = "total"
+	// ProgressEventAdd reports progress by Delta
+	ProgressEventAdd ProgressEventKind = "add"
+	// ProgressEventError reports an error for Item in Err
+	ProgressEventError ProgressEventKind = "error"
+	// ProgressEventThroughput carries a throughput sample in Throughput
+	ProgressEventThroughput ProgressEventKind = "throughput"
+	// ProgressEventScan carries the size estimated by the backup scanner in Scan
+	ProgressEventScan ProgressEventKind = "scan"
+	// ProgressEventFinish is sent when the operation has finished
+	ProgressEventFinish ProgressEventKind = "finish"
+)
+
+// ProgressEvent is a progress update sent by a channel progress reporter.
+// Only the fields belonging to Kind are set.
+type ProgressEvent struct {
+	Kind ProgressEventKind
+
+	Total      uint64
+	Delta      uint64
+	Item       string
+	Err        error
+	Throughput ThroughputSample
+	Scan       BackupScan
+}
+
+// channelProgress forwards all progress updates to a channel
+type channelProgress struct {
+	ch chan<- ProgressEvent
+}
+
+// NewChannelProgress returns a progress reporter which sends all updates,
+// including throughput samples and scan results, as events to ch. Sending
+// blocks until the event is received, so ch should be buffered and drained
+// while the operation runs. The channel is not closed, ProgressEventFinish marks the
+// end of an operation. Errors are reported and the operation continues.
+func NewChannelProgress(ch chan<- ProgressEvent) ProgressReporter {
+	return &channelProgress{ch: ch}
+}
+
+func (p *channelProgress) SetTotal(total uint64) {
+	p.ch <- ProgressEvent{Kind: ProgressEventTotal, Total: total}
+}
+
+func (p *channelProgress) Add(delta uint64) {
+	p.ch <- ProgressEvent{Kind: ProgressEventAdd, Delta: delta}
+}
+
+func (p *channelProgress) Error(item string, err error) error {
+	p.ch <- ProgressEvent{Kind: ProgressEventError, Item: item, Err: err}
+	return nil
+}
+
+func (p *channelProgress) Scan(scan BackupScan) {
+	p.ch <- ProgressEvent{Kind: ProgressEventScan, Scan: scan}
+}
+
+func (p *channelProgress) Finish() {
+	p.ch <- ProgressEvent{Kind: ProgressEventFinish}
+}
+
+func (p *channelProgress) Throughput(sample ThroughputSample) {
+	p.ch <- ProgressEvent{Kind: ProgressEventThroughput, Throughput: sample}
+}
@@ -0,0 +1,178 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueClosed is returned for operations submitted to a closed Queue
+var ErrQueueClosed = errors.New("operation queue is closed")
+
+// Future is the pending result of an operation submitted to a Queue
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Done is closed when the operation has completed
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the operation to complete and returns its result. The
+// context only bounds the wait, the operation keeps running if it expires.
+func (f *Future[T]) Wait(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// queueJob is an operation waiting in a Queue
+type queueJob struct {
+	exclusive bool
+	run       func()
+}
+
+// Queue runs operations submitted from many goroutines on one shared
+// Repository. Operations start in submission order: backups run
+// concurrently with each other, while forget, prune and check run alone,
+// after all earlier operations have completed and before any later one
+// starts.
+type Queue struct {
+	repo Repository
+
+	mu     sync.Mutex
+	jobs   []queueJob
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+// NewQueue starts a queue for operations on repo
+func NewQueue(repo Repository) *Queue {
+	q := &Queue{
+		repo: repo,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go q.dispatch()
+	return q
+}
+
+// Enqueue submits fn to the queue. Exclusive operations do not run
+// concurrently with any other operation of the queue.
+func Enqueue[T any](q *Queue, ctx context.Context, exclusive bool, fn func(ctx context.Context, repo Repository) (T, error)) *Future[T] {
+	f := &Future[T]{done: make(chan struct{})}
+	run := func() {
+		defer close(f.done)
+		if err := ctx.Err(); err != nil {
+			f.err = err
+			return
+		}
+		f.value, f.err = fn(ctx, q.repo)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		f.err = ErrQueueClosed
+		close(f.done)
+		return f
+	}
+	q.jobs = append(q.jobs, queueJob{exclusive: exclusive, run: run})
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return f
+}
+
+// Backup queues a backup, backups may run concurrently
+func (q *Queue) Backup(ctx context.Context, opts BackupOptions) *Future[BackupSummary] {
+	return Enqueue(q, ctx, false, func(ctx context.Context, repo Repository) (BackupSummary, error) {
+		return repo.Backup(ctx, opts)
+	})
+}
+
+// Forget queues removing snapshots according to policy
+func (q *Queue) Forget(ctx context.Context, policy ForgetPolicy) *Future[ForgetReport] {
+	return Enqueue(q, ctx, true, func(ctx context.Context, repo Repository) (ForgetReport, error) {
+		return repo.Forget(ctx, policy)
+	})
+}
+
+// Prune queues removing unused data
+func (q *Queue) Prune(ctx context.Context, opts PruneOptions) *Future[PruneReport] {
+	return Enqueue(q, ctx, true, func(ctx context.Context, repo Repository) (PruneReport, error) {
+		return repo.Prune(ctx, opts)
+	})
+}
+
+// Check queues an integrity check
+func (q *Queue) Check(ctx context.Context, opts CheckOptions) *Future[CheckReport] {
+	return Enqueue(q, ctx, true, func(ctx context.Context, repo Repository) (CheckReport, error) {
+		return repo.Check(ctx, opts)
+	})
+}
+
+// Close rejects new operations and waits for the queued ones to complete.
+// The repository is not closed.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.wake)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+// next removes the first queued job, ok is false once the queue is closed
+// and drained
+func (q *Queue) next() (job queueJob, ok bool) {
+	for {
+		q.mu.Lock()
+		if len(q.jobs) > 0 {
+			job = q.jobs[0]
+			q.jobs = q.jobs[1:]
+			q.mu.Unlock()
+			return job, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return queueJob{}, false
+		}
+		<-q.wake
+	}
+}
+
+// dispatch starts the queued jobs in order
+func (q *Queue) dispatch() {
+	defer close(q.done)
+
+	var shared sync.WaitGroup
+	defer shared.Wait()
+	for {
+		job, ok := q.next()
+		if !ok {
+			return
+		}
+		if job.exclusive {
+			shared.Wait()
+			job.run()
+			continue
+		}
+		shared.Add(1)
+		go func() {
+			defer shared.Done()
+			job.run()
+		}()
+	}
+}
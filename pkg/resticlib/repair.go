@@ -0,0 +1,401 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/ui/progress"
+	"github.com/restic/restic/internal/walker"
+)
+
+// RepairIndexOptions configures RepairIndex
+type RepairIndexOptions struct {
+	// ReadAllPacks ignores the existing index and reads all pack files,
+	// otherwise only pack files missing from the index are read
+	ReadAllPacks bool `json:"read_all_packs,omitempty"`
+
+	// Lock controls waiting for locks of other clients, the repository is
+	// locked exclusively while the index is rewritten
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// RepairIndexReport describes the changes made by RepairIndex
+type RepairIndexReport struct {
+	// InvalidIndexes are index files which could not be loaded
+	InvalidIndexes []string `json:"invalid_indexes,omitempty"`
+
+	// PacksAdded were missing from the index, PacksReindexed had an
+	// unexpected size and PacksRemoved no longer exist
+	PacksAdded     []string `json:"packs_added,omitempty"`
+	PacksReindexed []string `json:"packs_reindexed,omitempty"`
+	PacksRemoved   []string `json:"packs_removed,omitempty"`
+
+	// IncompletePacks could not be read completely and are not indexed
+	IncompletePacks []string `json:"incomplete_packs,omitempty"`
+
+	// IndexFilesRemoved is the number of replaced index files
+	IndexFilesRemoved int `json:"index_files_removed"`
+}
+
+// RebuildIndexReport describes the changes made by RebuildIndex
+type RebuildIndexReport struct {
+	// PacksIndexed is the number of pack files in the rebuilt index
+	PacksIndexed int `json:"packs_indexed"`
+	// PacksRead is the number of pack files read to build the index
+	PacksRead int `json:"packs_read"`
+	// IndexFilesRemoved is the number of obsolete index files removed
+	IndexFilesRemoved int `json:"index_files_removed"`
+}
+
+// RepairPacksOptions configures RepairPacks
+type RepairPacksOptions struct {
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// RepairPacksReport describes the pack files repaired by RepairPacks
+type RepairPacksReport struct {
+	// Packs were removed after their intact blobs were salvaged
+	Packs         []string `json:"packs"`
+	BlobsSalvaged int      `json:"blobs_salvaged"`
+	BlobsLost     int      `json:"blobs_lost"`
+}
+
+// RepairSnapshotsOptions configures RepairSnapshots
+type RepairSnapshotsOptions struct {
+	// Snapshots to repair, the snapshots matching Filter are repaired if empty
+	Snapshots []SnapshotID   `json:"snapshots,omitempty"`
+	Filter    SnapshotFilter `json:"filter,omitempty"`
+
+	// Forget removes the original snapshots, otherwise the repaired
+	// snapshots are tagged "repaired"
+	Forget bool `json:"forget,omitempty"`
+
+	// DryRun reports the repairs without changing the repository
+	DryRun bool `json:"dry_run,omitempty"`
+
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// RepairedSnapshot describes the repair of one snapshot
+type RepairedSnapshot struct {
+	Original SnapshotID `json:"original"`
+
+	// New is the repaired snapshot, empty for dry runs and removed snapshots
+	New SnapshotID `json:"new,omitempty"`
+
+	// Removed is set if the root tree of the snapshot was unreadable
+	Removed bool `json:"removed,omitempty"`
+
+	// Changes lists the repairs, e.g. `file "/a": removed missing content`
+	Changes []string `json:"changes"`
+}
+
+// RepairSnapshotsReport describes the changes made by RepairSnapshots
+type RepairSnapshotsReport struct {
+	Repaired  []RepairedSnapshot `json:"repaired,omitempty"`
+	Unchanged int                `json:"unchanged"`
+}
+
+// RepairIndex rebuilds the index from the pack files, like restic repair
+// index. Index files which cannot be loaded are removed and pack files which
+// are missing from the index or no longer exist are fixed.
+func (r *repositoryImpl) RepairIndex(ctx context.Context, opts RepairIndexOptions) (report RepairIndexReport, err error) {
+	if err := r.begin(); err != nil {
+		return RepairIndexReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	defer func(ctx context.Context) { r.notify(ctx, "repair-index", start, report, true, err) }(ctx)
+
+	return r.repairIndex(ctx, opts)
+}
+
+// RebuildIndex rebuilds the index, e.g. after Check reported index errors. If
+// readAllPacks is set the existing index is ignored and all pack files are
+// read, otherwise only pack files missing from the index. It is a shorthand
+// for RepairIndex.
+func (r *repositoryImpl) RebuildIndex(ctx context.Context, readAllPacks bool) (report RebuildIndexReport, err error) {
+	if err := r.begin(); err != nil {
+		return RebuildIndexReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	defer func(ctx context.Context) { r.notify(ctx, "rebuild-index", start, report, true, err) }(ctx)
+
+	repaired, err := r.repairIndex(ctx, RepairIndexOptions{ReadAllPacks: readAllPacks})
+	if err != nil {
+		return RebuildIndexReport{}, err
+	}
+	packs, err := r.indexedPacks(ctx)
+	if err != nil {
+		return RebuildIndexReport{}, fmt.Errorf("failed to list indexed packs: %w", err)
+	}
+	return RebuildIndexReport{
+		PacksIndexed:      len(packs),
+		PacksRead:         len(repaired.PacksAdded) + len(repaired.PacksReindexed),
+		IndexFilesRemoved: repaired.IndexFilesRemoved,
+	}, nil
+}
+
+// repairIndex implements RepairIndex
+func (r *repositoryImpl) repairIndex(ctx context.Context, opts RepairIndexOptions) (report RepairIndexReport, err error) {
+	if opts.Lock.NoLock {
+		return RepairIndexReport{}, errors.New("repairing the index requires a lock")
+	}
+	ctx, unlock, err := r.lock(ctx, opts.Lock)
+	if err != nil {
+		return RepairIndexReport{}, err
+	}
+	defer unlock()
+
+	return r.repairIndexLocked(ctx, opts.ReadAllPacks)
+}
+
+// repairIndexLocked repairs the index, the caller must hold an exclusive lock
+func (r *repositoryImpl) repairIndexLocked(ctx context.Context, readAllPacks bool) (report RepairIndexReport, err error) {
+	r.logf("info", "Repairing index (read all packs: %v)", readAllPacks)
+	stats, err := repository.RepairIndexWithStats(ctx, r.repo, repository.RepairIndexOptions{
+		ReadAllPacks: readAllPacks,
+	}, &progress.NoopPrinter{})
+	if err != nil {
+		return RepairIndexReport{}, fmt.Errorf("failed to repair index: %w", err)
+	}
+
+	report = RepairIndexReport{
+		InvalidIndexes:    idStrings(stats.InvalidIndexes),
+		PacksAdded:        idStrings(stats.PacksAdded),
+		PacksReindexed:    idStrings(stats.PacksReindexed),
+		PacksRemoved:      idStrings(stats.PacksRemoved),
+		IncompletePacks:   idStrings(stats.IncompletePacks),
+		IndexFilesRemoved: stats.ObsoleteIndexes,
+	}
+
+	// the repair drops the in-memory index
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return report, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	r.logf("info", "Index repaired: %d packs added, %d reindexed, %d removed",
+		len(report.PacksAdded), len(report.PacksReindexed), len(report.PacksRemoved))
+	return report, nil
+}
+
+// RepairPacks salvages the intact blobs of damaged pack files, e.g. reported
+// by Check, into new pack files and removes the damaged ones, like restic
+// repair packs. Snapshots referencing lost blobs can be fixed afterwards with
+// RepairSnapshots.
+func (r *repositoryImpl) RepairPacks(ctx context.Context, packIDs []string, opts RepairPacksOptions) (report RepairPacksReport, err error) {
+	if err := r.begin(); err != nil {
+		return RepairPacksReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	defer func(ctx context.Context) { r.notify(ctx, "repair-packs", start, report, true, err) }(ctx)
+
+	if len(packIDs) == 0 {
+		return RepairPacksReport{}, errors.New("no pack files specified")
+	}
+	ids := restic.NewIDSet()
+	for _, s := range packIDs {
+		id, err := restic.ParseID(s)
+		if err != nil {
+			return RepairPacksReport{}, fmt.Errorf("invalid pack ID %q: %w", s, err)
+		}
+		ids.Insert(id)
+	}
+
+	if opts.Lock.NoLock {
+		return RepairPacksReport{}, errors.New("repairing packs requires a lock")
+	}
+	ctx, unlock, err := r.lock(ctx, opts.Lock)
+	if err != nil {
+		return RepairPacksReport{}, err
+	}
+	defer unlock()
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return RepairPacksReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	return r.repairPacksLocked(ctx, ids)
+}
+
+// repairPacksLocked salvages the blobs of the packs, the caller must hold an
+// exclusive lock and have loaded the index
+func (r *repositoryImpl) repairPacksLocked(ctx context.Context, ids restic.IDSet) (report RepairPacksReport, err error) {
+	r.logf("info", "Repairing %d pack files", len(ids))
+	stats, err := repository.RepairPacksWithStats(ctx, r.repo, ids, &progress.NoopPrinter{})
+	if err != nil {
+		return RepairPacksReport{}, fmt.Errorf("failed to repair packs: %w", err)
+	}
+
+	report = RepairPacksReport{
+		Packs:         idStrings(ids.List()),
+		BlobsSalvaged: stats.BlobsSalvaged,
+		BlobsLost:     stats.BlobsLost,
+	}
+	r.logf("info", "Salvaged %d blobs, lost %d blobs", report.BlobsSalvaged, report.BlobsLost)
+	return report, nil
+}
+
+// RepairSnapshots removes missing data from snapshots, like restic repair
+// snapshots. Files with missing content are truncated to the available
+// content, unreadable directories are replaced by empty ones and snapshots
+// with an unreadable root are removed. The repaired snapshots are saved as
+// new snapshots.
+func (r *repositoryImpl) RepairSnapshots(ctx context.Context, opts RepairSnapshotsOptions) (report RepairSnapshotsReport, err error) {
+	if err := r.begin(); err != nil {
+		return RepairSnapshotsReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	defer func(ctx context.Context) { r.notify(ctx, "repair-snapshots", start, report, true, err) }(ctx)
+
+	if !opts.DryRun {
+		if opts.Lock.NoLock {
+			return RepairSnapshotsReport{}, errors.New("repairing snapshots requires a lock")
+		}
+		var unlock func()
+		ctx, unlock, err = r.lock(ctx, opts.Lock)
+		if err != nil {
+			return RepairSnapshotsReport{}, err
+		}
+		defer unlock()
+	}
+
+	snapshots, err := r.selectSnapshots(ctx, opts.Snapshots, opts.Filter)
+	if err != nil {
+		return RepairSnapshotsReport{}, err
+	}
+
+	return r.repairSnapshotsLocked(ctx, snapshots, opts.Forget, opts.DryRun)
+}
+
+// repairSnapshotsLocked repairs the snapshots, the caller must hold an
+// exclusive lock unless this is a dry run
+func (r *repositoryImpl) repairSnapshotsLocked(ctx context.Context, snapshots []*data.Snapshot, forget, dryRun bool) (report RepairSnapshotsReport, err error) {
+	// nothing is written to the repository in dry runs
+	repo := r.repo
+	if dryRun {
+		repo, err = r.dryRunRepository(ctx)
+		if err != nil {
+			return RepairSnapshotsReport{}, err
+		}
+	} else {
+		defer r.resetTreeSizes()
+	}
+	err = repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return RepairSnapshotsReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	for _, sn := range snapshots {
+		changes := &snapshotRepairs{}
+		rewriter := repairTreeRewriter(ctx, repo, changes)
+
+		oldID := *sn.ID()
+		newID, changed, err := r.replaceSnapshot(ctx, repo, sn, func(ctx context.Context) (restic.ID, error) {
+			return rewriter.RewriteTree(ctx, repo, "/", *sn.Tree)
+		}, dryRun, forget, "repaired")
+		if err != nil {
+			return report, fmt.Errorf("failed to repair snapshot %s: %w", oldID.Str(), err)
+		}
+		if !changed {
+			report.Unchanged++
+			continue
+		}
+
+		repaired := RepairedSnapshot{
+			Original: SnapshotID(oldID.String()),
+			Removed:  changes.removed,
+			Changes:  changes.changes,
+		}
+		if !newID.IsNull() {
+			repaired.New = SnapshotID(newID.String())
+		}
+		report.Repaired = append(report.Repaired, repaired)
+	}
+
+	r.logf("info", "Repaired %d snapshots, %d unchanged", len(report.Repaired), report.Unchanged)
+	return report, nil
+}
+
+// snapshotRepairs collects the changes made while repairing a snapshot
+type snapshotRepairs struct {
+	changes []string
+	removed bool
+}
+
+func (s *snapshotRepairs) add(format string, args ...interface{}) {
+	s.changes = append(s.changes, fmt.Sprintf(format, args...))
+}
+
+// repairTreeRewriter returns a rewriter which removes missing data from trees
+// and records each change
+func repairTreeRewriter(ctx context.Context, repo *repository.Repository, repairs *snapshotRepairs) *walker.TreeRewriter {
+	return walker.NewTreeRewriter(walker.RewriteOpts{
+		RewriteNode: func(node *data.Node, path string) *data.Node {
+			if node.Type == data.NodeTypeIrregular || node.Type == data.NodeTypeInvalid {
+				repairs.add("file %q: removed node with invalid type %q", path, node.Type)
+				return nil
+			}
+			if node.Type != data.NodeTypeFile {
+				return node
+			}
+
+			ok := true
+			content := restic.IDs{}
+			var size uint64
+			for _, id := range node.Content {
+				if blobSize, found := repo.LookupBlobSize(restic.DataBlob, id); found {
+					content = append(content, id)
+					size += uint64(blobSize)
+				} else {
+					ok = false
+				}
+			}
+			if !ok {
+				repairs.add("file %q: removed missing content", path)
+			} else if size != node.Size {
+				repairs.add("file %q: fixed incorrect size", path)
+			}
+			node.Content = content
+			node.Size = size
+			return node
+		},
+		RewriteFailedTree: func(_ restic.ID, path string, _ error) (restic.ID, error) {
+			if path == "/" {
+				repairs.add("dir %q: not readable", path)
+				repairs.removed = true
+				// remove snapshots with an invalid root
+				return restic.ID{}, nil
+			}
+			repairs.add("dir %q: replaced with empty directory", path)
+			return data.SaveTree(ctx, repo, &data.Tree{})
+		},
+		AllowUnstableSerialization: true,
+	})
+}
+
+// idStrings converts IDs to their string representation
+func idStrings(ids restic.IDs) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := make([]string, len(ids))
+	for i, id := range ids {
+		result[i] = id.String()
+	}
+	return result
+}
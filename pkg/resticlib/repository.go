@@ -3,11 +3,15 @@ package resticlib
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/hashicorp/golang-lru/v2/simplelru"
 	"github.com/restic/restic/internal/backend"
 	"github.com/restic/restic/internal/backend/azure"
 	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/dryrun"
 	"github.com/restic/restic/internal/backend/gs"
+	"github.com/restic/restic/internal/backend/limiter"
 	"github.com/restic/restic/internal/backend/local"
 	"github.com/restic/restic/internal/backend/location"
 	"github.com/restic/restic/internal/backend/rclone"
@@ -18,13 +22,36 @@ import (
 	"github.com/restic/restic/internal/errors"
 	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/pkg/resticlib/internal/backendhook"
 )
 
 // repositoryImpl implements the Repository interface
 type repositoryImpl struct {
 	repo   *repository.Repository
+	be     backend.Backend
 	cfg    Config
 	logger Logger
+
+	// treeSizes caches the sizes of recently visited trees for snapshot
+	// listings, it is created on first use
+	treeSizesMu sync.Mutex
+	treeSizes   *simplelru.LRU[restic.ID, SnapshotSize]
+
+	// pathIndexes caches the path index of each snapshot
+	pathIndexMu sync.Mutex
+	pathIndexes map[restic.ID]*snapshotPaths
+
+	// stateMu protects the number of running operations, the closed flag
+	// and the password, which is replaced by ChangePassword. passwordIndex
+	// is the index of the password which opened the repository.
+	stateMu       sync.Mutex
+	passwordIndex int
+	running       int
+	closed        bool
+	draining      bool
+
+	// idle is closed when the last running operation ends during Shutdown
+	idle chan struct{}
 }
 
 // getBackendRegistry creates and returns a backend registry with all supported backends
@@ -44,11 +71,23 @@ func getBackendRegistry() *location.Registry {
 
 // createBackend creates a backend based on the configuration
 func createBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
+	if be, ok := backendhook.Lookup(cfg.RepoURL); ok {
+		return be, nil
+	}
+
 	registry := getBackendRegistry()
 	loc, err := location.Parse(registry, cfg.RepoURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid repository URL: %w", err)
 	}
+	if err := configureBackend(cfg, loc); err != nil {
+		return nil, err
+	}
+
+	rt, err := newTransport(cfg, loc.Scheme)
+	if err != nil {
+		return nil, err
+	}
 
 	// Extract credentials from config if available
 	var options map[string]string
@@ -77,30 +116,30 @@ func createBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
 		return nil, fmt.Errorf("invalid local config type")
 	case "s3":
 		if cfg, ok := loc.Config.(*s3.Config); ok {
-			return s3.Create(ctx, *cfg, nil, loggerFunc)
+			return s3.Create(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(s3.Config); ok {
-			return s3.Create(ctx, cfg, nil, loggerFunc)
+			return s3.Create(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid s3 config type")
 	case "azure":
 		if cfg, ok := loc.Config.(*azure.Config); ok {
-			return azure.Create(ctx, *cfg, nil, loggerFunc)
+			return azure.Create(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(azure.Config); ok {
-			return azure.Create(ctx, cfg, nil, loggerFunc)
+			return azure.Create(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid azure config type")
 	case "gs":
 		if cfg, ok := loc.Config.(*gs.Config); ok {
-			return gs.Create(ctx, *cfg, nil, loggerFunc)
+			return gs.Create(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(gs.Config); ok {
-			return gs.Create(ctx, cfg, nil, loggerFunc)
+			return gs.Create(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid gs config type")
 	case "b2":
 		if cfg, ok := loc.Config.(*b2.Config); ok {
-			return b2.Create(ctx, *cfg, nil, loggerFunc)
+			return b2.Create(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(b2.Config); ok {
-			return b2.Create(ctx, cfg, nil, loggerFunc)
+			return b2.Create(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid b2 config type")
 	case "sftp":
@@ -112,16 +151,23 @@ func createBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
 		return nil, fmt.Errorf("invalid sftp config type")
 	case "swift":
 		if cfg, ok := loc.Config.(*swift.Config); ok {
-			return swift.Open(ctx, *cfg, nil, loggerFunc)
+			return swift.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(swift.Config); ok {
-			return swift.Open(ctx, cfg, nil, loggerFunc)
+			return swift.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid swift config type")
+	case "rclone":
+		if rcfg, ok := loc.Config.(*rclone.Config); ok {
+			return rclone.Create(ctx, *rcfg, rcloneLimiter(cfg), loggerFunc)
+		} else if rcfg, ok := loc.Config.(rclone.Config); ok {
+			return rclone.Create(ctx, rcfg, rcloneLimiter(cfg), loggerFunc)
+		}
+		return nil, fmt.Errorf("invalid rclone config type")
 	case "rest":
 		if cfg, ok := loc.Config.(*rest.Config); ok {
-			return rest.Create(ctx, *cfg, nil, loggerFunc)
+			return rest.Create(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(rest.Config); ok {
-			return rest.Create(ctx, cfg, nil, loggerFunc)
+			return rest.Create(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid rest config type")
 	default:
@@ -129,13 +175,114 @@ func createBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
 	}
 }
 
+// configureBackend applies backend specific settings to the parsed location
+func configureBackend(cfg Config, loc location.Location) error {
+	switch bcfg := loc.Config.(type) {
+	case *s3.Config:
+		if cfg.Endpoint != "" {
+			return fmt.Errorf("the s3 endpoint must be specified in the repository URL")
+		}
+		bcfg.PartSize = cfg.Upload.PartSizeMiB
+		bcfg.PartConcurrency = cfg.Upload.PartConcurrency
+	case *azure.Config:
+		bcfg.BlockSize = cfg.Upload.BlockSizeMiB
+		bcfg.Endpoint = cfg.Endpoint
+	case *gs.Config:
+		bcfg.ChunkSize = cfg.Upload.ChunkSizeMiB
+		bcfg.Endpoint = cfg.Endpoint
+	case *b2.Config:
+		bcfg.Endpoint = cfg.Endpoint
+	default:
+		if cfg.Endpoint != "" {
+			return fmt.Errorf("custom endpoints are not supported by the %s backend", loc.Scheme)
+		}
+	}
+
+	if cfg.Rclone != nil {
+		rcfg, ok := loc.Config.(*rclone.Config)
+		if !ok {
+			return fmt.Errorf("rclone options are not supported by the %s backend", loc.Scheme)
+		}
+		if cfg.Rclone.Program != "" {
+			rcfg.Program = cfg.Rclone.Program
+		}
+		if cfg.Rclone.Args != "" {
+			rcfg.Args = cfg.Rclone.Args
+		}
+		if cfg.Rclone.Connections > 0 {
+			rcfg.Connections = cfg.Rclone.Connections
+		}
+		if cfg.Rclone.Timeout > 0 {
+			rcfg.Timeout = cfg.Rclone.Timeout
+		}
+	}
+
+	if connections := backendConnections(loc.Config); connections != nil && cfg.Parallelism > 0 {
+		*connections = uint(cfg.Parallelism)
+	}
+	limitConnections(loc.Config, cfg.MemoryLimitMiB)
+
+	if cfg.ObjectLock != nil {
+		s3cfg, ok := loc.Config.(*s3.Config)
+		if !ok {
+			return fmt.Errorf("object lock is not supported by the %s backend", loc.Scheme)
+		}
+		s3cfg.ObjectLockMode = cfg.ObjectLock.Mode
+		s3cfg.ObjectLockRetention = cfg.ObjectLock.Retention
+	}
+
+	if cfg.ColdStorage != nil {
+		s3cfg, ok := loc.Config.(*s3.Config)
+		if !ok {
+			return fmt.Errorf("cold storage is not supported by the %s backend", loc.Scheme)
+		}
+		// opt in explicitly instead of enabling the process-wide feature flag
+		s3cfg.EnableRestore = true
+		s3cfg.RestoreOptIn = true
+		if cfg.ColdStorage.RestoreDays > 0 {
+			s3cfg.RestoreDays = cfg.ColdStorage.RestoreDays
+		}
+		if cfg.ColdStorage.RestoreTier != "" {
+			s3cfg.RestoreTier = cfg.ColdStorage.RestoreTier
+		}
+		if cfg.ColdStorage.RestoreTimeout > 0 {
+			s3cfg.RestoreTimeout = cfg.ColdStorage.RestoreTimeout
+		}
+	}
+	return nil
+}
+
+// rcloneLimiter returns the bandwidth limiter for the rclone connection, nil
+// if the bandwidth is unlimited
+func rcloneLimiter(cfg Config) limiter.Limiter {
+	if cfg.Rclone == nil || (cfg.Rclone.UploadKiB <= 0 && cfg.Rclone.DownloadKiB <= 0) {
+		return nil
+	}
+	return limiter.NewStaticLimiter(limiter.Limits{
+		UploadKb:   cfg.Rclone.UploadKiB,
+		DownloadKb: cfg.Rclone.DownloadKiB,
+	})
+}
+
 // openBackend opens an existing backend
 func openBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
+	if be, ok := backendhook.Lookup(cfg.RepoURL); ok {
+		return be, nil
+	}
+
 	registry := getBackendRegistry()
 	loc, err := location.Parse(registry, cfg.RepoURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid repository URL: %w", err)
 	}
+	if err := configureBackend(cfg, loc); err != nil {
+		return nil, err
+	}
+
+	rt, err := newTransport(cfg, loc.Scheme)
+	if err != nil {
+		return nil, err
+	}
 
 	// Extract credentials from config if available
 	var options map[string]string
@@ -164,30 +311,30 @@ func openBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
 		return nil, fmt.Errorf("invalid local config type")
 	case "s3":
 		if cfg, ok := loc.Config.(*s3.Config); ok {
-			return s3.Open(ctx, *cfg, nil, loggerFunc)
+			return s3.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(s3.Config); ok {
-			return s3.Open(ctx, cfg, nil, loggerFunc)
+			return s3.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid s3 config type")
 	case "azure":
 		if cfg, ok := loc.Config.(*azure.Config); ok {
-			return azure.Open(ctx, *cfg, nil, loggerFunc)
+			return azure.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(azure.Config); ok {
-			return azure.Open(ctx, cfg, nil, loggerFunc)
+			return azure.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid azure config type")
 	case "gs":
 		if cfg, ok := loc.Config.(*gs.Config); ok {
-			return gs.Open(ctx, *cfg, nil, loggerFunc)
+			return gs.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(gs.Config); ok {
-			return gs.Open(ctx, cfg, nil, loggerFunc)
+			return gs.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid gs config type")
 	case "b2":
 		if cfg, ok := loc.Config.(*b2.Config); ok {
-			return b2.Open(ctx, *cfg, nil, loggerFunc)
+			return b2.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(b2.Config); ok {
-			return b2.Open(ctx, cfg, nil, loggerFunc)
+			return b2.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid b2 config type")
 	case "sftp":
@@ -199,16 +346,23 @@ func openBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
 		return nil, fmt.Errorf("invalid sftp config type")
 	case "swift":
 		if cfg, ok := loc.Config.(*swift.Config); ok {
-			return swift.Open(ctx, *cfg, nil, loggerFunc)
+			return swift.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(swift.Config); ok {
-			return swift.Open(ctx, cfg, nil, loggerFunc)
+			return swift.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid swift config type")
+	case "rclone":
+		if rcfg, ok := loc.Config.(*rclone.Config); ok {
+			return rclone.Open(ctx, *rcfg, rcloneLimiter(cfg), loggerFunc)
+		} else if rcfg, ok := loc.Config.(rclone.Config); ok {
+			return rclone.Open(ctx, rcfg, rcloneLimiter(cfg), loggerFunc)
+		}
+		return nil, fmt.Errorf("invalid rclone config type")
 	case "rest":
 		if cfg, ok := loc.Config.(*rest.Config); ok {
-			return rest.Open(ctx, *cfg, nil, loggerFunc)
+			return rest.Open(ctx, *cfg, rt, loggerFunc)
 		} else if cfg, ok := loc.Config.(rest.Config); ok {
-			return rest.Open(ctx, cfg, nil, loggerFunc)
+			return rest.Open(ctx, cfg, rt, loggerFunc)
 		}
 		return nil, fmt.Errorf("invalid rest config type")
 	default:
@@ -216,10 +370,74 @@ func openBackend(ctx context.Context, cfg Config) (backend.Backend, error) {
 	}
 }
 
+// resolvePassword fetches the password from the PasswordProvider if none is
+// set. Providers implementing PasswordsProvider supply Password and the
+// first alternate passwords.
+func (cfg Config) resolvePassword(ctx context.Context) (Config, error) {
+	if len(cfg.Password) == 0 && cfg.PasswordProvider != nil {
+		if provider, ok := cfg.PasswordProvider.(PasswordsProvider); ok {
+			passwords, err := provider.Passwords(ctx)
+			if err != nil {
+				return cfg, fmt.Errorf("failed to get passwords: %w", err)
+			}
+			if len(passwords) > 0 {
+				cfg.Password = passwords[0]
+				cfg.AlternatePasswords = append(passwords[1:len(passwords):len(passwords)], cfg.AlternatePasswords...)
+			}
+		} else {
+			password, err := cfg.PasswordProvider.Password(ctx)
+			if err != nil {
+				return cfg, fmt.Errorf("failed to get password: %w", err)
+			}
+			cfg.Password = password
+		}
+	}
+	if len(cfg.Password) == 0 {
+		return cfg, errors.New("password is required")
+	}
+	return cfg, nil
+}
+
+// repositoryOptions returns the options of the repository wrapper
+func repositoryOptions(cfg Config) (repository.Options, error) {
+	opts := repository.Options{PackSize: cfg.PackSizeMiB * 1024 * 1024}
+	if cfg.Compression != "" {
+		if err := opts.Compression.Set(string(cfg.Compression)); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// dryRunRepository returns a second instance of the repository which reads
+// from the backend but discards all writes. It has its own index, so blobs
+// "saved" during a dry run are not visible to other operations.
+func (r *repositoryImpl) dryRunRepository(ctx context.Context) (*repository.Repository, error) {
+	opts, err := repositoryOptions(r.cfg)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := repository.New(dryrun.New(r.be), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+	err = repo.SearchKey(ctx, string(r.password()), 0, r.repo.KeyID().String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return repo, nil
+}
+
 // Init initializes a new repository with the given configuration
 func Init(ctx context.Context, cfg Config) (Repository, error) {
-	if cfg.Password == nil || len(cfg.Password) == 0 {
-		return nil, errors.New("password is required")
+	cfg, err := cfg.resolvePassword(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err = cfg.Profile.apply(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create backend
@@ -229,7 +447,12 @@ func Init(ctx context.Context, cfg Config) (Repository, error) {
 	}
 
 	// Create repository wrapper
-	repo, err := repository.New(be, repository.Options{})
+	opts, err := repositoryOptions(cfg)
+	if err != nil {
+		_ = be.Close()
+		return nil, err
+	}
+	repo, err := repository.New(be, opts)
 	if err != nil {
 		_ = be.Close()
 		return nil, fmt.Errorf("failed to create repository: %w", err)
@@ -237,6 +460,9 @@ func Init(ctx context.Context, cfg Config) (Repository, error) {
 
 	// Initialize repository with password
 	version := uint(restic.MaxRepoVersion)
+	if cfg.RepoVersion != 0 {
+		version = cfg.RepoVersion
+	}
 	err = repo.Init(ctx, version, string(cfg.Password), nil)
 	if err != nil {
 		_ = be.Close()
@@ -245,6 +471,7 @@ func Init(ctx context.Context, cfg Config) (Repository, error) {
 
 	return &repositoryImpl{
 		repo:   repo,
+		be:     be,
 		cfg:    cfg,
 		logger: cfg.Logger,
 	}, nil
@@ -252,8 +479,14 @@ func Init(ctx context.Context, cfg Config) (Repository, error) {
 
 // Open opens an existing repository with the given configuration
 func Open(ctx context.Context, cfg Config) (Repository, error) {
-	if cfg.Password == nil || len(cfg.Password) == 0 {
-		return nil, errors.New("password is required")
+	cfg, err := cfg.resolvePassword(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err = cfg.Profile.apply(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	// Open backend
@@ -263,29 +496,150 @@ func Open(ctx context.Context, cfg Config) (Repository, error) {
 	}
 
 	// Create repository wrapper
-	repo, err := repository.New(be, repository.Options{})
+	opts, err := repositoryOptions(cfg)
 	if err != nil {
 		_ = be.Close()
-		return nil, fmt.Errorf("failed to create repository: %w", err)
+		return nil, err
 	}
-
-	// Search for key and decrypt with password
-	err = repo.SearchKey(ctx, string(cfg.Password), 0, "")
+	repo, err := repository.New(be, opts)
 	if err != nil {
 		_ = be.Close()
-		return nil, fmt.Errorf("failed to open repository (invalid password?): %w", err)
+		return nil, fmt.Errorf("failed to create repository: %w", err)
 	}
 
-	return &repositoryImpl{
-		repo:   repo,
-		cfg:    cfg,
-		logger: cfg.Logger,
-	}, nil
+	// Search for key and decrypt with the candidate passwords in order
+	candidates := append([][]byte{cfg.Password}, cfg.AlternatePasswords...)
+	for i, password := range candidates {
+		err = repo.SearchKey(ctx, string(password), 0, "")
+		if errors.Is(err, repository.ErrNoKeyFound) {
+			continue
+		}
+		if err != nil {
+			_ = be.Close()
+			return nil, fmt.Errorf("failed to open repository (invalid password?): %w", err)
+		}
+
+		// later operations use the matching password
+		cfg.Password = password
+		return &repositoryImpl{
+			repo:          repo,
+			be:            be,
+			cfg:           cfg,
+			logger:        cfg.Logger,
+			passwordIndex: i,
+		}, nil
+	}
+
+	_ = be.Close()
+	return nil, fmt.Errorf("failed to open repository (invalid password?): %w", err)
 }
 
-// Close closes the repository connection
+// Close closes the repository connection. Pending index and pack data left
+// behind by an aborted operation is flushed first. Calling Close more than
+// once is a no-op, calling it while operations are running fails with
+// ErrOperationInProgress.
 func (r *repositoryImpl) Close() error {
-	return r.repo.Close()
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	if r.running > 0 {
+		return ErrOperationInProgress
+	}
+	r.closed = true
+
+	return r.closeRepo(context.Background())
+}
+
+// Shutdown stops accepting new operations and waits until the running ones
+// have finished, then flushes pending data and closes the backend. The
+// library only holds repository locks while an operation runs, so none are
+// left afterwards. If ctx is done first, Shutdown returns an error and the
+// repository keeps rejecting new operations, Shutdown or Close can be called
+// again later.
+func (r *repositoryImpl) Shutdown(ctx context.Context) error {
+	r.stateMu.Lock()
+	if r.closed {
+		r.stateMu.Unlock()
+		return nil
+	}
+	r.draining = true
+
+	for r.running > 0 {
+		if r.idle == nil {
+			r.idle = make(chan struct{})
+		}
+		idle := r.idle
+		running := r.running
+		r.stateMu.Unlock()
+
+		r.logf("info", "Waiting for %d running operations before shutdown", running)
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return fmt.Errorf("operations are still running: %w", ctx.Err())
+		}
+		r.stateMu.Lock()
+	}
+	// Close may have been called while waiting
+	if r.closed {
+		r.stateMu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.stateMu.Unlock()
+
+	return r.closeRepo(ctx)
+}
+
+// closeRepo flushes pending data and closes the repository
+func (r *repositoryImpl) closeRepo(ctx context.Context) error {
+	r.resetTreeSizes()
+	flushErr := r.repo.Flush(ctx)
+	err := r.repo.Close()
+	if flushErr != nil {
+		return fmt.Errorf("failed to flush pending data: %w", flushErr)
+	}
+	return err
+}
+
+// PasswordIndex returns which password opened the repository
+func (r *repositoryImpl) PasswordIndex() int {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	return r.passwordIndex
+}
+
+// password returns the current password of the repository
+func (r *repositoryImpl) password() []byte {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	return r.cfg.Password
+}
+
+// begin registers a running operation, it fails once the repository is closed
+func (r *repositoryImpl) begin() error {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+
+	if r.closed || r.draining {
+		return ErrRepositoryClosed
+	}
+	r.running++
+	return nil
+}
+
+// end marks an operation registered by begin as finished
+func (r *repositoryImpl) end() {
+	r.stateMu.Lock()
+	r.running--
+	if r.running == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+	r.stateMu.Unlock()
 }
 
 // Additional helper methods will be implemented in subsequent files...
@@ -5,22 +5,58 @@ package resticlib
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+var (
+	// ErrRepositoryClosed is returned by operations on a closed repository
+	ErrRepositoryClosed = errors.New("repository is closed")
+
+	// ErrOperationInProgress is returned by Close while operations are still running
+	ErrOperationInProgress = errors.New("repository operations are still in progress")
+
+	// ErrRemovalNotConfirmed is returned by Forget if ConfirmRemoval declined the removal
+	ErrRemovalNotConfirmed = errors.New("snapshot removal was not confirmed")
+
+	// ErrSnapshotRetained is returned for attempts to remove a snapshot
+	// before its retention lock expires, see RetainSnapshot
+	ErrSnapshotRetained = errors.New("snapshot is retention-locked")
+
+	// ErrSnapshotNotFound is returned if no snapshot matches a reference
+	ErrSnapshotNotFound = errors.New("snapshot not found")
 )
 
 // BackendKind represents the type of storage backend
 type BackendKind string
 
 const (
-	BackendLocal BackendKind = "local"
-	BackendS3    BackendKind = "s3"
-	BackendAzure BackendKind = "azure"
-	BackendGCS   BackendKind = "gcs"
-	BackendB2    BackendKind = "b2"
-	BackendSFTP  BackendKind = "sftp"
-	BackendSwift BackendKind = "swift"
-	BackendRest  BackendKind = "rest"
+	BackendLocal  BackendKind = "local"
+	BackendS3     BackendKind = "s3"
+	BackendAzure  BackendKind = "azure"
+	BackendGCS    BackendKind = "gcs"
+	BackendB2     BackendKind = "b2"
+	BackendSFTP   BackendKind = "sftp"
+	BackendSwift  BackendKind = "swift"
+	BackendRest   BackendKind = "rest"
+	BackendRclone BackendKind = "rclone"
+)
+
+// Compression is the compression mode for data written to the repository,
+// repositories of version 1 are never compressed
+type Compression string
+
+const (
+	CompressionAuto    Compression = "auto"
+	CompressionOff     Compression = "off"
+	CompressionFastest Compression = "fastest"
+	CompressionBetter  Compression = "better"
+	CompressionMax     Compression = "max"
 )
 
 // Credentials holds authentication information for backends
@@ -30,6 +66,102 @@ type Credentials struct {
 	Token     string `json:"token,omitempty"`
 }
 
+// ObjectLockOptions configures S3 object lock retention for uploaded pack
+// files. Index, snapshot and lock files are not retained as regular
+// operations rewrite or remove them. The bucket must have object lock enabled.
+type ObjectLockOptions struct {
+	// Mode is either "GOVERNANCE" or "COMPLIANCE"
+	Mode string `json:"mode"`
+
+	// Retention is the period for which uploaded pack files cannot be deleted
+	Retention time.Duration `json:"retention"`
+}
+
+// ColdStorageOptions enables restoring pack files from archival storage
+// classes such as S3 Glacier, s3 only. Zero values use the backend defaults.
+// Restore, Prune and SyncTo then warm up the packs they read. Unlike the
+// restic CLI, this does not require the s3-restore feature flag.
+type ColdStorageOptions struct {
+	// RestoreDays is the lifetime of restored copies in days (default: 7)
+	RestoreDays int `json:"restore_days,omitempty"`
+
+	// RestoreTier is Standard, Bulk or Expedited (default: Standard)
+	RestoreTier string `json:"restore_tier,omitempty"`
+
+	// RestoreTimeout limits how long Warmup waits for restores (default: 24h)
+	RestoreTimeout time.Duration `json:"restore_timeout,omitempty"`
+}
+
+// UploadOptions tunes how large files are uploaded to cloud backends. Zero
+// values use the backend defaults, settings for other backends are ignored.
+type UploadOptions struct {
+	// PartSizeMiB is the part size of S3 multipart uploads (default: 200)
+	PartSizeMiB uint `json:"part_size_mib,omitempty"`
+
+	// PartConcurrency is the number of parts of a file uploaded in parallel to S3 (default: 4)
+	PartConcurrency uint `json:"part_concurrency,omitempty"`
+
+	// BlockSizeMiB is the Azure block size, larger files are uploaded in blocks (default: 100)
+	BlockSizeMiB uint `json:"block_size_mib,omitempty"`
+
+	// ChunkSizeMiB enables resumable GCS uploads using chunks of this size (default: disabled)
+	ChunkSizeMiB uint `json:"chunk_size_mib,omitempty"`
+}
+
+// RcloneOptions controls the rclone process started for rclone repositories.
+// Zero values use the backend defaults.
+type RcloneOptions struct {
+	// Program is the rclone binary, e.g. a copy shipped with the application (default: "rclone")
+	Program string `json:"program,omitempty"`
+
+	// Args are the arguments passed to rclone (default: "serve restic --stdio --b2-hard-delete")
+	Args string `json:"args,omitempty"`
+
+	// Connections is the number of concurrent connections to rclone (default: 5)
+	Connections uint `json:"connections,omitempty"`
+
+	// Timeout waits this long for rclone to start (default: 1m)
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// UploadKiB and DownloadKiB limit the bandwidth in KiB/s (default: unlimited)
+	UploadKiB   int `json:"upload_kib,omitempty"`
+	DownloadKiB int `json:"download_kib,omitempty"`
+}
+
+// TLSOptions restricts the TLS connections of HTTPS backends
+type TLSOptions struct {
+	// MinVersion is the minimum TLS version: "1.0", "1.1", "1.2" or "1.3"
+	// (default: crypto/tls default)
+	MinVersion string `json:"min_version,omitempty"`
+
+	// CipherSuites are the names of the allowed TLS 1.0-1.2 cipher suites as
+	// listed by crypto/tls, TLS 1.3 suites cannot be configured (default: crypto/tls default)
+	CipherSuites []string `json:"cipher_suites,omitempty"`
+}
+
+// HTTPOptions tunes the HTTP transport of HTTP based backends. Zero values
+// use the defaults.
+type HTTPOptions struct {
+	// DisableHTTP2 restricts connections to HTTP/1.1, for servers which
+	// misbehave with HTTP/2
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
+
+	// MaxIdleConnsPerHost is the number of idle connections kept open (default: 100)
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+
+	// IdleConnTimeout closes connections idle for longer than this (default: 90s)
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout,omitempty"`
+
+	// KeepAlive is the interval of TCP keep-alive probes (default: 30s)
+	KeepAlive time.Duration `json:"keep_alive,omitempty"`
+}
+
+// WarmupReport describes the state of the packs required by a snapshot
+type WarmupReport struct {
+	PacksTotal     int `json:"packs_total"`
+	PacksWarmingUp int `json:"packs_warming_up"`
+}
+
 // Logger interface for pluggable logging
 type Logger interface {
 	Debug(msg string, args ...interface{})
@@ -60,17 +192,81 @@ type Config struct {
 	// Password for repository encryption (never logged)
 	Password []byte
 
+	// AlternatePasswords are tried in order by Open if Password does not
+	// match, e.g. while old and new passwords coexist during a rotation.
+	// Repository.PasswordIndex reports which one matched (optional)
+	AlternatePasswords [][]byte
+
+	// PasswordProvider fetches the password from a secrets manager if
+	// Password is not set, see VaultPassword, AWSSecretsManagerPassword,
+	// AWSKMSPassword, GCPSecretManagerPassword and AzureKeyVaultPassword (optional)
+	PasswordProvider PasswordProvider
+
+	// ObjectLock sets a retention on uploaded files, s3 only (optional)
+	ObjectLock *ObjectLockOptions
+
+	// ColdStorage restores packs from archival storage classes, s3 only (optional)
+	ColdStorage *ColdStorageOptions
+
+	// Endpoint overrides the API endpoint of the gs, azure and b2 backends,
+	// e.g. for emulators. The s3 endpoint is part of RepoURL (optional)
+	Endpoint string
+
+	// HTTP tunes the transport of HTTP based backends (optional)
+	HTTP HTTPOptions
+
+	// TLS restricts TLS versions and cipher suites of HTTPS backends (optional)
+	TLS *TLSOptions
+
+	// RESTHeaders are sent with every request of the rest backend, e.g. an
+	// X-Auth-Token for API gateways (optional)
+	RESTHeaders map[string]string
+
+	// Upload tunes multipart and chunked uploads for cloud backends (optional)
+	Upload UploadOptions
+
+	// Rclone controls the rclone process, rclone only (optional)
+	Rclone *RcloneOptions
+
+	// Profile presets the tuning settings below, settings which are set
+	// explicitly take precedence (optional)
+	Profile Profile
+
+	// RepoVersion is the format version of new repositories, 1 does not
+	// support compression. Only used by Init (default: latest)
+	RepoVersion uint
+
+	// Compression is the compression mode for new data (default: auto)
+	Compression Compression
+
+	// PackSizeMiB is the target size of pack files (default: 16)
+	PackSizeMiB uint
+
+	// MemoryLimitMiB bounds the data buffered by backup and restore workers
+	// by reducing their concurrency, the memory used by the repository index
+	// is not included. Combine with debug.SetMemoryLimit on small devices (optional)
+	MemoryLimitMiB uint
+
 	// CACertsPEM for custom CA certificates (optional)
 	CACertsPEM []byte
 
-	// Parallelism controls number of workers for upload/download
+	// Parallelism controls number of workers for upload/download, it sets
+	// the connection limit of the backend (default: backend specific)
 	Parallelism int
 
 	// TempDir for temporary files (optional, defaults to system temp)
 	TempDir string
 
+	// PathIndexDir stores the path index used by FindPaths, encrypted with
+	// the repository key (optional, the index is only kept in memory if
+	// empty)
+	PathIndexDir string
+
 	// Logger for log output (optional)
 	Logger Logger
+
+	// Notifier is informed about completed backup, check and prune operations (optional)
+	Notifier Notifier
 }
 
 // SnapshotID represents a unique snapshot identifier
@@ -81,17 +277,25 @@ func (s SnapshotID) String() string {
 	return string(s)
 }
 
-// Snapshot contains metadata about a backup snapshot
+// Snapshot contains metadata about a backup snapshot. In JSON, Time is an
+// RFC3339 timestamp in seconds.
 type Snapshot struct {
 	ID       SnapshotID `json:"id"`
-	Time     string     `json:"time"`
+	Time     time.Time  `json:"time"`
 	Tree     string     `json:"tree"`
 	Paths    []string   `json:"paths"`
 	Hostname string     `json:"hostname"`
 	Username string     `json:"username"`
 	Tags     []string   `json:"tags,omitempty"`
 	Parent   *string    `json:"parent,omitempty"`
-	Summary  *struct {
+
+	// RetainUntil is the end of the retention lock set by RetainSnapshot
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+
+	// Size is only computed if requested via SnapshotFilter.WithSizes
+	Size *SnapshotSize `json:"size,omitempty"`
+
+	Summary *struct {
 		FilesNew            uint64  `json:"files_new"`
 		FilesChanged        uint64  `json:"files_changed"`
 		FilesUnmodified     uint64  `json:"files_unmodified"`
@@ -108,6 +312,40 @@ type Snapshot struct {
 	} `json:"summary,omitempty"`
 }
 
+// snapshotJSON prevents the recursion of Snapshot.MarshalJSON
+type snapshotJSON Snapshot
+
+// MarshalJSON encodes Time with a precision of seconds, like the string it
+// replaced. Decoding accepts timestamps with and without fractional seconds.
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		snapshotJSON
+		Time string `json:"time"`
+	}{snapshotJSON(s), s.Time.Format(time.RFC3339)})
+}
+
+// SnapshotSize contains the number and total size of the files in a snapshot
+type SnapshotSize struct {
+	Files uint64 `json:"files"`
+	Dirs  uint64 `json:"dirs"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// ChangeSummary describes the changes of a snapshot relative to its parent
+type ChangeSummary struct {
+	Parent        SnapshotID `json:"parent,omitempty"`
+	FilesAdded    uint64     `json:"files_added"`
+	FilesRemoved  uint64     `json:"files_removed"`
+	FilesModified uint64     `json:"files_modified"`
+	DirsAdded     uint64     `json:"dirs_added"`
+	DirsRemoved   uint64     `json:"dirs_removed"`
+
+	// BytesAdded and BytesRemoved are the sizes of the data blobs only
+	// referenced by the added/modified or removed/modified files
+	BytesAdded   uint64 `json:"bytes_added"`
+	BytesRemoved uint64 `json:"bytes_removed"`
+}
+
 // BackupOptions configures backup operations
 type BackupOptions struct {
 	Paths    []string         `json:"paths"`
@@ -115,8 +353,207 @@ type BackupOptions struct {
 	Excludes []string         `json:"excludes,omitempty"`
 	Includes []string         `json:"includes,omitempty"`
 	ParentID *SnapshotID      `json:"parent_id,omitempty"`
-	DryRun   bool             `json:"dry_run,omitempty"`
 	Progress ProgressReporter `json:"-"`
+
+	// ExcludeFiles are files containing exclude patterns, like the
+	// --exclude-file option of the CLI. Their patterns are added to
+	// Excludes. Excludes and Includes use the syntax of the CLI, see
+	// ParsePatterns: "*.tmp" matches in any directory, "/srv/cache" only at
+	// that path. Directories are backed up if Includes may match within
+	// them.
+	ExcludeFiles []string `json:"exclude_files,omitempty"`
+
+	// Source is the file system the backup is read from, e.g. an in-memory
+	// file system in tests or an adapter for remote data. Paths are slash
+	// separated and relative to its root. Symlinks are skipped and files
+	// are owned by UID and GID 0. (default: the local file system)
+	Source fs.FS `json:"-"`
+
+	// FilesFrom, FilesFromVerbatim and FilesFromRaw add backup targets read
+	// from lists, like the CLI options of the same name: FilesFrom lists
+	// contain glob patterns and '#' comments, FilesFromVerbatim lists one
+	// path per line and FilesFromRaw lists zero terminated paths
+	FilesFrom         []TargetList `json:"files_from,omitempty"`
+	FilesFromVerbatim []TargetList `json:"files_from_verbatim,omitempty"`
+	FilesFromRaw      []TargetList `json:"files_from_raw,omitempty"`
+
+	// OnItem is called for every completed file and directory and for every
+	// item which could not be read (optional). It is never called
+	// concurrently.
+	OnItem func(item BackupItem) `json:"-"`
+
+	// ExcludeCaches skips the contents of directories marked with a
+	// CACHEDIR.TAG file, see https://bford.info/cachedir/
+	ExcludeCaches bool `json:"exclude_caches,omitempty"`
+
+	// ExcludeIfPresent skips the contents of directories containing one of
+	// the files, given as "filename[:header]". With a header the file is
+	// only considered if it starts with the header.
+	ExcludeIfPresent []string `json:"exclude_if_present,omitempty"`
+
+	// OneFileSystem does not cross file system boundaries, e.g. to skip
+	// /proc or network mounts when backing up "/". Mount points are kept as
+	// empty directories.
+	OneFileSystem bool `json:"one_file_system,omitempty"`
+
+	// IgnoreInode and IgnoreCtime control how files are compared with the
+	// parent snapshot, e.g. on FUSE or NAS file systems with unstable inode
+	// numbers. IgnoreInode implies IgnoreCtime.
+	IgnoreInode bool `json:"ignore_inode,omitempty"`
+	IgnoreCtime bool `json:"ignore_ctime,omitempty"`
+
+	// Hostname, Username and Time override the metadata of the snapshot,
+	// e.g. for agents backing up other machines or imports of historical
+	// data (default: this host, the current user and the start of the
+	// backup)
+	Hostname string    `json:"hostname,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Time     time.Time `json:"time,omitempty"`
+
+	// ParentGroupBy uses the latest snapshot with the same host, paths or
+	// tags as parent if ParentID is not set, e.g. Host and Paths like the
+	// CLI. Tags match snapshots having all tags of the backup. (default: no
+	// parent snapshot)
+	ParentGroupBy *SnapshotGroupBy `json:"parent_group_by,omitempty"`
+
+	// Force rereads all files instead of reusing unchanged files of the
+	// parent snapshot, ParentID and ParentGroupBy are ignored
+	Force bool `json:"force,omitempty"`
+
+	// DryRun reads and chunks all files but writes nothing to the
+	// repository, the summary shows how much data would be added
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// MetadataOnly records the structure and metadata of all files but
+	// stores regular files as empty placeholders, e.g. for fast inventory
+	// snapshots. The placeholders are regular files of size zero whose node
+	// records the original size, the snapshot is tagged "metadata-only".
+	// Restores of such snapshots report a notice for each placeholder.
+	MetadataOnly bool `json:"metadata_only,omitempty"`
+
+	// CPU restricts the CPU usage of the backup, e.g. for background
+	// backups on desktops (optional)
+	CPU CPULimit `json:"cpu,omitempty"`
+
+	// ReadConcurrency is the number of files read concurrently, e.g. more
+	// for fast NVMe drives or fewer for slow NAS sources (default:
+	// Config.Parallelism if set, 2 otherwise)
+	ReadConcurrency uint `json:"read_concurrency,omitempty"`
+
+	// SaveBlobConcurrency is the number of goroutines hashing, compressing
+	// and saving blobs (default: CPU.MaxProcs or Config.Parallelism if set,
+	// the number of CPUs otherwise)
+	SaveBlobConcurrency uint `json:"save_blob_concurrency,omitempty"`
+
+	// NoScan does not run the scanner, which estimates the size of the
+	// backup for Progress.SetTotal concurrently with the backup. Progress.Add
+	// reports the size of the files read.
+	NoScan bool `json:"no_scan,omitempty"`
+
+	// ThroughputInterval is the interval of throughput samples if Progress
+	// implements ThroughputReporter (default: 1s)
+	ThroughputInterval time.Duration `json:"throughput_interval,omitempty"`
+
+	// Lock controls waiting for locks of other clients. The backup locks the
+	// repository shared, Lock.NoLock is only supported for dry runs.
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// CPULimit restricts the CPU usage of a backup. Zero values are unlimited.
+type CPULimit struct {
+	// MaxProcs is the number of goroutines hashing, compressing and
+	// encrypting data (default: number of CPUs)
+	MaxProcs uint `json:"max_procs,omitempty"`
+
+	// ReadRateKiB paces reading file contents to this many KiB/s, which
+	// bounds the work per second (default: unlimited)
+	ReadRateKiB int `json:"read_rate_kib,omitempty"`
+}
+
+// BackupPathStats are the statistics of one of the backed up paths
+type BackupPathStats struct {
+	Path  string `json:"path"`
+	Files uint   `json:"files"`
+	Bytes uint64 `json:"bytes"`
+
+	// DataAdded is the size of the new data and metadata of this path
+	DataAdded uint64 `json:"data_added"`
+}
+
+// Classifications of items completed by a backup
+const (
+	BackupItemNew        = "new"
+	BackupItemChanged    = "changed"
+	BackupItemUnmodified = "unmodified"
+	BackupItemError      = "error"
+)
+
+// BackupItem describes a file or directory completed by a backup, like a
+// line of the verbose output of the CLI
+type BackupItem struct {
+	Path   string `json:"path"`
+	Type   string `json:"type,omitempty"` // "file", "dir", ...
+	Status string `json:"status"`
+
+	// Size is the size of the new data of the item, DataAdded the bytes
+	// added to the repository after compression
+	Size      uint64        `json:"size"`
+	DataAdded uint64        `json:"data_added"`
+	Duration  time.Duration `json:"duration"`
+
+	// Error is only set for the status BackupItemError
+	Error string `json:"error,omitempty"`
+}
+
+// BackupSummary describes a completed backup
+type BackupSummary struct {
+	// SnapshotID is empty for dry runs
+	SnapshotID SnapshotID `json:"snapshot_id"`
+	DryRun     bool       `json:"dry_run,omitempty"`
+
+	// Paths contains the statistics of each path in the order of BackupOptions.Paths
+	Paths []BackupPathStats `json:"paths"`
+
+	FilesNew        uint `json:"files_new"`
+	FilesChanged    uint `json:"files_changed"`
+	FilesUnmodified uint `json:"files_unmodified"`
+	DirsNew         uint `json:"dirs_new"`
+	DirsChanged     uint `json:"dirs_changed"`
+	DirsUnmodified  uint `json:"dirs_unmodified"`
+
+	// DataAdded is the size of the new data and metadata, DataAddedPacked
+	// its size in the repository after compression
+	DataAdded       uint64 `json:"data_added"`
+	DataAddedPacked uint64 `json:"data_added_packed"`
+
+	TotalFilesProcessed uint   `json:"total_files_processed"`
+	TotalBytesProcessed uint64 `json:"total_bytes_processed"`
+
+	BackupStart time.Time     `json:"backup_start"`
+	BackupEnd   time.Time     `json:"backup_end"`
+	Duration    time.Duration `json:"duration"`
+
+	// Changes previews the changes of a dry run, it is nil otherwise
+	Changes *BackupChanges `json:"changes,omitempty"`
+}
+
+// BackupChanges describes the files a dry run would add to the repository
+type BackupChanges struct {
+	// Files lists the first new and changed files, at most
+	// MaxBackupChangesFiles. Use BackupOptions.OnItem to receive all files.
+	Files []BackupItem `json:"files"`
+	// FilesOmitted counts the new and changed files missing from Files
+	FilesOmitted uint `json:"files_omitted,omitempty"`
+
+	FilesNew        uint `json:"files_new"`
+	FilesChanged    uint `json:"files_changed"`
+	FilesUnmodified uint `json:"files_unmodified"`
+
+	// DataAdded is the estimated size of the data and metadata which would
+	// be added, DataAddedPacked its size in the repository after
+	// compression
+	DataAdded       uint64 `json:"data_added"`
+	DataAddedPacked uint64 `json:"data_added_packed"`
 }
 
 // RestoreOptions configures restore operations
@@ -128,16 +565,182 @@ type RestoreOptions struct {
 	Delete    bool             `json:"delete,omitempty"`
 	DryRun    bool             `json:"dry_run,omitempty"`
 	Progress  ProgressReporter `json:"-"`
+
+	// OverwritePolicy selects how existing files in TargetDir are handled
+	// and takes precedence over Overwrite, which only chooses between
+	// OverwriteAlways and OverwriteIfNewer (optional)
+	OverwritePolicy OverwritePolicy `json:"overwrite_policy,omitempty"`
+
+	// Resume continues an interrupted restore into TargetDir by skipping
+	// files which are already correct, see RestoreResume. It takes
+	// precedence over Overwrite and cannot be combined with
+	// OverwritePolicy. (optional)
+	Resume RestoreResume `json:"resume,omitempty"`
+
+	// Paths selects a directory of the snapshot which is restored as the
+	// root of TargetDir, like "restic restore <id>:/var/www". Only its
+	// subtree is read, Includes and Excludes are relative to it. The
+	// directory may also be given in the snapshot reference, e.g.
+	// "latest:/var/www". Currently at most one path is supported.
+	Paths []string `json:"paths,omitempty"`
+
+	// Target restores into a writable filesystem instead of TargetDir, only
+	// files, directories and symlinks are restored along with their
+	// permissions. Existing files are always overwritten. It cannot be
+	// combined with Delete, DryRun, Verify or Resume. (optional)
+	Target RestoreFS `json:"-"`
+
+	// Verify rereads all restored files and compares their content with
+	// the snapshot, like the --verify option of the CLI. Restores with
+	// mismatches return an error. It cannot be combined with DryRun.
+	Verify bool `json:"verify,omitempty"`
+
+	// IncludeFiles and ExcludeFiles are files containing patterns which are
+	// added to Includes and Excludes, see ParsePatterns. All patterns use
+	// the syntax of the restore command of the CLI, e.g. "**" and leading
+	// slashes anchoring a pattern at the root of the snapshot.
+	IncludeFiles []string `json:"include_files,omitempty"`
+	ExcludeFiles []string `json:"exclude_files,omitempty"`
+
+	// Owners translates the owner of restored files, e.g. when restoring
+	// into a container or onto a system with a different user database
+	// (optional)
+	Owners *OwnerMapping `json:"owners,omitempty"`
+
+	// SkipOwnership, SkipPermissions and SkipTimestamps do not restore the
+	// owner, mode and timestamps of files, e.g. for restores as an
+	// unprivileged user. If any of them is set, failures to restore the
+	// remaining metadata are reported as notices instead of errors.
+	SkipOwnership   bool `json:"skip_ownership,omitempty"`
+	SkipPermissions bool `json:"skip_permissions,omitempty"`
+	SkipTimestamps  bool `json:"skip_timestamps,omitempty"`
+
+	// IncludeXattrs and ExcludeXattrs select the extended attributes which
+	// are restored by name, like the --include-xattr and --exclude-xattr
+	// options of the CLI. They are mutually exclusive, by default all
+	// extended attributes are restored.
+	IncludeXattrs []string `json:"include_xattrs,omitempty"`
+	ExcludeXattrs []string `json:"exclude_xattrs,omitempty"`
+
+	// SkipACLs does not restore POSIX ACLs, which are stored as extended
+	// attributes. Like the other Skip options it reports metadata failures
+	// as notices.
+	SkipACLs bool `json:"skip_acls,omitempty"`
+}
+
+// OverwritePolicy controls which existing files a restore overwrites, it
+// corresponds to the --overwrite option of the CLI
+type OverwritePolicy string
+
+const (
+	// OverwriteAlways restores all files
+	OverwriteAlways OverwritePolicy = "always"
+	// OverwriteIfChanged only restores the content of files whose size or
+	// modification time differ from the snapshot, metadata is always restored
+	OverwriteIfChanged OverwritePolicy = "if-changed"
+	// OverwriteIfNewer only overwrites files which are older than the
+	// version in the snapshot
+	OverwriteIfNewer OverwritePolicy = "if-newer"
+	// OverwriteNever keeps all existing files
+	OverwriteNever OverwritePolicy = "never"
+)
+
+// RestoreResume selects how a resumed restore detects files which are
+// already correct
+type RestoreResume string
+
+const (
+	// ResumeMetadata skips files whose size and modification time match
+	// the snapshot, like OverwriteIfChanged
+	ResumeMetadata RestoreResume = "metadata"
+	// ResumeContent compares existing files with the snapshot by content
+	// hash and only restores the parts which differ, which also completes
+	// partially restored files
+	ResumeContent RestoreResume = "content"
+)
+
+// OwnerMapping translates the owners recorded in a snapshot to owners on
+// the restore target. Name mappings take precedence over ID mappings, owners
+// without a mapping are restored unchanged.
+type OwnerMapping struct {
+	// UIDs maps user IDs, e.g. {1000: 2000}
+	UIDs map[uint32]uint32 `json:"uids,omitempty"`
+
+	// GIDs maps group IDs
+	GIDs map[uint32]uint32 `json:"gids,omitempty"`
+
+	// Users maps user names of the snapshot to user names on this system,
+	// which are resolved to user IDs before the restore starts
+	Users map[string]string `json:"users,omitempty"`
+
+	// Groups maps group names of the snapshot to group names on this system
+	Groups map[string]string `json:"groups,omitempty"`
+}
+
+// Planned restore actions
+const (
+	RestoreActionCreate    = "create"
+	RestoreActionOverwrite = "overwrite"
+	RestoreActionSkip      = "skip"
+	RestoreActionDelete    = "delete"
+)
+
+// RestoreAction is the action a restore performs for a path
+type RestoreAction struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Size   uint64 `json:"size,omitempty"`
+}
+
+// RestoreReport describes a restore
+type RestoreReport struct {
+	// Actions lists the planned action for each path, only set for dry runs
+	Actions []RestoreAction `json:"actions,omitempty"`
+
+	// Deleted lists the paths removed from the target because they are not
+	// in the snapshot, for dry runs the paths which would be removed. Only
+	// set if RestoreOptions.Delete is used.
+	Deleted []string `json:"deleted,omitempty"`
+
+	// Notices lists files whose metadata could not be restored if one of
+	// the Skip options is used, and the placeholders of metadata-only
+	// snapshots
+	Notices []RestoreNotice `json:"notices,omitempty"`
+
+	// FilesVerified is the number of restored files whose content matched
+	// the snapshot, Mismatches lists the others. Only set if
+	// RestoreOptions.Verify is used.
+	FilesVerified int             `json:"files_verified,omitempty"`
+	Mismatches    []RestoreNotice `json:"mismatches,omitempty"`
+
+	// FilesSkipped is the number of files which were already correct and
+	// were not restored again, only set if RestoreOptions.Resume is used
+	FilesSkipped int `json:"files_skipped,omitempty"`
+}
+
+// RestoreNotice describes a file whose metadata could not be restored
+type RestoreNotice struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
 }
 
 // SnapshotFilter for filtering snapshots
 type SnapshotFilter struct {
 	Hosts []string `json:"hosts,omitempty"`
+	// Paths selects snapshots with a path equal to or below one of the paths
 	Paths []string `json:"paths,omitempty"`
 	Tags  []string `json:"tags,omitempty"`
-	Since *string  `json:"since,omitempty"`
-	Until *string  `json:"until,omitempty"`
 	Limit int      `json:"limit,omitempty"`
+
+	// Since and Until restrict the snapshot time, both are inclusive. In JSON
+	// they are RFC3339 timestamps.
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+
+	// WithSizes computes the file count and total size of each listed
+	// snapshot. The results of recently visited trees are cached, such that
+	// unchanged subtrees are usually only walked once.
+	WithSizes bool `json:"with_sizes,omitempty"`
 }
 
 // ForgetPolicy defines retention policy for snapshots
@@ -150,21 +753,119 @@ type ForgetPolicy struct {
 	KeepYearly  int      `json:"keep_yearly,omitempty"`
 	KeepWithin  *string  `json:"keep_within,omitempty"`
 	KeepTags    []string `json:"keep_tags,omitempty"`
+
+	// KeepWithinHourly and friends keep the latest snapshot of each hour, day,
+	// etc. made within the given duration (e.g. "2y5m7d3h")
+	KeepWithinHourly  *string `json:"keep_within_hourly,omitempty"`
+	KeepWithinDaily   *string `json:"keep_within_daily,omitempty"`
+	KeepWithinWeekly  *string `json:"keep_within_weekly,omitempty"`
+	KeepWithinMonthly *string `json:"keep_within_monthly,omitempty"`
+	KeepWithinYearly  *string `json:"keep_within_yearly,omitempty"`
+
+	// Filter restricts the policy to snapshots matching the filter, all other
+	// snapshots are left untouched. The Limit of the filter is ignored.
+	Filter *SnapshotFilter `json:"filter,omitempty"`
+
+	// GroupBy selects how snapshots are grouped before the policy is applied
+	// to each group (default: by host and paths)
+	GroupBy *SnapshotGroupBy `json:"group_by,omitempty"`
+
+	// Protect lists snapshots which are never removed regardless of the
+	// policy, e.g. for legal holds. Short IDs are matched as prefixes.
+	Protect []SnapshotID `json:"protect,omitempty"`
+
+	// DryRun evaluates the policy without removing any snapshot
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// ConfirmRemoval is called with the snapshots selected for removal before
+	// anything is deleted (optional). Returning false aborts the operation
+	// with ErrRemovalNotConfirmed. It is not called in dry-run mode.
+	ConfirmRemoval func(toRemove []Snapshot) (bool, error) `json:"-"`
+
+	// Lock controls waiting for locks of other clients. Forget locks the
+	// repository exclusively, Lock.NoLock is only supported for dry runs.
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// SnapshotGroupBy selects the snapshot fields used for grouping, snapshots
+// are in the same group if all selected fields are equal. With no field
+// selected, all snapshots form a single group.
+type SnapshotGroupBy struct {
+	Host  bool `json:"host,omitempty"`
+	Paths bool `json:"paths,omitempty"`
+	Tags  bool `json:"tags,omitempty"`
 }
 
 // Empty returns true if the policy has no rules set
 func (p ForgetPolicy) Empty() bool {
 	return p.KeepLast == 0 && p.KeepHourly == 0 && p.KeepDaily == 0 &&
 		p.KeepWeekly == 0 && p.KeepMonthly == 0 && p.KeepYearly == 0 &&
-		p.KeepWithin == nil && len(p.KeepTags) == 0
+		p.KeepWithin == nil && len(p.KeepTags) == 0 &&
+		p.KeepWithinHourly == nil && p.KeepWithinDaily == nil &&
+		p.KeepWithinWeekly == nil && p.KeepWithinMonthly == nil &&
+		p.KeepWithinYearly == nil
+}
+
+// KeptSnapshot is a snapshot kept by a forget policy
+type KeptSnapshot struct {
+	ID SnapshotID `json:"id"`
+
+	// Reasons lists the matching rules, e.g. "last snapshot", "daily
+	// snapshot" or "within 7d", or "protected", "retention lock" and "last
+	// snapshot of group" for snapshots kept despite the policy
+	Reasons []string `json:"reasons"`
+}
+
+// ForgetReport contains results of forget operation
+type ForgetReport struct {
+	// Removed lists the snapshots that were removed, or would be removed in dry-run mode
+	Removed []SnapshotID `json:"removed"`
+	DryRun  bool         `json:"dry_run,omitempty"`
+
+	// Kept lists the snapshots the policy was applied to which are kept,
+	// together with the reasons
+	Kept []KeptSnapshot `json:"kept,omitempty"`
+
+	// Protected lists snapshots the policy would have removed but which were
+	// kept because they are listed in ForgetPolicy.Protect
+	Protected []SnapshotID `json:"protected,omitempty"`
+
+	// RetentionLocked lists snapshots the policy would have removed but
+	// which were kept because their retention lock has not expired yet
+	RetentionLocked []SnapshotID `json:"retention_locked,omitempty"`
+
+	// ReclaimableBlobs and ReclaimableBytes estimate the data which is only
+	// referenced by the removed snapshots and which a subsequent prune would
+	// free. They are only computed in dry-run mode.
+	ReclaimableBlobs int    `json:"reclaimable_blobs,omitempty"`
+	ReclaimableBytes uint64 `json:"reclaimable_bytes,omitempty"`
 }
 
 // PruneOptions configures prune operations
 type PruneOptions struct {
-	DryRun        bool             `json:"dry_run,omitempty"`
-	MaxUnused     string           `json:"max_unused,omitempty"`
-	MaxRepackSize string           `json:"max_repack_size,omitempty"`
-	Progress      ProgressReporter `json:"-"`
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// MaxUnused is the unused space tolerated after pruning, either a
+	// percentage of the repository size ("5%"), a size above zero ("1G") or
+	// "unlimited" (default: "5%"). "0%" tolerates no unused space.
+	MaxUnused string `json:"max_unused,omitempty"`
+
+	// MaxRepackSize limits the amount of data repacked, e.g. "10G"
+	// (default: unlimited)
+	MaxRepackSize string `json:"max_repack_size,omitempty"`
+
+	Progress ProgressReporter `json:"-"`
+
+	// Lock controls waiting for locks of other clients, prune always locks
+	// the repository exclusively
+	Lock LockOptions `json:"lock,omitempty"`
+
+	// KeepRecent is a safety window for pack files that are not referenced
+	// by any index yet, e.g. because a backup on another host is still
+	// uploading them. Such packs are kept until their modification time is
+	// older than KeepRecent. Packs on backends which do not report
+	// modification times are always kept.
+	KeepRecent time.Duration `json:"keep_recent,omitempty"`
 }
 
 // PruneReport contains results of prune operation
@@ -172,6 +873,8 @@ type PruneReport struct {
 	PacksDeleted  int    `json:"packs_deleted"`
 	PacksKept     int    `json:"packs_kept"`
 	PacksRepacked int    `json:"packs_repacked"`
+	PacksRecent   int    `json:"packs_recent"`
+	PacksLocked   int    `json:"packs_locked"`
 	BytesDeleted  uint64 `json:"bytes_deleted"`
 	BytesRepacked uint64 `json:"bytes_repacked"`
 }
@@ -185,37 +888,218 @@ const (
 	CheckDepthReadData CheckDepth = "read_data"
 )
 
+// CheckPhase identifies the stage of a running integrity check
+type CheckPhase string
+
+const (
+	CheckPhaseIndex     CheckPhase = "index"
+	CheckPhaseStructure CheckPhase = "structure"
+	CheckPhasePacks     CheckPhase = "packs"
+	CheckPhaseReadData  CheckPhase = "read_data"
+)
+
+// CheckEvent reports progress or a finding of a running integrity check
+type CheckEvent struct {
+	Phase        CheckPhase `json:"phase"`
+	PacksChecked uint64     `json:"packs_checked"`
+	PacksTotal   uint64     `json:"packs_total"`
+	BytesRead    uint64     `json:"bytes_read"`
+	BytesTotal   uint64     `json:"bytes_total"`
+	Pack         string     `json:"pack,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Warning      string     `json:"warning,omitempty"`
+}
+
+// CheckOptions configures integrity checks
+type CheckOptions struct {
+	Depth CheckDepth `json:"depth,omitempty"`
+
+	// ReadDataSubset reads and verifies only a subset of the packs, also if
+	// Depth is not CheckDepthReadData: "n/t" reads group n of t groups
+	// (t at most 256), such that t runs cover the whole repository, "x%"
+	// reads a random percentage of the packs and a size such as "500M" reads
+	// random packs totalling about that size
+	ReadDataSubset string `json:"read_data_subset,omitempty"`
+
+	// OnEvent is called for every checked pack and every error or warning
+	// as soon as it is found (optional). It is never called concurrently.
+	OnEvent func(event CheckEvent) `json:"-"`
+
+	// Repair repairs the repository if the check finds errors, like the
+	// RepairIndex, RepairPacks and RepairSnapshots calls recommended for the
+	// findings: the index is rebuilt, the intact blobs of damaged packs
+	// found while reading data are salvaged and snapshots referencing lost
+	// data are replaced by repaired copies tagged "repaired", like
+	// RepairSnapshots with Forget. Damaged retention-locked snapshots fail
+	// the repair. Requires a lock.
+	Repair bool `json:"repair,omitempty"`
+
+	// Progress is advanced by the size of each pack read and verified
+	// (optional). Damaged packs are passed to Progress.Error, the check is
+	// aborted if it returns an error.
+	Progress ProgressReporter `json:"-"`
+
+	// Lock controls waiting for locks of other clients. The check locks the
+	// repository exclusively unless Lock.NoLock is set.
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
 // CheckReport contains results of integrity check
 type CheckReport struct {
 	Errors   []string `json:"errors,omitempty"`
 	Warnings []string `json:"warnings,omitempty"`
 	Success  bool     `json:"success"`
+
+	// Problems describes the findings of Errors as typed errors, use
+	// errors.As to find *PackError, *BlobError, *IndexError and *TreeError.
+	// They are encoded as JSON objects with a "type" of "pack", "blob",
+	// "index" or "tree" and the IDs and path of the finding.
+	Problems []error `json:"-"`
+
+	// Incomplete is set if the check was aborted, the report then only
+	// contains the findings up to that point
+	Incomplete bool `json:"incomplete,omitempty"`
+
+	// Repair describes the repairs made for the findings if
+	// CheckOptions.Repair is set and errors were found
+	Repair *CheckRepairReport `json:"repair,omitempty"`
 }
 
-// Repository interface provides access to a restic repository
+// CheckRepairReport describes the repairs made by a check
+type CheckRepairReport struct {
+	Index     RepairIndexReport     `json:"index"`
+	Packs     RepairPacksReport     `json:"packs"`
+	Snapshots RepairSnapshotsReport `json:"snapshots"`
+}
+
+// Repository interface provides access to a restic repository. The settings
+// of an operation are passed in its options struct and its results returned in
+// a report struct. New settings and results are added as fields of these
+// structs rather than as new methods, such that existing callers keep
+// compiling.
 type Repository interface {
-	// Backup creates a new backup snapshot
-	Backup(ctx context.Context, opts BackupOptions) (SnapshotID, error)
+	// Backup creates a new backup snapshot and reports statistics per path
+	Backup(ctx context.Context, opts BackupOptions) (BackupSummary, error)
+
+	// Restore restores files from a snapshot and lists the planned actions of dry runs
+	Restore(ctx context.Context, snapshotID SnapshotID, opts RestoreOptions) (RestoreReport, error)
+
+	// VerifyRestore compares a restore target with a snapshot without writing anything
+	VerifyRestore(ctx context.Context, snapshotID SnapshotID, targetDir string) (VerifyRestoreReport, error)
+
+	// Stats computes size statistics of snapshots
+	Stats(ctx context.Context, opts StatsOptions) (StatsReport, error)
+
+	// Cat returns the decoded content of a repository object for debugging
+	Cat(ctx context.Context, objectType ObjectType, id string) ([]byte, error)
+
+	// Ls streams the entries of a snapshot to fn
+	Ls(ctx context.Context, snapshotID SnapshotID, fn func(entry LsEntry) error) error
 
-	// Restore restores files from a snapshot
-	Restore(ctx context.Context, snapshotID SnapshotID, opts RestoreOptions) error
+	// DumpFile writes the content of a single file from a snapshot to w
+	DumpFile(ctx context.Context, snapshotID SnapshotID, path string, w io.Writer) error
+
+	// Mount exposes the snapshots as read-only FUSE filesystem until ctx is cancelled
+	Mount(ctx context.Context, mountpoint string, opts MountOptions) error
+
+	// DumpArchive writes a file or directory of a snapshot as tar or zip archive to w
+	DumpArchive(ctx context.Context, snapshotID SnapshotID, path string, format ArchiveFormat, w io.Writer) error
+
+	// Warmup requests packs of a snapshot to be restored from cold storage
+	Warmup(ctx context.Context, snapshotID SnapshotID, wait bool) (WarmupReport, error)
 
 	// Snapshots lists snapshots matching the filter
 	Snapshots(ctx context.Context, filter SnapshotFilter) ([]Snapshot, error)
 
-	// Forget removes snapshots according to policy
-	Forget(ctx context.Context, policy ForgetPolicy) ([]SnapshotID, error)
+	// ResolveSnapshot returns the full ID for "latest" or a snapshot ID prefix
+	ResolveSnapshot(ctx context.Context, ref string, filter SnapshotFilter) (SnapshotID, error)
+
+	// ChangeSummary summarizes the changes of a snapshot relative to its parent
+	ChangeSummary(ctx context.Context, snapshotID SnapshotID) (ChangeSummary, error)
+
+	// ExportSnapshot writes a snapshot and the blobs missing on the receiver to a stream
+	ExportSnapshot(ctx context.Context, snapshotID SnapshotID, w io.Writer, opts ExportOptions) (ExportReport, error)
+
+	// ImportSnapshot saves a snapshot read from a stream written by ExportSnapshot
+	ImportSnapshot(ctx context.Context, rd io.Reader) (ExportReport, error)
+
+	// SyncTo copies missing snapshots and their data to another repository
+	SyncTo(ctx context.Context, dst Repository, opts SyncOptions) (SyncReport, error)
+
+	// RetainSnapshot prevents the removal of a snapshot before the given time
+	RetainSnapshot(ctx context.Context, snapshotID SnapshotID, until time.Time) (SnapshotID, error)
+
+	// DeleteSnapshots removes snapshots, refusing retention-locked ones
+	DeleteSnapshots(ctx context.Context, snapshotIDs []SnapshotID) ([]SnapshotID, error)
+
+	// Forget removes snapshots according to policy and returns a detailed report
+	Forget(ctx context.Context, policy ForgetPolicy) (ForgetReport, error)
 
 	// Prune removes unused data from repository
 	Prune(ctx context.Context, opts PruneOptions) (PruneReport, error)
 
-	// Check verifies repository integrity
-	Check(ctx context.Context, depth CheckDepth) (CheckReport, error)
+	// CompactIndex merges many small index files into fewer large ones
+	CompactIndex(ctx context.Context, opts CompactIndexOptions) (CompactIndexReport, error)
+
+	// Rewrite creates copies of snapshots with the excluded paths removed
+	Rewrite(ctx context.Context, snapshotIDs []SnapshotID, opts RewriteOptions) (RewriteReport, error)
+
+	// RepairIndex rebuilds the index from the pack files
+	RepairIndex(ctx context.Context, opts RepairIndexOptions) (RepairIndexReport, error)
+
+	// RebuildIndex rebuilds the index, optionally reading all pack files
+	RebuildIndex(ctx context.Context, readAllPacks bool) (RebuildIndexReport, error)
+
+	// RepairPacks salvages the intact blobs of damaged pack files
+	RepairPacks(ctx context.Context, packIDs []string, opts RepairPacksOptions) (RepairPacksReport, error)
+
+	// RepairSnapshots removes missing data from snapshots
+	RepairSnapshots(ctx context.Context, opts RepairSnapshotsOptions) (RepairSnapshotsReport, error)
+
+	// Check verifies repository integrity and streams progress events
+	Check(ctx context.Context, opts CheckOptions) (CheckReport, error)
+
+	// CheckSnapshot verifies only the data referenced by a single snapshot
+	CheckSnapshot(ctx context.Context, snapshotID SnapshotID, depth CheckDepth) (CheckReport, error)
+
+	// FindPaths finds paths matching a pattern in all snapshots using the path index
+	FindPaths(ctx context.Context, pattern string) ([]PathMatch, error)
+
+	// Find searches snapshots for paths matching patterns or referencing blobs or trees
+	Find(ctx context.Context, opts FindOptions) ([]FindMatch, error)
+
+	// UpdatePathIndex adds new snapshots to the path index and drops removed ones
+	UpdatePathIndex(ctx context.Context) error
+
+	// StartHealthChecks periodically runs lightweight checks in the background
+	StartHealthChecks(ctx context.Context, opts HealthCheckOptions) (*HealthChecker, error)
 
 	// Unlock removes stale locks from repository
 	Unlock(ctx context.Context) error
 
-	// Close closes the repository connection
+	// UnlockAll removes all locks, also those of running clients
+	UnlockAll(ctx context.Context) (int, error)
+
+	// PasswordIndex returns which password opened the repository, 0 for
+	// Config.Password and i for Config.AlternatePasswords[i-1]
+	PasswordIndex() int
+
+	// Keys lists the keys of the repository
+	Keys(ctx context.Context) ([]KeyInfo, error)
+
+	// AddKey adds a key for another password
+	AddKey(ctx context.Context, password []byte, opts AddKeyOptions) (KeyID, error)
+
+	// RemoveKey removes a key other than the current one
+	RemoveKey(ctx context.Context, keyID KeyID) error
+
+	// ChangePassword replaces the current key by a key for the new password
+	ChangePassword(ctx context.Context, newPassword []byte) error
+
+	// Shutdown waits for running operations, bounded by ctx, and then closes the repository
+	Shutdown(ctx context.Context) error
+
+	// Close closes the repository connection, further calls are no-ops
 	Close() error
 }
 
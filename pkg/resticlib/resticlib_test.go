@@ -1,12 +1,42 @@
 package resticlib
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/gs"
+	"github.com/restic/restic/internal/backend/location"
+	"github.com/restic/restic/internal/backend/rclone"
+	"github.com/restic/restic/internal/backend/s3"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/feature"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
 )
 
 // TestBasicAPI tests that the basic API functions compile and can be called
@@ -238,10 +268,7 @@ func TestRealRepository(t *testing.T) {
 		Tags:  []string{"test", "integration"},
 	}
 
-	snapshotID, err := repo2.Backup(ctx, backupOpts)
-	if err != nil {
-		t.Fatalf("Backup failed: %v", err)
-	}
+	snapshotID := backupSnapshot(t, ctx, repo2, backupOpts)
 
 	t.Logf("Backup created with snapshot ID: %s (length: %d)", snapshotID, len(string(snapshotID)))
 
@@ -274,7 +301,7 @@ func TestRealRepository(t *testing.T) {
 		Overwrite: true,
 	}
 
-	err = repo2.Restore(ctx, snapshotID, restoreOpts)
+	_, err = repo2.Restore(ctx, snapshotID, restoreOpts)
 	if err != nil {
 		t.Fatalf("Restore failed: %v", err)
 	}
@@ -306,7 +333,7 @@ func TestRealRepository(t *testing.T) {
 	t.Log("Restore successful, content verified")
 
 	// Test check
-	checkReport, err := repo2.Check(ctx, CheckDepthDefault)
+	checkReport, err := repo2.Check(ctx, CheckOptions{Depth: CheckDepthDefault})
 	if err != nil {
 		t.Fatalf("Check failed: %v", err)
 	}
@@ -317,3 +344,4400 @@ func TestRealRepository(t *testing.T) {
 
 	t.Log("Repository check passed")
 }
+
+// newTestRepository initializes a repository in a temporary directory and
+// returns it along with a directory which can be used for test data
+func newTestRepository(t *testing.T) (Repository, string) {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	config := Config{
+		RepoURL:  "local:" + filepath.Join(tempDir, "repo"),
+		Backend:  BackendLocal,
+		Password: []byte("testpassword123"),
+	}
+
+	repo, err := Init(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	dataDir := filepath.Join(tempDir, "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatalf("Failed to create test data dir: %v", err)
+	}
+	return repo, dataDir
+}
+
+// backupSnapshot creates a snapshot and fails the test on errors
+func backupSnapshot(t *testing.T, ctx context.Context, repo Repository, opts BackupOptions) SnapshotID {
+	t.Helper()
+
+	summary, err := repo.Backup(ctx, opts)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	return summary.SnapshotID
+}
+
+// TestForgetDryRun tests that a dry-run forget removes nothing and estimates
+// the reclaimable space
+func TestForgetDryRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for i, content := range []string{"first version", "second version"} {
+		err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		_, err = repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}})
+		if err != nil {
+			t.Fatalf("Backup %d failed: %v", i, err)
+		}
+	}
+
+	report, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1, DryRun: true})
+	if err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+
+	if len(report.Removed) != 1 {
+		t.Errorf("Expected 1 snapshot to be removed, got %d", len(report.Removed))
+	}
+	if report.ReclaimableBlobs == 0 || report.ReclaimableBytes == 0 {
+		t.Errorf("Expected reclaimable data, got %+v", report)
+	}
+	if len(report.Kept) != 1 || len(report.Kept[0].Reasons) != 1 || report.Kept[0].Reasons[0] != "last snapshot" {
+		t.Errorf("Expected 1 snapshot kept as last snapshot, got %+v", report.Kept)
+	}
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("Dry-run removed snapshots, %d left", len(snapshots))
+	}
+}
+
+// TestForgetGroupBy tests applying a policy per path and across all snapshots
+func TestForgetGroupBy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, dir := range []string{"a", "b"} {
+		path := filepath.Join(dataDir, dir)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatalf("Failed to create test dir: %v", err)
+		}
+		if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{path}, Tags: []string{"daily"}}); err != nil {
+			t.Fatalf("Backup of %s failed: %v", dir, err)
+		}
+	}
+
+	for _, test := range []struct {
+		groupBy *SnapshotGroupBy
+		removed int
+	}{
+		{nil, 0},
+		{&SnapshotGroupBy{Tags: true}, 1},
+		{&SnapshotGroupBy{}, 1},
+	} {
+		report, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1, GroupBy: test.groupBy, DryRun: true})
+		if err != nil {
+			t.Fatalf("Forget failed: %v", err)
+		}
+		if len(report.Removed) != test.removed || len(report.Kept) != 2-test.removed {
+			t.Errorf("GroupBy %+v: expected %d snapshots to be removed, got %+v", test.groupBy, test.removed, report)
+		}
+	}
+}
+
+// TestPrune tests that prune deletes the data of forgotten snapshots and
+// that a dry run deletes nothing
+func TestPrune(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	var ids []SnapshotID
+	for _, content := range []string{"first version", "second version"} {
+		err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte(strings.Repeat(content, 1000)), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+		ids = append(ids, id)
+	}
+	if _, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+
+	dryRun, err := repo.Prune(ctx, PruneOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry-run prune failed: %v", err)
+	}
+	if dryRun.PacksDeleted+dryRun.PacksRepacked == 0 || dryRun.BytesDeleted == 0 {
+		t.Errorf("Expected the dry run to plan deleting data, got %+v", dryRun)
+	}
+
+	report, err := repo.Prune(ctx, PruneOptions{})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if report != dryRun {
+		t.Errorf("Prune report %+v differs from dry run %+v", report, dryRun)
+	}
+
+	again, err := repo.Prune(ctx, PruneOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Second prune failed: %v", err)
+	}
+	if again.PacksDeleted != 0 || again.PacksRepacked != 0 {
+		t.Errorf("Expected nothing left to prune, got %+v", again)
+	}
+
+	check, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthReadData})
+	if err != nil || !check.Success {
+		t.Fatalf("Check after prune failed: %v %+v", err, check)
+	}
+	if _, err := repo.Restore(ctx, ids[1], RestoreOptions{TargetDir: t.TempDir()}); err != nil {
+		t.Errorf("Restore after prune failed: %v", err)
+	}
+}
+
+// lockedBackend reports every file as retained and records the queries
+type lockedBackend struct {
+	backend.Backend
+	mu      sync.Mutex
+	queried restic.IDSet
+}
+
+func (be *lockedBackend) RetainUntil(_ context.Context, h backend.Handle) (time.Time, error) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.queried.Insert(restic.TestParseID(h.Name))
+	return time.Now().Add(time.Hour), nil
+}
+
+// TestPruneObjectLock tests that prune keeps locked packs and only queries
+// the retention of the packs it would delete or repack
+func TestPruneObjectLock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for i, content := range []string{"first version", "second version"} {
+		err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte(strings.Repeat(content, 1000)), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+			t.Fatalf("Backup %d failed: %v", i, err)
+		}
+	}
+	if _, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+
+	impl := repo.(*repositoryImpl)
+	packs := 0
+	err := impl.repo.List(ctx, restic.PackFile, func(restic.ID, int64) error {
+		packs++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to list packs: %v", err)
+	}
+
+	be := &lockedBackend{Backend: impl.be, queried: restic.NewIDSet()}
+	impl.be = be
+	impl.cfg.ObjectLock = &ObjectLockOptions{Mode: "GOVERNANCE", Retention: time.Hour}
+
+	report, err := repo.Prune(ctx, PruneOptions{})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if report.PacksLocked == 0 || report.PacksDeleted != 0 || report.PacksRepacked != 0 {
+		t.Errorf("Expected all prune candidates to be kept, got %+v", report)
+	}
+	if len(be.queried) != report.PacksLocked || len(be.queried) >= packs {
+		t.Errorf("Expected only the %d prune candidates out of %d packs to be queried, got %d", report.PacksLocked, packs, len(be.queried))
+	}
+}
+
+// TestPruneKeepRecent tests that prune keeps a freshly written unindexed pack
+// without any locks in the repository and removes it once it is older than
+// KeepRecent
+func TestPruneKeepRecent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+	impl := repo.(*repositoryImpl)
+
+	data := []byte(strings.Repeat("unindexed pack", 100))
+	id := restic.Hash(data)
+	h := backend.Handle{Type: restic.PackFile, Name: id.String()}
+	if err := impl.be.Save(ctx, h, backend.NewByteReader(data, impl.be.Hasher())); err != nil {
+		t.Fatalf("Failed to save pack: %v", err)
+	}
+	locks := 0
+	err := impl.repo.List(ctx, restic.LockFile, func(restic.ID, int64) error {
+		locks++
+		return nil
+	})
+	if err != nil || locks != 0 {
+		t.Fatalf("Expected no locks, got %d %v", locks, err)
+	}
+
+	report, err := repo.Prune(ctx, PruneOptions{KeepRecent: time.Hour})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if report.PacksRecent != 1 || report.PacksDeleted != 0 {
+		t.Errorf("Expected the fresh pack to be kept, got %+v", report)
+	}
+	if _, err := impl.be.Stat(ctx, h); err != nil {
+		t.Fatalf("Fresh pack was removed: %v", err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	filename := filepath.Join(strings.TrimPrefix(impl.cfg.RepoURL, "local:"), "data", id.String()[:2], id.String())
+	if err := os.Chtimes(filename, old, old); err != nil {
+		t.Fatalf("Failed to age pack: %v", err)
+	}
+
+	report, err = repo.Prune(ctx, PruneOptions{KeepRecent: time.Hour})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if report.PacksRecent != 0 {
+		t.Errorf("Expected the old pack not to be kept, got %+v", report)
+	}
+	if _, err := impl.be.Stat(ctx, h); err == nil {
+		t.Errorf("Expected the old pack to be removed")
+	}
+}
+
+// TestPruneOptionsLimits tests parsing MaxUnused and MaxRepackSize
+func TestPruneOptionsLimits(t *testing.T) {
+	for _, test := range []struct {
+		maxUnused  string
+		used       uint64
+		wantUnused uint64
+	}{
+		{"", 95, 5},
+		{"50%", 100, 100},
+		{"0%", 100, 0},
+		{"1K", 100, 1024},
+		{"unlimited", 100, math.MaxUint64},
+	} {
+		opts, err := PruneOptions{MaxUnused: test.maxUnused}.repositoryOptions()
+		if err != nil {
+			t.Fatalf("MaxUnused %q: %v", test.maxUnused, err)
+		}
+		if got := opts.MaxUnusedBytes(test.used); got != test.wantUnused {
+			t.Errorf("MaxUnused %q: got %d unused bytes for %d used, want %d", test.maxUnused, got, test.used, test.wantUnused)
+		}
+		if opts.MaxRepackBytes != math.MaxUint64 {
+			t.Errorf("MaxRepackBytes = %d, want unlimited", opts.MaxRepackBytes)
+		}
+	}
+
+	opts, err := PruneOptions{MaxRepackSize: "2M"}.repositoryOptions()
+	if err != nil || opts.MaxRepackBytes != 2*1024*1024 {
+		t.Errorf("MaxRepackSize 2M: got %d, %v", opts.MaxRepackBytes, err)
+	}
+
+	for _, invalid := range []PruneOptions{
+		{MaxUnused: "100%"},
+		{MaxUnused: "-1%"},
+		{MaxUnused: "five"},
+		{MaxRepackSize: "lots"},
+		{MaxRepackSize: "0"},
+		{MaxRepackSize: "-1"},
+		{MaxRepackSize: "-1k"},
+		{MaxUnused: "0"},
+		{MaxUnused: "-5M"},
+	} {
+		if _, err := invalid.repositoryOptions(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+// TestForgetPolicyWithin tests the conversion of the keep-within rules
+func TestForgetPolicyWithin(t *testing.T) {
+	daily := "7d"
+	policy := ForgetPolicy{KeepWithinDaily: &daily}
+	if policy.Empty() {
+		t.Errorf("ForgetPolicy.Empty() = true, want false")
+	}
+
+	internal, err := policy.expirePolicy()
+	if err != nil {
+		t.Fatalf("expirePolicy() failed: %v", err)
+	}
+	if internal.WithinDaily.Days != 7 {
+		t.Errorf("WithinDaily = %v, want 7d", internal.WithinDaily)
+	}
+
+	invalid := "seven days"
+	policy = ForgetPolicy{KeepWithinYearly: &invalid}
+	if _, err := policy.expirePolicy(); err == nil {
+		t.Errorf("expirePolicy() accepted invalid duration %q", invalid)
+	}
+}
+
+// TestForgetFilter tests that Forget only considers snapshots matching the filter
+func TestForgetFilter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, tag := range []string{"keep", "keep", "scratch", "scratch"} {
+		_, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, Tags: []string{tag}})
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+	}
+
+	removed, err := repo.Forget(ctx, ForgetPolicy{
+		KeepLast: 1,
+		Filter:   &SnapshotFilter{Tags: []string{"scratch"}},
+	})
+	if err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	if len(removed.Removed) != 1 {
+		t.Errorf("Expected 1 snapshot to be removed, got %d", len(removed.Removed))
+	}
+
+	kept, err := repo.Snapshots(ctx, SnapshotFilter{Tags: []string{"keep"}})
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(kept) != 2 {
+		t.Errorf("Forget removed snapshots outside of its scope, %d left", len(kept))
+	}
+}
+
+// TestForgetProtect tests that protected snapshots are never removed
+func TestForgetProtect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	var ids []SnapshotID
+	for i := 0; i < 2; i++ {
+		id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+		ids = append(ids, id)
+	}
+
+	report, err := repo.Forget(ctx, ForgetPolicy{
+		KeepLast: 1,
+		Protect:  []SnapshotID{ids[0][:8]},
+	})
+	if err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	if len(report.Removed) != 0 {
+		t.Errorf("Expected no snapshot to be removed, got %v", report.Removed)
+	}
+	if len(report.Protected) != 1 || report.Protected[0] != ids[0] {
+		t.Errorf("Expected %v to be protected, got %v", ids[0], report.Protected)
+	}
+}
+
+// TestForgetConfirmRemoval tests that Forget asks for confirmation before deleting
+func TestForgetConfirmRemoval(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+	}
+
+	var asked []Snapshot
+	_, err := repo.Forget(ctx, ForgetPolicy{
+		KeepLast: 1,
+		ConfirmRemoval: func(toRemove []Snapshot) (bool, error) {
+			asked = toRemove
+			return false, nil
+		},
+	})
+	if !errors.Is(err, ErrRemovalNotConfirmed) {
+		t.Fatalf("Expected ErrRemovalNotConfirmed, got %v", err)
+	}
+	if len(asked) != 2 {
+		t.Errorf("Expected confirmation for 2 snapshots, got %d", len(asked))
+	}
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 3 {
+		t.Errorf("Unconfirmed forget removed snapshots, %d left", len(snapshots))
+	}
+}
+
+// TestCheckEvents tests that a read-data check reports every pack
+func TestCheckEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("check me"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	var last CheckEvent
+	packsRead := 0
+	report, err := repo.Check(ctx, CheckOptions{
+		Depth: CheckDepthReadData,
+		OnEvent: func(event CheckEvent) {
+			if event.Error != "" {
+				t.Errorf("Unexpected error event: %+v", event)
+			}
+			if event.Phase == CheckPhaseReadData {
+				packsRead++
+				last = event
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Success || report.Incomplete {
+		t.Errorf("Repository check failed: %+v", report)
+	}
+
+	if packsRead == 0 || uint64(packsRead) != last.PacksTotal {
+		t.Errorf("Expected an event for each of %d packs, got %d", last.PacksTotal, packsRead)
+	}
+	if last.PacksChecked != last.PacksTotal || last.BytesRead != last.BytesTotal {
+		t.Errorf("Final event does not cover all data: %+v", last)
+	}
+}
+
+// TestCheckProgress tests that a read-data check reports the verified bytes
+func TestCheckProgress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("check my progress"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	events := make(chan ProgressEvent, 100)
+	report, err := repo.Check(ctx, CheckOptions{
+		Depth:    CheckDepthReadData,
+		Progress: NewChannelProgress(events),
+	})
+	close(events)
+	if err != nil || !report.Success {
+		t.Fatalf("Check failed: %v %+v", err, report)
+	}
+
+	var total, done uint64
+	finished := false
+	for ev := range events {
+		switch ev.Kind {
+		case ProgressEventTotal:
+			total = ev.Total
+		case ProgressEventAdd:
+			done += ev.Delta
+		case ProgressEventFinish:
+			finished = true
+		}
+	}
+	if total == 0 || done != total || !finished {
+		t.Errorf("Expected all %d bytes to be reported, got %d (finished: %v)", total, done, finished)
+	}
+}
+
+// TestCheckProblems tests that damaged and missing data is reported as typed errors
+func TestCheckProblems(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "damaged.txt"), []byte("this content gets damaged"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	blob, packFile := damageDataBlob(t, repo, dataDir)
+	pack := blob.PackID.String()
+
+	report, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthReadData})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	var blobErr *BlobError
+	found := false
+	for _, problem := range report.Problems {
+		if errors.As(problem, &blobErr) && blobErr.BlobID == blob.ID.String() && blobErr.PackID == pack {
+			found = true
+		}
+	}
+	if report.Success || !found {
+		t.Errorf("Expected the damaged blob to be reported, got %v", report.Problems)
+	}
+
+	// without the pack file, the snapshot references missing data
+	if err := os.Remove(packFile); err != nil {
+		t.Fatalf("Failed to remove pack file: %v", err)
+	}
+	if _, err := repo.RepairIndex(ctx, RepairIndexOptions{}); err != nil {
+		t.Fatalf("RepairIndex failed: %v", err)
+	}
+
+	report, err = repo.CheckSnapshot(ctx, snapshotID, CheckDepthDefault)
+	if err != nil {
+		t.Fatalf("CheckSnapshot failed: %v", err)
+	}
+	var treeErr *TreeError
+	if len(report.Problems) != 1 || !errors.As(report.Problems[0], &treeErr) || !errors.As(report.Problems[0], &blobErr) {
+		t.Fatalf("Expected a tree error for the missing blob, got %v", report.Problems)
+	}
+	if !strings.HasSuffix(treeErr.Path, "/damaged.txt") || treeErr.SnapshotID != snapshotID {
+		t.Errorf("Unexpected tree error %+v", treeErr)
+	}
+}
+
+// TestCheckReportJSON tests that the typed findings survive a JSON round trip
+func TestCheckReportJSON(t *testing.T) {
+	report := CheckReport{
+		Errors: []string{"a", "b", "c"},
+		Problems: []error{
+			&PackError{PackID: "p1", Err: errors.New("missing")},
+			&IndexError{Err: errors.New("broken")},
+			&TreeError{SnapshotID: "s1", TreeID: "t1", Path: "/a/b", Err: &BlobError{BlobID: "b1", PackID: "p2", Err: errors.New("lost")}},
+		},
+	}
+
+	buf, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Failed to encode report: %v", err)
+	}
+	if !strings.Contains(string(buf), `{"type":"pack","pack_id":"p1","error":"missing"}`) {
+		t.Errorf("Unexpected encoding %s", buf)
+	}
+
+	var decoded CheckReport
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(decoded.Errors) != 3 || len(decoded.Problems) != 3 {
+		t.Fatalf("Unexpected report %+v", decoded)
+	}
+	for i, problem := range decoded.Problems {
+		if problem.Error() != report.Problems[i].Error() {
+			t.Errorf("Expected %q, got %q", report.Problems[i], problem)
+		}
+	}
+	var treeErr *TreeError
+	var blobErr *BlobError
+	if !errors.As(decoded.Problems[2], &treeErr) || !errors.As(decoded.Problems[2], &blobErr) || blobErr.PackID != "p2" {
+		t.Errorf("Expected a tree error for a blob, got %#v", decoded.Problems[2])
+	}
+}
+
+// damageDataBlob flips a byte of the last data blob in the index and returns
+// the blob and its pack file
+func damageDataBlob(t *testing.T, repo Repository, dataDir string) (restic.PackedBlob, string) {
+	t.Helper()
+	ctx := context.Background()
+	impl := repo.(*repositoryImpl)
+
+	if err := impl.repo.LoadIndex(ctx, nil); err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	var blob restic.PackedBlob
+	err := impl.repo.ListBlobs(ctx, func(pb restic.PackedBlob) {
+		if pb.Type == restic.DataBlob {
+			blob = pb
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to list blobs: %v", err)
+	}
+
+	pack := blob.PackID.String()
+	packFile := filepath.Join(filepath.Dir(dataDir), "repo", "data", pack[:2], pack)
+	buf, err := os.ReadFile(packFile)
+	if err != nil {
+		t.Fatalf("Failed to read pack file: %v", err)
+	}
+	buf[blob.Offset+blob.Length/2] ^= 0xff
+	if err := os.Chmod(packFile, 0644); err != nil {
+		t.Fatalf("Failed to make pack file writable: %v", err)
+	}
+	if err := os.WriteFile(packFile, buf, 0644); err != nil {
+		t.Fatalf("Failed to damage pack file: %v", err)
+	}
+	return blob, packFile
+}
+
+// TestCheckRepair tests that a check with Repair heals a damaged pack
+func TestCheckRepair(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "damaged.txt"), []byte("this content gets damaged"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	blob, _ := damageDataBlob(t, repo, dataDir)
+
+	if _, err := repo.Check(ctx, CheckOptions{Repair: true, Lock: LockOptions{NoLock: true}}); err == nil {
+		t.Error("Expected repair without lock to be rejected")
+	}
+
+	report, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthReadData, Repair: true})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if report.Success || report.Repair == nil {
+		t.Fatalf("Expected errors to be found and repaired, got %+v", report)
+	}
+	if len(report.Repair.Packs.Packs) != 1 || report.Repair.Packs.Packs[0] != blob.PackID.String() || report.Repair.Packs.BlobsLost != 1 {
+		t.Errorf("Expected the damaged pack to be salvaged, got %+v", report.Repair.Packs)
+	}
+	if len(report.Repair.Snapshots.Repaired) != 1 {
+		t.Fatalf("Expected the snapshot to be repaired, got %+v", report.Repair.Snapshots)
+	}
+	repaired := report.Repair.Snapshots.Repaired[0].New
+
+	report, err = repo.Check(ctx, CheckOptions{Depth: CheckDepthReadData})
+	if err != nil || !report.Success {
+		t.Errorf("Expected the repaired repository to pass the check: %v %+v", err, report)
+	}
+
+	// the damaged original is replaced, the trees of all snapshots are intact
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != repaired {
+		t.Errorf("Expected only the repaired snapshot %s to remain, got %+v", repaired, snapshots)
+	}
+	for _, sn := range snapshots {
+		report, err := repo.CheckSnapshot(ctx, sn.ID, CheckDepthReadData)
+		if err != nil || !report.Success {
+			t.Errorf("Expected snapshot %s to pass the check: %v %+v", sn.ID, err, report)
+		}
+	}
+}
+
+// TestReadDataSubset tests parsing subsets and selecting their packs
+func TestReadDataSubset(t *testing.T) {
+	packs := make(map[restic.ID]int64)
+	for i := 0; i < 10; i++ {
+		packs[restic.NewRandomID()] = 100
+	}
+
+	for _, test := range []struct {
+		subset string
+		want   int
+	}{
+		{"1/1", 10},
+		{"50%", 5},
+		{"0.1%", 1},
+		{"100%", 10},
+		{"300B", 3},
+		{"1K", 10},
+	} {
+		subset, err := parseReadDataSubset(test.subset)
+		if err != nil {
+			t.Fatalf("Subset %q: %v", test.subset, err)
+		}
+		if got := len(subset.selectPacks(packs)); got != test.want {
+			t.Errorf("Subset %q selected %d packs, want %d", test.subset, got, test.want)
+		}
+	}
+
+	// the groups of n/t cover each pack exactly once
+	seen := 0
+	for n := 1; n <= 3; n++ {
+		subset, err := parseReadDataSubset(fmt.Sprintf("%d/3", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen += len(subset.selectPacks(packs))
+	}
+	if seen != len(packs) {
+		t.Errorf("Groups selected %d packs, want %d", seen, len(packs))
+	}
+
+	for _, invalid := range []string{"0/2", "3/2", "1/257", "0%", "101%", "-5M", "some"} {
+		if _, err := parseReadDataSubset(invalid); err == nil {
+			t.Errorf("Expected subset %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestCheckSnapshot tests checking the data of a single snapshot
+func TestCheckSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("check this snapshot"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	report, err := repo.CheckSnapshot(ctx, id, CheckDepthReadData)
+	if err != nil {
+		t.Fatalf("CheckSnapshot failed: %v", err)
+	}
+	if !report.Success {
+		t.Errorf("Snapshot check failed: %+v", report)
+	}
+
+	// remove all pack files, only the index still references them
+	packDir := filepath.Join(filepath.Dir(dataDir), "repo", "data")
+	if err := os.RemoveAll(packDir); err != nil {
+		t.Fatalf("Failed to remove pack files: %v", err)
+	}
+
+	report, err = repo.CheckSnapshot(ctx, id, CheckDepthFull)
+	if err != nil {
+		t.Fatalf("CheckSnapshot failed: %v", err)
+	}
+	if report.Success || len(report.Errors) == 0 {
+		t.Errorf("Expected missing packs to be reported: %+v", report)
+	}
+}
+
+// TestSnapshotSizes tests computing the size of listed snapshots and that the
+// cached sizes are dropped by prune
+func TestSnapshotSizes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		err := os.WriteFile(filepath.Join(dataDir, name), []byte("12345"), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if snapshots[0].Size != nil {
+		t.Errorf("Size computed without being requested: %+v", snapshots[0].Size)
+	}
+
+	snapshots, err = repo.Snapshots(ctx, SnapshotFilter{WithSizes: true})
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	size := snapshots[0].Size
+	if size == nil || size.Files != 2 || size.Bytes != 10 {
+		t.Errorf("Unexpected snapshot size: %+v", size)
+	}
+
+	impl := repo.(*repositoryImpl)
+	if impl.treeSizes == nil || impl.treeSizes.Len() == 0 {
+		t.Fatalf("Expected the tree sizes to be cached")
+	}
+	if _, err := repo.Prune(ctx, PruneOptions{}); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if impl.treeSizes != nil {
+		t.Errorf("Expected prune to reset the cached tree sizes, got %d", impl.treeSizes.Len())
+	}
+}
+
+// TestChangeSummary tests summarizing the changes against the parent snapshot
+func TestChangeSummary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	writeFile("modified.txt", "old content")
+	writeFile("removed.txt", "going away")
+	parent := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	writeFile("modified.txt", "new content")
+	writeFile("added.txt", "brand new")
+	if err := os.Remove(filepath.Join(dataDir, "removed.txt")); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}, ParentID: &parent})
+
+	summary, err := repo.ChangeSummary(ctx, id)
+	if err != nil {
+		t.Fatalf("ChangeSummary failed: %v", err)
+	}
+	if summary.Parent != parent {
+		t.Errorf("Parent = %v, want %v", summary.Parent, parent)
+	}
+	if summary.FilesAdded != 1 || summary.FilesRemoved != 1 || summary.FilesModified != 1 {
+		t.Errorf("Unexpected file changes: %+v", summary)
+	}
+	if summary.BytesAdded == 0 || summary.BytesRemoved == 0 {
+		t.Errorf("Expected data to be added and removed: %+v", summary)
+	}
+}
+
+// TestCloseIdempotent tests closing a repository more than once
+func TestCloseIdempotent(t *testing.T) {
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+
+	_, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}})
+	if !errors.Is(err, ErrRepositoryClosed) {
+		t.Errorf("Expected ErrRepositoryClosed, got %v", err)
+	}
+}
+
+// TestHealthChecks tests the background health checks
+func TestHealthChecks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("check me"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	results := make(chan HealthCheckResult, 1)
+	checker, err := repo.StartHealthChecks(ctx, HealthCheckOptions{
+		Interval:         10 * time.Millisecond,
+		RemoveStaleLocks: true,
+		ReadDataPacks:    1,
+		OnResult: func(result HealthCheckResult) {
+			select {
+			case results <- result:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartHealthChecks failed: %v", err)
+	}
+
+	select {
+	case result := <-results:
+		if !result.Success || result.PacksRead != 1 {
+			t.Errorf("Unexpected health check result: %+v", result)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("No health check result received")
+	}
+	checker.Stop()
+}
+
+// TestFindPaths tests finding paths using a persistent path index
+func TestFindPaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tempDir := t.TempDir()
+	dataDir := filepath.Join(tempDir, "data")
+	indexDir := filepath.Join(tempDir, "index")
+	if err := os.MkdirAll(filepath.Join(dataDir, "etc"), 0755); err != nil {
+		t.Fatalf("Failed to create data dir: %v", err)
+	}
+	for _, name := range []string{"etc/app.conf", "etc/other.txt", "readme.txt"} {
+		err := os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	cfg := Config{
+		RepoURL:      filepath.Join(tempDir, "repo"),
+		Backend:      BackendLocal,
+		Password:     []byte("testpassword"),
+		PathIndexDir: indexDir,
+	}
+	repo, err := Init(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	buf, err := os.ReadFile(filepath.Join(indexDir, string(snapshotID)+pathIndexSuffix))
+	if err != nil {
+		t.Fatalf("Path index was not written after backup: %v", err)
+	}
+	if bytes.Contains(buf, []byte("app.conf")) {
+		t.Error("Path index is not encrypted")
+	}
+	// plaintext indexes of older versions are removed
+	legacy := filepath.Join(indexDir, string(snapshotID)+legacyPathIndexSuffix)
+	if err := os.WriteFile(legacy, []byte("plaintext"), 0600); err != nil {
+		t.Fatalf("Failed to write legacy path index: %v", err)
+	}
+
+	// a reopened repository must answer from the persistent index
+	repo, err = Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+
+	matches, err := repo.FindPaths(ctx, "*.txt")
+	if err != nil {
+		t.Fatalf("FindPaths failed: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %+v", matches)
+	}
+	for _, m := range matches {
+		if m.Snapshot != snapshotID || m.Type != "file" {
+			t.Errorf("Unexpected match: %+v", m)
+		}
+	}
+
+	matches, err = repo.FindPaths(ctx, filepath.ToSlash(filepath.Join(dataDir, "etc", "app.conf")))
+	if err != nil {
+		t.Fatalf("FindPaths failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Size != uint64(len("etc/app.conf")) {
+		t.Errorf("Expected app.conf, got %+v", matches)
+	}
+
+	// forgetting the older of two snapshots drops its path index
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if _, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1}); err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	if err := repo.UpdatePathIndex(ctx); err != nil {
+		t.Fatalf("UpdatePathIndex failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(indexDir, string(snapshotID)+pathIndexSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Path index of removed snapshot was kept: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("Legacy path index was kept: %v", err)
+	}
+}
+
+// TestWebhookNotifier tests rendering and retrying webhook notifications
+func TestWebhookNotifier(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, req.URL.Path+" "+string(body))
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{
+		URLs:       []string{server.URL + "/ping{{if not .Success}}/fail{{end}}"},
+		Body:       "{{.Operation}}: {{.Error}}",
+		Operations: []string{"check"},
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+	}
+
+	ctx := context.Background()
+	err := notifier.Notify(ctx, Notification{Operation: "check", Error: "pack missing"})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if err := notifier.Notify(ctx, Notification{Operation: "backup", Success: true}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	expected := "/ping/fail check: pack missing"
+	if len(requests) != 2 || requests[0] != expected || requests[1] != expected {
+		t.Errorf("Unexpected requests: %q", requests)
+	}
+}
+
+// TestObjectLockUnsupported tests that object lock is rejected for backends without support
+func TestObjectLockUnsupported(t *testing.T) {
+	_, err := Init(context.Background(), Config{
+		RepoURL:  filepath.Join(t.TempDir(), "repo"),
+		Backend:  BackendLocal,
+		Password: []byte("testpassword"),
+		ObjectLock: &ObjectLockOptions{
+			Mode:      "GOVERNANCE",
+			Retention: time.Hour,
+		},
+	})
+	if err == nil {
+		t.Fatal("Expected object lock to be rejected for the local backend")
+	}
+}
+
+// TestWarmup tests that backends without cold storage report all packs as available
+func TestWarmup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("warm me up"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	report, err := repo.Warmup(ctx, snapshotID, true)
+	if err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+	if report.PacksTotal == 0 || report.PacksWarmingUp != 0 {
+		t.Errorf("Unexpected warmup report: %+v", report)
+	}
+}
+
+// TestExportImport tests that incremental exports transfer snapshots between repositories
+func TestExportImport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	src, dataDir := newTestRepository(t)
+	dst, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "first.txt"), bytes.Repeat([]byte("first"), 1000), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	first := backupSnapshot(t, ctx, src, BackupOptions{Paths: []string{dataDir}})
+	err = os.WriteFile(filepath.Join(dataDir, "second.txt"), bytes.Repeat([]byte("second"), 1000), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	second := backupSnapshot(t, ctx, src, BackupOptions{Paths: []string{dataDir}})
+
+	var full, incremental bytes.Buffer
+	if _, err := src.ExportSnapshot(ctx, first, &full, ExportOptions{}); err != nil {
+		t.Fatalf("ExportSnapshot failed: %v", err)
+	}
+	report, err := src.ExportSnapshot(ctx, second, &incremental, ExportOptions{Base: []SnapshotID{first}})
+	if err != nil {
+		t.Fatalf("ExportSnapshot failed: %v", err)
+	}
+	if report.BlobsSkipped == 0 {
+		t.Errorf("Expected blobs of the base snapshot to be skipped: %+v", report)
+	}
+
+	// the incremental export is useless without the base snapshot
+	if _, err := dst.ImportSnapshot(ctx, bytes.NewReader(incremental.Bytes())); err == nil {
+		t.Fatal("Expected import without base snapshot to fail")
+	}
+
+	if _, err := dst.ImportSnapshot(ctx, &full); err != nil {
+		t.Fatalf("ImportSnapshot failed: %v", err)
+	}
+	imported, err := dst.ImportSnapshot(ctx, &incremental)
+	if err != nil {
+		t.Fatalf("ImportSnapshot failed: %v", err)
+	}
+
+	check, err := dst.CheckSnapshot(ctx, imported.Snapshot, CheckDepthReadData)
+	if err != nil || !check.Success {
+		t.Fatalf("Imported snapshot is not intact: %v %+v", err, check)
+	}
+	snapshots, err := dst.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 2 {
+		t.Errorf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+}
+
+// TestReadExportRecord tests that oversized and truncated records are rejected
+// before their payload is allocated
+func TestReadExportRecord(t *testing.T) {
+	var buf bytes.Buffer
+	id := restic.NewRandomID()
+	if err := writeExportRecord(&buf, exportRecordData, id[:], []byte("data")); err != nil {
+		t.Fatalf("writeExportRecord failed: %v", err)
+	}
+	tpe, payload, err := readExportRecord(&buf)
+	if err != nil || tpe != exportRecordData || string(payload[len(id):]) != "data" {
+		t.Errorf("Unexpected record %q %q: %v", tpe, payload, err)
+	}
+
+	if err := writeExportRecord(&buf, exportRecordData, nil, make([]byte, maxExportRecordSize+1)); err == nil {
+		t.Error("Expected oversized record to be rejected by the writer")
+	}
+
+	for _, invalid := range [][]byte{
+		{exportRecordData, 0xff, 0xff, 0xff, 0xff},
+		{exportRecordData, 0, 0, 0, 40, 1, 2, 3},
+		{exportRecordData, 0, 0, 0, 4, 1, 2, 3, 4},
+	} {
+		if _, _, err := readExportRecord(bytes.NewReader(invalid)); err == nil {
+			t.Errorf("Expected record %v to be rejected", invalid)
+		}
+	}
+}
+
+// TestSyncTo tests that missing snapshots are copied and deletions are mirrored
+func TestSyncTo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	src, dataDir := newTestRepository(t)
+	dst, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	var ids []SnapshotID
+	for _, name := range []string{"first.txt", "second.txt"} {
+		err := os.WriteFile(filepath.Join(dataDir, name), bytes.Repeat([]byte(name), 1000), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		id := backupSnapshot(t, ctx, src, BackupOptions{Paths: []string{dataDir}})
+		ids = append(ids, id)
+	}
+
+	// the destination is locked exclusively, except for dry runs
+	other, _, err := repository.Lock(ctx, dst.(*repositoryImpl).repo, false, 0, func(string) {}, t.Logf)
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if _, err := src.SyncTo(ctx, dst, SyncOptions{}); err == nil {
+		t.Error("Expected sync to fail while the destination is locked")
+	}
+	if report, err := src.SyncTo(ctx, dst, SyncOptions{DryRun: true}); err != nil || len(report.Copied) != 2 {
+		t.Errorf("Expected dry run to succeed: %v %+v", err, report)
+	}
+	other.Unlock()
+
+	report, err := src.SyncTo(ctx, dst, SyncOptions{})
+	if err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if len(report.Copied) != 2 || report.BlobsCopied == 0 {
+		t.Errorf("Unexpected sync report: %+v", report)
+	}
+
+	// a second sync has nothing to do
+	report, err = src.SyncTo(ctx, dst, SyncOptions{})
+	if err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if len(report.Copied) != 0 || report.BlobsCopied != 0 {
+		t.Errorf("Expected nothing to be copied: %+v", report)
+	}
+
+	_, err = src.Forget(ctx, ForgetPolicy{KeepLast: 1})
+	if err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	report, err = src.SyncTo(ctx, dst, SyncOptions{MirrorDeletions: true})
+	if err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if len(report.Removed) != 1 {
+		t.Errorf("Expected 1 removed snapshot: %+v", report)
+	}
+
+	snapshots, err := dst.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot in destination, got %d", len(snapshots))
+	}
+	check, err := dst.CheckSnapshot(ctx, snapshots[0].ID, CheckDepthReadData)
+	if err != nil || !check.Success {
+		t.Errorf("Synced snapshot is not intact: %v %+v", err, check)
+	}
+}
+
+// TestVerifyRestore tests that differences between a restore target and the snapshot are reported
+func TestVerifyRestore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		err := os.WriteFile(filepath.Join(dataDir, name), bytes.Repeat([]byte(name), 1000), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	target := t.TempDir()
+	if _, err := repo.Restore(ctx, snapshotID, RestoreOptions{TargetDir: target}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	report, err := repo.VerifyRestore(ctx, snapshotID, target)
+	if err != nil {
+		t.Fatalf("VerifyRestore failed: %v", err)
+	}
+	if !report.Match || report.FilesChecked != 2 {
+		t.Fatalf("Expected restored files to match: %+v", report)
+	}
+
+	restored := filepath.Join(target, dataDir)
+	err = os.WriteFile(filepath.Join(restored, "a.txt"), bytes.Repeat([]byte("A.TXT"), 1000), 0644)
+	if err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(restored, "b.txt")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(restored, "c.txt"), []byte("extra"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	report, err = repo.VerifyRestore(ctx, snapshotID, target)
+	if err != nil {
+		t.Fatalf("VerifyRestore failed: %v", err)
+	}
+	kinds := make(map[string]string)
+	for _, d := range report.Differences {
+		kinds[filepath.Base(d.Path)+":"+d.Kind] = d.Detail
+	}
+	for _, want := range []string{"a.txt:" + DifferenceContent, "b.txt:" + DifferenceMissing, "c.txt:" + DifferenceExtra} {
+		if _, ok := kinds[want]; !ok {
+			t.Errorf("Expected difference %s, got %+v", want, report.Differences)
+		}
+	}
+	if report.Match {
+		t.Error("Expected target not to match")
+	}
+}
+
+// TestDumpFile tests reading a single file of a snapshot without restoring it
+func TestDumpFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("dump me "), 100000)
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), content, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	var buf bytes.Buffer
+	err := repo.DumpFile(ctx, snapshotID, filepath.ToSlash(filepath.Join(dataDir, "file.txt")), &buf)
+	if err != nil {
+		t.Fatalf("DumpFile failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Expected %d bytes of file content, got %d", len(content), buf.Len())
+	}
+
+	if err := repo.DumpFile(ctx, snapshotID, filepath.ToSlash(dataDir), io.Discard); err == nil {
+		t.Error("Expected dumping a directory to fail")
+	}
+	if err := repo.DumpFile(ctx, snapshotID, "/does/not/exist", io.Discard); err == nil {
+		t.Error("Expected dumping a missing file to fail")
+	}
+}
+
+// TestStats tests the counting modes of Stats
+func TestStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	content := []byte("duplicate content")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	var snapshotID SnapshotID
+	for i := 0; i < 2; i++ {
+		id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+		snapshotID = id
+	}
+
+	restoreSize, err := repo.Stats(ctx, StatsOptions{})
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if restoreSize.Mode != StatsModeRestoreSize || restoreSize.SnapshotsCount != 2 {
+		t.Errorf("Unexpected report: %+v", restoreSize)
+	}
+	if restoreSize.TotalSize != 4*uint64(len(content)) {
+		t.Errorf("Expected restore size %d, got %d", 4*len(content), restoreSize.TotalSize)
+	}
+
+	byContents, err := repo.Stats(ctx, StatsOptions{Mode: StatsModeFilesByContents})
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if byContents.TotalSize != uint64(len(content)) {
+		t.Errorf("Expected unique file size %d, got %d", len(content), byContents.TotalSize)
+	}
+
+	rawData, err := repo.Stats(ctx, StatsOptions{Mode: StatsModeRawData, Snapshots: []SnapshotID{snapshotID}})
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if rawData.SnapshotsCount != 1 || rawData.TotalBlobCount == 0 || rawData.TotalSize == 0 {
+		t.Errorf("Unexpected raw data report: %+v", rawData)
+	}
+
+	if _, err := repo.Stats(ctx, StatsOptions{Mode: "bogus"}); err == nil {
+		t.Error("Expected unknown mode to fail")
+	}
+}
+
+// TestFind tests searching snapshots by pattern and by blob ID
+func TestFind(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"photo.JPG", "notes.txt", "sub/image.png"} {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	root := filepath.ToSlash(dataDir)
+	matches, err := repo.Find(ctx, FindOptions{Patterns: []string{"*.jpg", "*.png"}, IgnoreCase: true})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	var paths []string
+	for _, m := range matches {
+		if m.Snapshot != snapshotID {
+			t.Errorf("Expected match in snapshot %s, got %s", snapshotID, m.Snapshot)
+		}
+		paths = append(paths, m.Path)
+	}
+	expected := []string{root + "/photo.JPG", root + "/sub/image.png"}
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected matches %v, got %v", expected, paths)
+	}
+
+	future := time.Now().Add(time.Hour)
+	matches, err = repo.Find(ctx, FindOptions{Patterns: []string{"*.txt"}, Oldest: &future})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Expected no matches modified in the future, got %v", matches)
+	}
+
+	// look up the blob of notes.txt and find the file by its ID
+	impl := repo.(*repositoryImpl)
+	sn, _, err := data.FindSnapshot(ctx, impl.repo, impl.repo, string(snapshotID))
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	var blob restic.ID
+	err = walker.Walk(ctx, impl.repo, *sn.Tree, walker.WalkVisitor{
+		ProcessNode: func(_ restic.ID, nodepath string, node *data.Node, err error) error {
+			if err == nil && node != nil && node.Name == "notes.txt" {
+				blob = node.Content[0]
+			}
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk snapshot: %v", err)
+	}
+	matches, err = repo.Find(ctx, FindOptions{BlobIDs: []string{blob.String()[:8]}})
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Path != root+"/notes.txt" || matches[0].BlobID != blob.String() {
+		t.Errorf("Expected notes.txt to be found by blob ID, got %+v", matches)
+	}
+
+	if _, err := repo.Find(ctx, FindOptions{Patterns: []string{"x"}, TreeIDs: []string{"abc"}}); err == nil {
+		t.Error("Expected combining patterns and IDs to fail")
+	}
+}
+
+// TestLs tests streaming the entries of a snapshot
+func TestLs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "skip/b.txt", "sub/c.txt"} {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	root := filepath.ToSlash(dataDir)
+	entries := make(map[string]LsEntry)
+	err := repo.Ls(ctx, snapshotID, func(entry LsEntry) error {
+		entries[entry.Path] = entry
+		if entry.Path == root+"/skip" {
+			return SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Ls failed: %v", err)
+	}
+
+	file, ok := entries[root+"/sub/c.txt"]
+	if !ok {
+		t.Fatalf("Expected sub/c.txt to be listed, got %v", entries)
+	}
+	if file.Type != "file" || file.Size != uint64(len("sub/c.txt")) || file.ModTime.IsZero() {
+		t.Errorf("Unexpected entry for sub/c.txt: %+v", file)
+	}
+	if entries[root+"/sub"].Type != "dir" {
+		t.Errorf("Expected sub to be a directory, got %+v", entries[root+"/sub"])
+	}
+	if _, ok := entries[root+"/skip"]; !ok {
+		t.Error("Expected skipped directory to be listed")
+	}
+	if _, ok := entries[root+"/skip/b.txt"]; ok {
+		t.Error("Expected contents of skipped directory not to be listed")
+	}
+
+	stop := errors.New("stop")
+	if err := repo.Ls(ctx, snapshotID, func(LsEntry) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("Expected callback error to abort Ls, got %v", err)
+	}
+}
+
+// TestMountInvalidMountpoint tests that Mount checks the mountpoint before
+// mounting anything
+func TestMountInvalidMountpoint(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	err := repo.Mount(ctx, filepath.Join(t.TempDir(), "missing"), MountOptions{})
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected missing mountpoint to fail, got %v", err)
+	}
+	err = repo.Mount(ctx, t.TempDir(), MountOptions{TimeTemplate: "/2006"})
+	if err == nil {
+		t.Error("Expected invalid time template to fail")
+	}
+}
+
+// TestMountSnapshotFilter tests that the snapshot filter of Mount parses the
+// comma separated tag lists
+func TestMountSnapshotFilter(t *testing.T) {
+	filter, err := MountOptions{
+		Hosts: []string{"host"},
+		Tags:  []string{"a,b", "c"},
+		Paths: []string{"/data"},
+	}.snapshotFilter()
+	if err != nil {
+		t.Fatalf("snapshotFilter failed: %v", err)
+	}
+	want := data.TagLists{{"a", "b"}, {"c"}}
+	if fmt.Sprint(filter.Tags) != fmt.Sprint(want) {
+		t.Errorf("Tags = %v, want %v", filter.Tags, want)
+	}
+	if !slices.Equal(filter.Hosts, []string{"host"}) || !slices.Equal(filter.Paths, []string{"/data"}) {
+		t.Errorf("Unexpected filter %+v", filter)
+	}
+}
+
+// TestMount tests that a mounted snapshot can be read and that cancelling the
+// context unmounts it and releases the lock
+func TestMount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("Mount test requires linux")
+	}
+	if _, err := exec.LookPath("fusermount3"); err != nil {
+		if _, err := exec.LookPath("fusermount"); err != nil {
+			t.Skip("fusermount not available")
+		}
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("mounted content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	mountpoint := t.TempDir()
+	mountCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mountErr := make(chan error, 1)
+	go func() {
+		mountErr <- repo.Mount(mountCtx, mountpoint, MountOptions{})
+	}()
+
+	// wait for the snapshot to show up
+	var entries []os.DirEntry
+	for deadline := time.Now().Add(10 * time.Second); len(entries) == 0; {
+		select {
+		case err := <-mountErr:
+			t.Skipf("FUSE not available: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the mount")
+		}
+		entries, _ = os.ReadDir(filepath.Join(mountpoint, "snapshots"))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	content, err := os.ReadFile(filepath.Join(mountpoint, "snapshots", "latest", dataDir, "file.txt"))
+	if err != nil || string(content) != "mounted content" {
+		t.Errorf("Failed to read mounted file: %q %v", content, err)
+	}
+
+	cancel()
+	if err := <-mountErr; err != nil {
+		t.Errorf("Mount failed: %v", err)
+	}
+	if _, err := repo.Prune(ctx, PruneOptions{DryRun: true}); err != nil {
+		t.Errorf("Expected the lock to be released after unmounting: %v", err)
+	}
+}
+
+// TestDumpArchive tests dumping directories and files as tar and zip archives
+func TestDumpArchive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.MkdirAll(filepath.Join(dataDir, "sub"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(dataDir, filepath.FromSlash(name)), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	root := filepath.ToSlash(dataDir)
+	var buf bytes.Buffer
+	if err := repo.DumpArchive(ctx, snapshotID, root+"/sub", ArchiveTar, &buf); err != nil {
+		t.Fatalf("DumpArchive failed: %v", err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar archive: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	expected := strings.TrimPrefix(root, "/") + "/sub/b.txt"
+	if len(names) != 2 || names[1] != expected {
+		t.Errorf("Expected tar archive with sub and %s, got %v", expected, names)
+	}
+
+	buf.Reset()
+	if err := repo.DumpArchive(ctx, snapshotID, root+"/a.txt", ArchiveZip, &buf); err != nil {
+		t.Fatalf("DumpArchive failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read zip archive: %v", err)
+	}
+	if len(zr.File) != 1 || !strings.HasSuffix(zr.File[0].Name, "/a.txt") {
+		t.Errorf("Expected zip archive with a.txt, got %v", zr.File)
+	}
+
+	if err := repo.DumpArchive(ctx, snapshotID, root, "rar", io.Discard); err == nil {
+		t.Error("Expected unknown archive format to fail")
+	}
+}
+
+// TestBackupPathStats tests that statistics are reported for each backup path
+func TestBackupPathStats(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	small := filepath.Join(dataDir, "small")
+	large := filepath.Join(dataDir, "large")
+	for i, dir := range []string{small, large} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		for j := 0; j <= i; j++ {
+			name := filepath.Join(dir, fmt.Sprintf("file%d.txt", j))
+			if err := os.WriteFile(name, bytes.Repeat([]byte(name), 100*(i+1)), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+		}
+	}
+
+	summary, err := repo.Backup(ctx, BackupOptions{Paths: []string{small, large}})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if summary.SnapshotID == "" || len(summary.Paths) != 2 {
+		t.Fatalf("Unexpected summary: %+v", summary)
+	}
+	for i, stats := range summary.Paths {
+		if stats.Files != uint(i+1) || stats.Bytes == 0 || stats.DataAdded == 0 {
+			t.Errorf("Unexpected stats for %s: %+v", stats.Path, stats)
+		}
+	}
+
+	// nothing is added by a second backup
+	summary, err = repo.Backup(ctx, BackupOptions{Paths: []string{small, large}})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	for _, stats := range summary.Paths {
+		if stats.DataAdded != 0 {
+			t.Errorf("Expected no new data for %s: %+v", stats.Path, stats)
+		}
+	}
+}
+
+// TestBackupDryRun tests that dry runs report the data to be added without
+// writing anything
+func TestBackupDryRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), bytes.Repeat([]byte("preview"), 1000), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		summary, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, DryRun: true})
+		if err != nil {
+			t.Fatalf("Dry run failed: %v", err)
+		}
+		if !summary.DryRun || summary.SnapshotID != "" {
+			t.Errorf("Unexpected dry run summary: %+v", summary)
+		}
+		if len(summary.Paths) != 1 || summary.Paths[0].Files != 1 || summary.Paths[0].DataAdded == 0 {
+			t.Errorf("Expected dry run %d to report new data: %+v", i, summary.Paths)
+		}
+		if c := summary.Changes; c == nil || c.FilesNew != 1 || len(c.Files) != 1 || c.DataAdded == 0 || c.DataAddedPacked == 0 {
+			t.Errorf("Expected dry run %d to list the new file: %+v", i, c)
+		}
+	}
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots after dry runs, got %d", len(snapshots))
+	}
+
+	report, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.Success {
+		t.Errorf("Expected repository to be intact: %+v", report)
+	}
+
+	// preview the changes relative to a parent snapshot
+	parentID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+	if err := os.WriteFile(filepath.Join(dataDir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	summary, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, ParentID: &parentID, DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	c := summary.Changes
+	if c == nil || c.FilesNew != 1 || c.FilesChanged != 0 || c.FilesUnmodified != 1 || len(c.Files) != 1 {
+		t.Fatalf("Unexpected changes: %+v", c)
+	}
+	if c.Files[0].Path != filepath.ToSlash(filepath.Join(dataDir, "new.txt")) || c.Files[0].Status != BackupItemNew {
+		t.Errorf("Unexpected changed file: %+v", c.Files[0])
+	}
+}
+
+// TestBackupChangesLimit tests that the files listed by a dry run are capped
+func TestBackupChangesLimit(t *testing.T) {
+	var c BackupChanges
+	for i := 0; i < MaxBackupChangesFiles+5; i++ {
+		c.add(BackupItem{Path: fmt.Sprintf("/%d", i), Type: "file", Status: BackupItemNew})
+	}
+	c.add(BackupItem{Path: "/same", Type: "file", Status: BackupItemUnmodified})
+
+	if len(c.Files) != MaxBackupChangesFiles || c.FilesOmitted != 5 || c.FilesNew != MaxBackupChangesFiles+5 || c.FilesUnmodified != 1 {
+		t.Errorf("Unexpected changes: %d files, %d omitted, %d new, %d unmodified", len(c.Files), c.FilesOmitted, c.FilesNew, c.FilesUnmodified)
+	}
+}
+
+// throughputRecorder records the throughput samples of a backup
+type throughputRecorder struct {
+	samples []ThroughputSample
+}
+
+func (p *throughputRecorder) SetTotal(uint64)                 {}
+func (p *throughputRecorder) Add(uint64)                      {}
+func (p *throughputRecorder) Error(_ string, err error) error { return err }
+func (p *throughputRecorder) Finish()                         {}
+func (p *throughputRecorder) Throughput(sample ThroughputSample) {
+	p.samples = append(p.samples, sample)
+}
+
+// TestBackupThroughput tests that throughput samples are sent to reporters implementing ThroughputReporter
+func TestBackupThroughput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), bytes.Repeat([]byte("throughput"), 10000), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	for i, wantDedup := range []float64{0, 1} {
+		recorder := &throughputRecorder{}
+		_, err := repo.Backup(ctx, BackupOptions{
+			Paths:              []string{dataDir},
+			Progress:           recorder,
+			ThroughputInterval: 10 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		if len(recorder.samples) == 0 {
+			t.Fatalf("Backup %d: no throughput samples received", i)
+		}
+
+		final := recorder.samples[len(recorder.samples)-1]
+		if final.BytesRead != 100000 || final.DedupRatio != wantDedup {
+			t.Errorf("Backup %d: unexpected final sample: %+v", i, final)
+		}
+	}
+}
+
+// TestChannelProgress tests that backup progress is delivered as events
+func TestChannelProgress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), bytes.Repeat([]byte("events"), 1000), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	events := make(chan ProgressEvent)
+	kinds := make(chan map[ProgressEventKind]int)
+	go func() {
+		seen := make(map[ProgressEventKind]int)
+		for ev := range events {
+			seen[ev.Kind]++
+		}
+		kinds <- seen
+	}()
+
+	_, err = repo.Backup(ctx, BackupOptions{
+		Paths:    []string{dataDir},
+		Progress: NewChannelProgress(events),
+	})
+	close(events)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	seen := <-kinds
+	for _, kind := range []ProgressEventKind{ProgressEventAdd, ProgressEventThroughput, ProgressEventFinish} {
+		if seen[kind] == 0 {
+			t.Errorf("No %s event received: %v", kind, seen)
+		}
+	}
+}
+
+// TestBackupScan tests that the scanner reports the total size of the backup
+func TestBackupScan(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	var size uint64
+	for i, name := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		content := bytes.Repeat([]byte("scan"), 1000*(i+1))
+		size += uint64(len(content))
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	for _, noScan := range []bool{false, true} {
+		events := make(chan ProgressEvent, 16)
+		done := make(chan []ProgressEvent)
+		go func() {
+			var received []ProgressEvent
+			for ev := range events {
+				received = append(received, ev)
+			}
+			done <- received
+		}()
+
+		_, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, Progress: NewChannelProgress(events), NoScan: noScan})
+		close(events)
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+
+		var total, added uint64
+		var scan BackupScan
+		for _, ev := range <-done {
+			switch ev.Kind {
+			case ProgressEventTotal:
+				total = ev.Total
+			case ProgressEventAdd:
+				added += ev.Delta
+			case ProgressEventScan:
+				scan = ev.Scan
+			}
+		}
+		if added != size {
+			t.Errorf("Expected %d bytes to be added, got %d", size, added)
+		}
+		if noScan {
+			if total != 0 || scan.Files != 0 {
+				t.Errorf("Expected no scan results, got total %d and %+v", total, scan)
+			}
+			continue
+		}
+		if total != size || !scan.Complete || scan.Files != 3 || scan.Bytes != size {
+			t.Errorf("Unexpected scan results: total %d, %+v", total, scan)
+		}
+	}
+}
+
+// TestBackupCPULimit tests that reading is paced by the CPU limit
+func TestBackupCPULimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.bin"), bytes.Repeat([]byte{1, 2, 3, 4}, 256*1024), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// 1 MiB at 512 KiB/s takes about a second, the first 512 KiB are allowed as burst
+	start := time.Now()
+	_, err = repo.Backup(ctx, BackupOptions{
+		Paths: []string{dataDir},
+		CPU:   CPULimit{MaxProcs: 1, ReadRateKiB: 512},
+	})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Expected reading to be paced, backup took %v", elapsed)
+	}
+}
+
+// TestBackupMetadataOnly tests that metadata-only backups store empty placeholders
+// which record the original size, and that restores report them
+func TestBackupMetadataOnly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	err := os.WriteFile(filepath.Join(dataDir, "file.txt"), bytes.Repeat([]byte("content"), 1000), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	summary, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, MetadataOnly: true})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if summary.Paths[0].Files != 1 || summary.Paths[0].DataAdded > 1000 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	check, err := repo.CheckSnapshot(ctx, summary.SnapshotID, CheckDepthReadData)
+	if err != nil || !check.Success {
+		t.Fatalf("Snapshot is not intact: %v %+v", err, check)
+	}
+
+	matches, err := repo.FindPaths(ctx, "file.txt")
+	if err != nil {
+		t.Fatalf("FindPaths failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Size != 0 || matches[0].Type != "file" {
+		t.Errorf("Expected an empty placeholder, got %+v", matches)
+	}
+
+	impl := repo.(*repositoryImpl)
+	sn, _, err := impl.findSnapshot(ctx, string(summary.SnapshotID), SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if !sn.HasTags([]string{metadataOnlyTag}) {
+		t.Errorf("Expected the snapshot to be tagged %q, got %v", metadataOnlyTag, sn.Tags)
+	}
+	node, err := impl.findNode(ctx, sn, filepath.ToSlash(filepath.Join(dataDir, "file.txt")))
+	if err != nil {
+		t.Fatalf("Failed to find placeholder: %v", err)
+	}
+	if size, ok := metadataOnlySize(node); !ok || size != 7000 {
+		t.Errorf("Expected the placeholder to record the original size 7000, got %v %v", size, ok)
+	}
+
+	target := t.TempDir()
+	report, err := repo.Restore(ctx, summary.SnapshotID, RestoreOptions{TargetDir: target})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(report.Notices) != 1 || !strings.HasSuffix(report.Notices[0].Path, "/file.txt") || !strings.Contains(report.Notices[0].Message, "7000 bytes") {
+		t.Errorf("Expected a notice for the placeholder, got %+v", report.Notices)
+	}
+	fi, err := os.Stat(filepath.Join(target, dataDir, "file.txt"))
+	if err != nil {
+		t.Fatalf("Placeholder not restored: %v", err)
+	}
+	if fi.Size() != 0 {
+		t.Errorf("Expected empty placeholder, got %d bytes", fi.Size())
+	}
+}
+
+// TestBackupChangeDetection tests the inode, ctime and force options
+func TestBackupChangeDetection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	file := filepath.Join(dataDir, "a.txt")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	var status string
+	opts := BackupOptions{
+		Paths: []string{dataDir},
+		OnItem: func(item BackupItem) {
+			if item.Path == file {
+				status = item.Status
+			}
+		},
+	}
+	parentID := backupSnapshot(t, ctx, repo, opts)
+
+	// replace the file by a copy with the same content and mtime, but a new inode
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	tmp := filepath.Join(filepath.Dir(dataDir), "copy.txt")
+	if err := os.WriteFile(tmp, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		modify func(opts *BackupOptions)
+		status string
+	}{
+		{"default", func(*BackupOptions) {}, BackupItemChanged},
+		{"ignore inode", func(opts *BackupOptions) { opts.IgnoreInode = true }, BackupItemUnmodified},
+		{"force", func(opts *BackupOptions) { opts.IgnoreInode, opts.Force = true, true }, BackupItemNew},
+	} {
+		opts := opts
+		opts.ParentID = &parentID
+		test.modify(&opts)
+		status = ""
+		if _, err := repo.Backup(ctx, opts); err != nil {
+			t.Fatalf("%s: backup failed: %v", test.name, err)
+		}
+		if status != test.status {
+			t.Errorf("%s: expected status %q, got %q", test.name, test.status, status)
+		}
+	}
+}
+
+// TestBackupParentGroupBy tests that the parent snapshot is selected from the group of the backup
+func TestBackupParentGroupBy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	var dirs []string
+	for _, name := range []string{"a", "b"} {
+		dir := filepath.Join(dataDir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dir}}); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	file := filepath.Join(dirs[0], "file.txt")
+	for _, test := range []struct {
+		groupBy *SnapshotGroupBy
+		status  string
+	}{
+		{nil, BackupItemNew},
+		{&SnapshotGroupBy{}, BackupItemNew},
+		{&SnapshotGroupBy{Host: true, Paths: true}, BackupItemUnmodified},
+	} {
+		var status string
+		_, err := repo.Backup(ctx, BackupOptions{
+			Paths:         dirs[:1],
+			ParentGroupBy: test.groupBy,
+			DryRun:        true,
+			OnItem: func(item BackupItem) {
+				if item.Path == file {
+					status = item.Status
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		if status != test.status {
+			t.Errorf("group by %+v: expected status %q, got %q", test.groupBy, test.status, status)
+		}
+	}
+}
+
+// TestResolveSnapshot tests resolving "latest" and snapshot ID prefixes
+func TestResolveSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}, Tags: []string{"first"}, Time: snapshotTime})
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("second"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	second := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}, Time: snapshotTime.Add(time.Hour)})
+
+	for _, test := range []struct {
+		ref    string
+		filter SnapshotFilter
+		want   SnapshotID
+	}{
+		{"latest", SnapshotFilter{}, second},
+		{"latest", SnapshotFilter{Tags: []string{"first"}}, first},
+		{string(first[:8]), SnapshotFilter{}, first},
+		{string(second), SnapshotFilter{Tags: []string{"first"}}, second},
+		{"latest:" + dataDir, SnapshotFilter{}, second + SnapshotID(":"+dataDir)},
+	} {
+		id, err := repo.ResolveSnapshot(ctx, test.ref, test.filter)
+		if err != nil {
+			t.Fatalf("ResolveSnapshot(%q) failed: %v", test.ref, err)
+		}
+		if id != test.want {
+			t.Errorf("ResolveSnapshot(%q, %+v): expected %s, got %s", test.ref, test.filter, test.want, id)
+		}
+	}
+
+	for _, ref := range []string{"latest", "zzzz"} {
+		_, err := repo.ResolveSnapshot(ctx, ref, SnapshotFilter{Hosts: []string{"unknown"}})
+		if !errors.Is(err, ErrSnapshotNotFound) {
+			t.Errorf("Expected ErrSnapshotNotFound for %q, got %v", ref, err)
+		}
+	}
+
+	// snapshot-consuming methods accept "latest" as well
+	var buf bytes.Buffer
+	if err := repo.DumpFile(ctx, "latest", dataDir+"/file.txt", &buf); err != nil {
+		t.Fatalf("DumpFile failed: %v", err)
+	}
+	if buf.String() != "second" {
+		t.Errorf("Expected the content of the latest snapshot, got %q", buf.String())
+	}
+}
+
+// TestBackupMetadataOverrides tests the hostname, username and time overrides
+func TestBackupMetadataOverrides(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	snapshotTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	id := backupSnapshot(t, ctx, repo, BackupOptions{
+		Paths:    []string{dataDir},
+		Hostname: "other-host",
+		Username: "other-user",
+		Time:     snapshotTime,
+	})
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != id {
+		t.Fatalf("Unexpected snapshots: %+v", snapshots)
+	}
+	sn := snapshots[0]
+	if sn.Hostname != "other-host" || sn.Username != "other-user" || !sn.Time.Equal(snapshotTime) {
+		t.Errorf("Metadata not overridden: %+v", sn)
+	}
+
+	// parents are only selected from snapshots taken before the backup
+	var status string
+	_, err = repo.Backup(ctx, BackupOptions{
+		Paths:         []string{dataDir},
+		Hostname:      "other-host",
+		Time:          snapshotTime.Add(-time.Hour),
+		ParentGroupBy: &SnapshotGroupBy{Host: true},
+		DryRun:        true,
+		OnItem: func(item BackupItem) {
+			if item.Type == "file" {
+				status = item.Status
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if status != BackupItemNew {
+		t.Errorf("Expected no parent for an earlier snapshot, got status %q", status)
+	}
+}
+
+// TestBackupSource tests backing up an io/fs file system
+func TestBackupSource(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	source := fstest.MapFS{
+		"docs/a.txt":     {Data: []byte("file a"), Mode: 0644, ModTime: modTime},
+		"docs/sub/b.txt": {Data: []byte("file b"), Mode: 0600, ModTime: modTime},
+		"other/c.txt":    {Data: []byte("file c"), Mode: 0644, ModTime: modTime},
+	}
+	summary, err := repo.Backup(ctx, BackupOptions{Paths: []string{"docs"}, Source: source})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if len(summary.Paths) != 1 || summary.Paths[0].Path != "/docs" || summary.Paths[0].Files != 2 {
+		t.Errorf("Unexpected summary: %+v", summary.Paths)
+	}
+
+	target := t.TempDir()
+	if _, err := repo.Restore(ctx, summary.SnapshotID, RestoreOptions{TargetDir: target}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	for name, file := range source {
+		content, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if strings.HasPrefix(name, "other/") {
+			if err == nil {
+				t.Errorf("Expected %s not to be backed up", name)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(content, file.Data) {
+			t.Errorf("Unexpected content of %s: %q, %v", name, content, err)
+		}
+	}
+
+	// symlinks are skipped, a cycle must not recurse forever
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Symlink(".", filepath.Join(dir, "loop")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	summary, err = repo.Backup(ctx, BackupOptions{Paths: []string{"."}, Source: os.DirFS(dir)})
+	if err != nil {
+		t.Fatalf("Backup with symlink cycle failed: %v", err)
+	}
+	var names []string
+	err = repo.Ls(ctx, summary.SnapshotID, func(entry LsEntry) error {
+		names = append(names, entry.Path)
+		if entry.UID != 0 || entry.GID != 0 {
+			t.Errorf("Expected %s to be owned by root, got %d:%d", entry.Path, entry.UID, entry.GID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Ls failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"/file.txt"}) {
+		t.Errorf("Expected only the file to be backed up, got %v", names)
+	}
+}
+
+// TestBackupSummary tests that the summary matches the statistics stored in the snapshot
+func TestBackupSummary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), bytes.Repeat([]byte(name), 100), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	summary, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if summary.FilesNew != 2 || summary.DirsNew == 0 || summary.TotalFilesProcessed != 2 || summary.TotalBytesProcessed != 1000 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if summary.DataAdded == 0 || summary.DataAddedPacked == 0 || summary.BackupEnd.Before(summary.BackupStart) {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	if err := os.WriteFile(filepath.Join(dataDir, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	summary, err = repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, ParentID: &summary.SnapshotID})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if summary.FilesNew != 0 || summary.FilesChanged != 1 || summary.FilesUnmodified != 1 || summary.DirsChanged == 0 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	id, err := restic.ParseID(string(summary.SnapshotID))
+	if err != nil {
+		t.Fatalf("Invalid snapshot ID: %v", err)
+	}
+	sn, err := data.LoadSnapshot(ctx, repo.(*repositoryImpl).repo, id)
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	stored := sn.Summary
+	if stored.DataAdded != summary.DataAdded || stored.TotalBytesProcessed != summary.TotalBytesProcessed ||
+		stored.DirsChanged != summary.DirsChanged || !stored.BackupEnd.Equal(summary.BackupEnd) {
+		t.Errorf("Summary %+v does not match snapshot summary %+v", summary, stored)
+	}
+}
+
+// TestParsePatterns tests the parsing of pattern files
+func TestParsePatterns(t *testing.T) {
+	t.Setenv("PATTERN_DIR", "/home/user")
+	input := "# comment\n\n  *.tmp  \n$PATTERN_DIR/.cache\n/price$$\n\t# indented comment\n"
+	patterns, err := ParsePatterns(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParsePatterns failed: %v", err)
+	}
+	expected := []string{"*.tmp", "/home/user/.cache", "/price$"}
+	if !slices.Equal(patterns, expected) {
+		t.Errorf("Expected %q, got %q", expected, patterns)
+	}
+
+	if _, err := ReadPatternFiles(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error for a missing pattern file")
+	}
+}
+
+// TestBackupExcludeCaches tests that directories tagged with CACHEDIR.TAG are skipped
+func TestBackupExcludeCaches(t *testing.T) {
+	testBackupExcludes(t, BackupOptions{ExcludeCaches: true}, map[string]string{
+		"keep/file.txt":      "keep",
+		"cache/file.txt":     "cached",
+		"cache/CACHEDIR.TAG": "Signature: 8a477f597d28d172789f06886806bc55\n",
+		"fake/file.txt":      "not a cache",
+		"fake/CACHEDIR.TAG":  "no signature",
+	}, "cache/file.txt")
+}
+
+// TestBackupExcludeIfPresent tests that directories containing a marker file are skipped
+func TestBackupExcludeIfPresent(t *testing.T) {
+	testBackupExcludes(t, BackupOptions{ExcludeIfPresent: []string{".nobackup", "marker:skip"}}, map[string]string{
+		"keep/file.txt":  "keep",
+		"a/file.txt":     "excluded",
+		"a/.nobackup":    "",
+		"b/file.txt":     "excluded",
+		"b/marker":       "skip me",
+		"c/file.txt":     "wrong header",
+		"c/marker":       "keep me",
+		"a/sub/file.txt": "excluded",
+	}, "a/file.txt", "a/sub/file.txt", "b/file.txt")
+
+	if testing.Short() {
+		return
+	}
+	repo, dataDir := newTestRepository(t)
+	_, err := repo.Backup(context.Background(), BackupOptions{Paths: []string{dataDir}, ExcludeIfPresent: []string{":header"}})
+	if err == nil {
+		t.Error("Expected an error for an exclude-if-present spec without file name")
+	}
+}
+
+// TestBackupOneFileSystem tests that files on the same file system are backed up
+func TestBackupOneFileSystem(t *testing.T) {
+	testBackupExcludes(t, BackupOptions{OneFileSystem: true}, map[string]string{
+		"file.txt":     "content",
+		"sub/file.txt": "content",
+	})
+}
+
+// TestBackupExcludeFiles tests that patterns are read from exclude files
+func TestBackupExcludeFiles(t *testing.T) {
+	excludeFile := filepath.Join(t.TempDir(), "excludes")
+	if err := os.WriteFile(excludeFile, []byte("# logs\n*.log\n\n  sub/skip  \n"), 0644); err != nil {
+		t.Fatalf("Failed to write exclude file: %v", err)
+	}
+	testBackupExcludes(t, BackupOptions{ExcludeFiles: []string{excludeFile}}, map[string]string{
+		"keep.txt":          "keep",
+		"app.log":           "excluded",
+		"dir/app.log":       "excluded",
+		"sub/skip/file.txt": "excluded",
+		"sub/keep/file.txt": "keep",
+	}, "app.log", "dir/app.log", "sub/skip/file.txt")
+}
+
+// TestBackupPatterns tests that Excludes and Includes use the pattern syntax
+// of the CLI like the patterns of exclude files
+func TestBackupPatterns(t *testing.T) {
+	files := map[string]string{
+		"a.txt":          "a",
+		"b.log":          "b",
+		"sub/c.txt":      "c",
+		"sub/tmp/d.txt":  "d",
+		"other/e.log":    "e",
+		"other/tmp/f.md": "f",
+	}
+	testBackupExcludes(t, BackupOptions{Excludes: []string{"*.log", "*/sub/tmp"}}, files,
+		"b.log", "other/e.log", "sub/tmp/d.txt")
+	testBackupExcludes(t, BackupOptions{Includes: []string{"*.txt"}}, files,
+		"b.log", "other/e.log", "other/tmp/f.md")
+	testBackupExcludes(t, BackupOptions{Includes: []string{"**/sub/*"}, Excludes: []string{"**/sub/tmp"}}, files,
+		"a.txt", "b.log", "sub/tmp/d.txt", "other/e.log", "other/tmp/f.md")
+}
+
+// testBackupExcludes backs up files with opts and checks that exactly the
+// excluded files are missing from the snapshot
+func testBackupExcludes(t *testing.T, opts BackupOptions, files map[string]string, excluded ...string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for name, content := range files {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+
+	opts.Paths = []string{dataDir}
+	id := backupSnapshot(t, ctx, repo, opts)
+
+	target := t.TempDir()
+	if _, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: target}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	for name := range files {
+		_, err := os.Stat(filepath.Join(target, dataDir, filepath.FromSlash(name)))
+		if slices.Contains(excluded, name) {
+			if err == nil {
+				t.Errorf("Expected %s to be excluded", name)
+			}
+		} else if err != nil {
+			t.Errorf("Expected %s to be backed up: %v", name, err)
+		}
+	}
+}
+
+// TestRestorePatterns tests that restore filters use the pattern syntax of the CLI
+func TestRestorePatterns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	files := []string{"a.txt", "sub/b.txt", "sub/deep/c.log"}
+	for _, name := range files {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	root := filepath.ToSlash(dataDir)
+	for _, test := range []struct {
+		opts     RestoreOptions
+		restored []string
+	}{
+		{RestoreOptions{Includes: []string{"*.txt"}}, []string{"a.txt", "sub/b.txt"}},
+		{RestoreOptions{Includes: []string{root + "/sub"}}, []string{"sub/b.txt", "sub/deep/c.log"}},
+		{RestoreOptions{Includes: []string{"**/deep/*"}}, []string{"sub/deep/c.log"}},
+		{RestoreOptions{Excludes: []string{"sub"}}, []string{"a.txt"}},
+		{RestoreOptions{Excludes: []string{"**/deep"}}, []string{"a.txt", "sub/b.txt"}},
+		{RestoreOptions{Includes: []string{root + "/sub"}, Excludes: []string{"*.log"}}, []string{"sub/b.txt"}},
+	} {
+		opts := test.opts
+		opts.TargetDir = t.TempDir()
+		if _, err := repo.Restore(ctx, id, opts); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		for _, name := range files {
+			_, err := os.Stat(filepath.Join(opts.TargetDir, dataDir, filepath.FromSlash(name)))
+			if restored := slices.Contains(test.restored, name); restored != (err == nil) {
+				t.Errorf("includes %q, excludes %q: expected %s restored: %v, got error %v",
+					test.opts.Includes, test.opts.Excludes, name, restored, err)
+			}
+		}
+	}
+
+	_, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: t.TempDir(), Includes: []string{"[invalid"}})
+	if err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+// TestRestoreXattrFilter tests the selection of extended attributes to restore
+func TestRestoreXattrFilter(t *testing.T) {
+	warnf := func(msg string, args ...interface{}) { t.Errorf(msg, args...) }
+	names := []string{"user.comment", "user.mime_type", "security.selinux", "system.posix_acl_access"}
+
+	for _, test := range []struct {
+		includes, excludes []string
+		skipACLs           bool
+		selected           []string
+	}{
+		{nil, nil, true, []string{"user.comment", "user.mime_type", "security.selinux"}},
+		{[]string{"user.*"}, nil, false, []string{"user.comment", "user.mime_type"}},
+		{nil, []string{"security.*"}, false, []string{"user.comment", "user.mime_type", "system.posix_acl_access"}},
+		{[]string{"*"}, nil, true, []string{"user.comment", "user.mime_type", "security.selinux"}},
+		{nil, []string{"user.comment"}, true, []string{"user.mime_type", "security.selinux"}},
+	} {
+		selectXattr, err := restoreXattrFilter(test.includes, test.excludes, test.skipACLs, warnf)
+		if err != nil {
+			t.Fatalf("restoreXattrFilter failed: %v", err)
+		}
+		for _, name := range names {
+			if selected := slices.Contains(test.selected, name); selectXattr(name) != selected {
+				t.Errorf("includes %q, excludes %q, skip ACLs %v: expected %s selected: %v",
+					test.includes, test.excludes, test.skipACLs, name, selected)
+			}
+		}
+	}
+
+	if _, err := restoreXattrFilter([]string{"user.*"}, []string{"security.*"}, false, warnf); err == nil {
+		t.Error("Expected an error for include and exclude patterns")
+	}
+	if _, err := restoreXattrFilter(nil, []string{"[invalid"}, false, warnf); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+// TestRestoreTotal tests that the size of the selected files is reported
+// before a restore
+func TestRestoreTotal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for name, size := range map[string]int{"a.txt": 1000, "b.txt": 2000, "sub/c.log": 4000} {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	for _, test := range []struct {
+		includes []string
+		excludes []string
+		want     uint64
+	}{
+		{nil, nil, 7000},
+		{[]string{"*.txt"}, nil, 3000},
+		{nil, []string{"a.txt"}, 6000},
+	} {
+		events := make(chan ProgressEvent, 16)
+		done := make(chan []ProgressEvent)
+		go func() {
+			var received []ProgressEvent
+			for ev := range events {
+				received = append(received, ev)
+			}
+			done <- received
+		}()
+
+		_, err := repo.Restore(ctx, id, RestoreOptions{
+			TargetDir: t.TempDir(),
+			Includes:  test.includes,
+			Excludes:  test.excludes,
+			Progress:  NewChannelProgress(events),
+		})
+		close(events)
+		if err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+
+		var total, added uint64
+		for _, ev := range <-done {
+			switch ev.Kind {
+			case ProgressEventTotal:
+				if added != 0 {
+					t.Error("Expected the total before any progress")
+				}
+				total = ev.Total
+			case ProgressEventAdd:
+				added += ev.Delta
+			}
+		}
+		if total != test.want || added != test.want {
+			t.Errorf("includes %q, excludes %q: expected %d bytes, got total %d and %d added",
+				test.includes, test.excludes, test.want, total, added)
+		}
+	}
+}
+
+// TestRestorePaths tests restoring a directory of a snapshot as restore root
+func TestRestorePaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	files := []string{"www/index.html", "www/css/site.css", "other.txt"}
+	for _, name := range files {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	www := filepath.ToSlash(dataDir) + "/www"
+	for _, test := range []struct {
+		ref      SnapshotID
+		opts     RestoreOptions
+		restored []string
+	}{
+		{id, RestoreOptions{Paths: []string{www}}, []string{"index.html", "css/site.css"}},
+		{id + SnapshotID(":"+www), RestoreOptions{}, []string{"index.html", "css/site.css"}},
+		{id, RestoreOptions{Paths: []string{www}, Includes: []string{"/css"}}, []string{"css/site.css"}},
+	} {
+		opts := test.opts
+		opts.TargetDir = t.TempDir()
+		if _, err := repo.Restore(ctx, test.ref, opts); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		for _, name := range []string{"index.html", "css/site.css", "other.txt"} {
+			_, err := os.Stat(filepath.Join(opts.TargetDir, filepath.FromSlash(name)))
+			if restored := slices.Contains(test.restored, name); restored != (err == nil) {
+				t.Errorf("%s with paths %q: expected %s restored: %v, got error %v",
+					test.ref, test.opts.Paths, name, restored, err)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		ref   SnapshotID
+		paths []string
+	}{
+		{id, []string{www + "/index.html"}},
+		{id, []string{www + "/missing"}},
+		{id, []string{www, www + "/css"}},
+		{id + SnapshotID(":"+www), []string{www}},
+	} {
+		_, err := repo.Restore(ctx, test.ref, RestoreOptions{TargetDir: t.TempDir(), Paths: test.paths})
+		if err == nil {
+			t.Errorf("Expected an error for restoring %q of %s", test.paths, test.ref)
+		}
+	}
+}
+
+// TestRestoreResume tests that resumed restores skip correct files and
+// complete the others
+func TestRestoreResume(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	files := map[string][]byte{}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin", "d.bin"} {
+		files[name] = bytes.Repeat([]byte(name), 100000)
+		if err := os.WriteFile(filepath.Join(dataDir, name), files[name], 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	for _, mode := range []RestoreResume{ResumeMetadata, ResumeContent} {
+		t.Run(string(mode), func(t *testing.T) {
+			target := t.TempDir()
+			if _, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: target}); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+
+			// simulate an interrupted restore: a partially written file and
+			// a missing one
+			restored := filepath.Join(target, dataDir)
+			if err := os.Truncate(filepath.Join(restored, "c.bin"), 1000); err != nil {
+				t.Fatalf("Failed to truncate file: %v", err)
+			}
+			if err := os.Remove(filepath.Join(restored, "d.bin")); err != nil {
+				t.Fatalf("Failed to remove file: %v", err)
+			}
+
+			report, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: target, Resume: mode})
+			if err != nil {
+				t.Fatalf("Resumed restore failed: %v", err)
+			}
+			if report.FilesSkipped != 2 {
+				t.Errorf("Expected 2 skipped files, got %d", report.FilesSkipped)
+			}
+			for name, content := range files {
+				data, err := os.ReadFile(filepath.Join(restored, name))
+				if err != nil || !bytes.Equal(data, content) {
+					t.Errorf("File %s not restored correctly: %v", name, err)
+				}
+			}
+		})
+	}
+
+	_, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: t.TempDir(), Resume: ResumeContent, OverwritePolicy: OverwriteNever})
+	if err == nil {
+		t.Error("Expected an error for resume with an overwrite policy")
+	}
+	_, err = repo.Restore(ctx, id, RestoreOptions{TargetDir: t.TempDir(), Resume: "sometimes"})
+	if err == nil {
+		t.Error("Expected an error for an invalid resume mode")
+	}
+}
+
+// TestRestoreVerify tests that restored files are verified
+func TestRestoreVerify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt", "empty.txt"} {
+		content := bytes.Repeat([]byte(name), 1000)
+		if name == "empty.txt" {
+			content = nil
+		}
+		if err := os.WriteFile(filepath.Join(dataDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	report, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: t.TempDir(), Verify: true})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if report.FilesVerified != 3 || len(report.Mismatches) != 0 {
+		t.Errorf("Unexpected verification result: %+v", report)
+	}
+
+	_, err = repo.Restore(ctx, id, RestoreOptions{TargetDir: t.TempDir(), Verify: true, DryRun: true})
+	if err == nil {
+		t.Error("Expected an error for verifying a dry run")
+	}
+}
+
+// TestRestorePatternFiles tests that restore patterns are read from files
+func TestRestorePatternFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt", "c.log"} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	id := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	patternDir := t.TempDir()
+	includeFile := filepath.Join(patternDir, "includes")
+	excludeFile := filepath.Join(patternDir, "excludes")
+	if err := os.WriteFile(includeFile, []byte("# text files\n*.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to write pattern file: %v", err)
+	}
+	if err := os.WriteFile(excludeFile, []byte("b.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to write pattern file: %v", err)
+	}
+
+	target := t.TempDir()
+	_, err := repo.Restore(ctx, id, RestoreOptions{TargetDir: target, IncludeFiles: []string{includeFile}, ExcludeFiles: []string{excludeFile}})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	for name, restored := range map[string]bool{"a.txt": true, "b.txt": false, "c.log": false} {
+		_, err := os.Stat(filepath.Join(target, dataDir, name))
+		if restored != (err == nil) {
+			t.Errorf("Expected %s restored: %v, got error %v", name, restored, err)
+		}
+	}
+}
+
+// TestRestoreDryRunActions tests that dry runs list the planned actions without writing
+func TestRestoreDryRunActions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		err := os.WriteFile(filepath.Join(dataDir, name), bytes.Repeat([]byte(name), 1000), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	target := t.TempDir()
+	report, err := repo.Restore(ctx, snapshotID, RestoreOptions{TargetDir: target, DryRun: true})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	actions := make(map[string]string)
+	for _, a := range report.Actions {
+		actions[filepath.Base(a.Path)] = a.Action
+	}
+	if actions["a.txt"] != RestoreActionCreate || actions["b.txt"] != RestoreActionCreate {
+		t.Errorf("Expected files to be created: %+v", report.Actions)
+	}
+	if entries, _ := os.ReadDir(target); len(entries) != 0 {
+		t.Fatalf("Dry run wrote %d entries", len(entries))
+	}
+
+	if _, err := repo.Restore(ctx, snapshotID, RestoreOptions{TargetDir: target}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	restored := filepath.Join(target, dataDir)
+	if err := os.WriteFile(filepath.Join(restored, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(restored, "extra.txt"), []byte("extra"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	report, err = repo.Restore(ctx, snapshotID, RestoreOptions{
+		TargetDir: target,
+		Overwrite: true,
+		Delete:    true,
+		DryRun:    true,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	actions = make(map[string]string)
+	for _, a := range report.Actions {
+		actions[filepath.Base(a.Path)] = a.Action
+	}
+	if actions["a.txt"] != RestoreActionOverwrite || actions["extra.txt"] != RestoreActionDelete {
+		t.Errorf("Unexpected actions: %+v", report.Actions)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "extra.txt")); err != nil {
+		t.Errorf("Dry run deleted a file: %v", err)
+	}
+	if len(report.Deleted) != 1 || filepath.Base(report.Deleted[0]) != "extra.txt" {
+		t.Errorf("Expected extra.txt to be deleted, got %v", report.Deleted)
+	}
+
+	report, err = repo.Restore(ctx, snapshotID, RestoreOptions{TargetDir: target, Delete: true})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(report.Deleted) != 1 || filepath.Base(report.Deleted[0]) != "extra.txt" {
+		t.Errorf("Expected extra.txt to be deleted, got %v", report.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "extra.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected extra.txt to be removed, got %v", err)
+	}
+}
+
+// TestRestoreOverwritePolicy tests that the overwrite policy is applied to
+// existing files
+func TestRestoreOverwritePolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "a.txt"), []byte("original"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	tests := []struct {
+		policy OverwritePolicy
+		want   string
+	}{
+		{OverwriteNever, "changed"},
+		{OverwriteIfNewer, "changed"},
+		{OverwriteIfChanged, "original"},
+		{OverwriteAlways, "original"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			target := t.TempDir()
+			file := filepath.Join(target, dataDir, "a.txt")
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(file, []byte("changed"), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			newer := time.Now().Add(time.Hour)
+			if err := os.Chtimes(file, newer, newer); err != nil {
+				t.Fatalf("Failed to set file times: %v", err)
+			}
+
+			_, err := repo.Restore(ctx, snapshotID, RestoreOptions{TargetDir: target, OverwritePolicy: test.policy})
+			if err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read restored file: %v", err)
+			}
+			if string(content) != test.want {
+				t.Errorf("Expected %q, got %q", test.want, content)
+			}
+		})
+	}
+
+	_, err := repo.Restore(ctx, snapshotID, RestoreOptions{TargetDir: t.TempDir(), OverwritePolicy: "sometimes"})
+	if err == nil {
+		t.Error("Expected an error for an invalid overwrite policy")
+	}
+}
+
+// TestConfigureBackendUpload tests that upload tuning is passed to the backend config
+func TestConfigureBackendUpload(t *testing.T) {
+	loc, err := location.Parse(getBackendRegistry(), "s3:https://s3.example.com/bucket")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+
+	cfg := Config{Upload: UploadOptions{PartSizeMiB: 16, PartConcurrency: 2, BlockSizeMiB: 8}}
+	if err := configureBackend(cfg, loc); err != nil {
+		t.Fatalf("configureBackend failed: %v", err)
+	}
+
+	s3cfg := loc.Config.(*s3.Config)
+	if s3cfg.PartSize != 16 || s3cfg.PartConcurrency != 2 {
+		t.Errorf("Upload options not applied: %+v", s3cfg)
+	}
+}
+
+// TestConfigureBackendColdStorage tests that cold storage is enabled for the
+// repository by an explicit opt-in, without changing the process-wide feature
+// flags
+func TestConfigureBackendColdStorage(t *testing.T) {
+	loc, err := location.Parse(getBackendRegistry(), "s3:https://s3.example.com/bucket")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+
+	cfg := Config{ColdStorage: &ColdStorageOptions{RestoreDays: 3}}
+	if err := configureBackend(cfg, loc); err != nil {
+		t.Fatalf("configureBackend failed: %v", err)
+	}
+	s3cfg := loc.Config.(*s3.Config)
+	if !s3cfg.EnableRestore || !s3cfg.RestoreOptIn || s3cfg.RestoreDays != 3 {
+		t.Errorf("Cold storage options not applied: %+v", s3cfg)
+	}
+	if feature.Flag.Enabled(feature.S3Restore) {
+		t.Error("Expected the global s3-restore feature flag to stay disabled")
+	}
+}
+
+// TestConfigureBackendEndpoint tests overriding the endpoint of cloud backends
+func TestConfigureBackendEndpoint(t *testing.T) {
+	loc, err := location.Parse(getBackendRegistry(), "gs:bucket:/restic")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+	if err := configureBackend(Config{Endpoint: "http://127.0.0.1:4443/storage/v1/"}, loc); err != nil {
+		t.Fatalf("configureBackend failed: %v", err)
+	}
+	if endpoint := loc.Config.(*gs.Config).Endpoint; endpoint != "http://127.0.0.1:4443/storage/v1/" {
+		t.Errorf("Endpoint not applied, got %q", endpoint)
+	}
+
+	loc, err = location.Parse(getBackendRegistry(), "local:/tmp/repo")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+	if err := configureBackend(Config{Endpoint: "http://127.0.0.1"}, loc); err == nil {
+		t.Error("Expected endpoint to be rejected for the local backend")
+	}
+}
+
+// TestConfigureBackendRclone tests that rclone options are applied to the rclone backend only
+func TestConfigureBackendRclone(t *testing.T) {
+	loc, err := location.Parse(getBackendRegistry(), "rclone:remote:restic")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+	opts := &RcloneOptions{Program: "/opt/app/rclone", Connections: 8}
+	if err := configureBackend(Config{Rclone: opts}, loc); err != nil {
+		t.Fatalf("configureBackend failed: %v", err)
+	}
+	rcfg := loc.Config.(*rclone.Config)
+	if rcfg.Program != "/opt/app/rclone" || rcfg.Connections != 8 {
+		t.Errorf("Options not applied, got program %q, connections %d", rcfg.Program, rcfg.Connections)
+	}
+	if rcfg.Args != "serve restic --stdio --b2-hard-delete" {
+		t.Errorf("Default args not kept, got %q", rcfg.Args)
+	}
+
+	loc, err = location.Parse(getBackendRegistry(), "local:/tmp/repo")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+	if err := configureBackend(Config{Rclone: opts}, loc); err == nil {
+		t.Error("Expected rclone options to be rejected for the local backend")
+	}
+}
+
+// TestMemoryLimit tests that the memory limit reduces the worker concurrency
+func TestMemoryLimit(t *testing.T) {
+	loc, err := location.Parse(getBackendRegistry(), "s3:https://s3.example.com/bucket")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+	if err := configureBackend(Config{MemoryLimitMiB: 64}, loc); err != nil {
+		t.Fatalf("configureBackend failed: %v", err)
+	}
+	if connections := loc.Config.(*s3.Config).Connections; connections != 4 {
+		t.Errorf("Expected 4 connections, got %d", connections)
+	}
+
+	opts := limitArchiver(CPULimit{}.archiverOptions(), 32)
+	if opts.ReadConcurrency != 1 || opts.SaveBlobConcurrency != 1 {
+		t.Errorf("Unexpected archiver options: %+v", opts)
+	}
+
+	// without a limit the archiver defaults are used
+	if opts := limitArchiver(CPULimit{}.archiverOptions(), 0); opts.SaveBlobConcurrency != 0 {
+		t.Errorf("Unexpected archiver options: %+v", opts)
+	}
+}
+
+// TestBackupConcurrency tests the precedence of the archiver concurrency settings
+func TestBackupConcurrency(t *testing.T) {
+	for _, test := range []struct {
+		cfg        Config
+		opts       BackupOptions
+		read, save uint
+	}{
+		{Config{}, BackupOptions{}, 0, 0},
+		{Config{Parallelism: 8}, BackupOptions{}, 8, 8},
+		{Config{Parallelism: 8}, BackupOptions{CPU: CPULimit{MaxProcs: 1}}, 1, 1},
+		{Config{Parallelism: 8}, BackupOptions{ReadConcurrency: 4, SaveBlobConcurrency: 3}, 4, 3},
+		{Config{Parallelism: 8, MemoryLimitMiB: 32}, BackupOptions{ReadConcurrency: 4}, 1, 1},
+	} {
+		r := &repositoryImpl{cfg: test.cfg}
+		opts := r.backupArchiverOptions(test.opts, test.opts.CPU)
+		if opts.ReadConcurrency != test.read || opts.SaveBlobConcurrency != test.save {
+			t.Errorf("%+v, %+v: unexpected archiver options %+v", test.cfg, test.opts, opts)
+		}
+		if test.read > 0 && opts.SaveTreeConcurrency != test.read+test.save {
+			t.Errorf("%+v, %+v: unexpected tree concurrency %+v", test.cfg, test.opts, opts)
+		}
+	}
+}
+
+// TestProfile tests that profiles only fill settings which are not set explicitly
+func TestProfile(t *testing.T) {
+	cfg, err := ProfileLowMemory.apply(Config{MemoryLimitMiB: 96})
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if cfg.MemoryLimitMiB != 96 || cfg.PackSizeMiB != 4 || cfg.Upload.PartSizeMiB != 16 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	cfg, err = ProfileHighThroughput.apply(Config{})
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	loc, err := location.Parse(getBackendRegistry(), "s3:https://s3.example.com/bucket")
+	if err != nil {
+		t.Fatalf("Failed to parse location: %v", err)
+	}
+	if err := configureBackend(cfg, loc); err != nil {
+		t.Fatalf("configureBackend failed: %v", err)
+	}
+	if connections := loc.Config.(*s3.Config).Connections; connections != 16 {
+		t.Errorf("Expected 16 connections, got %d", connections)
+	}
+
+	if cpu := ProfileLowImpact.backupCPU(CPULimit{}); cpu.MaxProcs != 1 {
+		t.Errorf("Unexpected CPU limit: %+v", cpu)
+	}
+	if _, err := Profile("turbo").apply(Config{}); err == nil {
+		t.Error("Expected unknown profile to be rejected")
+	}
+}
+
+// TestShutdown tests that Shutdown waits for running operations
+func TestShutdown(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	impl := repo.(*repositoryImpl)
+
+	// simulate a running operation
+	if err := impl.begin(); err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := repo.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected shutdown to time out, got %v", err)
+	}
+	if _, err := repo.Snapshots(context.Background(), SnapshotFilter{}); !errors.Is(err, ErrRepositoryClosed) {
+		t.Errorf("Expected new operations to be rejected, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		impl.end()
+	}()
+	if err := repo.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := repo.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected second shutdown to be a no-op, got %v", err)
+	}
+}
+
+// TestInitOptions tests that the repository version and compression are applied
+func TestInitOptions(t *testing.T) {
+	ctx := context.Background()
+	config := Config{
+		RepoURL:     "local:" + filepath.Join(t.TempDir(), "repo"),
+		Backend:     BackendLocal,
+		Password:    []byte("testpassword123"),
+		RepoVersion: 1,
+		Compression: CompressionOff,
+	}
+
+	repo, err := Init(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+
+	if version := repo.(*repositoryImpl).repo.Config().Version; version != 1 {
+		t.Errorf("Expected repository version 1, got %d", version)
+	}
+
+	config.RepoURL = "local:" + filepath.Join(t.TempDir(), "repo")
+	config.RepoVersion = 0
+	config.Compression = "zip"
+	if _, err := Init(ctx, config); err == nil {
+		t.Error("Expected invalid compression mode to be rejected")
+	}
+}
+
+// TestPasswordProviders tests fetching passwords from secrets managers
+func TestPasswordProviders(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/v1/secret/data/backup":
+			if req.Header.Get("X-Vault-Token") != "vault-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "from-vault"}, "metadata": {}}}`))
+		case req.URL.Path == "/v1/kv/backup":
+			_, _ = w.Write([]byte(`{"data": {"password": "from-vault-v1"}}`))
+		case req.URL.Path == "/secrets/restic/v2" && req.URL.Query().Get("api-version") == "7.4":
+			if req.Header.Get("Authorization") != "Bearer azure-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"value": "from-azure\n"}`))
+		case req.URL.Path == "/v1/projects/p/secrets/restic/versions/latest:access":
+			_, _ = w.Write([]byte(`{"payload": {"data": "ZnJvbS1nY3AK"}}`))
+		case req.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue":
+			if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+				!strings.Contains(req.Header.Get("Authorization"), "/eu-central-1/secretsmanager/aws4_request") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"SecretString": "{\"password\": \"from-aws\"}"}`))
+		case req.Header.Get("X-Amz-Target") == "TrentService.Decrypt":
+			var body struct{ CiphertextBlob []byte }
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil || string(body.CiphertextBlob) != "encrypted" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"Plaintext": "ZnJvbS1rbXM="}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	creds := &Credentials{AccessKey: "AKID", SecretKey: "secret"}
+	providers := map[string]PasswordProvider{
+		"from-vault":    &VaultPassword{Address: server.URL, Token: "vault-token", Path: "secret/data/backup"},
+		"from-vault-v1": &VaultPassword{KVVersion: 1, Address: server.URL, Path: "kv/backup"},
+		"from-azure": &AzureKeyVaultPassword{VaultURL: server.URL, Name: "restic", Version: "v2",
+			Credential: staticTokenCredential("azure-token"), Client: server.Client()},
+		"from-aws": &AWSSecretsManagerPassword{Region: "eu-central-1", SecretID: "backup", Field: "password",
+			Credentials: creds, Endpoint: server.URL},
+		"from-kms": &AWSKMSPassword{Region: "eu-central-1", Ciphertext: []byte("encrypted"),
+			Credentials: creds, Endpoint: server.URL},
+		"from-gcp": &GCPSecretManagerPassword{Name: "projects/p/secrets/restic/versions/latest",
+			Endpoint: server.URL, Client: server.Client()},
+	}
+	for want, provider := range providers {
+		password, err := provider.Password(ctx)
+		if err != nil {
+			t.Errorf("%s: %v", want, err)
+			continue
+		}
+		if string(password) != want {
+			t.Errorf("Expected %q, got %q", want, password)
+		}
+	}
+
+	if _, err := (&VaultPassword{Address: server.URL, Token: "wrong", Path: "secret/data/backup"}).Password(ctx); err == nil {
+		t.Error("Expected wrong vault token to fail")
+	}
+	if _, err := (&VaultPassword{Address: server.URL, Path: "kv/backup"}).Password(ctx); err == nil {
+		t.Error("Expected KV version 1 secret read as version 2 to fail")
+	}
+	if _, err := (&AzureKeyVaultPassword{VaultURL: server.URL, Name: "restic", Version: "v2",
+		Credential: staticTokenCredential("wrong"), Client: server.Client()}).Password(ctx); err == nil {
+		t.Error("Expected wrong azure token to fail")
+	}
+
+	// the provider is only used if no password is set
+	repo, err := Init(ctx, Config{
+		RepoURL:          "local:" + filepath.Join(t.TempDir(), "repo"),
+		Backend:          BackendLocal,
+		PasswordProvider: providers["from-vault"],
+	})
+	if err != nil {
+		t.Fatalf("Init with password provider failed: %v", err)
+	}
+	if string(repo.(*repositoryImpl).cfg.Password) != "from-vault" {
+		t.Errorf("Expected password from provider to be used")
+	}
+	_ = repo.Close()
+}
+
+// staticTokenCredential is an azcore.TokenCredential returning a fixed token
+type staticTokenCredential string
+
+func (c staticTokenCredential) GetToken(_ context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(c), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// TestSignAWSRequest tests the signature against a vector computed following
+// the AWS signature version 4 documentation, using the example credentials
+// of the AWS test suite
+func TestSignAWSRequest(t *testing.T) {
+	body := []byte(`{"SecretId":"backup"}`)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, test := range []struct {
+		token         string
+		signedHeaders string
+		signature     string
+	}{
+		{"", "content-type;host;x-amz-date;x-amz-target",
+			"2bdfc5727c2f295c5b7d694830656810464f1732f3b480c19ba2ee43ca6f7240"},
+		{"session", "content-type;host;x-amz-date;x-amz-security-token;x-amz-target",
+			"40899acfcdfaba6cf22cc77a08104103e2f48828b3b6d736ad8cd9104fc45e35"},
+	} {
+		req, err := http.NewRequest(http.MethodPost, "https://secretsmanager.us-east-1.amazonaws.com/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+		signAWSRequest(req, body, credentials.Value{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			SessionToken:    test.token,
+		}, "us-east-1", "secretsmanager", now)
+
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/secretsmanager/aws4_request, " +
+			"SignedHeaders=" + test.signedHeaders + ", Signature=" + test.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("Unexpected authorization header with token %q:\n got: %s\nwant: %s", test.token, got, want)
+		}
+		if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+			t.Errorf("Unexpected date header %q", req.Header.Get("X-Amz-Date"))
+		}
+	}
+}
+
+// TestRESTHeaders tests that custom headers are sent to the rest server
+func TestRESTHeaders(t *testing.T) {
+	tokens := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case tokens <- req.Header.Get("X-Auth-Token"):
+		default:
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	_, err := Open(context.Background(), Config{
+		RepoURL:     "rest:" + server.URL + "/",
+		Backend:     BackendRest,
+		Password:    []byte("testpassword"),
+		RESTHeaders: map[string]string{"X-Auth-Token": "secret"},
+	})
+	if err == nil {
+		t.Fatal("Expected Open to fail")
+	}
+
+	select {
+	case token := <-tokens:
+		if token != "secret" {
+			t.Errorf("Expected X-Auth-Token: secret, got: %q", token)
+		}
+	default:
+		t.Error("No request reached the server")
+	}
+}
+
+// TestTLSOptions tests converting the TLS options for crypto/tls
+func TestTLSOptions(t *testing.T) {
+	minVersion, suites, err := TLSOptions{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+	}.parse()
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if minVersion != tls.VersionTLS12 || len(suites) != 1 || suites[0] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("Unexpected result: %x %x", minVersion, suites)
+	}
+
+	if _, _, err := (TLSOptions{MinVersion: "1.4"}).parse(); err == nil {
+		t.Error("Expected error for unknown TLS version")
+	}
+	if _, _, err := (TLSOptions{CipherSuites: []string{"TLS_NONE"}}).parse(); err == nil {
+		t.Error("Expected error for unknown cipher suite")
+	}
+}
+
+// rotatingPasswords is a PasswordsProvider for TestAlternatePasswords
+type rotatingPasswords [][]byte
+
+func (p rotatingPasswords) Password(context.Context) ([]byte, error) { return p[0], nil }
+
+func (p rotatingPasswords) Passwords(context.Context) ([][]byte, error) { return p, nil }
+
+// TestAlternatePasswords tests that Open tries the candidate passwords in order
+func TestAlternatePasswords(t *testing.T) {
+	ctx := context.Background()
+	config := Config{
+		RepoURL:  "local:" + filepath.Join(t.TempDir(), "repo"),
+		Backend:  BackendLocal,
+		Password: []byte("oldpassword"),
+	}
+
+	repo, err := Init(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	config.Password = []byte("newpassword")
+	config.AlternatePasswords = [][]byte{[]byte("wrong"), []byte("oldpassword")}
+	repo, err = Open(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	if index := repo.PasswordIndex(); index != 2 {
+		t.Errorf("Expected password index 2, got %d", index)
+	}
+	_ = repo.Close()
+
+	config.Password = nil
+	config.AlternatePasswords = nil
+	config.PasswordProvider = rotatingPasswords{[]byte("newpassword"), []byte("oldpassword")}
+	repo, err = Open(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to open repository with provider: %v", err)
+	}
+	if index := repo.PasswordIndex(); index != 1 {
+		t.Errorf("Expected password index 1, got %d", index)
+	}
+	_ = repo.Close()
+
+	config.PasswordProvider = nil
+	config.Password = []byte("newpassword")
+	config.AlternatePasswords = [][]byte{[]byte("wrong")}
+	if _, err := Open(ctx, config); err == nil {
+		t.Error("Expected open with wrong passwords to fail")
+	}
+}
+
+// TestKeys tests adding, listing and removing keys and changing the password
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	config := Config{
+		RepoURL:  "local:" + filepath.Join(t.TempDir(), "repo"),
+		Backend:  BackendLocal,
+		Password: []byte("oldpassword"),
+	}
+
+	repo, err := Init(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	defer func() { _ = repo.Close() }()
+
+	keyID, err := repo.AddKey(ctx, []byte("clientpassword"), AddKeyOptions{Username: "client", Hostname: "nas"})
+	if err != nil {
+		t.Fatalf("AddKey failed: %v", err)
+	}
+	if _, err := repo.AddKey(ctx, nil, AddKeyOptions{}); err == nil {
+		t.Error("Expected adding an empty password to fail")
+	}
+
+	keys, err := repo.Keys(ctx)
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if len(keys) != 2 || !keys[0].Current || keys[1].ID != keyID || keys[1].Current || keys[1].Hostname != "nas" {
+		t.Fatalf("Unexpected keys: %+v", keys)
+	}
+
+	if err := repo.RemoveKey(ctx, keys[0].ID); err == nil {
+		t.Error("Expected removing the current key to fail")
+	}
+
+	newPassword := []byte("newpassword")
+	if err := repo.ChangePassword(ctx, newPassword); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	// the repository must not keep the caller's slice
+	copy(newPassword, "xxxxxxxxxxx")
+	if !bytes.Equal(repo.(*repositoryImpl).password(), []byte("newpassword")) || repo.PasswordIndex() != 0 {
+		t.Errorf("Unexpected password state after ChangePassword")
+	}
+	if err := repo.RemoveKey(ctx, keyID[:8]); err != nil {
+		t.Fatalf("RemoveKey failed: %v", err)
+	}
+	keys, err = repo.Keys(ctx)
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].Current {
+		t.Errorf("Expected only the new key to remain, got %+v", keys)
+	}
+	_ = repo.Close()
+
+	if _, err := Open(ctx, config); err == nil {
+		t.Error("Expected the old password to be rejected")
+	}
+	config.Password = []byte("newpassword")
+	repo, err = Open(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to open repository with new password: %v", err)
+	}
+}
+
+// TestSnapshotTimeFilter tests the Since/Until filters and their JSON encoding
+func TestSnapshotTimeFilter(t *testing.T) {
+	r := &repositoryImpl{}
+	sn := &data.Snapshot{Time: time.Date(2024, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))}
+
+	var filter SnapshotFilter
+	if err := json.Unmarshal([]byte(`{"since": "2024-06-01T10:00:00Z", "until": "2024-06-01T10:00:00Z"}`), &filter); err != nil {
+		t.Fatalf("Failed to decode filter: %v", err)
+	}
+	if !r.matchesFilter(sn, filter) {
+		t.Error("Expected snapshot at the boundaries to match")
+	}
+
+	after := sn.Time.Add(time.Second)
+	if r.matchesFilter(sn, SnapshotFilter{Since: &after}) {
+		t.Error("Expected snapshot before Since to be filtered")
+	}
+	before := sn.Time.Add(-time.Second)
+	if r.matchesFilter(sn, SnapshotFilter{Until: &before}) {
+		t.Error("Expected snapshot after Until to be filtered")
+	}
+
+	// fractional seconds are not encoded, like in the previous string format
+	buf, err := json.Marshal(Snapshot{ID: "abc", Time: sn.Time.Add(500 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("Failed to encode snapshot: %v", err)
+	}
+	if !strings.Contains(string(buf), `"time":"2024-06-01T12:00:00+02:00"`) {
+		t.Errorf("Expected RFC3339 time in JSON, got %s", buf)
+	}
+	var decoded Snapshot
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatalf("Failed to decode snapshot: %v", err)
+	}
+	if decoded.ID != "abc" || !decoded.Time.Equal(sn.Time) {
+		t.Errorf("Unexpected decoded snapshot %+v", decoded)
+	}
+}
+
+// TestSnapshotPathFilter tests that the Paths filter compares path components
+func TestSnapshotPathFilter(t *testing.T) {
+	r := &repositoryImpl{}
+	sn := &data.Snapshot{Paths: []string{"/database", "/srv/www"}}
+
+	for _, test := range []struct {
+		paths []string
+		match bool
+	}{
+		{[]string{"/database"}, true},
+		{[]string{"/database/"}, true},
+		{[]string{"/srv"}, true},
+		{[]string{"/"}, true},
+		{[]string{"/data"}, false},
+		{[]string{"/srv/w"}, false},
+		{[]string{"/srv/www/html"}, false},
+		{[]string{"/data", "/srv/www"}, true},
+	} {
+		if got := r.matchesFilter(sn, SnapshotFilter{Paths: test.paths}); got != test.match {
+			t.Errorf("Paths %v: got match %v, want %v", test.paths, got, test.match)
+		}
+	}
+}
+
+// TestOwnerMapping tests translating the owners of restored files
+func TestOwnerMapping(t *testing.T) {
+	mapping := &OwnerMapping{
+		UIDs: map[uint32]uint32{1000: 2000},
+		GIDs: map[uint32]uint32{100: 200},
+	}
+	mapOwner, err := mapping.mapper()
+	if err != nil {
+		t.Fatalf("mapper failed: %v", err)
+	}
+
+	if uid, gid := mapOwner(&data.Node{UID: 1000, GID: 100}); uid != 2000 || gid != 200 {
+		t.Errorf("Expected 2000:200, got %d:%d", uid, gid)
+	}
+	if uid, gid := mapOwner(&data.Node{UID: 1001, GID: 101}); uid != 1001 || gid != 101 {
+		t.Errorf("Expected unmapped owner to be kept, got %d:%d", uid, gid)
+	}
+
+	mapping.Users = map[string]string{"alice": "no-such-user-restic"}
+	if _, err := mapping.mapper(); err == nil {
+		t.Error("Expected unknown target user to be rejected")
+	}
+}
+
+// TestRestoreSkipMetadata tests that skipped metadata is not restored
+func TestRestoreSkipMetadata(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	file := filepath.Join(dataDir, "script.sh")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	target := t.TempDir()
+	report, err := repo.Restore(ctx, snapshotID, RestoreOptions{
+		TargetDir:       target,
+		SkipOwnership:   true,
+		SkipPermissions: true,
+		SkipTimestamps:  true,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if len(report.Notices) != 0 {
+		t.Errorf("Expected no notices, got %v", report.Notices)
+	}
+
+	fi, err := os.Stat(filepath.Join(target, file))
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %v", err)
+	}
+	if fi.Mode().Perm() == 0755 {
+		t.Error("Expected file mode not to be restored")
+	}
+	if fi.ModTime().Equal(old) {
+		t.Error("Expected modification time not to be restored")
+	}
+}
+
+// TestBackupFilesFrom tests reading the backup targets from lists
+func TestBackupFilesFrom(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	listFile := filepath.Join(dir, "list")
+	if err := os.WriteFile(listFile, []byte("# comment\n"+filepath.Join(dir, "*.txt")+"\n\n"), 0644); err != nil {
+		t.Fatalf("Failed to write list: %v", err)
+	}
+
+	r := &repositoryImpl{}
+	targets, err := r.backupTargets(BackupOptions{
+		Paths:             []string{"/extra"},
+		FilesFrom:         []TargetList{{Path: listFile}},
+		FilesFromVerbatim: []TargetList{{Reader: strings.NewReader("/with space/*\n\n")}},
+		FilesFromRaw:      []TargetList{{Reader: strings.NewReader("/raw\nname\x00/other\x00")}},
+	})
+	if err != nil {
+		t.Fatalf("backupTargets failed: %v", err)
+	}
+	expected := []string{
+		filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"),
+		"/with space/*", "/raw\nname", "/other", "/extra",
+	}
+	if strings.Join(targets, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected targets %q, got %q", expected, targets)
+	}
+
+	_, err = r.backupTargets(BackupOptions{FilesFromRaw: []TargetList{{Reader: strings.NewReader("/raw")}}})
+	if err == nil {
+		t.Error("Expected missing zero byte to be rejected")
+	}
+}
+
+// TestBackupOnItem tests the per-item backup callback
+func TestBackupOnItem(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	file := filepath.Join(dataDir, "a.txt")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	items := make(map[string]BackupItem)
+	opts := BackupOptions{
+		Paths:  []string{dataDir},
+		OnItem: func(item BackupItem) { items[item.Path] = item },
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, opts)
+	if item := items[file]; item.Status != BackupItemNew || item.Type != "file" || item.Size == 0 {
+		t.Errorf("Expected new file item, got %+v", item)
+	}
+
+	opts.ParentID = &snapshotID
+	if _, err := repo.Backup(ctx, opts); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if item := items[file]; item.Status != BackupItemUnmodified {
+		t.Errorf("Expected unmodified file item, got %+v", item)
+	}
+}
+
+// TestCheckLock tests that a check reports locks of other clients
+func TestCheckLock(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	other, _, err := repository.Lock(ctx, repo.(*repositoryImpl).repo, false, 0, func(string) {}, t.Logf)
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	defer other.Unlock()
+
+	var blocking []LockInfo
+	_, err = repo.Check(ctx, CheckOptions{
+		Depth: CheckDepthDefault,
+		Lock:  LockOptions{OnBlocked: func(lock LockInfo) { blocking = append(blocking, lock) }},
+	})
+	if err == nil {
+		t.Fatal("Expected check to fail while the repository is locked")
+	}
+	if len(blocking) != 1 || blocking[0].Exclusive || blocking[0].PID != os.Getpid() {
+		t.Errorf("Expected the blocking lock to be reported, got %+v", blocking)
+	}
+
+	report, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault, Lock: LockOptions{NoLock: true}})
+	if err != nil || !report.Success {
+		t.Errorf("Expected check without lock to succeed: %v", err)
+	}
+
+	if _, err := repo.Prune(ctx, PruneOptions{Lock: LockOptions{NoLock: true}}); err == nil {
+		t.Error("Expected prune without lock to be rejected")
+	}
+}
+
+// TestOperationLocks tests that backups, imports and forget lock the
+// repository, such that a prune cannot remove the data they reference
+func TestOperationLocks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	// a backup of another client holds a shared lock
+	other, _, err := repository.Lock(ctx, repo.(*repositoryImpl).repo, false, 0, func(string) {}, t.Logf)
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	if _, err := repo.Prune(ctx, PruneOptions{}); err == nil {
+		t.Error("Expected prune to fail while a shared lock is held")
+	}
+	if _, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1}); err == nil {
+		t.Error("Expected forget to fail while a shared lock is held")
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+	other.Unlock()
+
+	var export bytes.Buffer
+	if _, err := repo.ExportSnapshot(ctx, snapshotID, &export, ExportOptions{}); err != nil {
+		t.Fatalf("ExportSnapshot failed: %v", err)
+	}
+
+	// a prune of another client holds an exclusive lock
+	other, _, err = repository.Lock(ctx, repo.(*repositoryImpl).repo, true, 0, func(string) {}, t.Logf)
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	defer other.Unlock()
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err == nil {
+		t.Error("Expected backup to fail while an exclusive lock is held")
+	}
+	if _, err := repo.ImportSnapshot(ctx, &export); err == nil {
+		t.Error("Expected import to fail while an exclusive lock is held")
+	}
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}, Lock: LockOptions{NoLock: true}}); err == nil {
+		t.Error("Expected backup without lock to be rejected")
+	}
+}
+
+// TestUnlockAll tests removing a lock which is not stale
+func TestUnlockAll(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	other, _, err := repository.Lock(ctx, repo.(*repositoryImpl).repo, true, 0, func(string) {}, t.Logf)
+	if err != nil {
+		t.Fatalf("Failed to create lock: %v", err)
+	}
+	defer other.Unlock()
+
+	if err := repo.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if _, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault}); err == nil {
+		t.Fatal("Expected Unlock to keep the lock of a running client")
+	}
+
+	removed, err := repo.UnlockAll(ctx)
+	if err != nil {
+		t.Fatalf("UnlockAll failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 lock to be removed, got %d", removed)
+	}
+	if report, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault}); err != nil || !report.Success {
+		t.Errorf("Expected check to succeed after UnlockAll: %v", err)
+	}
+}
+
+// TestCompactIndex tests merging the index files of several backups
+func TestCompactIndex(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		err := os.WriteFile(filepath.Join(dataDir, fmt.Sprintf("file%d.txt", i)), []byte(fmt.Sprintf("content %d", i)), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+	}
+
+	report, err := repo.CompactIndex(ctx, CompactIndexOptions{})
+	if err != nil {
+		t.Fatalf("CompactIndex failed: %v", err)
+	}
+	if report.IndexFilesBefore != 3 || report.IndexFilesAfter != 1 {
+		t.Errorf("Expected 3 index files to be merged into 1, got %+v", report)
+	}
+
+	check, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault})
+	if err != nil || !check.Success {
+		t.Fatalf("Expected check to succeed after compaction: %v %+v", err, check.Errors)
+	}
+}
+
+// TestCat tests reading decoded repository objects
+func TestCat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+	impl := repo.(*repositoryImpl)
+
+	content := []byte("cat me")
+	if err := os.WriteFile(filepath.Join(dataDir, "file.txt"), content, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	buf, err := repo.Cat(ctx, ObjectConfig, "")
+	if err != nil || !bytes.Contains(buf, []byte(impl.repo.Config().ID)) {
+		t.Errorf("Expected config with repository ID, got %q: %v", buf, err)
+	}
+
+	buf, err = repo.Cat(ctx, ObjectSnapshot, string(snapshotID)[:8])
+	if err != nil {
+		t.Fatalf("Cat snapshot failed: %v", err)
+	}
+	var sn data.Snapshot
+	if err := json.Unmarshal(buf, &sn); err != nil || sn.Hostname == "" {
+		t.Errorf("Expected snapshot JSON, got %q: %v", buf, err)
+	}
+
+	var pack, blob restic.ID
+	err = impl.repo.ListBlobs(ctx, func(pb restic.PackedBlob) {
+		if pb.Type == restic.DataBlob {
+			pack, blob = pb.PackID, pb.ID
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to list blobs: %v", err)
+	}
+
+	buf, err = repo.Cat(ctx, ObjectBlob, blob.String())
+	if err != nil || !bytes.Equal(buf, content) {
+		t.Errorf("Expected blob content %q, got %q: %v", content, buf, err)
+	}
+
+	buf, err = repo.Cat(ctx, ObjectPackHeader, pack.String())
+	if err != nil {
+		t.Fatalf("Cat pack header failed: %v", err)
+	}
+	var entries []PackHeaderEntry
+	if err := json.Unmarshal(buf, &entries); err != nil || len(entries) != 1 || entries[0].ID != blob.String() {
+		t.Errorf("Expected pack header with blob %s, got %q: %v", blob.Str(), buf, err)
+	}
+
+	buf, err = repo.Cat(ctx, ObjectPack, pack.String())
+	if err != nil || !restic.Hash(buf).Equal(pack) {
+		t.Errorf("Expected raw pack file, got %d bytes: %v", len(buf), err)
+	}
+
+	if _, err := repo.Cat(ctx, "bogus", ""); err == nil {
+		t.Error("Expected unknown object type to fail")
+	}
+}
+
+// TestRewrite tests removing files from existing snapshots
+func TestRewrite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"keep.txt", "secret.txt", "SECRET.key"} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	opts := RewriteOptions{
+		Excludes:            []string{"secret.txt"},
+		InsensitiveExcludes: []string{"*.KEY"},
+		DryRun:              true,
+	}
+	report, err := repo.Rewrite(ctx, []SnapshotID{snapshotID}, opts)
+	if err != nil {
+		t.Fatalf("Rewrite dry run failed: %v", err)
+	}
+	if len(report.Rewritten) != 1 || report.Rewritten[0].New != "" || len(report.Rewritten[0].Excluded) != 2 {
+		t.Fatalf("Unexpected dry run report: %+v", report)
+	}
+
+	opts.DryRun = false
+	opts.Forget = true
+	report, err = repo.Rewrite(ctx, []SnapshotID{snapshotID}, opts)
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if len(report.Rewritten) != 1 || report.Rewritten[0].New == "" {
+		t.Fatalf("Expected one rewritten snapshot, got %+v", report)
+	}
+	newID := report.Rewritten[0].New
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != newID {
+		t.Errorf("Expected only the rewritten snapshot to remain, got %+v", snapshots)
+	}
+
+	var names []string
+	err = repo.Ls(ctx, newID, func(entry LsEntry) error {
+		if entry.Type == "file" {
+			names = append(names, filepath.Base(entry.Path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Ls failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "keep.txt" {
+		t.Errorf("Expected only keep.txt to remain, got %v", names)
+	}
+
+	report, err = repo.Rewrite(ctx, []SnapshotID{newID}, opts)
+	if err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	if len(report.Rewritten) != 0 || report.Unchanged != 1 {
+		t.Errorf("Expected snapshot without excluded files to be unchanged, got %+v", report)
+	}
+}
+
+// TestRepair tests repairing the index and snapshots after a pack file was lost
+func TestRepair(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+	impl := repo.(*repositoryImpl)
+
+	if err := os.WriteFile(filepath.Join(dataDir, "lost.txt"), []byte("this content gets lost"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	snapshotID := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}})
+
+	dataPack := func() restic.ID {
+		if err := impl.repo.LoadIndex(ctx, nil); err != nil {
+			t.Fatalf("Failed to load index: %v", err)
+		}
+		var id restic.ID
+		err := impl.repo.ListBlobs(ctx, func(blob restic.PackedBlob) {
+			if blob.Type == restic.DataBlob {
+				id = blob.PackID
+			}
+		})
+		if err != nil {
+			t.Fatalf("Failed to list blobs: %v", err)
+		}
+		return id
+	}
+
+	// salvaging an intact pack file loses nothing
+	packs, err := repo.RepairPacks(ctx, []string{dataPack().String()}, RepairPacksOptions{})
+	if err != nil {
+		t.Fatalf("RepairPacks failed: %v", err)
+	}
+	if packs.BlobsSalvaged != 1 || packs.BlobsLost != 0 {
+		t.Errorf("Expected one salvaged blob, got %+v", packs)
+	}
+	if _, err := repo.RepairPacks(ctx, []string{"invalid"}, RepairPacksOptions{}); err == nil {
+		t.Error("Expected invalid pack ID to fail")
+	}
+
+	// lose the new data pack file
+	lost := dataPack().String()
+	if err := os.Remove(filepath.Join(filepath.Dir(dataDir), "repo", "data", lost[:2], lost)); err != nil {
+		t.Fatalf("Failed to remove pack file: %v", err)
+	}
+
+	index, err := repo.RepairIndex(ctx, RepairIndexOptions{})
+	if err != nil {
+		t.Fatalf("RepairIndex failed: %v", err)
+	}
+	if len(index.PacksRemoved) != 1 || index.PacksRemoved[0] != lost || index.IndexFilesRemoved == 0 {
+		t.Errorf("Expected the lost pack to be removed from the index, got %+v", index)
+	}
+
+	dryRun, err := repo.RepairSnapshots(ctx, RepairSnapshotsOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("RepairSnapshots dry run failed: %v", err)
+	}
+	if len(dryRun.Repaired) != 1 || dryRun.Repaired[0].New != "" {
+		t.Errorf("Expected dry run to report one snapshot without saving it, got %+v", dryRun)
+	}
+
+	report, err := repo.RepairSnapshots(ctx, RepairSnapshotsOptions{Forget: true})
+	if err != nil {
+		t.Fatalf("RepairSnapshots failed: %v", err)
+	}
+	if len(report.Repaired) != 1 || report.Repaired[0].Original != snapshotID || report.Repaired[0].New == "" {
+		t.Fatalf("Expected one repaired snapshot, got %+v", report)
+	}
+	expected := fmt.Sprintf("file %q: removed missing content", filepath.ToSlash(filepath.Join(dataDir, "lost.txt")))
+	if changes := report.Repaired[0].Changes; len(changes) != 1 || changes[0] != expected {
+		t.Errorf("Expected change %q, got %v", expected, changes)
+	}
+
+	check, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault})
+	if err != nil || !check.Success {
+		t.Fatalf("Expected check to succeed after repair: %v %+v", err, check.Errors)
+	}
+}
+
+// TestRebuildIndex tests rebuilding the index from all pack files
+func TestRebuildIndex(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		err := os.WriteFile(filepath.Join(dataDir, fmt.Sprintf("file%d.txt", i)), []byte(fmt.Sprintf("content %d", i)), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+	}
+
+	report, err := repo.RebuildIndex(ctx, false)
+	if err != nil {
+		t.Fatalf("RebuildIndex failed: %v", err)
+	}
+	if report.PacksIndexed != 4 || report.PacksRead != 0 || report.IndexFilesRemoved != 2 {
+		t.Errorf("Expected complete index to be rewritten without reading packs, got %+v", report)
+	}
+
+	// two backups with one data and one tree pack each
+	report, err = repo.RebuildIndex(ctx, true)
+	if err != nil {
+		t.Fatalf("RebuildIndex failed: %v", err)
+	}
+	if report.PacksIndexed != 4 || report.PacksRead != 4 || report.IndexFilesRemoved != 1 {
+		t.Errorf("Expected all 4 packs to be read, got %+v", report)
+	}
+
+	check, err := repo.Check(ctx, CheckOptions{Depth: CheckDepthDefault})
+	if err != nil || !check.Success {
+		t.Fatalf("Expected check to succeed after rebuild: %v %+v", err, check.Errors)
+	}
+}
+
+// TestQueue tests that exclusive operations of a queue run alone and in order
+func TestQueue(t *testing.T) {
+	q := NewQueue(nil)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	shared := func(name string) func(context.Context, Repository) (string, error) {
+		return func(context.Context, Repository) (string, error) {
+			record(name + " start")
+			started <- struct{}{}
+			<-release
+			record(name + " end")
+			return name, nil
+		}
+	}
+
+	a := Enqueue(q, ctx, false, shared("a"))
+	b := Enqueue(q, ctx, false, shared("b"))
+	c := Enqueue(q, ctx, true, func(context.Context, Repository) (string, error) {
+		record("c")
+		return "c", nil
+	})
+
+	// both shared operations start before the exclusive one
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expected both shared operations to run concurrently")
+		}
+	}
+	mu.Lock()
+	if len(events) != 2 {
+		t.Errorf("Expected only the shared operations to run, got %v", events)
+	}
+	mu.Unlock()
+	close(release)
+
+	if value, err := c.Wait(ctx); err != nil || value != "c" {
+		t.Fatalf("Unexpected result %q, %v", value, err)
+	}
+	for _, f := range []*Future[string]{a, b} {
+		if _, err := f.Wait(ctx); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+	}
+	if events[len(events)-1] != "c" {
+		t.Errorf("Expected exclusive operation to run last, got %v", events)
+	}
+
+	q.Close()
+	if _, err := Enqueue(q, ctx, false, shared("d")).Wait(ctx); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Expected ErrQueueClosed, got %v", err)
+	}
+}
+
+// TestRetainSnapshot tests that Forget keeps retention-locked snapshots
+func TestRetainSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	repo, dataDir := newTestRepository(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(filepath.Join(dataDir, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	first := backupSnapshot(t, ctx, repo, BackupOptions{Paths: []string{dataDir}, Tags: []string{"monthly"}})
+	if _, err := repo.Backup(ctx, BackupOptions{Paths: []string{dataDir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	retained, err := repo.RetainSnapshot(ctx, first, until)
+	if err != nil {
+		t.Fatalf("RetainSnapshot failed: %v", err)
+	}
+	if retained == first {
+		t.Fatal("Expected the snapshot ID to change")
+	}
+	if again, err := repo.RetainSnapshot(ctx, retained, until.Add(-time.Minute)); err != nil || again != retained {
+		t.Errorf("Expected a shorter lock to be ignored, got %s, %v", again, err)
+	}
+
+	snapshots, err := repo.Snapshots(ctx, SnapshotFilter{Tags: []string{"monthly"}})
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Expected the retained snapshot to keep its tags: %v %+v", err, snapshots)
+	}
+	if snapshots[0].RetainUntil == nil || !snapshots[0].RetainUntil.Equal(until) {
+		t.Errorf("Expected retention until %v, got %v", until, snapshots[0].RetainUntil)
+	}
+
+	report, err := repo.Forget(ctx, ForgetPolicy{KeepLast: 1})
+	if err != nil {
+		t.Fatalf("Forget failed: %v", err)
+	}
+	if len(report.Removed) != 0 || len(report.RetentionLocked) != 1 || report.RetentionLocked[0] != retained {
+		t.Errorf("Expected the retained snapshot to be kept: %+v", report)
+	}
+
+	// all other removal paths refuse to remove the snapshot as well
+	latest, err := repo.ResolveSnapshot(ctx, "latest", SnapshotFilter{})
+	if err != nil {
+		t.Fatalf("ResolveSnapshot failed: %v", err)
+	}
+	_, err = repo.DeleteSnapshots(ctx, []SnapshotID{latest, retained})
+	if !errors.Is(err, ErrSnapshotRetained) {
+		t.Errorf("Expected ErrSnapshotRetained, got %v", err)
+	}
+	_, err = repo.Rewrite(ctx, []SnapshotID{retained}, RewriteOptions{Excludes: []string{"a.txt"}, Forget: true})
+	if !errors.Is(err, ErrSnapshotRetained) {
+		t.Errorf("Expected ErrSnapshotRetained from Rewrite, got %v", err)
+	}
+
+	dst, _ := newTestRepository(t)
+	if _, err := repo.SyncTo(ctx, dst, SyncOptions{}); err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if _, err := repo.DeleteSnapshots(ctx, []SnapshotID{latest}); err != nil {
+		t.Fatalf("DeleteSnapshots failed: %v", err)
+	}
+	copied, err := dst.ResolveSnapshot(ctx, "latest", SnapshotFilter{Tags: []string{"monthly"}})
+	if err != nil {
+		t.Fatalf("ResolveSnapshot failed: %v", err)
+	}
+	// remove the retained snapshot from the source behind the library's back
+	sn, err := data.LoadSnapshot(ctx, repo.(*repositoryImpl).repo, restic.TestParseID(string(retained)))
+	if err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	if err := repo.(*repositoryImpl).repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, *sn.ID()); err != nil {
+		t.Fatalf("Failed to remove snapshot: %v", err)
+	}
+	syncReport, err := repo.SyncTo(ctx, dst, SyncOptions{MirrorDeletions: true})
+	if err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if len(syncReport.Removed) != 1 || len(syncReport.RetentionLocked) != 1 || syncReport.RetentionLocked[0] != copied {
+		t.Errorf("Expected the retained destination snapshot to be kept: %+v", syncReport)
+	}
+}
@@ -0,0 +1,143 @@
+package resticlibtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/restic/restic/internal/backend"
+)
+
+// ErrInjected is the default error of injected faults
+var ErrInjected = errors.New("resticlibtest: injected fault")
+
+// Op is a backend operation which can be made to fail
+type Op string
+
+// Backend operations
+const (
+	OpSave   Op = "save"
+	OpLoad   Op = "load"
+	OpStat   Op = "stat"
+	OpList   Op = "list"
+	OpRemove Op = "remove"
+)
+
+// Fault describes failing backend operations
+type Fault struct {
+	Op Op
+
+	// FileType restricts the fault to files of a type, e.g. "data",
+	// "index", "snapshot", "lock", "key" or "config" (default: all)
+	FileType string
+
+	// Count is the number of calls which fail (default: all calls)
+	Count int
+
+	// Err is returned by the failing calls (default: ErrInjected)
+	Err error
+}
+
+// Faults injects errors into the backend of an in-memory repository
+type Faults struct {
+	mu     sync.Mutex
+	faults []*Fault
+	calls  map[Op]int
+}
+
+// Inject makes matching backend operations fail. Faults are checked in the
+// order they were injected.
+func (f *Faults) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fault.Err == nil {
+		fault.Err = ErrInjected
+	}
+	f.faults = append(f.faults, &fault)
+}
+
+// Reset removes all faults which were not used up yet
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+}
+
+// Calls returns how often op was called, including failed calls
+func (f *Faults) Calls(op Op) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// check records a call and returns the error of the first matching fault
+func (f *Faults) check(op Op, t backend.FileType) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.calls == nil {
+		f.calls = make(map[Op]int)
+	}
+	f.calls[op]++
+
+	for i, fault := range f.faults {
+		if fault.Op != op || (fault.FileType != "" && fault.FileType != t.String()) {
+			continue
+		}
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			}
+		}
+		return fault.Err
+	}
+	return nil
+}
+
+// faultBackend fails the operations selected by faults
+type faultBackend struct {
+	backend.Backend
+	faults *Faults
+}
+
+// Unwrap returns the wrapped backend
+func (be *faultBackend) Unwrap() backend.Backend {
+	return be.Backend
+}
+
+func (be *faultBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if err := be.faults.check(OpSave, h.Type); err != nil {
+		return err
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func (be *faultBackend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if err := be.faults.check(OpLoad, h.Type); err != nil {
+		return err
+	}
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func (be *faultBackend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	if err := be.faults.check(OpStat, h.Type); err != nil {
+		return backend.FileInfo{}, err
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+func (be *faultBackend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	if err := be.faults.check(OpList, t); err != nil {
+		return err
+	}
+	return be.Backend.List(ctx, t, fn)
+}
+
+func (be *faultBackend) Remove(ctx context.Context, h backend.Handle) error {
+	if err := be.faults.check(OpRemove, h.Type); err != nil {
+		return err
+	}
+	return be.Backend.Remove(ctx, h)
+}
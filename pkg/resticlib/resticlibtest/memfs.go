@@ -0,0 +1,100 @@
+package resticlibtest
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"testing/fstest"
+
+	"github.com/restic/restic/pkg/resticlib"
+)
+
+// MemFS is an in-memory resticlib.RestoreFS. Its content can be inspected
+// with the io/fs functions on the result of MapFS.
+type MemFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+var _ resticlib.RestoreFS = (*MemFS)(nil)
+
+// NewMemFS returns an empty MemFS
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(fstest.MapFS)}
+}
+
+// MkdirAll implements resticlib.RestoreFS
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if f, ok := m.files[dir]; ok {
+			if !f.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+			}
+			continue
+		}
+		if dir != name {
+			perm = 0755
+		}
+		m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | perm}
+	}
+	return nil
+}
+
+// Create implements resticlib.RestoreFS
+func (m *MemFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	return &memFile{fs: m, name: name, perm: perm}, nil
+}
+
+// Symlink implements resticlib.RestoreFS
+func (m *MemFS) Symlink(oldname, newname string) error {
+	if !fs.ValidPath(newname) || newname == "." {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrInvalid}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[newname] = &fstest.MapFile{Mode: fs.ModeSymlink | 0777, Data: []byte(oldname)}
+	return nil
+}
+
+// MapFS returns a copy of the files written so far
+func (m *MemFS) MapFS() fstest.MapFS {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make(fstest.MapFS, len(m.files))
+	for name, f := range m.files {
+		c := *f
+		files[name] = &c
+	}
+	return files
+}
+
+// memFile buffers the content of a file until it is closed
+type memFile struct {
+	fs   *MemFS
+	name string
+	perm fs.FileMode
+	buf  bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.fs.files[f.name] = &fstest.MapFile{Mode: f.perm, Data: bytes.Clone(f.buf.Bytes())}
+	return nil
+}
@@ -0,0 +1,83 @@
+package resticlibtest
+
+import (
+	"sync"
+
+	"github.com/restic/restic/pkg/resticlib"
+)
+
+// ProgressRecorder is a resticlib.ProgressReporter which records all calls
+type ProgressRecorder struct {
+	mu       sync.Mutex
+	total    uint64
+	done     uint64
+	errors   []string
+	finished bool
+
+	// ErrorResult is returned by Error (default: the reported error, which
+	// aborts the operation)
+	ErrorResult func(item string, err error) error
+}
+
+var _ resticlib.ProgressReporter = (*ProgressRecorder)(nil)
+
+// SetTotal implements resticlib.ProgressReporter
+func (p *ProgressRecorder) SetTotal(total uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+// Add implements resticlib.ProgressReporter
+func (p *ProgressRecorder) Add(delta uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += delta
+}
+
+// Error implements resticlib.ProgressReporter
+func (p *ProgressRecorder) Error(item string, err error) error {
+	p.mu.Lock()
+	p.errors = append(p.errors, item+": "+err.Error())
+	p.mu.Unlock()
+
+	if p.ErrorResult != nil {
+		return p.ErrorResult(item, err)
+	}
+	return err
+}
+
+// Finish implements resticlib.ProgressReporter
+func (p *ProgressRecorder) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished = true
+}
+
+// Total returns the last total set
+func (p *ProgressRecorder) Total() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// Done returns the sum of all added progress
+func (p *ProgressRecorder) Done() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+// Errors returns the reported errors as "item: error"
+func (p *ProgressRecorder) Errors() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.errors...)
+}
+
+// Finished reports whether Finish was called
+func (p *ProgressRecorder) Finished() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.finished
+}
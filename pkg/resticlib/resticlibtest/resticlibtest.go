@@ -0,0 +1,131 @@
+// Package resticlibtest provides in-memory repositories, snapshot fixtures, a
+// progress recorder, fault injection and an in-memory restore target for
+// deterministic tests of applications embedding resticlib.
+package resticlibtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/restic/restic/internal/backend"
+	"github.com/restic/restic/internal/backend/mem"
+	"github.com/restic/restic/pkg/resticlib"
+	"github.com/restic/restic/pkg/resticlib/internal/backendhook"
+)
+
+// Password is the password of the repositories created by this package
+const Password = "resticlibtest"
+
+// urlPrefix is the prefix of the repository URLs of in-memory backends
+const urlPrefix = "resticlibtest:"
+
+var (
+	// repoCounter makes the names of in-memory backends unique
+	repoCounter atomic.Uint64
+
+	backendsMu sync.Mutex
+	backends   = make(map[string]backend.Backend)
+)
+
+func init() {
+	backendhook.Open = openBackend
+}
+
+// openBackend returns the in-memory backend of a repository URL
+func openBackend(repoURL string) (backend.Backend, bool) {
+	if !strings.HasPrefix(repoURL, urlPrefix) {
+		return nil, false
+	}
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	be, ok := backends[repoURL]
+	return be, ok
+}
+
+// NewConfig returns the configuration of a new, uninitialized in-memory
+// repository. It can be passed to Init and Open any number of times until
+// the test completes. Errors can be injected with faults, which may be nil.
+func NewConfig(t testing.TB, faults *Faults) resticlib.Config {
+	t.Helper()
+
+	repoURL := fmt.Sprintf("%s%d", urlPrefix, repoCounter.Add(1))
+	if faults == nil {
+		faults = &Faults{}
+	}
+	backendsMu.Lock()
+	backends[repoURL] = &faultBackend{Backend: mem.New(), faults: faults}
+	backendsMu.Unlock()
+	t.Cleanup(func() {
+		backendsMu.Lock()
+		delete(backends, repoURL)
+		backendsMu.Unlock()
+	})
+
+	return resticlib.Config{
+		RepoURL:  repoURL,
+		Password: []byte(Password),
+	}
+}
+
+// NewRepository initializes an in-memory repository, which is closed when
+// the test completes
+func NewRepository(t testing.TB) resticlib.Repository {
+	t.Helper()
+	repo, _ := NewRepositoryWithFaults(t)
+	return repo
+}
+
+// NewRepositoryWithFaults initializes an in-memory repository and returns
+// the Faults to inject errors into its backend
+func NewRepositoryWithFaults(t testing.TB) (resticlib.Repository, *Faults) {
+	t.Helper()
+
+	faults := &Faults{}
+	repo, err := resticlib.Init(context.Background(), NewConfig(t, faults))
+	if err != nil {
+		t.Fatalf("failed to initialize repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo, faults
+}
+
+// WriteFiles creates the files in a new temporary directory and returns the
+// directory. The keys are slash separated paths relative to the directory,
+// the values the file contents.
+func WriteFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// Snapshot backs up the files, see WriteFiles, and returns the snapshot ID
+// and the backed up directory
+func Snapshot(t testing.TB, repo resticlib.Repository, files map[string]string, tags ...string) (resticlib.SnapshotID, string) {
+	t.Helper()
+
+	dir := WriteFiles(t, files)
+	summary, err := repo.Backup(context.Background(), resticlib.BackupOptions{
+		Paths: []string{dir},
+		Tags:  tags,
+	})
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	return summary.SnapshotID, dir
+}
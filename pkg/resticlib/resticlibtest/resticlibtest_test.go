@@ -0,0 +1,115 @@
+package resticlibtest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/restic/restic/pkg/resticlib"
+	"github.com/restic/restic/pkg/resticlib/resticlibtest"
+)
+
+func TestSnapshotFixture(t *testing.T) {
+	repo := resticlibtest.NewRepository(t)
+	ctx := context.Background()
+
+	id, dir := resticlibtest.Snapshot(t, repo, map[string]string{"docs/a.txt": "hello"}, "fixture")
+
+	snapshots, err := repo.Snapshots(ctx, resticlib.SnapshotFilter{Tags: []string{"fixture"}})
+	if err != nil {
+		t.Fatalf("Snapshots failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != id {
+		t.Fatalf("Expected the fixture snapshot, got %+v", snapshots)
+	}
+
+	var buf bytes.Buffer
+	if err := repo.DumpFile(ctx, id, dir+"/docs/a.txt", &buf); err != nil {
+		t.Fatalf("DumpFile failed: %v", err)
+	}
+	if buf.String() != "hello" {
+		t.Errorf("Expected file content %q, got %q", "hello", buf.String())
+	}
+}
+
+func TestReopen(t *testing.T) {
+	ctx := context.Background()
+	cfg := resticlibtest.NewConfig(t, nil)
+
+	repo, err := resticlib.Init(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	_ = repo.Close()
+
+	repo, err = resticlib.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	_ = repo.Close()
+}
+
+func TestFaults(t *testing.T) {
+	repo, faults := resticlibtest.NewRepositoryWithFaults(t)
+	dir := resticlibtest.WriteFiles(t, map[string]string{"a.txt": "content"})
+
+	faults.Inject(resticlibtest.Fault{Op: resticlibtest.OpSave, FileType: "snapshot", Count: 1})
+
+	progress := &resticlibtest.ProgressRecorder{}
+	_, err := repo.Backup(context.Background(), resticlib.BackupOptions{Paths: []string{dir}, Progress: progress})
+	if !errors.Is(err, resticlibtest.ErrInjected) {
+		t.Fatalf("Expected injected fault, got %v", err)
+	}
+	if !progress.Finished() || progress.Done() == 0 {
+		t.Errorf("Expected progress to be recorded, done %d, finished %v", progress.Done(), progress.Finished())
+	}
+
+	// the fault is used up
+	if _, err := repo.Backup(context.Background(), resticlib.BackupOptions{Paths: []string{dir}}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if faults.Calls(resticlibtest.OpSave) == 0 {
+		t.Error("Expected saves to be counted")
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	repo := resticlibtest.NewRepository(t)
+	ctx := context.Background()
+
+	id, dir := resticlibtest.Snapshot(t, repo, map[string]string{
+		"docs/a.txt":  "hello",
+		"docs/b.tmp":  "temporary",
+		"other/c.txt": "world",
+	})
+
+	target := resticlibtest.NewMemFS()
+	_, err := repo.Restore(ctx, id, resticlib.RestoreOptions{
+		Target:   target,
+		Includes: []string{dir + "/docs"},
+		Excludes: []string{"*.tmp"},
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	files := target.MapFS()
+	root := strings.TrimPrefix(dir, "/")
+	content, err := fs.ReadFile(files, root+"/docs/a.txt")
+	if err != nil || string(content) != "hello" {
+		t.Errorf("Expected file content %q, got %q (%v)", "hello", content, err)
+	}
+	for _, name := range []string{"docs/b.tmp", "other/c.txt"} {
+		if _, err := fs.Stat(files, root+"/"+name); err == nil {
+			t.Errorf("Expected %s not to be restored", name)
+		}
+	}
+
+	_, err = repo.Restore(ctx, id, resticlib.RestoreOptions{Target: target, Delete: true})
+	if err == nil {
+		t.Error("Expected an error for deleting files of a target filesystem")
+	}
+}
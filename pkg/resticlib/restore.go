@@ -2,19 +2,34 @@ package resticlib
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"os/user"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/fs"
+	"github.com/restic/restic/internal/restic"
 	"github.com/restic/restic/internal/restorer"
 	"github.com/restic/restic/internal/ui/progress"
 	"github.com/restic/restic/internal/ui/restore"
+	"github.com/restic/restic/internal/walker"
 )
 
 // restoreProgressWrapper adapts our ProgressReporter to restorer progress interface
 type restoreProgressPrinter struct {
 	reporter ProgressReporter
+
+	// actions collects the completed items if set
+	actions *[]RestoreAction
+	// deleted collects the deleted paths if set
+	deleted *[]string
+	// skipped counts the files which were already up to date if set
+	skipped *int
 }
 
 func (p *restoreProgressPrinter) Update(progress restore.State, duration time.Duration) {
@@ -29,11 +44,34 @@ func (p *restoreProgressPrinter) Error(item string, err error) error {
 }
 
 func (p *restoreProgressPrinter) CompleteItem(action restore.ItemAction, item string, size uint64) {
+	if p.actions != nil {
+		*p.actions = append(*p.actions, RestoreAction{
+			Path:   item,
+			Action: restoreActions[action],
+			Size:   size,
+		})
+	}
+	if p.deleted != nil && action == restore.ActionDeleted {
+		*p.deleted = append(*p.deleted, item)
+	}
+	if p.skipped != nil && action == restore.ActionFileUnchanged {
+		*p.skipped++
+	}
 	if p.reporter != nil {
 		p.reporter.Add(size)
 	}
 }
 
+// restoreActions maps the restorer actions to the planned actions
+var restoreActions = map[restore.ItemAction]string{
+	restore.ActionDirRestored:   RestoreActionCreate,
+	restore.ActionFileRestored:  RestoreActionCreate,
+	restore.ActionOtherRestored: RestoreActionCreate,
+	restore.ActionFileUpdated:   RestoreActionOverwrite,
+	restore.ActionFileUnchanged: RestoreActionSkip,
+	restore.ActionDeleted:       RestoreActionDelete,
+}
+
 func (p *restoreProgressPrinter) Finish(progress restore.State, duration time.Duration) {
 	if p.reporter != nil {
 		p.reporter.Finish()
@@ -80,29 +118,68 @@ func (p *restoreProgressPrinter) VV(msg string, args ...interface{}) {
 	// Debug messages
 }
 
-// Restore restores files from a snapshot
-func (r *repositoryImpl) Restore(ctx context.Context, snapshotID SnapshotID, opts RestoreOptions) error {
+// Restore restores files from a snapshot. With DryRun set nothing is written
+// and the report lists the planned action for each path.
+func (r *repositoryImpl) Restore(ctx context.Context, snapshotID SnapshotID, opts RestoreOptions) (RestoreReport, error) {
+	if err := r.begin(); err != nil {
+		return RestoreReport{}, err
+	}
+	defer r.end()
+
+	var report RestoreReport
+
+	if opts.DryRun && opts.Verify {
+		return report, errors.New("dry run and verify are mutually exclusive")
+	}
+	if opts.Target != nil && (opts.Delete || opts.DryRun || opts.Verify || opts.Resume != "") {
+		return report, errors.New("restores to a target filesystem do not support delete, dry run, verify and resume")
+	}
+
 	r.logf("info", "Starting restore from snapshot %s to %s", snapshotID, opts.TargetDir)
 
 	// Find and load snapshot (supports partial IDs)
-	sn, subfolder, err := data.FindSnapshot(ctx, r.repo, r.repo, string(snapshotID))
+	sn, subfolder, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
 	if err != nil {
-		return fmt.Errorf("failed to find snapshot: %w", err)
+		return report, fmt.Errorf("failed to find snapshot: %w", err)
 	}
 
-	// If there's a subfolder specified, we would handle it here
-	_ = subfolder // Currently unused
+	// the restore root is either given as path or as part of the reference
+	switch {
+	case len(opts.Paths) > 1:
+		return report, errors.New("only a single path can be restored")
+	case len(opts.Paths) == 1 && subfolder != "":
+		return report, errors.New("the path to restore is given both in the snapshot reference and in Paths")
+	case len(opts.Paths) == 1:
+		subfolder = opts.Paths[0]
+	}
 
 	// Load index
 	err = r.repo.LoadIndex(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to load index: %w", err)
+		return report, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	if subfolder != "" {
+		sn.Tree, err = data.FindTreeDirectory(ctx, r.repo, sn.Tree, subfolder)
+		if err != nil {
+			return report, fmt.Errorf("failed to find %q in snapshot: %w", subfolder, err)
+		}
 	}
 
 	// Set up progress reporting
 	var progress *restore.Progress
-	if opts.Progress != nil {
+	if opts.Progress != nil || opts.DryRun || opts.Delete || opts.Resume != "" {
 		printer := &restoreProgressPrinter{reporter: opts.Progress}
+		if opts.DryRun {
+			report.Actions = []RestoreAction{}
+			printer.actions = &report.Actions
+		}
+		if opts.Delete {
+			printer.deleted = &report.Deleted
+		}
+		if opts.Resume != "" {
+			printer.skipped = &report.FilesSkipped
+		}
 		progress = restore.NewProgress(printer, 0) // 0 means no automatic updates
 	}
 
@@ -112,11 +189,26 @@ func (r *repositoryImpl) Restore(ctx context.Context, snapshotID SnapshotID, opt
 		Progress:  progress,
 		Overwrite: restorer.OverwriteAlways, // Default overwrite behavior
 		Delete:    opts.Delete,
+		Warmup:    r.cfg.ColdStorage != nil,
 	}
 
-	if opts.Overwrite {
+	switch {
+	case opts.Resume != "" && opts.OverwritePolicy != "":
+		return report, errors.New("resume and overwrite policy are mutually exclusive")
+	case opts.Resume == ResumeMetadata:
+		restorerOpts.Overwrite = restorer.OverwriteIfChanged
+	case opts.Resume == ResumeContent:
+		// existing files are verified, only differing parts are restored
+		restorerOpts.Overwrite = restorer.OverwriteAlways
+	case opts.Resume != "":
+		return report, fmt.Errorf("invalid resume mode %q", opts.Resume)
+	case opts.OverwritePolicy != "":
+		if err := restorerOpts.Overwrite.Set(string(opts.OverwritePolicy)); err != nil {
+			return report, err
+		}
+	case opts.Overwrite:
 		restorerOpts.Overwrite = restorer.OverwriteAlways
-	} else {
+	default:
 		restorerOpts.Overwrite = restorer.OverwriteIfNewer
 	}
 
@@ -133,50 +225,303 @@ func (r *repositoryImpl) Restore(ctx context.Context, snapshotID SnapshotID, opt
 	if len(opts.Excludes) > 0 {
 		excludePatterns = opts.Excludes
 	}
+	if len(opts.IncludeFiles) > 0 {
+		patterns, err := ReadPatternFiles(opts.IncludeFiles...)
+		if err != nil {
+			return report, err
+		}
+		includePatterns = append(append([]string(nil), includePatterns...), patterns...)
+	}
+	if len(opts.ExcludeFiles) > 0 {
+		patterns, err := ReadPatternFiles(opts.ExcludeFiles...)
+		if err != nil {
+			return report, err
+		}
+		excludePatterns = append(append([]string(nil), excludePatterns...), patterns...)
+	}
 
-	// Set up selection function
-	selectFilter := func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
-		// Apply includes first (if any)
-		if len(includePatterns) > 0 {
-			matched := false
-			for _, pattern := range includePatterns {
-				if matched, _ = filepath.Match(pattern, item); matched {
-					break
-				}
-				if matched, _ = filepath.Match(pattern, filepath.Base(item)); matched {
-					break
-				}
-			}
-			if !matched {
-				return false, true // Don't restore this item, but children might match
-			}
+	// patterns use the syntax of the CLI
+	if err := filter.ValidatePatterns(append(append([]string(nil), includePatterns...), excludePatterns...)); err != nil {
+		return report, err
+	}
+	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		warnf := func(msg string, args ...interface{}) {
+			r.logf("warn", msg, args...)
 		}
+		res.SelectFilter = restoreSelectFilter(includePatterns, excludePatterns, warnf)
+	}
 
-		// Apply excludes
-		for _, pattern := range excludePatterns {
-			if matched, _ := filepath.Match(pattern, item); matched {
-				return false, false // Don't restore this item or children
-			}
-			if matched, _ := filepath.Match(pattern, filepath.Base(item)); matched {
-				return false, false // Don't restore this item or children
+	if len(opts.IncludeXattrs) > 0 || len(opts.ExcludeXattrs) > 0 || opts.SkipACLs {
+		warnf := func(msg string, args ...interface{}) {
+			r.logf("warn", msg, args...)
+		}
+		res.XattrSelectFilter, err = restoreXattrFilter(opts.IncludeXattrs, opts.ExcludeXattrs, opts.SkipACLs, warnf)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	res.SkipMetadata = fs.MetadataSkip{
+		Ownership:   opts.SkipOwnership,
+		Permissions: opts.SkipPermissions,
+		Timestamps:  opts.SkipTimestamps,
+	}
+	if opts.SkipOwnership || opts.SkipPermissions || opts.SkipTimestamps || opts.SkipACLs {
+		res.MetadataError = func(location string, err error) error {
+			r.logf("warn", "Failed to restore metadata of %s: %v", location, err)
+			report.Notices = append(report.Notices, RestoreNotice{Path: location, Message: err.Error()})
+			return nil
+		}
+	}
+
+	if opts.Owners != nil {
+		res.MapOwner, err = opts.Owners.mapper()
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if sn.HasTags([]string{metadataOnlyTag}) {
+		r.logf("warn", "Snapshot %s is metadata-only, files are restored as empty placeholders", sn.ID().Str())
+		err := r.walkSelected(ctx, *sn.Tree, res.SelectFilter, func(nodepath string, node *data.Node) error {
+			size, ok := metadataOnlySize(node)
+			if ok {
+				report.Notices = append(report.Notices, RestoreNotice{
+					Path:    nodepath,
+					Message: fmt.Sprintf("metadata-only placeholder, the original file had %d bytes", size),
+				})
 			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to list placeholders: %w", err)
 		}
+	}
 
-		return true, true
+	if opts.Progress != nil {
+		total, err := r.restoreTotal(ctx, *sn.Tree, res.SelectFilter)
+		if err != nil {
+			return report, fmt.Errorf("failed to compute restore size: %w", err)
+		}
+		opts.Progress.SetTotal(total)
 	}
 
-	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
-		res.SelectFilter = selectFilter
+	if opts.Target != nil {
+		filesRestored, err := r.restoreToFS(ctx, sn, res.SelectFilter, opts.Target, opts.Progress)
+		if err != nil {
+			return report, fmt.Errorf("restore failed: %w", err)
+		}
+		r.logf("info", "Restored %d files to the target filesystem", filesRestored)
+		return report, nil
 	}
 
 	// Perform restore
 	filesRestored, err := res.RestoreTo(ctx, opts.TargetDir)
 	if err != nil {
-		return fmt.Errorf("restore failed: %w", err)
+		return report, fmt.Errorf("restore failed: %w", err)
 	}
 
 	r.logf("info", "Restored %d files", filesRestored)
 
+	if opts.DryRun {
+		r.logf("info", "Dry run: restore would perform %d actions in %s", len(report.Actions), opts.TargetDir)
+		return report, nil
+	}
+
+	if opts.Verify {
+		if err := r.verifyRestoredFiles(ctx, res, opts.TargetDir, filesRestored, &report); err != nil {
+			return report, err
+		}
+	}
+
 	r.logf("info", "Restore completed successfully to %s", opts.TargetDir)
+	return report, nil
+}
+
+// metadataOnlySize returns the original size of a placeholder stored by a
+// metadata-only backup
+func metadataOnlySize(node *data.Node) (uint64, bool) {
+	raw, ok := node.GenericAttributes[data.TypeMetadataOnly]
+	if !ok || node.Type != data.NodeTypeFile {
+		return 0, false
+	}
+	var size uint64
+	if err := json.Unmarshal(raw, &size); err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+// walkSelected calls fn for all nodes of a tree which are selected by
+// selectFilter, in the order in which they are restored. Without a filter
+// all nodes are selected.
+func (r *repositoryImpl) walkSelected(ctx context.Context, tree restic.ID, selectFilter func(item string, isDir bool) (bool, bool), fn func(nodepath string, node *data.Node) error) error {
+	return walker.Walk(ctx, r.repo, tree, walker.WalkVisitor{ProcessNode: func(_ restic.ID, nodepath string, node *data.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return nil
+		}
+
+		isDir := node.Type == data.NodeTypeDir
+		selected, childMayBeSelected := true, true
+		if selectFilter != nil {
+			selected, childMayBeSelected = selectFilter(nodepath, isDir)
+		}
+		if !selected {
+			// skipping a file would skip the rest of its directory
+			if isDir && !childMayBeSelected {
+				return walker.ErrSkipNode
+			}
+			return nil
+		}
+		return fn(nodepath, node)
+	}})
+}
+
+// restoreTotal returns the size of the files of a tree which are selected by
+// selectFilter
+func (r *repositoryImpl) restoreTotal(ctx context.Context, tree restic.ID, selectFilter func(item string, isDir bool) (bool, bool)) (uint64, error) {
+	if selectFilter == nil {
+		size, err := r.treeSize(ctx, tree)
+		return size.Bytes, err
+	}
+
+	var total uint64
+	err := r.walkSelected(ctx, tree, selectFilter, func(_ string, node *data.Node) error {
+		if node.Type == data.NodeTypeFile {
+			total += node.Size
+		}
+		return nil
+	})
+	return total, err
+}
+
+// verifyRestoredFiles rereads the restored files and compares them with the
+// snapshot, mismatches are added to the report
+func (r *repositoryImpl) verifyRestoredFiles(ctx context.Context, res *restorer.Restorer, targetDir string, filesRestored uint64, report *RestoreReport) error {
+	r.logf("info", "Verifying files in %s", targetDir)
+
+	var mu sync.Mutex
+	res.Error = func(location string, err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		r.logf("warn", "Verification of %s failed: %v", location, err)
+		report.Mismatches = append(report.Mismatches, RestoreNotice{Path: location, Message: err.Error()})
+		return nil
+	}
+
+	count, err := res.VerifyFiles(ctx, targetDir, filesRestored, nil)
+	report.FilesVerified = count
+	if err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	if len(report.Mismatches) > 0 {
+		return fmt.Errorf("verification failed for %d of %d files", len(report.Mismatches), count+len(report.Mismatches))
+	}
+
+	r.logf("info", "Verified %d files", count)
 	return nil
 }
+
+// mapper resolves the name mappings and returns a function which translates
+// the owner of a node
+func (m *OwnerMapping) mapper() (func(node *data.Node) (uid, gid uint32), error) {
+	users := make(map[string]uint32, len(m.Users))
+	for from, to := range m.Users {
+		u, err := user.Lookup(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map user %q: %w", from, err)
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map user %q: invalid uid %q", from, u.Uid)
+		}
+		users[from] = uint32(uid)
+	}
+
+	groups := make(map[string]uint32, len(m.Groups))
+	for from, to := range m.Groups {
+		g, err := user.LookupGroup(to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map group %q: %w", from, err)
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map group %q: invalid gid %q", from, g.Gid)
+		}
+		groups[from] = uint32(gid)
+	}
+
+	return func(node *data.Node) (uint32, uint32) {
+		uid, ok := users[node.User]
+		if !ok {
+			uid, ok = m.UIDs[node.UID]
+		}
+		if !ok {
+			uid = node.UID
+		}
+
+		gid, ok := groups[node.Group]
+		if !ok {
+			gid, ok = m.GIDs[node.GID]
+		}
+		if !ok {
+			gid = node.GID
+		}
+		return uid, gid
+	}, nil
+}
+
+// restoreSelectFilter selects the items matching one of the include patterns,
+// or all items if there are none, unless they match an exclude pattern. Like
+// the CLI, the contents of excluded directories are not restored.
+func restoreSelectFilter(includes, excludes []string, warnf func(msg string, args ...interface{})) func(item string, isDir bool) (bool, bool) {
+	include := filter.IncludeByPattern(includes, warnf)
+	reject := filter.RejectByPattern(excludes, warnf)
+
+	return func(item string, isDir bool) (selectedForRestore bool, childMayBeSelected bool) {
+		if reject(item) {
+			return false, false
+		}
+
+		selectedForRestore, childMayBeSelected = true, true
+		if len(includes) > 0 {
+			selectedForRestore, childMayBeSelected = include(item)
+		}
+		return selectedForRestore, childMayBeSelected && isDir
+	}
+}
+
+// aclXattrs are the extended attributes holding POSIX ACLs
+var aclXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// restoreXattrFilter returns the filter selecting the extended attributes to
+// restore. Include and exclude patterns are mutually exclusive, skipACLs
+// excludes the ACL attributes in either case.
+func restoreXattrFilter(includes, excludes []string, skipACLs bool, warnf func(msg string, args ...interface{})) (func(xattrName string) bool, error) {
+	if len(includes) > 0 && len(excludes) > 0 {
+		return nil, errors.New("exclude and include xattr patterns are mutually exclusive")
+	}
+	if err := filter.ValidatePatterns(append(append([]string(nil), includes...), excludes...)); err != nil {
+		return nil, fmt.Errorf("invalid xattr pattern: %w", err)
+	}
+	if skipACLs {
+		excludes = append(append([]string(nil), excludes...), aclXattrs...)
+	}
+
+	include := filter.IncludeByPattern(includes, warnf)
+	reject := filter.RejectByPattern(excludes, warnf)
+
+	return func(xattrName string) bool {
+		if reject(xattrName) {
+			return false
+		}
+		if len(includes) > 0 {
+			selected, _ := include(xattrName)
+			return selected
+		}
+		return true
+	}, nil
+}
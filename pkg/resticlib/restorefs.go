@@ -0,0 +1,79 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"path"
+	"strings"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/dump"
+)
+
+// RestoreFS is a writable filesystem a snapshot can be restored into instead
+// of a local directory, e.g. object storage, an in-memory filesystem or a
+// container volume. Names are slash separated and relative to the root of
+// the filesystem, like the names of io/fs.
+type RestoreFS interface {
+	// MkdirAll creates a directory along with any missing parents
+	MkdirAll(name string, perm iofs.FileMode) error
+
+	// Create creates or truncates a file, its content is written to the
+	// returned writer and is complete once the writer is closed
+	Create(name string, perm iofs.FileMode) (io.WriteCloser, error)
+
+	// Symlink creates newname as symbolic link to oldname
+	Symlink(oldname, newname string) error
+}
+
+// restoreToFS writes the selected files, directories and symlinks of a
+// snapshot to target and returns the number of restored files. Other node
+// types and all metadata except for permissions are not restored.
+func (r *repositoryImpl) restoreToFS(ctx context.Context, sn *data.Snapshot, selectFilter func(item string, isDir bool) (bool, bool), target RestoreFS, reporter ProgressReporter) (uint64, error) {
+	var filesRestored uint64
+	err := r.walkSelected(ctx, *sn.Tree, selectFilter, func(nodepath string, node *data.Node) error {
+		name := strings.TrimPrefix(nodepath, "/")
+		if node.Type != data.NodeTypeDir {
+			// parent directories may not be selected themselves
+			if err := target.MkdirAll(path.Dir(name), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", nodepath, err)
+			}
+		}
+
+		switch node.Type {
+		case data.NodeTypeDir:
+			if err := target.MkdirAll(name, node.Mode.Perm()); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", nodepath, err)
+			}
+		case data.NodeTypeFile:
+			w, err := target.Create(name, node.Mode.Perm())
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", nodepath, err)
+			}
+			err = dump.New("tar", r.repo, w).WriteNode(ctx, node)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", nodepath, err)
+			}
+			filesRestored++
+			if reporter != nil {
+				reporter.Add(node.Size)
+			}
+		case data.NodeTypeSymlink:
+			if err := target.Symlink(node.LinkTarget, name); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", nodepath, err)
+			}
+		default:
+			r.logf("debug", "Skipping %s of type %s", nodepath, node.Type)
+		}
+		return nil
+	})
+	if reporter != nil {
+		reporter.Finish()
+	}
+	return filesRestored, err
+}
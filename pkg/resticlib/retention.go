@@ -0,0 +1,110 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// retentionTagPrefix marks snapshots which must not be removed before the
+// time following the prefix
+const retentionTagPrefix = "retain-until:"
+
+// RetainSnapshot retention-locks a snapshot until the given time, no
+// operation of this package removes it before. The lock is stored as a tag
+// of the snapshot, which changes the snapshot ID like "restic tag" does, the
+// new ID is returned. Existing locks are only ever extended.
+//
+// The lock is only honored by this package. It does not protect against
+// other clients: the restic CLI removes the snapshot with "restic forget"
+// and the tag with "restic tag --remove". Use an object lock of the backend
+// for protection against deletion.
+func (r *repositoryImpl) RetainSnapshot(ctx context.Context, snapshotID SnapshotID, until time.Time) (SnapshotID, error) {
+	if err := r.begin(); err != nil {
+		return "", err
+	}
+	defer r.end()
+
+	ctx, unlock, err := r.lock(ctx, LockOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	sn, _, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return "", fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	if current, ok := retainedUntil(sn); ok && !until.After(current) {
+		r.logf("info", "Snapshot %s is already retained until %s", sn.ID().Str(), current.Format(time.RFC3339))
+		return SnapshotID(sn.ID().String()), nil
+	}
+
+	var tags []string
+	for _, tag := range sn.Tags {
+		if !strings.HasPrefix(tag, retentionTagPrefix) {
+			tags = append(tags, tag)
+		}
+	}
+	sn.Tags = append(tags, retentionTagPrefix+until.UTC().Format(time.RFC3339))
+
+	// retain the original snapshot ID over all changes
+	oldID := *sn.ID()
+	if sn.Original == nil {
+		sn.Original = &oldID
+	}
+
+	id, err := data.SaveSnapshot(ctx, r.repo, sn)
+	if err != nil {
+		return "", fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	// the new snapshot carries the lock of the old one, so the old one may
+	// be removed despite its lock
+	if err := r.repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, oldID); err != nil {
+		return "", fmt.Errorf("failed to remove old snapshot: %w", err)
+	}
+
+	r.logf("info", "Snapshot %s is retained until %s as %s", oldID.Str(), until.Format(time.RFC3339), id.Str())
+	return SnapshotID(id.String()), nil
+}
+
+// retainedUntil returns the end of the retention lock of a snapshot
+func retainedUntil(sn *data.Snapshot) (time.Time, bool) {
+	var until time.Time
+	for _, tag := range sn.Tags {
+		value, ok := strings.CutPrefix(tag, retentionTagPrefix)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err == nil && t.After(until) {
+			until = t
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// checkRetention returns ErrSnapshotRetained if the retention lock of a
+// snapshot has not expired yet
+func checkRetention(sn *data.Snapshot) error {
+	if until, ok := retainedUntil(sn); ok && time.Now().Before(until) {
+		return fmt.Errorf("%w: snapshot %s is retained until %s",
+			ErrSnapshotRetained, sn.ID().Str(), until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// removeSnapshot removes a snapshot unless it is retention-locked. All
+// operations removing snapshots must use it.
+func removeSnapshot(ctx context.Context, repo *repository.Repository, sn *data.Snapshot) error {
+	if err := checkRetention(sn); err != nil {
+		return err
+	}
+	return repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, *sn.ID())
+}
@@ -0,0 +1,161 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/filter"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+// RewriteOptions configures Rewrite
+type RewriteOptions struct {
+	// Excludes are removed from the snapshots, patterns use the syntax of
+	// restic's --exclude, e.g. "/home/*/.cache" or "*.key"
+	Excludes []string `json:"excludes,omitempty"`
+	// InsensitiveExcludes are like Excludes but case insensitive
+	InsensitiveExcludes []string `json:"insensitive_excludes,omitempty"`
+
+	// Forget removes the original snapshots, otherwise the rewritten
+	// snapshots are tagged "rewrite" and the originals are kept. Only
+	// forgetting the originals and a subsequent prune remove the data.
+	Forget bool `json:"forget,omitempty"`
+
+	// DryRun reports the excluded paths without changing the repository
+	DryRun bool `json:"dry_run,omitempty"`
+
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// RewrittenSnapshot describes the rewrite of one snapshot
+type RewrittenSnapshot struct {
+	Original SnapshotID `json:"original"`
+
+	// New is the rewritten snapshot, empty for dry runs
+	New SnapshotID `json:"new,omitempty"`
+
+	// Excluded lists the removed files and directories, the contents of
+	// removed directories are not listed
+	Excluded []string `json:"excluded"`
+}
+
+// RewriteReport describes the changes made by Rewrite
+type RewriteReport struct {
+	Rewritten []RewrittenSnapshot `json:"rewritten,omitempty"`
+	Unchanged int                 `json:"unchanged"`
+}
+
+// Rewrite creates copies of snapshots with the excluded paths removed, like
+// restic rewrite. Snapshots without excluded paths are not changed.
+func (r *repositoryImpl) Rewrite(ctx context.Context, snapshotIDs []SnapshotID, opts RewriteOptions) (report RewriteReport, err error) {
+	if err := r.begin(); err != nil {
+		return RewriteReport{}, err
+	}
+	defer r.end()
+
+	start := time.Now()
+	defer func(ctx context.Context) { r.notify(ctx, "rewrite", start, report, true, err) }(ctx)
+
+	if len(snapshotIDs) == 0 {
+		return RewriteReport{}, errors.New("no snapshots specified")
+	}
+	if len(opts.Excludes) == 0 && len(opts.InsensitiveExcludes) == 0 {
+		return RewriteReport{}, errors.New("no excludes specified")
+	}
+	if err := filter.ValidatePatterns(append(append([]string(nil), opts.Excludes...), opts.InsensitiveExcludes...)); err != nil {
+		return RewriteReport{}, err
+	}
+
+	warnf := func(msg string, args ...interface{}) {
+		r.logf("warn", msg, args...)
+	}
+	rejects := []filter.RejectByNameFunc{
+		filter.RejectByPattern(opts.Excludes, warnf),
+		filter.RejectByInsensitivePattern(append([]string(nil), opts.InsensitiveExcludes...), warnf),
+	}
+
+	if !opts.DryRun {
+		if opts.Lock.NoLock {
+			return RewriteReport{}, errors.New("rewriting snapshots requires a lock")
+		}
+		var unlock func()
+		ctx, unlock, err = r.lock(ctx, opts.Lock)
+		if err != nil {
+			return RewriteReport{}, err
+		}
+		defer unlock()
+	}
+
+	snapshots, err := r.selectSnapshots(ctx, snapshotIDs, SnapshotFilter{})
+	if err != nil {
+		return RewriteReport{}, err
+	}
+
+	// nothing is written to the repository in dry runs
+	repo := r.repo
+	if opts.DryRun {
+		repo, err = r.dryRunRepository(ctx)
+		if err != nil {
+			return RewriteReport{}, err
+		}
+	}
+	err = repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return RewriteReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			return report, fmt.Errorf("snapshot %s has no tree", sn.ID().Str())
+		}
+
+		var excluded []string
+		rewriter, querySize := walker.NewSnapshotSizeRewriter(func(node *data.Node, path string) *data.Node {
+			for _, reject := range rejects {
+				if reject(path) {
+					excluded = append(excluded, path)
+					return nil
+				}
+			}
+			return node
+		})
+
+		oldID := *sn.ID()
+		newID, changed, err := r.replaceSnapshot(ctx, repo, sn, func(ctx context.Context) (restic.ID, error) {
+			tree, err := rewriter.RewriteTree(ctx, repo, "/", *sn.Tree)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			// the summary reflects the remaining files
+			if sn.Summary != nil {
+				size := querySize()
+				sn.Summary.TotalFilesProcessed = size.FileCount
+				sn.Summary.TotalBytesProcessed = size.FileSize
+			}
+			return tree, nil
+		}, opts.DryRun, opts.Forget, "rewrite")
+		if err != nil {
+			return report, fmt.Errorf("failed to rewrite snapshot %s: %w", oldID.Str(), err)
+		}
+		if !changed {
+			report.Unchanged++
+			continue
+		}
+
+		rewritten := RewrittenSnapshot{
+			Original: SnapshotID(oldID.String()),
+			Excluded: excluded,
+		}
+		if !newID.IsNull() {
+			rewritten.New = SnapshotID(newID.String())
+		}
+		report.Rewritten = append(report.Rewritten, rewritten)
+	}
+
+	r.logf("info", "Rewrote %d snapshots, %d unchanged", len(report.Rewritten), report.Unchanged)
+	return report, nil
+}
@@ -0,0 +1,76 @@
+package resticlib
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/fs"
+)
+
+// scanReportInterval limits how often intermediate scan results are reported
+const scanReportInterval = 100 * time.Millisecond
+
+// BackupScan is the size of a backup as estimated by the scanner, which
+// traverses the backup paths concurrently with the backup
+type BackupScan struct {
+	Files uint   `json:"files"`
+	Dirs  uint   `json:"dirs"`
+	Bytes uint64 `json:"bytes"`
+
+	// Complete is set for the final result of the scanner
+	Complete bool `json:"complete"`
+}
+
+// ScanReporter can be implemented in addition to ProgressReporter to
+// receive the file and directory counts found by the scanner of a backup
+type ScanReporter interface {
+	Scan(scan BackupScan)
+}
+
+// startScan runs the scanner on the targets and reports the total size of
+// the backup to reporter. The returned function stops the scanner and
+// waits for it to exit.
+func (r *repositoryImpl) startScan(ctx context.Context, arch *archiver.Archiver, filesystem fs.FS, targets []string, reporter ProgressReporter) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	scanReporter, _ := reporter.(ScanReporter)
+
+	var last time.Time
+	report := func(stats archiver.ScanStats, complete bool) {
+		if !complete && time.Since(last) < scanReportInterval {
+			return
+		}
+		last = time.Now()
+		reporter.SetTotal(stats.Bytes)
+		if scanReporter != nil {
+			scanReporter.Scan(BackupScan{Files: stats.Files, Dirs: stats.Dirs, Bytes: stats.Bytes, Complete: complete})
+		}
+	}
+
+	sc := archiver.NewScanner(filesystem)
+	sc.SelectByName = arch.SelectByName
+	sc.Select = arch.Select
+	// errors are reported by the archiver
+	sc.Error = func(string, error) error { return nil }
+	sc.Result = func(item string, stats archiver.ScanStats) {
+		// the scan is cut short once the backup has completed
+		if ctx.Err() == nil {
+			report(stats, item == "")
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := sc.Scan(ctx, targets); err != nil && ctx.Err() == nil {
+			r.logf("warn", "Failed to estimate backup size: %v", err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
@@ -2,17 +2,26 @@ package resticlib
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path"
 	"sort"
 	"strings"
-	"time"
 
+	"github.com/hashicorp/golang-lru/v2/simplelru"
 	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
 	"github.com/restic/restic/internal/restic"
+	"golang.org/x/sync/errgroup"
 )
 
 // Snapshots lists snapshots matching the filter
 func (r *repositoryImpl) Snapshots(ctx context.Context, filter SnapshotFilter) ([]Snapshot, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
 	r.logf("debug", "Listing snapshots with filter: %+v", filter)
 
 	// Load all snapshots from repository
@@ -48,16 +57,189 @@ func (r *repositoryImpl) Snapshots(ctx context.Context, filter SnapshotFilter) (
 		filteredSnapshots = filteredSnapshots[:filter.Limit]
 	}
 
+	// Sizes are computed from the trees, which requires the index
+	if filter.WithSizes && len(filteredSnapshots) > 0 {
+		err = r.repo.LoadIndex(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load index: %w", err)
+		}
+	}
+
 	// Convert to library types
 	result := make([]Snapshot, len(filteredSnapshots))
 	for i, sn := range filteredSnapshots {
 		result[i] = r.convertSnapshot(sn)
+
+		if filter.WithSizes {
+			size, err := r.treeSize(ctx, *sn.Tree)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compute size of snapshot %s: %w", sn.ID().Str(), err)
+			}
+			result[i].Size = &size
+		}
 	}
 
 	r.logf("info", "Found %d snapshots matching criteria", len(result))
 	return result, nil
 }
 
+// ResolveSnapshot returns the full ID of the snapshot a reference points to.
+// The reference is either "latest", the newest snapshot matching filter, or
+// a full or abbreviated snapshot ID, for which the filter is ignored. A
+// subfolder suffix such as "latest:/home/user" is kept in the result.
+func (r *repositoryImpl) ResolveSnapshot(ctx context.Context, ref string, filter SnapshotFilter) (SnapshotID, error) {
+	if err := r.begin(); err != nil {
+		return "", err
+	}
+	defer r.end()
+
+	sn, subfolder, err := r.findSnapshot(ctx, ref, filter)
+	if err != nil {
+		return "", err
+	}
+
+	id := sn.ID().String()
+	if subfolder != "" {
+		id += ":" + subfolder
+	}
+	r.logf("debug", "Resolved snapshot %s to %s", ref, id)
+	return SnapshotID(id), nil
+}
+
+// findSnapshot loads the snapshot a reference points to, see ResolveSnapshot,
+// and returns it along with the subfolder of the reference
+func (r *repositoryImpl) findSnapshot(ctx context.Context, ref string, filter SnapshotFilter) (*data.Snapshot, string, error) {
+	id, subfolder, _ := strings.Cut(ref, ":")
+	if id != "latest" {
+		sn, subfolder, err := data.FindSnapshot(ctx, r.repo, r.repo, ref)
+		var noID *restic.NoIDByPrefixError
+		if errors.As(err, &noID) {
+			err = fmt.Errorf("%w: %v", ErrSnapshotNotFound, err)
+		}
+		return sn, subfolder, err
+	}
+
+	snapshots, err := r.selectSnapshots(ctx, nil, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	var latest *data.Snapshot
+	for _, sn := range snapshots {
+		if latest == nil || sn.Time.After(latest.Time) {
+			latest = sn
+		}
+	}
+	if latest == nil {
+		return nil, "", fmt.Errorf("%w: no snapshot matches the filter for %q", ErrSnapshotNotFound, ref)
+	}
+	return latest, subfolder, nil
+}
+
+// selectSnapshots loads the given snapshots or, if there are none, all
+// snapshots matching the filter
+func (r *repositoryImpl) selectSnapshots(ctx context.Context, ids []SnapshotID, filter SnapshotFilter) ([]*data.Snapshot, error) {
+	var snapshots []*data.Snapshot
+	if len(ids) > 0 {
+		for _, id := range ids {
+			sn, _, err := r.findSnapshot(ctx, string(id), filter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find snapshot %s: %w", id, err)
+			}
+			snapshots = append(snapshots, sn)
+		}
+		return snapshots, nil
+	}
+
+	err := r.repo.List(ctx, restic.SnapshotFile, func(id restic.ID, _ int64) error {
+		sn, err := data.LoadSnapshot(ctx, r.repo, id)
+		if err != nil {
+			r.logf("warn", "Failed to load snapshot %s: %v", id.Str(), err)
+			return nil
+		}
+		if r.matchesFilter(sn, filter) {
+			snapshots = append(snapshots, sn)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// maxTreeSizes bounds the number of trees whose size is cached
+const maxTreeSizes = 64 * 1024
+
+// treeSize returns the number and total size of all files below the given
+// tree. The results for the most recently visited subtrees are cached.
+func (r *repositoryImpl) treeSize(ctx context.Context, id restic.ID) (SnapshotSize, error) {
+	var size SnapshotSize
+	ok := false
+	r.treeSizesMu.Lock()
+	if r.treeSizes != nil {
+		size, ok = r.treeSizes.Get(id)
+	}
+	r.treeSizesMu.Unlock()
+	if ok {
+		return size, nil
+	}
+
+	tree, err := data.LoadTree(ctx, r.repo, id)
+	if err != nil {
+		return SnapshotSize{}, err
+	}
+
+	for _, node := range tree.Nodes {
+		switch node.Type {
+		case data.NodeTypeFile:
+			size.Files++
+			size.Bytes += node.Size
+		case data.NodeTypeDir:
+			size.Dirs++
+			if node.Subtree == nil {
+				continue
+			}
+			sub, err := r.treeSize(ctx, *node.Subtree)
+			if err != nil {
+				return SnapshotSize{}, err
+			}
+			size.Files += sub.Files
+			size.Dirs += sub.Dirs
+			size.Bytes += sub.Bytes
+		}
+	}
+
+	r.treeSizesMu.Lock()
+	if r.treeSizes == nil {
+		r.treeSizes, err = simplelru.NewLRU[restic.ID, SnapshotSize](maxTreeSizes, nil)
+		if err != nil {
+			r.treeSizesMu.Unlock()
+			return SnapshotSize{}, err
+		}
+	}
+	r.treeSizes.Add(id, size)
+	r.treeSizesMu.Unlock()
+
+	return size, nil
+}
+
+// resetTreeSizes drops the cached tree sizes
+func (r *repositoryImpl) resetTreeSizes() {
+	r.treeSizesMu.Lock()
+	r.treeSizes = nil
+	r.treeSizesMu.Unlock()
+}
+
+// pathWithin returns true if p is dir or a path below dir, comparing whole
+// path components such that "/database" is not within "/data"
+func pathWithin(p, dir string) bool {
+	p, dir = path.Clean(p), path.Clean(dir)
+	if p == dir || dir == "/" {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
 // matchesFilter checks if a snapshot matches the given filter criteria
 func (r *repositoryImpl) matchesFilter(sn *data.Snapshot, filter SnapshotFilter) bool {
 	// Check hosts
@@ -79,7 +261,7 @@ func (r *repositoryImpl) matchesFilter(sn *data.Snapshot, filter SnapshotFilter)
 		found := false
 		for _, filterPath := range filter.Paths {
 			for _, snPath := range sn.Paths {
-				if strings.Contains(snPath, filterPath) {
+				if pathWithin(snPath, filterPath) {
 					found = true
 					break
 				}
@@ -113,18 +295,12 @@ func (r *repositoryImpl) matchesFilter(sn *data.Snapshot, filter SnapshotFilter)
 	}
 
 	// Check time range
-	if filter.Since != nil {
-		since, err := time.Parse(time.RFC3339, *filter.Since)
-		if err == nil && sn.Time.Before(since) {
-			return false
-		}
+	if filter.Since != nil && sn.Time.Before(*filter.Since) {
+		return false
 	}
 
-	if filter.Until != nil {
-		until, err := time.Parse(time.RFC3339, *filter.Until)
-		if err == nil && sn.Time.After(until) {
-			return false
-		}
+	if filter.Until != nil && sn.Time.After(*filter.Until) {
+		return false
 	}
 
 	return true
@@ -134,7 +310,7 @@ func (r *repositoryImpl) matchesFilter(sn *data.Snapshot, filter SnapshotFilter)
 func (r *repositoryImpl) convertSnapshot(sn *data.Snapshot) Snapshot {
 	result := Snapshot{
 		ID:       SnapshotID(sn.ID().String()),
-		Time:     sn.Time.Format(time.RFC3339),
+		Time:     sn.Time,
 		Tree:     sn.Tree.String(),
 		Paths:    sn.Paths,
 		Hostname: sn.Hostname,
@@ -147,5 +323,81 @@ func (r *repositoryImpl) convertSnapshot(sn *data.Snapshot) Snapshot {
 		result.Parent = &parent
 	}
 
+	if until, ok := retainedUntil(sn); ok {
+		result.RetainUntil = &until
+	}
+
 	return result
 }
+
+// replaceSnapshot rewrites the tree of a snapshot using rewrite and saves the
+// result as new snapshot, which references the original one. Unless forget
+// is set, the original snapshot is kept and the new one gets the tag addTag.
+// A null tree removes the snapshot. Snapshots which would be removed must not
+// be retention-locked. In dry runs nothing is saved or removed.
+// The new snapshot ID is null if the snapshot was removed or, in dry runs,
+// would have been replaced.
+func (r *repositoryImpl) replaceSnapshot(ctx context.Context, repo *repository.Repository, sn *data.Snapshot,
+	rewrite func(ctx context.Context) (restic.ID, error), dryRun, forget bool, addTag string) (newID restic.ID, changed bool, err error) {
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+
+	var tree restic.ID
+	wg.Go(func() error {
+		var err error
+		tree, err = rewrite(wgCtx)
+		if err != nil {
+			return err
+		}
+		return repo.Flush(wgCtx)
+	})
+	if err := wg.Wait(); err != nil {
+		return restic.ID{}, false, err
+	}
+
+	oldID := *sn.ID()
+	if tree.IsNull() {
+		if err := checkRetention(sn); err != nil {
+			return restic.ID{}, false, err
+		}
+		if !dryRun {
+			if err := removeSnapshot(ctx, repo, sn); err != nil {
+				return restic.ID{}, false, fmt.Errorf("failed to remove snapshot: %w", err)
+			}
+			r.logf("info", "Removed empty snapshot %s", oldID.Str())
+		}
+		return restic.ID{}, true, nil
+	}
+	if tree == *sn.Tree {
+		return oldID, false, nil
+	}
+	if forget {
+		if err := checkRetention(sn); err != nil {
+			return restic.ID{}, false, err
+		}
+	}
+	if dryRun {
+		return restic.ID{}, true, nil
+	}
+
+	sn.Original = &oldID
+	sn.Tree = &tree
+	if !forget {
+		sn.AddTags([]string{addTag})
+	}
+	newID, err = data.SaveSnapshot(ctx, repo, sn)
+	if err != nil {
+		return restic.ID{}, false, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	if forget {
+		// the retention of the original was checked above, sn now describes
+		// the new snapshot
+		if err := repo.RemoveUnpacked(ctx, restic.WriteableSnapshotFile, oldID); err != nil {
+			return restic.ID{}, false, fmt.Errorf("failed to remove old snapshot: %w", err)
+		}
+	}
+	r.logf("info", "Replaced snapshot %s by %s", oldID.Str(), newID.Str())
+	return newID, true, nil
+}
@@ -0,0 +1,201 @@
+package resticlib
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/fs"
+)
+
+// sourceFS makes an io/fs.FS available to the archiver. The files of the
+// source are presented as absolute slash separated paths, "/" being the root
+// of the source. Symlinks are skipped when listing directories, as io/fs
+// cannot represent them and following them may never end. Owners are not
+// part of io/fs either, files are owned by UID and GID 0.
+type sourceFS struct {
+	fsys iofs.FS
+}
+
+// lstatFS is implemented by file systems which can describe a symlink instead
+// of following it, like os.DirFS and fstest.MapFS in newer Go versions
+type lstatFS interface {
+	iofs.FS
+	Lstat(name string) (iofs.FileInfo, error)
+}
+
+func newSourceFS(fsys iofs.FS) *sourceFS {
+	return &sourceFS{fsys: fsys}
+}
+
+// statically ensure that sourceFS implements fs.FS
+var _ fs.FS = &sourceFS{}
+
+// name returns the io/fs name of an absolute path
+func (s *sourceFS) name(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (s *sourceFS) OpenFile(name string, flag int, metadataOnly bool) (fs.File, error) {
+	fi, err := s.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&fs.O_DIRECTORY != 0 && !fi.Mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	f := &sourceFile{fsys: s.fsys, name: s.name(name), path: name, fi: fi}
+	if !metadataOnly {
+		if err := f.MakeReadable(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (s *sourceFS) Lstat(name string) (*fs.ExtendedFileInfo, error) {
+	var fi iofs.FileInfo
+	var err error
+	if fsys, ok := s.fsys.(lstatFS); ok {
+		fi, err = fsys.Lstat(s.name(name))
+	} else {
+		fi, err = iofs.Stat(s.fsys, s.name(name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode()&iofs.ModeSymlink != 0 {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: errors.New("symlinks are not supported")}
+	}
+	return &fs.ExtendedFileInfo{
+		Name:       fi.Name(),
+		Mode:       fi.Mode(),
+		Size:       fi.Size(),
+		AccessTime: fi.ModTime(),
+		ModTime:    fi.ModTime(),
+		ChangeTime: fi.ModTime(),
+	}, nil
+}
+
+func (s *sourceFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (s *sourceFS) Separator() string {
+	return "/"
+}
+
+func (s *sourceFS) Abs(p string) (string, error) {
+	return path.Clean("/" + p), nil
+}
+
+func (s *sourceFS) Clean(p string) string {
+	return path.Clean(p)
+}
+
+func (s *sourceFS) VolumeName(_ string) string {
+	return ""
+}
+
+func (s *sourceFS) IsAbs(p string) bool {
+	return path.IsAbs(p)
+}
+
+func (s *sourceFS) Dir(p string) string {
+	return path.Dir(p)
+}
+
+func (s *sourceFS) Base(p string) string {
+	return path.Base(p)
+}
+
+// sourceFile is a file or directory of a sourceFS, it is only opened once
+// it is made readable
+type sourceFile struct {
+	fsys iofs.FS
+	name string
+	path string
+	fi   *fs.ExtendedFileInfo
+	f    iofs.File
+}
+
+func (f *sourceFile) MakeReadable() error {
+	if f.fi.Mode.IsDir() || f.f != nil {
+		return nil
+	}
+	file, err := f.fsys.Open(f.name)
+	if err != nil {
+		return err
+	}
+	f.f = file
+	return nil
+}
+
+func (f *sourceFile) Read(p []byte) (int, error) {
+	if f.f == nil {
+		return 0, &os.PathError{Op: "read", Path: f.path, Err: os.ErrInvalid}
+	}
+	return f.f.Read(p)
+}
+
+func (f *sourceFile) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
+
+func (f *sourceFile) Readdirnames(n int) ([]string, error) {
+	if n > 0 {
+		return nil, &os.PathError{Op: "readdirnames", Path: f.path, Err: os.ErrInvalid}
+	}
+	entries, err := iofs.ReadDir(f.fsys, f.name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type()&iofs.ModeSymlink != 0 {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (f *sourceFile) Stat() (*fs.ExtendedFileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *sourceFile) ToNode(_ bool, _ func(format string, args ...any)) (*data.Node, error) {
+	node := &data.Node{
+		Path:       f.path,
+		Name:       f.fi.Name,
+		Mode:       f.fi.Mode & (os.ModePerm | os.ModeType),
+		ModTime:    f.fi.ModTime,
+		AccessTime: f.fi.AccessTime,
+		ChangeTime: f.fi.ChangeTime,
+	}
+	switch {
+	case f.fi.Mode.IsRegular():
+		node.Type = data.NodeTypeFile
+		node.Size = uint64(f.fi.Size)
+	case f.fi.Mode.IsDir():
+		node.Type = data.NodeTypeDir
+	default:
+		node.Type = data.NodeTypeIrregular
+	}
+	return node, nil
+}
+
+// ensure that sourceFile implements fs.File
+var _ fs.File = &sourceFile{}
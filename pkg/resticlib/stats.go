@@ -0,0 +1,218 @@
+package resticlib
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path"
+
+	"github.com/restic/restic/internal/crypto"
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/restorer"
+	"github.com/restic/restic/internal/walker"
+)
+
+// StatsMode selects how Stats counts files and data
+type StatsMode string
+
+// Counting modes, see the stats command of restic
+const (
+	// StatsModeRestoreSize counts the size of the restored files (default)
+	StatsModeRestoreSize StatsMode = "restore-size"
+	// StatsModeFilesByContents counts files with unique contents once
+	StatsModeFilesByContents StatsMode = "files-by-contents"
+	// StatsModeBlobsPerFile counts the unique blobs of each file path
+	StatsModeBlobsPerFile StatsMode = "blobs-per-file"
+	// StatsModeRawData counts the stored size of the referenced blobs
+	StatsModeRawData StatsMode = "raw-data"
+)
+
+// StatsOptions configures Stats
+type StatsOptions struct {
+	Mode StatsMode `json:"mode,omitempty"`
+
+	// Snapshots to count, the snapshots matching Filter are counted if empty
+	Snapshots []SnapshotID   `json:"snapshots,omitempty"`
+	Filter    SnapshotFilter `json:"filter,omitempty"`
+}
+
+// StatsReport contains the statistics computed by Stats
+type StatsReport struct {
+	Mode           StatsMode `json:"mode"`
+	SnapshotsCount int       `json:"snapshots_count"`
+	TotalSize      uint64    `json:"total_size"`
+	TotalFileCount uint64    `json:"total_file_count,omitempty"`
+	TotalBlobCount uint64    `json:"total_blob_count,omitempty"`
+
+	// Compression statistics, only computed in the raw-data mode for
+	// repositories of version 2 or later
+	TotalUncompressedSize  uint64  `json:"total_uncompressed_size,omitempty"`
+	CompressionRatio       float64 `json:"compression_ratio,omitempty"`
+	CompressionProgress    float64 `json:"compression_progress,omitempty"`
+	CompressionSpaceSaving float64 `json:"compression_space_saving,omitempty"`
+}
+
+// statsCollector holds the state of a Stats run
+type statsCollector struct {
+	report StatsReport
+
+	uniqueFiles map[[sha256.Size]byte]struct{}
+	fileBlobs   map[string]restic.IDSet
+	blobs       restic.BlobSet
+	hardLinks   *restorer.HardlinkIndex[struct{}]
+}
+
+// Stats computes size statistics of snapshots
+func (r *repositoryImpl) Stats(ctx context.Context, opts StatsOptions) (StatsReport, error) {
+	if err := r.begin(); err != nil {
+		return StatsReport{}, err
+	}
+	defer r.end()
+
+	if opts.Mode == "" {
+		opts.Mode = StatsModeRestoreSize
+	}
+	switch opts.Mode {
+	case StatsModeRestoreSize, StatsModeFilesByContents, StatsModeBlobsPerFile, StatsModeRawData:
+	default:
+		return StatsReport{}, fmt.Errorf("unknown stats mode %q", opts.Mode)
+	}
+
+	snapshots, err := r.selectSnapshots(ctx, opts.Snapshots, opts.Filter)
+	if err != nil {
+		return StatsReport{}, err
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return StatsReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	c := &statsCollector{
+		report:      StatsReport{Mode: opts.Mode},
+		uniqueFiles: make(map[[sha256.Size]byte]struct{}),
+		fileBlobs:   make(map[string]restic.IDSet),
+		blobs:       restic.NewBlobSet(),
+	}
+
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			return StatsReport{}, fmt.Errorf("snapshot %s has no tree", sn.ID().Str())
+		}
+		c.report.SnapshotsCount++
+
+		if opts.Mode == StatsModeRawData {
+			err = data.FindUsedBlobs(ctx, r.repo, restic.IDs{*sn.Tree}, c.blobs, nil)
+		} else {
+			c.hardLinks = restorer.NewHardlinkIndex[struct{}]()
+			err = walker.Walk(ctx, r.repo, *sn.Tree, walker.WalkVisitor{ProcessNode: c.processNode(r, opts.Mode)})
+		}
+		if err != nil {
+			return StatsReport{}, fmt.Errorf("failed to walk snapshot %s: %w", sn.ID().Str(), err)
+		}
+	}
+
+	if opts.Mode == StatsModeRawData {
+		err = r.countRawData(c)
+		if err != nil {
+			return StatsReport{}, err
+		}
+	}
+
+	r.logf("info", "Stats in %s mode: %d snapshots, %d bytes", opts.Mode, c.report.SnapshotsCount, c.report.TotalSize)
+	return c.report, nil
+}
+
+// processNode returns the walk function counting the nodes of a snapshot
+func (c *statsCollector) processNode(r *repositoryImpl, mode StatsMode) walker.WalkFunc {
+	return func(parentTreeID restic.ID, nodepath string, node *data.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return nil
+		}
+
+		switch mode {
+		case StatsModeRestoreSize:
+			c.report.TotalFileCount++
+			if node.Links <= 1 || node.Type == data.NodeTypeDir {
+				c.report.TotalSize += node.Size
+			} else if node.Inode == 0 || !c.hardLinks.Has(node.Inode, node.DeviceID) {
+				// hard linked files are only restored once
+				c.hardLinks.Add(node.Inode, node.DeviceID, struct{}{})
+				c.report.TotalSize += node.Size
+			}
+
+		case StatsModeFilesByContents, StatsModeBlobsPerFile:
+			var contents []byte
+			for _, id := range node.Content {
+				contents = append(contents, id[:]...)
+			}
+			fid := sha256.Sum256(contents)
+			if _, ok := c.uniqueFiles[fid]; ok {
+				return nil
+			}
+			c.uniqueFiles[fid] = struct{}{}
+
+			if mode == StatsModeFilesByContents {
+				c.report.TotalSize += node.Size
+				c.report.TotalFileCount++
+				return nil
+			}
+
+			// in this mode a file is unique by its contents and path
+			filePath := path.Join(nodepath, node.Name)
+			for _, id := range node.Content {
+				if _, ok := c.fileBlobs[filePath]; !ok {
+					c.fileBlobs[filePath] = restic.NewIDSet()
+					c.report.TotalFileCount++
+				}
+				if c.fileBlobs[filePath].Has(id) {
+					continue
+				}
+				size, found := r.repo.LookupBlobSize(restic.DataBlob, id)
+				if !found {
+					return fmt.Errorf("blob %s not found for tree %s", id.Str(), parentTreeID.Str())
+				}
+				c.report.TotalSize += uint64(size)
+				c.report.TotalBlobCount++
+				c.fileBlobs[filePath].Insert(id)
+			}
+		}
+		return nil
+	}
+}
+
+// countRawData sums up the stored size of the collected blobs
+func (r *repositoryImpl) countRawData(c *statsCollector) error {
+	var compressedSize, compressedUncompressedSize uint64
+	for h := range c.blobs {
+		pbs := r.repo.LookupBlob(h.Type, h.ID)
+		if len(pbs) == 0 {
+			return fmt.Errorf("blob %v not found", h)
+		}
+		pb := pbs[0]
+		c.report.TotalSize += uint64(pb.Length)
+		c.report.TotalBlobCount++
+
+		if r.repo.Config().Version >= 2 {
+			uncompressed := uint64(crypto.CiphertextLength(int(pb.DataLength())))
+			c.report.TotalUncompressedSize += uncompressed
+			if pb.IsCompressed() {
+				compressedSize += uint64(pb.Length)
+				compressedUncompressedSize += uncompressed
+			}
+		}
+	}
+
+	if compressedSize > 0 {
+		c.report.CompressionRatio = float64(compressedUncompressedSize) / float64(compressedSize)
+	}
+	if c.report.TotalUncompressedSize > 0 {
+		c.report.CompressionProgress = float64(compressedUncompressedSize) / float64(c.report.TotalUncompressedSize) * 100
+		c.report.CompressionSpaceSaving = (1 - float64(c.report.TotalSize)/float64(c.report.TotalUncompressedSize)) * 100
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package resticlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/repository"
+	"github.com/restic/restic/internal/restic"
+)
+
+// SyncOptions control SyncTo
+type SyncOptions struct {
+	// MirrorDeletions removes snapshots from the destination which no longer
+	// exist in the source. Unreferenced data is removed by the next Prune of
+	// the destination.
+	MirrorDeletions bool `json:"mirror_deletions"`
+
+	// DryRun only reports what would be copied and removed
+	DryRun bool `json:"dry_run"`
+
+	// Lock controls waiting for locks of other clients. The source is locked
+	// shared and the destination exclusively, or shared for dry runs.
+	Lock LockOptions `json:"lock,omitempty"`
+}
+
+// SyncReport describes the changes made by SyncTo
+type SyncReport struct {
+	// Copied are the source IDs of the snapshots copied to the destination
+	Copied []SnapshotID `json:"copied"`
+
+	// Removed are the destination IDs of the snapshots removed by MirrorDeletions
+	Removed []SnapshotID `json:"removed"`
+
+	// RetentionLocked are the destination IDs of the snapshots which were
+	// kept by MirrorDeletions because of their retention lock
+	RetentionLocked []SnapshotID `json:"retention_locked,omitempty"`
+
+	// BlobsCopied and BytesCopied count the data which was missing in the destination
+	BlobsCopied int    `json:"blobs_copied"`
+	BytesCopied uint64 `json:"bytes_copied"`
+}
+
+// SyncTo copies all snapshots which are missing in the destination together
+// with their data. Snapshots are matched like restic copy does, using the
+// original snapshot ID which is stored in copied snapshots. The destination
+// must be a different repository opened with this package, its data is
+// re-encrypted with its own key.
+func (r *repositoryImpl) SyncTo(ctx context.Context, dst Repository, opts SyncOptions) (SyncReport, error) {
+	report := SyncReport{Copied: []SnapshotID{}, Removed: []SnapshotID{}}
+
+	target, ok := dst.(*repositoryImpl)
+	if !ok {
+		return report, errors.New("destination repository must be opened with resticlib")
+	}
+	if target == r {
+		return report, errors.New("cannot sync a repository to itself")
+	}
+
+	if err := r.begin(); err != nil {
+		return report, err
+	}
+	defer r.end()
+	if err := target.begin(); err != nil {
+		return report, err
+	}
+	defer target.end()
+
+	ctx, unlock, err := r.lockShared(ctx, opts.Lock)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	lockTarget := target.lock
+	if opts.DryRun {
+		lockTarget = target.lockShared
+	}
+	ctx, unlockTarget, err := lockTarget(ctx, opts.Lock)
+	if err != nil {
+		return report, fmt.Errorf("destination: %w", err)
+	}
+	defer unlockTarget()
+
+	r.logf("info", "Syncing snapshots to destination repository")
+
+	srcSnapshots, err := r.listSnapshots(ctx)
+	if err != nil {
+		return report, err
+	}
+	dstSnapshots, err := target.listSnapshots(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	// oldest snapshots first, so that an interrupted sync resumes in order
+	sort.Slice(srcSnapshots, func(i, j int) bool {
+		return srcSnapshots[i].Time.Before(srcSnapshots[j].Time)
+	})
+
+	existing := restic.NewIDSet()
+	for _, sn := range dstSnapshots {
+		existing.Insert(originalID(sn))
+	}
+
+	sources := restic.NewIDSet()
+	var missing data.Snapshots
+	for _, sn := range srcSnapshots {
+		sources.Insert(originalID(sn))
+		if !existing.Has(originalID(sn)) {
+			missing = append(missing, sn)
+			report.Copied = append(report.Copied, SnapshotID(sn.ID().String()))
+		}
+	}
+
+	var removed data.Snapshots
+	if opts.MirrorDeletions {
+		for _, sn := range dstSnapshots {
+			if sources.Has(originalID(sn)) {
+				continue
+			}
+			if err := checkRetention(sn); err != nil {
+				r.logf("info", "Keeping destination snapshot: %v", err)
+				report.RetentionLocked = append(report.RetentionLocked, SnapshotID(sn.ID().String()))
+				continue
+			}
+			removed = append(removed, sn)
+		}
+	}
+
+	if opts.DryRun {
+		for _, sn := range removed {
+			report.Removed = append(report.Removed, SnapshotID(sn.ID().String()))
+		}
+		r.logf("info", "Dry run: would copy %d and remove %d snapshots", len(report.Copied), len(report.Removed))
+		return report, nil
+	}
+
+	if len(missing) > 0 {
+		if err := r.syncData(ctx, target, missing, &report); err != nil {
+			return report, err
+		}
+
+		for _, sn := range missing {
+			// the parent does not exist in the destination
+			copied := *sn
+			copied.Parent = nil
+			if copied.Original == nil {
+				copied.Original = sn.ID()
+			}
+			newID, err := data.SaveSnapshot(ctx, target.repo, &copied)
+			if err != nil {
+				return report, fmt.Errorf("failed to save snapshot: %w", err)
+			}
+			r.logf("debug", "Copied snapshot %s as %s", sn.ID().Str(), newID.Str())
+		}
+	}
+
+	for _, sn := range removed {
+		err := removeSnapshot(ctx, target.repo, sn)
+		if err != nil {
+			return report, fmt.Errorf("failed to remove snapshot %s: %w", sn.ID().Str(), err)
+		}
+		report.Removed = append(report.Removed, SnapshotID(sn.ID().String()))
+	}
+
+	r.logf("info", "Sync completed, copied %d snapshots (%d bytes), removed %d snapshots",
+		len(report.Copied), report.BytesCopied, len(report.Removed))
+	return report, nil
+}
+
+// syncData copies all blobs of the snapshots which are missing in the target
+func (r *repositoryImpl) syncData(ctx context.Context, target *repositoryImpl, snapshots data.Snapshots, report *SyncReport) error {
+	if err := r.repo.LoadIndex(ctx, nil); err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	if err := target.repo.LoadIndex(ctx, nil); err != nil {
+		return fmt.Errorf("failed to load index of destination: %w", err)
+	}
+
+	var trees restic.IDs
+	for _, sn := range snapshots {
+		trees = append(trees, *sn.Tree)
+	}
+	blobs := restic.NewBlobSet()
+	err := data.FindUsedBlobs(ctx, r.repo, trees, blobs, nil)
+	if err != nil {
+		return fmt.Errorf("failed to find blobs of snapshots: %w", err)
+	}
+
+	packs := restic.NewIDSet()
+	for h := range blobs {
+		if _, ok := target.repo.LookupBlobSize(h.Type, h.ID); ok {
+			blobs.Delete(h)
+			continue
+		}
+		for _, pb := range r.repo.LookupBlob(h.Type, h.ID) {
+			packs.Insert(pb.PackID)
+		}
+		size, _ := r.repo.LookupBlobSize(h.Type, h.ID)
+		report.BlobsCopied++
+		report.BytesCopied += uint64(size)
+	}
+
+	r.logf("debug", "Copying %d blobs from %d packs", len(blobs), len(packs))
+	_, err = repository.Repack(ctx, r.repo, target.repo, packs, blobs, r.cfg.ColdStorage != nil, nil, func(msg string, args ...interface{}) {
+		r.logf("warn", msg, args...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy data: %w", err)
+	}
+	return nil
+}
+
+// originalID returns the ID of the snapshot a copy was made from, or the
+// snapshot's own ID if it is not a copy
+func originalID(sn *data.Snapshot) restic.ID {
+	if sn.Original != nil {
+		return *sn.Original
+	}
+	return *sn.ID()
+}
@@ -0,0 +1,155 @@
+package resticlib
+
+import (
+	"io"
+
+	"github.com/restic/chunker"
+	"github.com/restic/restic/internal/archiver"
+	"github.com/restic/restic/internal/backend/azure"
+	"github.com/restic/restic/internal/backend/b2"
+	"github.com/restic/restic/internal/backend/gs"
+	"github.com/restic/restic/internal/backend/limiter"
+	"github.com/restic/restic/internal/backend/local"
+	"github.com/restic/restic/internal/backend/rclone"
+	"github.com/restic/restic/internal/backend/rest"
+	"github.com/restic/restic/internal/backend/s3"
+	"github.com/restic/restic/internal/backend/sftp"
+	"github.com/restic/restic/internal/backend/swift"
+	"github.com/restic/restic/internal/fs"
+)
+
+// workerMemory is the memory needed by a goroutine processing a chunk, which
+// holds the chunk and its compressed copy
+const workerMemory = 2 * chunker.MaxSize
+
+// memoryWorkers returns the number of goroutines processing chunks which fit
+// into the memory limit, 0 means unlimited
+func memoryWorkers(limitMiB uint) uint {
+	if limitMiB == 0 {
+		return 0
+	}
+	return max(1, limitMiB*1024*1024/workerMemory)
+}
+
+// limitArchiver restricts the archiver concurrency to the memory limit. Files
+// are only read while a worker is available, which provides back-pressure.
+func limitArchiver(opts archiver.Options, limitMiB uint) archiver.Options {
+	workers := memoryWorkers(limitMiB)
+	if workers == 0 {
+		return opts
+	}
+
+	opts = opts.ApplyDefaults()
+	opts.ReadConcurrency = min(opts.ReadConcurrency, max(1, workers/2))
+	opts.SaveBlobConcurrency = min(opts.SaveBlobConcurrency, max(1, workers-opts.ReadConcurrency))
+	opts.SaveTreeConcurrency = opts.SaveBlobConcurrency + opts.ReadConcurrency
+	return opts
+}
+
+// limitConnections restricts the backend connections, and thereby the
+// number of restore workers, to the memory limit
+func limitConnections(cfg interface{}, limitMiB uint) {
+	workers := memoryWorkers(limitMiB)
+	connections := backendConnections(cfg)
+	if workers == 0 || connections == nil {
+		return
+	}
+	// prune repacks with at least two connections
+	*connections = min(*connections, max(2, workers))
+}
+
+// backendConnections returns the connection limit of a backend config
+func backendConnections(cfg interface{}) *uint {
+	switch bcfg := cfg.(type) {
+	case *local.Config:
+		return &bcfg.Connections
+	case *s3.Config:
+		return &bcfg.Connections
+	case *azure.Config:
+		return &bcfg.Connections
+	case *gs.Config:
+		return &bcfg.Connections
+	case *b2.Config:
+		return &bcfg.Connections
+	case *sftp.Config:
+		return &bcfg.Connections
+	case *swift.Config:
+		return &bcfg.Connections
+	case *rest.Config:
+		return &bcfg.Connections
+	case *rclone.Config:
+		return &bcfg.Connections
+	}
+	return nil
+}
+
+// archiverOptions returns the archiver options restricted by the CPU limit
+func (l CPULimit) archiverOptions() archiver.Options {
+	var opts archiver.Options
+	if l.MaxProcs > 0 {
+		opts.SaveBlobConcurrency = l.MaxProcs
+		// chunking runs in the reader goroutines
+		opts.ReadConcurrency = min(l.MaxProcs, 2)
+		opts.SaveTreeConcurrency = l.MaxProcs + opts.ReadConcurrency
+	}
+	return opts
+}
+
+// backupArchiverOptions returns the archiver options of a backup. The
+// concurrency set in the backup options takes precedence over the CPU limit,
+// which takes precedence over the parallelism of the repository. All of them
+// are restricted by the memory limit.
+func (r *repositoryImpl) backupArchiverOptions(opts BackupOptions, cpu CPULimit) archiver.Options {
+	o := cpu.archiverOptions()
+	if r.cfg.Parallelism > 0 {
+		o.ReadConcurrency = orDefault(o.ReadConcurrency, uint(r.cfg.Parallelism))
+		o.SaveBlobConcurrency = orDefault(o.SaveBlobConcurrency, uint(r.cfg.Parallelism))
+	}
+	if opts.ReadConcurrency > 0 {
+		o.ReadConcurrency = opts.ReadConcurrency
+	}
+	if opts.SaveBlobConcurrency > 0 {
+		o.SaveBlobConcurrency = opts.SaveBlobConcurrency
+	}
+	if o.ReadConcurrency > 0 || o.SaveBlobConcurrency > 0 {
+		o = o.ApplyDefaults()
+		o.SaveTreeConcurrency = o.SaveBlobConcurrency + o.ReadConcurrency
+	}
+	return limitArchiver(o, r.cfg.MemoryLimitMiB)
+}
+
+// pacedFS limits the rate at which file contents are read
+type pacedFS struct {
+	fs.FS
+	lim limiter.Limiter
+}
+
+// newPacedFS returns filesystem reading at most rateKiB KiB/s, or filesystem
+// itself if the rate is unlimited
+func newPacedFS(filesystem fs.FS, rateKiB int) fs.FS {
+	if rateKiB <= 0 {
+		return filesystem
+	}
+	return &pacedFS{
+		FS:  filesystem,
+		lim: limiter.NewStaticLimiter(limiter.Limits{DownloadKb: rateKiB}),
+	}
+}
+
+func (p *pacedFS) OpenFile(name string, flag int, metadataOnly bool) (fs.File, error) {
+	f, err := p.FS.OpenFile(name, flag, metadataOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &pacedFile{File: f, rd: p.lim.Downstream(f)}, nil
+}
+
+// pacedFile reads through the limiter of its filesystem
+type pacedFile struct {
+	fs.File
+	rd io.Reader
+}
+
+func (f *pacedFile) Read(p []byte) (int, error) {
+	return f.rd.Read(p)
+}
@@ -0,0 +1,110 @@
+package resticlib
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultThroughputInterval is used if BackupOptions.ThroughputInterval is not set
+const defaultThroughputInterval = time.Second
+
+// ThroughputSample is a periodic measurement of the backup throughput
+type ThroughputSample struct {
+	// Elapsed is the time since the backup started
+	Elapsed time.Duration `json:"elapsed"`
+
+	// BytesRead is the amount of file data read so far
+	BytesRead uint64 `json:"bytes_read"`
+
+	// BytesAdded is the amount of new data so far, before compression
+	BytesAdded uint64 `json:"bytes_added"`
+
+	// BytesStored is the amount of new data so far as stored in the repository
+	BytesStored uint64 `json:"bytes_stored"`
+
+	// ReadRate and UploadRate are the bytes per second read and stored since
+	// the previous sample
+	ReadRate   float64 `json:"read_rate"`
+	UploadRate float64 `json:"upload_rate"`
+
+	// DedupRatio is the share of the data read so far which was already
+	// stored in the repository, between 0 and 1
+	DedupRatio float64 `json:"dedup_ratio"`
+}
+
+// ThroughputReporter can be implemented in addition to ProgressReporter to
+// receive throughput samples during a backup
+type ThroughputReporter interface {
+	Throughput(sample ThroughputSample)
+}
+
+// throughputMeter counts the transferred bytes and reports samples periodically
+type throughputMeter struct {
+	read   atomic.Uint64
+	added  atomic.Uint64
+	stored atomic.Uint64
+
+	start    time.Time
+	last     ThroughputSample
+	reporter ThroughputReporter
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startThroughputMeter starts reporting samples to the reporter every interval
+func startThroughputMeter(reporter ThroughputReporter, interval time.Duration) *throughputMeter {
+	if interval <= 0 {
+		interval = defaultThroughputInterval
+	}
+
+	m := &throughputMeter{
+		start:    time.Now(),
+		reporter: reporter,
+		done:     make(chan struct{}),
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.report()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// stop ends the periodic reports and sends a final sample
+func (m *throughputMeter) stop() {
+	close(m.done)
+	m.wg.Wait()
+	m.report()
+}
+
+// report sends a sample with the rates since the previous sample
+func (m *throughputMeter) report() {
+	sample := ThroughputSample{
+		Elapsed:     time.Since(m.start),
+		BytesRead:   m.read.Load(),
+		BytesAdded:  m.added.Load(),
+		BytesStored: m.stored.Load(),
+	}
+
+	if seconds := (sample.Elapsed - m.last.Elapsed).Seconds(); seconds > 0 {
+		sample.ReadRate = float64(sample.BytesRead-m.last.BytesRead) / seconds
+		sample.UploadRate = float64(sample.BytesStored-m.last.BytesStored) / seconds
+	}
+	if sample.BytesRead > 0 && sample.BytesAdded < sample.BytesRead {
+		sample.DedupRatio = 1 - float64(sample.BytesAdded)/float64(sample.BytesRead)
+	}
+
+	m.last = sample
+	m.reporter.Throughput(sample)
+}
@@ -0,0 +1,105 @@
+package resticlib
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/restic/restic/internal/backend"
+)
+
+// tlsVersions maps the names accepted by TLSOptions.MinVersion to crypto/tls
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTransport returns the HTTP transport for a backend. nil is returned if
+// no settings require a custom transport, the backend then uses its default.
+func newTransport(cfg Config, scheme string) (http.RoundTripper, error) {
+	if len(cfg.RESTHeaders) == 0 && cfg.TLS == nil && cfg.HTTP == (HTTPOptions{}) {
+		return nil, nil
+	}
+	if len(cfg.RESTHeaders) > 0 && scheme != "rest" {
+		return nil, fmt.Errorf("custom headers are not supported by the %s backend", scheme)
+	}
+
+	opts := backend.TransportOptions{
+		DisableHTTP2:        cfg.HTTP.DisableHTTP2,
+		MaxIdleConnsPerHost: cfg.HTTP.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.HTTP.IdleConnTimeout,
+		KeepAlive:           cfg.HTTP.KeepAlive,
+	}
+	if cfg.TLS != nil {
+		var err error
+		opts.TLSMinVersion, opts.TLSCipherSuites, err = cfg.TLS.parse()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rt, err := backend.Transport(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transport: %w", err)
+	}
+	if len(cfg.RESTHeaders) > 0 {
+		rt = newHeaderRoundTripper(rt, cfg.RESTHeaders)
+	}
+	return rt, nil
+}
+
+// parse converts the TLS options to the values used by crypto/tls
+func (o TLSOptions) parse() (minVersion uint16, cipherSuites []uint16, err error) {
+	if o.MinVersion != "" {
+		var ok bool
+		minVersion, ok = tlsVersions[o.MinVersion]
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid TLS version %q", o.MinVersion)
+		}
+	}
+
+	if len(o.CipherSuites) == 0 {
+		return minVersion, nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	for _, name := range o.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+	return minVersion, cipherSuites, nil
+}
+
+// headerRoundTripper adds a fixed set of headers to all outgoing requests
+type headerRoundTripper struct {
+	headers http.Header
+	rt      http.RoundTripper
+}
+
+func newHeaderRoundTripper(rt http.RoundTripper, headers map[string]string) *headerRoundTripper {
+	h := &headerRoundTripper{
+		headers: make(http.Header, len(headers)),
+		rt:      rt,
+	}
+	for name, value := range headers {
+		h.headers.Set(name, value)
+	}
+	return h
+}
+
+// RoundTrip sets the headers on a copy of the request and passes it on
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range h.headers {
+		req.Header[name] = values
+	}
+	return h.rt.RoundTrip(req)
+}
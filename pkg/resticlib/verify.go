@@ -0,0 +1,247 @@
+package resticlib
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/restic"
+	"github.com/restic/restic/internal/walker"
+)
+
+// RestoreDifference kinds reported by VerifyRestore
+const (
+	DifferenceMissing = "missing"
+	DifferenceExtra   = "extra"
+	DifferenceType    = "type"
+	DifferenceSize    = "size"
+	DifferenceContent = "content"
+	DifferenceMode    = "mode"
+	DifferenceModTime = "mtime"
+	DifferenceLink    = "link"
+)
+
+// RestoreDifference is a mismatch between a snapshot and a restore target
+type RestoreDifference struct {
+	// Path is the path in the snapshot
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// VerifyRestoreReport is the result of VerifyRestore
+type VerifyRestoreReport struct {
+	FilesChecked int                 `json:"files_checked"`
+	BytesChecked uint64              `json:"bytes_checked"`
+	Differences  []RestoreDifference `json:"differences"`
+
+	// Match is true if the target is identical to the snapshot
+	Match bool `json:"match"`
+}
+
+// VerifyRestore compares the files in targetDir with the snapshot as if it
+// had been restored there, without writing anything. File contents are
+// compared by hash, metadata by type, size, mode, modification time and
+// symlink target. Files in targetDir which are not part of the snapshot are
+// reported as extra.
+func (r *repositoryImpl) VerifyRestore(ctx context.Context, snapshotID SnapshotID, targetDir string) (VerifyRestoreReport, error) {
+	if err := r.begin(); err != nil {
+		return VerifyRestoreReport{}, err
+	}
+	defer r.end()
+
+	report := VerifyRestoreReport{Differences: []RestoreDifference{}}
+
+	sn, _, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return report, fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return report, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	r.logf("info", "Verifying %s against snapshot %s", targetDir, sn.ID().Str())
+
+	known := make(map[string]struct{})
+	err = walker.Walk(ctx, r.repo, *sn.Tree, walker.WalkVisitor{
+		ProcessNode: func(_ restic.ID, nodepath string, node *data.Node, err error) error {
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return nil
+			}
+			known[nodepath] = struct{}{}
+
+			diffs, err := r.verifyNode(ctx, filepath.Join(targetDir, filepath.FromSlash(nodepath)), node, &report)
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %w", nodepath, err)
+			}
+			for _, diff := range diffs {
+				diff.Path = nodepath
+				report.Differences = append(report.Differences, diff)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// anything left in the target directory was not part of the snapshot
+	err = filepath.WalkDir(targetDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == targetDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if path == targetDir {
+			return nil
+		}
+		rel, err := filepath.Rel(targetDir, path)
+		if err != nil {
+			return err
+		}
+		nodepath := "/" + filepath.ToSlash(rel)
+		if _, ok := known[nodepath]; ok {
+			return nil
+		}
+		report.Differences = append(report.Differences, RestoreDifference{Path: nodepath, Kind: DifferenceExtra})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to list target directory: %w", err)
+	}
+
+	report.Match = len(report.Differences) == 0
+	if report.Match {
+		r.logf("info", "Verified %d files, target matches snapshot", report.FilesChecked)
+	} else {
+		r.logf("info", "Verified %d files, found %d differences", report.FilesChecked, len(report.Differences))
+	}
+	return report, nil
+}
+
+// verifyNode compares a single path with the node from the snapshot. Errors
+// are only returned if the repository cannot be read.
+func (r *repositoryImpl) verifyNode(ctx context.Context, path string, node *data.Node, report *VerifyRestoreReport) ([]RestoreDifference, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return []RestoreDifference{{Kind: DifferenceMissing, Detail: err.Error()}}, nil
+	}
+
+	if nodeType(fi) != node.Type {
+		return []RestoreDifference{{Kind: DifferenceType,
+			Detail: fmt.Sprintf("expected %s, found %s", node.Type, nodeType(fi))}}, nil
+	}
+
+	var diffs []RestoreDifference
+	switch node.Type {
+	case data.NodeTypeSymlink:
+		target, err := os.Readlink(path)
+		if err != nil || target != node.LinkTarget {
+			diffs = append(diffs, RestoreDifference{Kind: DifferenceLink,
+				Detail: fmt.Sprintf("expected %q, found %q", node.LinkTarget, target)})
+		}
+		// mode and modification time of symlinks are not restored everywhere
+		return diffs, nil
+
+	case data.NodeTypeFile:
+		report.FilesChecked++
+		if uint64(fi.Size()) != node.Size {
+			diffs = append(diffs, RestoreDifference{Kind: DifferenceSize,
+				Detail: fmt.Sprintf("expected %d bytes, found %d", node.Size, fi.Size())})
+		} else {
+			same, err := r.sameContent(ctx, path, node, report)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				diffs = append(diffs, RestoreDifference{Kind: DifferenceContent})
+			}
+		}
+	}
+
+	// permissions are emulated on Windows
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != node.Mode.Perm() {
+		diffs = append(diffs, RestoreDifference{Kind: DifferenceMode,
+			Detail: fmt.Sprintf("expected %v, found %v", node.Mode.Perm(), fi.Mode().Perm())})
+	}
+
+	// file systems differ in their timestamp resolution
+	if node.Type != data.NodeTypeDir && !fi.ModTime().Truncate(time.Microsecond).Equal(node.ModTime.Truncate(time.Microsecond)) {
+		diffs = append(diffs, RestoreDifference{Kind: DifferenceModTime,
+			Detail: fmt.Sprintf("expected %v, found %v", node.ModTime, fi.ModTime())})
+	}
+
+	return diffs, nil
+}
+
+// sameContent hashes the file blob by blob and compares the hashes with the
+// blob IDs of the node. A file which cannot be read counts as different.
+func (r *repositoryImpl) sameContent(ctx context.Context, path string, node *data.Node, report *VerifyRestoreReport) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, nil
+	}
+	defer func() { _ = f.Close() }()
+
+	var buf []byte
+	for _, id := range node.Content {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		size, ok := r.repo.LookupBlobSize(restic.DataBlob, id)
+		if !ok {
+			return false, fmt.Errorf("blob %s not found in index", id.Str())
+		}
+		if cap(buf) < int(size) {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return false, nil
+		}
+		report.BytesChecked += uint64(size)
+		if restic.ID(sha256.Sum256(buf)) != id {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// nodeType returns the snapshot node type of a file
+func nodeType(fi os.FileInfo) data.NodeType {
+	switch mode := fi.Mode(); {
+	case mode.IsRegular():
+		return data.NodeTypeFile
+	case mode.IsDir():
+		return data.NodeTypeDir
+	case mode&os.ModeSymlink != 0:
+		return data.NodeTypeSymlink
+	case mode&os.ModeCharDevice != 0:
+		return data.NodeTypeCharDev
+	case mode&os.ModeDevice != 0:
+		return data.NodeTypeDev
+	case mode&os.ModeNamedPipe != 0:
+		return data.NodeTypeFifo
+	case mode&os.ModeSocket != 0:
+		return data.NodeTypeSocket
+	}
+	return data.NodeTypeIrregular
+}
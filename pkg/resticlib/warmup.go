@@ -0,0 +1,70 @@
+package resticlib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/restic/restic/internal/data"
+	"github.com/restic/restic/internal/restic"
+)
+
+// Warmup requests all packs containing data of the snapshot to be restored
+// from cold storage. Packs which are already available are skipped. Without
+// wait the call returns right after the requests, calling it again reports
+// how many packs are still being restored. With wait it blocks until all
+// packs are available or the restore timeout expires. Backends without cold
+// storage support report all packs as available.
+func (r *repositoryImpl) Warmup(ctx context.Context, snapshotID SnapshotID, wait bool) (WarmupReport, error) {
+	if err := r.begin(); err != nil {
+		return WarmupReport{}, err
+	}
+	defer r.end()
+
+	sn, _, err := r.findSnapshot(ctx, string(snapshotID), SnapshotFilter{})
+	if err != nil {
+		return WarmupReport{}, fmt.Errorf("failed to find snapshot: %w", err)
+	}
+
+	err = r.repo.LoadIndex(ctx, nil)
+	if err != nil {
+		return WarmupReport{}, fmt.Errorf("failed to load index: %w", err)
+	}
+
+	blobs := restic.NewBlobSet()
+	err = data.FindUsedBlobs(ctx, r.repo, restic.IDs{*sn.Tree}, blobs, nil)
+	if err != nil {
+		return WarmupReport{}, fmt.Errorf("failed to find blobs of snapshot: %w", err)
+	}
+
+	// tree packs are never moved to cold storage, only data packs are relevant
+	packs := restic.NewIDSet()
+	for h := range blobs {
+		if h.Type != restic.DataBlob {
+			continue
+		}
+		for _, pb := range r.repo.LookupBlob(h.Type, h.ID) {
+			packs.Insert(pb.PackID)
+		}
+	}
+
+	job, err := r.repo.StartWarmup(ctx, packs)
+	if err != nil {
+		return WarmupReport{}, fmt.Errorf("failed to request restore from cold storage: %w", err)
+	}
+
+	report := WarmupReport{
+		PacksTotal:     len(packs),
+		PacksWarmingUp: job.HandleCount(),
+	}
+	r.logf("info", "%d of %d packs of snapshot %s are being restored from cold storage",
+		report.PacksWarmingUp, report.PacksTotal, sn.ID().Str())
+
+	if wait && report.PacksWarmingUp > 0 {
+		if err := job.Wait(ctx); err != nil {
+			return report, fmt.Errorf("failed to wait for restore from cold storage: %w", err)
+		}
+		report.PacksWarmingUp = 0
+	}
+
+	return report, nil
+}